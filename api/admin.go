@@ -0,0 +1,174 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"gt06/config"
+	"gt06/database"
+	"gt06/services/svc"
+	"gt06/tcp"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SessionManager exposes the live GT06 sessions to the admin API
+type SessionManager interface {
+	// Sessions returns a snapshot of the live sessions, oldest connection first
+	Sessions() []tcp.SessionInfo
+	Session(id string) (tcp.SessionInfo, bool)
+	// Disconnect closes a session by connection id, it reports whether there was one
+	Disconnect(id string) bool
+	// DisconnectIMEI closes the sessions of a device, it returns how many it closed
+	DisconnectIMEI(imei string) int
+}
+
+// BlockRequest is the body of an IMEI block
+type BlockRequest struct {
+	Reason    string `json:"reason"`
+	BlockedBy string `json:"blocked_by"`
+}
+
+// BlockResult is a stored block with the number of sessions it closed
+type BlockResult struct {
+	*database.BlockedDevice
+	Disconnected int `json:"disconnected"`
+}
+
+// NewAdminServer builds the operator API of the GT06 listener, it does not listen until Start
+func NewAdminServer(c config.AdminConf, svc *svc.ServiceContext, sessions SessionManager) *Server {
+	s := newServer("admin API", config.APIConf{Addr: c.Addr, Timeout: c.Timeout}, svc)
+	s.sessions = sessions
+	s.adminRoutes()
+
+	if c.Token != "" {
+		s.server.Handler = requireToken(c.Token, s.mux)
+	}
+	return s
+}
+
+func (s *Server) adminRoutes() {
+	s.mux.HandleFunc("GET /admin/v1/sessions", s.listSessions)
+	s.mux.HandleFunc("GET /admin/v1/sessions/{id}", s.getSession)
+	s.mux.HandleFunc("DELETE /admin/v1/sessions/{id}", s.disconnectSession)
+	s.mux.HandleFunc("GET /admin/v1/blocklist", s.listBlocked)
+	s.mux.HandleFunc("PUT /admin/v1/blocklist/{imei}", s.blockDevice)
+	s.mux.HandleFunc("DELETE /admin/v1/blocklist/{imei}", s.unblockDevice)
+	s.journalRoutes()
+	s.webhookRoutes()
+	s.healthRoutes()
+	s.mux.HandleFunc("/", s.notFound)
+}
+
+// requireToken rejects the requests without the bearer token
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			writeError(w, r, &apiError{status: http.StatusUnauthorized, code: "unauthorized", message: "missing or invalid token"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) listSessions(w http.ResponseWriter, r *http.Request) {
+	p, err := parsePaging(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	sessions := s.sessions.Sessions()
+	if imei := r.URL.Query().Get("imei"); imei != "" {
+		matched := sessions[:0]
+		for _, session := range sessions {
+			if session.IMEI == imei {
+				matched = append(matched, session)
+			}
+		}
+		sessions = matched
+	}
+
+	writePage(w, paginate(sessions, p.skip, p.limit+1), p)
+}
+
+func (s *Server) getSession(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	session, ok := s.sessions.Session(id)
+	if !ok {
+		writeError(w, r, errNotFound("session %s not found", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, session)
+}
+
+// disconnectSession closes a session, the device usually reconnects unless it is blocked
+func (s *Server) disconnectSession(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	if !s.sessions.Disconnect(id) {
+		writeError(w, r, errNotFound("session %s not found", id))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listBlocked(w http.ResponseWriter, r *http.Request) {
+	p, err := parsePaging(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	devices, err := s.svc.BlocklistRepository.List(r.Context(), p.skip, p.limit+1)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writePage(w, devices, p)
+}
+
+// blockDevice refuses the future logins of an IMEI and closes its live sessions
+func (s *Server) blockDevice(w http.ResponseWriter, r *http.Request) {
+	imei := r.PathValue("imei")
+
+	var req BlockRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+		writeError(w, r, errBadRequest("invalid body: %v", err))
+		return
+	}
+	if req.BlockedBy == "" {
+		writeError(w, r, errBadRequest("blocked_by is required"))
+		return
+	}
+
+	blocked := &database.BlockedDevice{
+		IMEI:      imei,
+		Reason:    req.Reason,
+		BlockedBy: req.BlockedBy,
+		BlockedAt: time.Now(),
+	}
+	if err := s.svc.BlocklistRepository.Block(r.Context(), blocked); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, BlockResult{BlockedDevice: blocked, Disconnected: s.sessions.DisconnectIMEI(imei)})
+}
+
+func (s *Server) unblockDevice(w http.ResponseWriter, r *http.Request) {
+	imei := r.PathValue("imei")
+
+	ok, err := s.svc.BlocklistRepository.Unblock(r.Context(), imei)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if !ok {
+		writeError(w, r, errNotFound("device %s is not blocked", imei))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,31 @@
+package api
+
+import "net/http"
+
+// findAlarms searches alarms by device and time range
+func (s *Server) findAlarms(w http.ResponseWriter, r *http.Request) {
+	imeis := parseIMEIs(r)
+	if len(imeis) > 1 {
+		writeError(w, r, errBadRequest("alarms can be filtered by a single imei"))
+		return
+	}
+
+	imei := ""
+	if len(imeis) == 1 {
+		imei = imeis[0]
+	}
+
+	q, p, err := parseQuery(r, imei)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	alarms, err := s.svc.AlarmRepository.Find(r.Context(), q)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writePage(w, alarms, p)
+}
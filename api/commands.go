@@ -0,0 +1,217 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"gt06/commands"
+	"gt06/database"
+	"gt06/protocol"
+	"gt06/services"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CommandRequest is the body of a command sent to a device
+type CommandRequest struct {
+	// Command is the raw text, or Name and Args the typed command rendered for the device model
+	Command string   `json:"command,omitempty"`
+	Name    string   `json:"name,omitempty"`
+	Args    []string `json:"args,omitempty"`
+	Timeout int      `json:"timeout,omitempty"` // seconds to wait for the reply
+
+	// Queue stores the command until the device logs in instead of requiring a live session
+	Queue       bool `json:"queue,omitempty"`
+	Priority    int  `json:"priority,omitempty"`
+	ExpiresIn   int  `json:"expires_in,omitempty"` // seconds
+	MaxAttempts int  `json:"max_attempts,omitempty"`
+}
+
+type operatorKey struct{}
+
+// requireOperator rejects the requests without the bearer token of an operator, the name of the
+// operator is then the sender of the commands
+func requireOperator(operators []commands.Operator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if ok {
+			for _, operator := range operators {
+				if operator.Token != "" && subtle.ConstantTimeCompare([]byte(given), []byte(operator.Token)) == 1 {
+					next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), operatorKey{}, operator.Name)))
+					return
+				}
+			}
+		}
+		writeError(w, r, &apiError{status: http.StatusUnauthorized, code: "unauthorized", message: "missing or invalid operator token"})
+	})
+}
+
+// sendCommand delivers a command to the live session of a device and answers with its reply
+func (s *Server) sendCommand(w http.ResponseWriter, r *http.Request) {
+	imei := r.PathValue("imei")
+
+	var req CommandRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+		writeError(w, r, errBadRequest("invalid body: %v", err))
+		return
+	}
+	service := services.NewCommandService(r.Context(), s.svc)
+	switch {
+	case req.Command != "" && req.Name != "":
+		writeError(w, r, errBadRequest("command and name cannot be combined"))
+		return
+	case req.Name != "":
+		typed, err := protocol.NewTextCommand(req.Name, req.Args)
+		if err != nil {
+			writeError(w, r, errBadRequest("%v", err))
+			return
+		}
+		if req.Command, err = service.Format(imei, typed); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	case req.Command == "":
+		writeError(w, r, errBadRequest("command or name is required"))
+		return
+	}
+	sender, _ := r.Context().Value(operatorKey{}).(string)
+
+	conf := s.svc.Config.Commands
+	if req.Queue {
+		s.queueCommand(w, r, service, imei, sender, req)
+		return
+	}
+
+	timeout := conf.Timeout
+	if req.Timeout != 0 {
+		timeout = req.Timeout
+	}
+	if timeout <= 0 || timeout > conf.MaxTimeout {
+		writeError(w, r, errBadRequest("timeout must be between 1 and %d seconds", conf.MaxTimeout))
+		return
+	}
+
+	// the reply may take longer than the write timeout of the server
+	wait := time.Duration(timeout) * time.Second
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(wait + 10*time.Second)); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	command, err := service.Send(imei, req.Command, sender, wait)
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusOK, commandView(command))
+	case errors.Is(err, commands.ErrInvalidCommand):
+		writeError(w, r, errBadRequest("%v", err))
+	case errors.Is(err, commands.ErrOffline):
+		writeError(w, r, &apiError{status: http.StatusConflict, code: "device_offline",
+			message: "device " + imei + " is not connected, command " + command.ID.Hex() + " was not sent"})
+	case errors.Is(err, commands.ErrDisconnected):
+		writeError(w, r, &apiError{status: http.StatusBadGateway, code: "device_disconnected",
+			message: "device " + imei + " disconnected before replying to command " + command.ID.Hex()})
+	case errors.Is(err, context.DeadlineExceeded):
+		writeError(w, r, &apiError{status: http.StatusGatewayTimeout, code: "timeout",
+			message: "no reply to command " + command.ID.Hex() + " within " + wait.String()})
+	default:
+		writeError(w, r, err)
+	}
+}
+
+// queueCommand stores a command for delivery on the next login of the device, or right away when it is online
+func (s *Server) queueCommand(w http.ResponseWriter, r *http.Request, service *services.CommandService, imei, sender string, req CommandRequest) {
+	conf := s.svc.Config.Commands
+
+	expiresIn := conf.Expiry
+	if req.ExpiresIn != 0 {
+		expiresIn = req.ExpiresIn
+	}
+	if expiresIn <= 0 {
+		writeError(w, r, errBadRequest("expires_in must be positive"))
+		return
+	}
+
+	maxAttempts := conf.MaxAttempts
+	if req.MaxAttempts != 0 {
+		maxAttempts = req.MaxAttempts
+	}
+	if maxAttempts <= 0 {
+		writeError(w, r, errBadRequest("max_attempts must be positive"))
+		return
+	}
+
+	command, err := service.Enqueue(imei, req.Command, sender,
+		req.Priority, time.Duration(expiresIn)*time.Second, maxAttempts)
+	if errors.Is(err, commands.ErrInvalidCommand) {
+		writeError(w, r, errBadRequest("%v", err))
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, commandView(command))
+}
+
+// deviceCommands lists the commands sent to a device
+func (s *Server) deviceCommands(w http.ResponseWriter, r *http.Request) {
+	q, p, err := parseQuery(r, r.PathValue("imei"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	found, err := s.svc.CommandRepository.Find(r.Context(), q)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	views := make([]CommandView, len(found))
+	for i, command := range found {
+		views[i] = commandView(command)
+	}
+	writePage(w, views, p)
+}
+
+func (s *Server) getCommand(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, errBadRequest("invalid command id"))
+		return
+	}
+
+	command, err := s.svc.CommandRepository.FindByID(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if command == nil {
+		writeError(w, r, errNotFound("command %s not found", id.Hex()))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, commandView(command))
+}
+
+// CommandView is a command with its reply parsed, for STATUS, PARAM, VERSION and WHERE
+type CommandView struct {
+	*database.Command
+	Result any `json:"result,omitempty"`
+}
+
+func commandView(command *database.Command) CommandView {
+	view := CommandView{Command: command}
+	if command.Status == database.CommandReplied {
+		// a reply in an unexpected format is still returned as text
+		if result, err := protocol.ParseTextReply(command.Command, command.Reply); err == nil {
+			view.Result = result
+		}
+	}
+	return view
+}
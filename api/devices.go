@@ -0,0 +1,60 @@
+package api
+
+import (
+	"gt06/database"
+	"net/http"
+)
+
+// DeviceView is a registered device with its last known state
+type DeviceView struct {
+	*database.Device
+	State *database.DeviceState `json:"state,omitempty"`
+}
+
+func (s *Server) listDevices(w http.ResponseWriter, r *http.Request) {
+	p, err := parsePaging(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	devices, err := s.svc.DeviceRepository.List(r.Context(), p.skip, p.limit+1)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	views := make([]DeviceView, 0, len(devices))
+	for _, device := range devices {
+		state, err := s.svc.DeviceStateRepository.Get(r.Context(), device.IMEI)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		views = append(views, DeviceView{Device: device, State: state})
+	}
+
+	writePage(w, views, p)
+}
+
+func (s *Server) getDevice(w http.ResponseWriter, r *http.Request) {
+	imei := r.PathValue("imei")
+
+	device, err := s.svc.DeviceRepository.FindByIMEI(r.Context(), imei)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if device == nil {
+		writeError(w, r, errNotFound("device %s not found", imei))
+		return
+	}
+
+	state, err := s.svc.DeviceStateRepository.Get(r.Context(), imei)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, DeviceView{Device: device, State: state})
+}
@@ -0,0 +1,30 @@
+package api
+
+import (
+	"gt06/health"
+	"net/http"
+)
+
+// healthRoutes serves the probes of an orchestrator or a load balancer
+func (s *Server) healthRoutes() {
+	s.mux.HandleFunc("GET /healthz", s.healthz)
+	s.mux.HandleFunc("GET /readyz", s.readyz)
+}
+
+// healthz reports whether the server makes progress, a failure calls for a restart
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, s.svc.Health.Liveness())
+}
+
+// readyz reports whether the server can take traffic, a failure calls for routing devices elsewhere
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, s.svc.Health.Readiness())
+}
+
+func writeReport(w http.ResponseWriter, report health.Report) {
+	status := http.StatusOK
+	if !report.OK {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, report)
+}
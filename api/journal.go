@@ -0,0 +1,49 @@
+package api
+
+import "net/http"
+
+// JournalStatus describes which devices the frame journal records
+type JournalStatus struct {
+	AllDevices bool     `json:"all_devices"`
+	Devices    []string `json:"devices"` // enabled one by one, journaled when AllDevices is off
+}
+
+var errJournalDisabled = &apiError{status: http.StatusConflict, code: "journal_disabled", message: "the frame journal is disabled"}
+
+func (s *Server) journalRoutes() {
+	s.mux.HandleFunc("GET /admin/v1/journal", s.journalStatus)
+	s.mux.HandleFunc("PUT /admin/v1/journal/devices/{imei}", s.enableJournal)
+	s.mux.HandleFunc("DELETE /admin/v1/journal/devices/{imei}", s.disableJournal)
+}
+
+func (s *Server) journalStatus(w http.ResponseWriter, r *http.Request) {
+	if s.svc.Journal == nil {
+		writeError(w, r, errJournalDisabled)
+		return
+	}
+	writeJSON(w, http.StatusOK, JournalStatus{AllDevices: s.svc.Journal.AllDevices(), Devices: s.svc.Journal.Devices()})
+}
+
+// enableJournal journals the frames of a device until it is disabled or the server restarts
+func (s *Server) enableJournal(w http.ResponseWriter, r *http.Request) {
+	if s.svc.Journal == nil {
+		writeError(w, r, errJournalDisabled)
+		return
+	}
+	s.svc.Journal.EnableDevice(r.PathValue("imei"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) disableJournal(w http.ResponseWriter, r *http.Request) {
+	imei := r.PathValue("imei")
+
+	if s.svc.Journal == nil {
+		writeError(w, r, errJournalDisabled)
+		return
+	}
+	if !s.svc.Journal.DisableDevice(imei) {
+		writeError(w, r, errNotFound("journal of device %s is not enabled", imei))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"gt06/database"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+type paging struct {
+	skip  int64
+	limit int64
+}
+
+// parsePaging reads skip and limit, limit defaults to 100 and is capped at 1000
+func parsePaging(r *http.Request) (paging, error) {
+	p := paging{limit: defaultLimit}
+
+	if v := r.URL.Query().Get("skip"); v != "" {
+		skip, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || skip < 0 {
+			return p, errBadRequest("skip must be a non-negative integer")
+		}
+		p.skip = skip
+	}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || limit <= 0 || limit > maxLimit {
+			return p, errBadRequest("limit must be between 1 and %d", maxLimit)
+		}
+		p.limit = limit
+	}
+	return p, nil
+}
+
+// parseQuery reads the time range, order and paging of a time-series query. The limit is
+// raised by one so that writePage can tell whether another page follows.
+func parseQuery(r *http.Request, imei string) (database.Query, paging, error) {
+	p, err := parsePaging(r)
+	if err != nil {
+		return database.Query{}, p, err
+	}
+
+	q := database.Query{IMEI: imei, Skip: p.skip, Limit: p.limit + 1}
+	if q.From, err = parseTime(r, "from"); err != nil {
+		return q, p, err
+	}
+	if q.To, err = parseTime(r, "to"); err != nil {
+		return q, p, err
+	}
+	if !q.From.IsZero() && !q.To.IsZero() && !q.From.Before(q.To) {
+		return q, p, errBadRequest("from must be before to")
+	}
+
+	switch r.URL.Query().Get("order") {
+	case "", "desc":
+	case "asc":
+		q.Ascending = true
+	default:
+		return q, p, errBadRequest("order must be asc or desc")
+	}
+
+	return q, p, nil
+}
+
+// parseTime reads an RFC 3339 time or unix seconds
+func parseTime(r *http.Request, name string) (time.Time, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Time{}, errBadRequest("%s must be an RFC 3339 time or unix seconds", name)
+}
+
+// parseArea reads near=lat,lng,meters or bbox=south,west,north,east, nil when neither is set
+func parseArea(r *http.Request) (database.Area, error) {
+	area, err := readArea(r)
+	if err != nil || area == nil {
+		return nil, err
+	}
+	if err := area.Validate(); err != nil {
+		return nil, errBadRequest("%v", err)
+	}
+	return area, nil
+}
+
+func readArea(r *http.Request) (database.Area, error) {
+	near, bbox := r.URL.Query().Get("near"), r.URL.Query().Get("bbox")
+	switch {
+	case near != "" && bbox != "":
+		return nil, errBadRequest("near and bbox cannot be combined")
+
+	case near != "":
+		v, err := parseFloats(near, 3)
+		if err != nil || v[2] <= 0 {
+			return nil, errBadRequest("near must be latitude,longitude,meters")
+		}
+		return database.Circle{Center: database.LatLng{Latitude: v[0], Longitude: v[1]}, Radius: v[2]}, nil
+
+	case bbox != "":
+		v, err := parseFloats(bbox, 4)
+		if err != nil {
+			return nil, errBadRequest("bbox must be south,west,north,east")
+		}
+		return database.Box{
+			SouthWest: database.LatLng{Latitude: v[0], Longitude: v[1]},
+			NorthEast: database.LatLng{Latitude: v[2], Longitude: v[3]},
+		}, nil
+	}
+	return nil, nil
+}
+
+func parseFloats(s string, n int) ([]float64, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != n {
+		return nil, strconv.ErrSyntax
+	}
+
+	values := make([]float64, n)
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// parseIMEIs reads a comma separated imei list
+func parseIMEIs(r *http.Request) []string {
+	return splitList(r.URL.Query().Get("imei"))
+}
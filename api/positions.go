@@ -0,0 +1,129 @@
+package api
+
+import (
+	"gt06/database"
+	"net/http"
+	"time"
+)
+
+// LatestPosition is the last known fix of a device
+type LatestPosition struct {
+	IMEI     string                  `json:"imei"`
+	Online   bool                    `json:"online"`
+	LastSeen time.Time               `json:"last_seen"`
+	Position *database.StatePosition `json:"position"`
+}
+
+func (s *Server) devicePositions(w http.ResponseWriter, r *http.Request) {
+	s.writePositions(w, r, r.PathValue("imei"))
+}
+
+// findPositions searches positions of any device, optionally within an area
+func (s *Server) findPositions(w http.ResponseWriter, r *http.Request) {
+	imeis := parseIMEIs(r)
+	if len(imeis) > 1 {
+		writeError(w, r, errBadRequest("positions can be filtered by a single imei"))
+		return
+	}
+
+	imei := ""
+	if len(imeis) == 1 {
+		imei = imeis[0]
+	}
+	s.writePositions(w, r, imei)
+}
+
+func (s *Server) writePositions(w http.ResponseWriter, r *http.Request, imei string) {
+	q, p, err := parseQuery(r, imei)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	area, err := parseArea(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var positions []*database.Position
+	if area != nil {
+		positions, err = s.svc.PositionRepository.FindWithin(r.Context(), q, area)
+	} else {
+		positions, err = s.svc.PositionRepository.Find(r.Context(), q)
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writePage(w, positions, p)
+}
+
+func (s *Server) deviceLatestPosition(w http.ResponseWriter, r *http.Request) {
+	imei := r.PathValue("imei")
+
+	position, err := s.svc.PositionRepository.FindLatest(r.Context(), imei)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if position == nil {
+		writeError(w, r, errNotFound("no position of device %s", imei))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, position)
+}
+
+// latestPositions answers from the device states, for the listed devices or the whole fleet
+func (s *Server) latestPositions(w http.ResponseWriter, r *http.Request) {
+	p, err := parsePaging(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var states []*database.DeviceState
+	if imeis := parseIMEIs(r); len(imeis) > 0 {
+		for _, imei := range imeis {
+			state, err := s.svc.DeviceStateRepository.Get(r.Context(), imei)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			if state != nil {
+				states = append(states, state)
+			}
+		}
+		states = paginate(states, p.skip, p.limit+1)
+	} else {
+		states, err = s.svc.DeviceStateRepository.List(r.Context(), p.skip, p.limit+1)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+
+	latest := make([]LatestPosition, 0, len(states))
+	for _, state := range states {
+		latest = append(latest, LatestPosition{
+			IMEI:     state.IMEI,
+			Online:   state.Online,
+			LastSeen: state.LastSeen,
+			Position: state.Position,
+		})
+	}
+
+	writePage(w, latest, p)
+}
+
+func paginate[T any](items []T, skip, limit int64) []T {
+	if skip >= int64(len(items)) {
+		return items[:0]
+	}
+	items = items[skip:]
+	if limit < int64(len(items)) {
+		items = items[:limit]
+	}
+	return items
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gt06/database"
+	"net/http"
+
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+// ErrorBody is the shape of every error response
+type ErrorBody struct {
+	Error ErrorDetail `json:"error"`
+}
+
+// ErrorDetail describes an error, Code is stable and meant for programs
+type ErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Page is the shape of every list response. HasMore tells whether another page follows.
+type Page[T any] struct {
+	Items   []T   `json:"items"`
+	Skip    int64 `json:"skip"`
+	Limit   int64 `json:"limit"`
+	HasMore bool  `json:"has_more"`
+}
+
+// apiError is an error with its HTTP status and code
+type apiError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+func errBadRequest(format string, args ...any) error {
+	return &apiError{status: http.StatusBadRequest, code: "bad_request", message: fmt.Sprintf(format, args...)}
+}
+
+func errUnavailable(format string, args ...any) error {
+	return &apiError{status: http.StatusServiceUnavailable, code: "unavailable", message: fmt.Sprintf(format, args...)}
+}
+
+func errNotFound(format string, args ...any) error {
+	return &apiError{status: http.StatusNotFound, code: "not_found", message: fmt.Sprintf(format, args...)}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logx.Errorf("Failed to write response: %v", err)
+	}
+}
+
+// writeError answers with the status and code of err, unexpected errors are logged and not exposed
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var apiErr *apiError
+	switch {
+	case errors.As(err, &apiErr):
+	case errors.Is(err, database.ErrGeoNotSupported):
+		apiErr = &apiError{status: http.StatusNotImplemented, code: "not_supported", message: err.Error()}
+	case errors.Is(err, database.ErrIMEIRequired), errors.Is(err, database.ErrInvalidArea):
+		apiErr = &apiError{status: http.StatusBadRequest, code: "bad_request", message: err.Error()}
+	default:
+		logx.WithContext(r.Context()).Errorf("%s %s failed: %v", r.Method, r.URL.Path, err)
+		apiErr = &apiError{status: http.StatusInternalServerError, code: "internal", message: "internal error"}
+	}
+
+	writeJSON(w, apiErr.status, ErrorBody{Error: ErrorDetail{Code: apiErr.code, Message: apiErr.message}})
+}
+
+// writePage answers with one page of items, fetched with one extra item to know whether more follow
+func writePage[T any](w http.ResponseWriter, items []T, p paging) {
+	page := Page[T]{Items: items, Skip: p.skip, Limit: p.limit}
+	if int64(len(items)) > p.limit {
+		page.Items = items[:p.limit]
+		page.HasMore = true
+	}
+	writeJSON(w, http.StatusOK, page)
+}
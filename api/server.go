@@ -0,0 +1,104 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"gt06/config"
+	"gt06/services/svc"
+	"net/http"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/logx"
+	"github.com/zeromicro/go-zero/core/threading"
+)
+
+// Server is the HTTP API served next to the GT06 listener
+type Server struct {
+	name   string
+	svc    *svc.ServiceContext
+	conf   config.APIConf
+	server *http.Server
+	mux    *http.ServeMux
+
+	// sessions is only set on the admin API
+	sessions SessionManager
+}
+
+// NewServer builds the API server of svc, it does not listen until Start
+func NewServer(c config.APIConf, svc *svc.ServiceContext) *Server {
+	if c.KeepAlive <= 0 {
+		c.KeepAlive = 15
+	}
+
+	s := newServer("HTTP API", c, svc)
+	s.routes()
+	return s
+}
+
+func newServer(name string, c config.APIConf, svc *svc.ServiceContext) *Server {
+	s := &Server{
+		name: name,
+		svc:  svc,
+		conf: c,
+		mux:  http.NewServeMux(),
+	}
+
+	s.server = &http.Server{
+		Addr:              c.Addr,
+		Handler:           s.mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		WriteTimeout:      time.Duration(c.Timeout) * time.Second,
+	}
+	return s
+}
+
+func (s *Server) routes() {
+	// the fleet data needs an operator token as much as the commands do, only health is public
+	operators := s.svc.Config.Commands.Operators
+	handle := func(pattern string, handler http.HandlerFunc) {
+		s.mux.Handle(pattern, requireOperator(operators, handler))
+	}
+
+	handle("GET /api/v1/devices", s.listDevices)
+	handle("GET /api/v1/devices/{imei}", s.getDevice)
+	handle("GET /api/v1/devices/{imei}/positions", s.devicePositions)
+	handle("GET /api/v1/devices/{imei}/positions/latest", s.deviceLatestPosition)
+	handle("POST /api/v1/devices/{imei}/commands", s.sendCommand)
+	handle("GET /api/v1/devices/{imei}/commands", s.deviceCommands)
+	handle("GET /api/v1/commands/{id}", s.getCommand)
+	handle("GET /api/v1/positions", s.findPositions)
+	handle("GET /api/v1/positions/latest", s.latestPositions)
+	handle("GET /api/v1/alarms", s.findAlarms)
+	handle("GET /api/v1/stream", s.stream)
+	s.healthRoutes()
+	s.mux.HandleFunc("/", s.notFound)
+}
+
+func (s *Server) notFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, errNotFound("no route for %s %s", r.Method, r.URL.Path))
+}
+
+// Handle registers an additional handler, used by features that live outside this package
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// Start listens in the background
+func (s *Server) Start() {
+	threading.GoSafe(func() {
+		logx.Infof("Starting %s on %s", s.name, s.conf.Addr)
+		if err := s.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logx.Errorf("%s stopped: %v", s.name, err)
+		}
+	})
+}
+
+// Stop shuts the server down, waiting for the running requests
+func (s *Server) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := s.server.Shutdown(ctx); err != nil {
+		logx.Errorf("Failed to stop %s: %v", s.name, err)
+	}
+}
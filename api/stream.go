@@ -0,0 +1,142 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gt06/events"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// stream sends the live events matching the query as Server-Sent Events until the client leaves
+func (s *Server) stream(w http.ResponseWriter, r *http.Request) {
+	filter, err := s.parseFilter(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	sub, err := s.svc.Events.Subscribe(filter)
+	if err != nil {
+		writeError(w, r, errUnavailable("%v", err))
+		return
+	}
+	defer sub.Close()
+
+	// the write timeout of the server bounds requests, not streams
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(time.Duration(s.conf.KeepAlive) * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+
+		case e, ok := <-sub.Events():
+			if !ok {
+				writeStreamError(w, sub.Err())
+				rc.Flush()
+				return
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Seq, e.Type, data)
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// parseFilter reads the imei, group, type and area parameters of a stream
+func (s *Server) parseFilter(r *http.Request) (events.Filter, error) {
+	var filter events.Filter
+
+	imeis := parseIMEIs(r)
+	for _, group := range splitList(r.URL.Query().Get("group")) {
+		members, ok := s.svc.Events.Group(group)
+		if !ok {
+			return filter, errBadRequest("unknown group %s", group)
+		}
+		imeis = append(imeis, members...)
+	}
+	if len(imeis) > 0 {
+		filter.IMEIs = make(map[string]struct{}, len(imeis))
+		for _, imei := range imeis {
+			filter.IMEIs[imei] = struct{}{}
+		}
+	} else if r.URL.Query().Has("group") {
+		// an empty group selects no device rather than every device
+		filter.IMEIs = map[string]struct{}{"": {}}
+	}
+
+	for _, name := range splitList(r.URL.Query().Get("type")) {
+		t := events.Type(name)
+		if !t.Known() {
+			return filter, errBadRequest("unknown event type %s", name)
+		}
+		if filter.Types == nil {
+			filter.Types = make(map[events.Type]struct{})
+		}
+		filter.Types[t] = struct{}{}
+	}
+
+	area, err := parseArea(r)
+	if err != nil {
+		return filter, err
+	}
+	filter.Area = area
+
+	return filter, nil
+}
+
+func writeStreamError(w http.ResponseWriter, err error) {
+	code := "closed"
+	if errors.Is(err, events.ErrSlowConsumer) {
+		code = "slow_consumer"
+	}
+	message := "stream closed"
+	if err != nil {
+		message = err.Error()
+	}
+
+	data, _ := json.Marshal(ErrorBody{Error: ErrorDetail{Code: code, Message: message}})
+	fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+}
+
+func splitList(v string) []string {
+	if v == "" {
+		return nil
+	}
+
+	items := make([]string, 0)
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"errors"
+	"gt06/webhook"
+	"net/http"
+)
+
+// DeadLetters lists the oldest dead letters of a webhook endpoint, Total counts them all
+type DeadLetters struct {
+	Items []*webhook.Delivery `json:"items"`
+	Total int                 `json:"total"`
+}
+
+// WebhookResult counts the dead letters redelivered or purged
+type WebhookResult struct {
+	Count int `json:"count"`
+}
+
+var errWebhooksDisabled = &apiError{status: http.StatusConflict, code: "webhooks_disabled", message: "webhooks are disabled"}
+
+func (s *Server) webhookRoutes() {
+	s.mux.HandleFunc("GET /admin/v1/webhooks", s.listWebhooks)
+	s.mux.HandleFunc("GET /admin/v1/webhooks/{name}/dead-letters", s.listDeadLetters)
+	s.mux.HandleFunc("POST /admin/v1/webhooks/{name}/dead-letters/redeliver", s.redeliverDeadLetters)
+	s.mux.HandleFunc("DELETE /admin/v1/webhooks/{name}/dead-letters", s.purgeDeadLetters)
+}
+
+func (s *Server) listWebhooks(w http.ResponseWriter, r *http.Request) {
+	if s.svc.Webhooks == nil {
+		writeError(w, r, errWebhooksDisabled)
+		return
+	}
+	endpoints, err := s.svc.Webhooks.Endpoints()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, endpoints)
+}
+
+func (s *Server) listDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if s.svc.Webhooks == nil {
+		writeError(w, r, errWebhooksDisabled)
+		return
+	}
+	p, err := parsePaging(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	name := r.PathValue("name")
+	all, err := s.svc.Webhooks.DeadLetters(name, 0)
+	if err != nil {
+		writeError(w, r, webhookError(err, name))
+		return
+	}
+	items := all[min(p.skip, int64(len(all))):]
+	items = items[:min(p.limit, int64(len(items)))]
+	writeJSON(w, http.StatusOK, DeadLetters{Items: items, Total: len(all)})
+}
+
+// redeliverDeadLetters queues the dead letters of an endpoint again
+func (s *Server) redeliverDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if s.svc.Webhooks == nil {
+		writeError(w, r, errWebhooksDisabled)
+		return
+	}
+	name := r.PathValue("name")
+	queued, err := s.svc.Webhooks.Redeliver(name)
+	if err != nil {
+		writeError(w, r, webhookError(err, name))
+		return
+	}
+	writeJSON(w, http.StatusAccepted, WebhookResult{Count: queued})
+}
+
+func (s *Server) purgeDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if s.svc.Webhooks == nil {
+		writeError(w, r, errWebhooksDisabled)
+		return
+	}
+	name := r.PathValue("name")
+	purged, err := s.svc.Webhooks.Purge(name)
+	if err != nil {
+		writeError(w, r, webhookError(err, name))
+		return
+	}
+	writeJSON(w, http.StatusOK, WebhookResult{Count: purged})
+}
+
+func webhookError(err error, name string) error {
+	if errors.Is(err, webhook.ErrUnknownEndpoint) {
+		return errNotFound("webhook endpoint %s does not exist", name)
+	}
+	return err
+}
@@ -59,8 +59,8 @@ def build_login_packet(imei, model_code, time_zone, language, serial_number):
     Returns:
         bytes: The constructed login packet.
     """
-    # Convert IMEI to 8-byte Terminal ID
-    imei_bytes = struct.pack('>Q', int(imei))[-8:]
+    # Convert IMEI to 8-byte BCD Terminal ID (padded with a leading zero)
+    imei_bytes = bytes.fromhex(imei.rjust(16, '0'))
 
     # Time zone and language calculation
     time_zone_hex = (time_zone * 100) & 0xFFF  # Encode time zone as hex
@@ -0,0 +1,246 @@
+// Package decode implements the decode subcommand, which explains GT06 frames given in hex
+// field by field, for the support engineers reading device logs.
+package decode
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"gt06/protocol"
+	"io"
+	"os"
+	"strings"
+)
+
+const usage = `Usage: gt06 decode [flags] [hex ...]
+
+Decodes GT06 frames given in hex: the arguments, joined, or every line of -f or of the
+standard input when no argument is given. Spaces, commas, colons, 0x prefixes and #
+comments are ignored. The framing and the CRC are checked and every field is printed with
+its offset, its bytes and its meaning, down to the bits of the terminal information, the
+course and status and the time zone. The command fails when a frame is invalid.
+
+Flags:
+`
+
+// maxRawBytes are printed in the bytes column of the text output, longer fields are cut
+const maxRawBytes = 8
+
+// Run runs the decode subcommand with its arguments
+func Run(args []string) error {
+	fs := flag.NewFlagSet("decode", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+
+	file := fs.String("f", "", "file of hex frames, one input per line, - for the standard input")
+	asJSON := fs.Bool("json", false, "print every frame as a JSON document")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	p := &printer{w: os.Stdout, json: *asJSON}
+	switch {
+	case *file != "" && fs.NArg() > 0:
+		return fmt.Errorf("give the hex either as arguments or with -f")
+	case fs.NArg() > 0:
+		data, err := parseHex(strings.Join(fs.Args(), " "))
+		if err != nil {
+			return err
+		}
+		if err := p.print(data); err != nil {
+			return err
+		}
+	case *file == "" || *file == "-":
+		if err := p.printLines(os.Stdin); err != nil {
+			return err
+		}
+	default:
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := p.printLines(f); err != nil {
+			return fmt.Errorf("%s: %w", *file, err)
+		}
+	}
+
+	if p.invalid > 0 {
+		return fmt.Errorf("%d of %d frames are invalid", p.invalid, p.frames)
+	}
+	return nil
+}
+
+// parseHex decodes hex digits, ignoring separators, 0x prefixes and # comments, and
+// reports a bad character with its column
+func parseHex(text string) ([]byte, error) {
+	text, _, _ = strings.Cut(text, "#")
+
+	digits := make([]byte, 0, len(text))
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == ' ' || c == '\t' || c == ',' || c == ':' || c == '\r' || c == '\n':
+		case c == '0' && i+1 < len(text) && (text[i+1] == 'x' || text[i+1] == 'X'):
+			i++
+		case ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F'):
+			digits = append(digits, c)
+		default:
+			return nil, fmt.Errorf("invalid hex character %q at column %d", c, i+1)
+		}
+	}
+	if len(digits)%2 != 0 {
+		return nil, fmt.Errorf("odd number of hex digits: %d", len(digits))
+	}
+
+	data := make([]byte, len(digits)/2)
+	_, err := hex.Decode(data, digits)
+	return data, err
+}
+
+// printer writes the decoded frames as text or JSON
+type printer struct {
+	w       io.Writer
+	json    bool
+	frames  int
+	invalid int
+}
+
+// printLines decodes every non empty line of r as an input of its own
+func (p *printer) printLines(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		data, err := parseHex(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if len(data) == 0 {
+			continue
+		}
+		if !p.json {
+			fmt.Fprintf(p.w, "# line %d\n", line)
+		}
+		if err := p.print(data); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func (p *printer) print(data []byte) error {
+	for _, f := range protocol.Inspect(data) {
+		p.frames++
+		if !f.Valid {
+			p.invalid++
+		}
+
+		if p.json {
+			enc := json.NewEncoder(p.w)
+			enc.SetEscapeHTML(false)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(f); err != nil {
+				return err
+			}
+			continue
+		}
+		printFrame(p.w, f)
+	}
+	return nil
+}
+
+func printFrame(w io.Writer, f *protocol.InspectedFrame) {
+	status := "valid"
+	if !f.Valid {
+		status = fmt.Sprintf("%d errors", len(f.Errors))
+		if len(f.Errors) == 1 {
+			status = "1 error"
+		}
+	}
+	kind := "not a frame"
+	switch {
+	case f.Protocol != "":
+		kind = fmt.Sprintf("%s (%s)", f.Protocol, f.ProtocolNumber)
+	case len(f.Fields) > 0:
+		kind = "incomplete frame"
+	}
+	fmt.Fprintf(w, "frame at offset %d, %d bytes, %s: %s\n", f.Offset, f.Length, kind, status)
+
+	for _, field := range f.Fields {
+		printField(w, field, 1)
+	}
+	for _, e := range f.Errors {
+		fmt.Fprintf(w, "  error at offset %d: %s\n", e.Offset, e.Message)
+	}
+	fmt.Fprintln(w)
+}
+
+func printField(w io.Writer, field protocol.Field, depth int) {
+	position := fmt.Sprint(field.Offset)
+	raw := spacedHex(field.Raw)
+	if field.Bits != "" {
+		position = "bit " + field.Bits
+		raw = field.Raw
+	}
+
+	name := strings.Repeat("  ", depth-1) + strings.ReplaceAll(field.Name, "_", " ")
+	line := fmt.Sprintf("  %-9s %-26s %-28s %s", position, raw, name, describe(field))
+	fmt.Fprintln(w, strings.TrimRight(line, " "))
+
+	for _, bit := range field.Fields {
+		printField(w, bit, depth+1)
+	}
+}
+
+// describe returns the value of a field and its meaning
+func describe(field protocol.Field) string {
+	value := ""
+	switch v := field.Value.(type) {
+	case nil:
+	case bool:
+		// flags are described by their meaning
+	case float64:
+		value = fmt.Sprintf("%.6f", v)
+	case uint8, uint64:
+		value = fmt.Sprint(v)
+		if len(field.Fields) > 0 && field.Length > 0 {
+			// words split in bits read better in hex
+			value = fmt.Sprintf("0x%0*X", 2*field.Length, v)
+		}
+	default:
+		value = fmt.Sprint(v)
+	}
+
+	switch {
+	case field.Text == "" || field.Text == value:
+		return value
+	case value == "":
+		return field.Text
+	default:
+		return fmt.Sprintf("%s (%s)", value, field.Text)
+	}
+}
+
+// spacedHex splits hex into bytes, cutting it after maxRawBytes
+func spacedHex(raw string) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(raw); i += 2 {
+		if i/2 == maxRawBytes {
+			b.WriteString(" ..")
+			break
+		}
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(raw[i : i+2])
+	}
+	return b.String()
+}
@@ -0,0 +1,210 @@
+package replay
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// link types of the classic pcap format
+const (
+	linkNull     = 0
+	linkEthernet = 1
+	linkRaw      = 101
+	linkLoop     = 108
+	linkLinuxSLL = 113
+	linkSLL2     = 276
+)
+
+const (
+	tcpFIN = 0x01
+	tcpSYN = 0x02
+	tcpRST = 0x04
+)
+
+func isPcapMagic(head []byte) bool {
+	switch binary.LittleEndian.Uint32(head) {
+	case 0xa1b2c3d4, 0xd4c3b2a1, 0xa1b23c4d, 0x4d3cb2a1:
+		return true
+	}
+	return false
+}
+
+// flow is the device side of a captured TCP connection
+type flow struct {
+	next  uint32 // next expected sequence number
+	split splitter
+}
+
+// readPcap emits the frames sent to port in a classic pcap capture, reassembling the TCP streams.
+// Frames lost to the capture are skipped.
+func readPcap(r io.Reader, port int, emit emitFunc) error {
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return fmt.Errorf("invalid pcap header: %w", err)
+	}
+
+	var order binary.ByteOrder = binary.LittleEndian
+	nano := false
+	switch binary.LittleEndian.Uint32(header) {
+	case 0xa1b2c3d4:
+	case 0xa1b23c4d:
+		nano = true
+	case 0xd4c3b2a1:
+		order = binary.BigEndian
+	case 0x4d3cb2a1:
+		order, nano = binary.BigEndian, true
+	default:
+		return errors.New("not a pcap file")
+	}
+	linkType := order.Uint32(header[20:24]) & 0x0fffffff
+
+	flows := make(map[string]*flow)
+	record := make([]byte, 16)
+	for {
+		if _, err := io.ReadFull(r, record); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("truncated pcap record: %w", err)
+		}
+
+		sec, frac := order.Uint32(record[0:4]), order.Uint32(record[4:8])
+		at := time.Unix(int64(sec), int64(frac)*1000)
+		if nano {
+			at = time.Unix(int64(sec), int64(frac))
+		}
+
+		packet := make([]byte, order.Uint32(record[8:12]))
+		if _, err := io.ReadFull(r, packet); err != nil {
+			return fmt.Errorf("truncated pcap record: %w", err)
+		}
+
+		src, dst, segment, ok := parseLink(linkType, packet)
+		if !ok || len(segment) < 20 {
+			continue
+		}
+
+		srcPort := binary.BigEndian.Uint16(segment[0:2])
+		dstPort := binary.BigEndian.Uint16(segment[2:4])
+		if int(dstPort) != port {
+			continue
+		}
+		seq := binary.BigEndian.Uint32(segment[4:8])
+		offset := int(segment[12]>>4) * 4
+		flags := segment[13]
+		if offset < 20 || offset > len(segment) {
+			continue
+		}
+		payload := segment[offset:]
+
+		conn := net.JoinHostPort(src.String(), strconv.Itoa(int(srcPort))) + "->" + net.JoinHostPort(dst.String(), strconv.Itoa(int(dstPort)))
+		f := flows[conn]
+		if flags&tcpSYN != 0 {
+			f = &flow{next: seq + 1}
+			flows[conn] = f
+		}
+		if len(payload) > 0 {
+			if f == nil {
+				// the capture started in the middle of the connection
+				f = &flow{next: seq}
+				flows[conn] = f
+			}
+
+			switch diff := int32(seq - f.next); {
+			case diff > 0:
+				// bytes missing from the capture, the partial frame cannot be completed
+				f.split.buf = f.split.buf[:0]
+			case diff < 0:
+				// retransmission of bytes already seen
+				if int(-diff) >= len(payload) {
+					payload = nil
+				} else {
+					payload = payload[-diff:]
+				}
+			}
+			if len(payload) > 0 {
+				f.next = seq + uint32(len(segment[offset:]))
+				err := f.split.write(payload, func(data []byte) error {
+					return emit(&frame{at: at, conn: conn, data: data})
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if flags&(tcpFIN|tcpRST) != 0 {
+			delete(flows, conn)
+		}
+	}
+}
+
+// parseLink returns the addresses and the TCP segment of a captured packet
+func parseLink(linkType uint32, packet []byte) (src, dst net.IP, segment []byte, ok bool) {
+	var ethertype uint16
+	switch linkType {
+	case linkEthernet:
+		if len(packet) < 14 {
+			return nil, nil, nil, false
+		}
+		ethertype, packet = binary.BigEndian.Uint16(packet[12:14]), packet[14:]
+		if ethertype == 0x8100 && len(packet) >= 4 {
+			ethertype, packet = binary.BigEndian.Uint16(packet[2:4]), packet[4:]
+		}
+	case linkLinuxSLL:
+		if len(packet) < 16 {
+			return nil, nil, nil, false
+		}
+		ethertype, packet = binary.BigEndian.Uint16(packet[14:16]), packet[16:]
+	case linkSLL2:
+		if len(packet) < 20 {
+			return nil, nil, nil, false
+		}
+		ethertype, packet = binary.BigEndian.Uint16(packet[0:2]), packet[20:]
+	case linkNull, linkLoop:
+		if len(packet) < 4 {
+			return nil, nil, nil, false
+		}
+		packet = packet[4:]
+	case linkRaw:
+	default:
+		return nil, nil, nil, false
+	}
+
+	if ethertype != 0 && ethertype != 0x0800 && ethertype != 0x86DD {
+		return nil, nil, nil, false
+	}
+	if len(packet) == 0 {
+		return nil, nil, nil, false
+	}
+
+	switch packet[0] >> 4 {
+	case 4:
+		if len(packet) < 20 {
+			return nil, nil, nil, false
+		}
+		headerLength := int(packet[0]&0x0f) * 4
+		totalLength := int(binary.BigEndian.Uint16(packet[2:4]))
+		fragment := binary.BigEndian.Uint16(packet[6:8])
+		// fragments are not reassembled, GT06 frames fit one segment
+		if packet[9] != 6 || fragment&0x3fff != 0 || headerLength < 20 || totalLength < headerLength || totalLength > len(packet) {
+			return nil, nil, nil, false
+		}
+		return net.IP(packet[12:16]), net.IP(packet[16:20]), packet[headerLength:totalLength], true
+	case 6:
+		if len(packet) < 40 {
+			return nil, nil, nil, false
+		}
+		payloadLength := int(binary.BigEndian.Uint16(packet[4:6]))
+		// extension headers are not followed
+		if packet[6] != 6 || 40+payloadLength > len(packet) {
+			return nil, nil, nil, false
+		}
+		return net.IP(packet[8:24]), net.IP(packet[24:40]), packet[40 : 40+payloadLength], true
+	}
+	return nil, nil, nil, false
+}
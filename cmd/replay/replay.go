@@ -0,0 +1,207 @@
+// Package replay implements the replay subcommand, which feeds captured device traffic
+// through the decoder and the packet services to reproduce bugs or backfill data.
+package replay
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"gt06/conf"
+	"gt06/config"
+	"gt06/services/svc"
+	"gt06/sinks"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+const (
+	targetLocal = "local"
+	targetTCP   = "tcp"
+)
+
+const usage = `Usage: gt06 replay [flags] [file|dir ...]
+
+Replays the frames sent by devices, read from journal files or directories,
+hex dumps or pcap captures, or the standard input when no file is given.
+
+Flags:
+`
+
+// Run runs the replay subcommand with its arguments
+func Run(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+
+	configFile := fs.String("c", "etc/server.yaml", "the config file of the services, for the local target")
+	format := fs.String("format", formatAuto, "input format: auto, journal, hex or pcap")
+	targetName := fs.String("target", targetLocal, "local processes the frames in this process, tcp sends them to -addr")
+	addr := fs.String("addr", "127.0.0.1:8000", "address of the server of the tcp target")
+	speed := fs.Float64("speed", 0, "pace relative to the capture, 1 is real time, 0 is as fast as possible")
+	imeis := fs.String("imei", "", "comma separated IMEIs to replay, all devices when empty")
+	port := fs.Int("port", 8000, "server port of the pcap captures, the frames sent to it are replayed")
+	dryRun := fs.Bool("dry-run", false, "decode the frames and print them as JSON lines instead of replaying them")
+	wait := fs.Duration("wait", 2*time.Second, "time to wait for the last acks of the tcp target")
+	verbose := fs.Bool("v", false, "log the services")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	// logs go to stderr, the dry run writes to stdout
+	logx.DisableStat()
+	logx.SetWriter(logx.NewWriter(os.Stderr))
+	if !*verbose {
+		logx.SetLevel(logx.ErrorLevel)
+	}
+
+	st := &stats{}
+	var t target
+	switch {
+	case *dryRun:
+		t = newDryRun(os.Stdout, st)
+	case *targetName == targetTCP:
+		t = newRemote(*addr, *wait, st)
+	case *targetName == targetLocal:
+		c := config.Default()
+		if err := conf.Load(*configFile, &c); err != nil {
+			return err
+		}
+		// the spool, the journal and the event outputs belong to the server process, replayed
+		// traffic must not reach the consumers of its events a second time
+		c.Spool.Enabled = false
+		c.Journal.Enabled = false
+		c.Webhooks.Enabled = false
+		c.Sinks = sinks.DefaultConf()
+		svcCtx := svc.NewServiceContext(c)
+		svcCtx.Publisher = svc.Discard
+		t = newLocal(svcCtx, st)
+	default:
+		return fmt.Errorf("unknown target %q", *targetName)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	r := &replayer{
+		ctx:    ctx,
+		target: t,
+		stats:  st,
+		filter: newFilter(*imeis),
+		pacer:  pacer{speed: *speed},
+	}
+
+	inputs := fs.Args()
+	if len(inputs) == 0 {
+		inputs = []string{"-"}
+	}
+
+	var err error
+	for _, input := range inputs {
+		if err = readInput(input, *format, *port, r.replay); err != nil {
+			break
+		}
+	}
+	t.close()
+
+	fmt.Fprintf(os.Stderr, "replay: %s\n", st)
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+// replayer paces and filters the frames before handing them to the target
+type replayer struct {
+	ctx    context.Context
+	target target
+	stats  *stats
+	filter *filter
+	pacer  pacer
+}
+
+func (r *replayer) replay(f *frame) error {
+	if err := r.ctx.Err(); err != nil {
+		return err
+	}
+
+	r.stats.read++
+	if !r.filter.match(f) {
+		r.stats.filtered++
+		return nil
+	}
+
+	if err := r.pacer.wait(r.ctx, f.at); err != nil {
+		return err
+	}
+	return r.target.send(f)
+}
+
+// filter keeps the frames of the selected devices, the IMEI of a connection being learned from its login
+type filter struct {
+	imeis map[string]bool
+	conns map[string]string
+}
+
+func newFilter(imeis string) *filter {
+	f := &filter{imeis: make(map[string]bool), conns: make(map[string]string)}
+	for _, imei := range strings.Split(imeis, ",") {
+		if imei = strings.TrimSpace(imei); imei != "" {
+			f.imeis[imei] = true
+		}
+	}
+	return f
+}
+
+func (f *filter) match(fr *frame) bool {
+	if imei, ok := loginIMEI(fr.data); ok {
+		fr.imei = imei
+	}
+	if fr.imei == "" {
+		fr.imei = f.conns[fr.conn]
+	} else {
+		f.conns[fr.conn] = fr.imei
+	}
+	return len(f.imeis) == 0 || f.imeis[fr.imei]
+}
+
+// pacer delays the frames to reproduce the timing of the capture
+type pacer struct {
+	speed float64
+	first time.Time // capture time of the first frame
+	start time.Time // when the first frame was replayed
+}
+
+func (p *pacer) wait(ctx context.Context, at time.Time) error {
+	if p.speed <= 0 || at.IsZero() {
+		return nil
+	}
+	if p.first.IsZero() {
+		p.first, p.start = at, time.Now()
+		return nil
+	}
+
+	delay := time.Until(p.start.Add(time.Duration(float64(at.Sub(p.first)) / p.speed)))
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
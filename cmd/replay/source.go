@@ -0,0 +1,247 @@
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gt06/journal"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	formatAuto    = "auto"
+	formatJournal = "journal"
+	formatHex     = "hex"
+	formatPcap    = "pcap"
+)
+
+// frame is one frame sent by a device
+type frame struct {
+	at   time.Time // zero when the source has no timestamps
+	imei string    // empty until the connection logged in
+	conn string    // connection the frame was sent on
+	data []byte
+}
+
+type emitFunc func(f *frame) error
+
+var startBits = []byte{0x78, 0x78}
+
+// readInput emits the frames sent by the devices in name, "-" being the standard input.
+// A directory is read as the journal files it holds, oldest first.
+func readInput(name, format string, port int, emit emitFunc) error {
+	if name != "-" {
+		info, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			files, err := journal.Files(name)
+			if err != nil {
+				return err
+			}
+			for _, file := range files {
+				if err := readInput(file, formatJournal, port, emit); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	br := bufio.NewReaderSize(r, 64<<10)
+	if format == formatAuto {
+		var err error
+		if format, err = sniff(br); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	var err error
+	switch format {
+	case formatJournal:
+		err = readJournal(br, emit)
+	case formatHex:
+		err = readHex(name, br, emit)
+	case formatPcap:
+		err = readPcap(br, port, emit)
+	default:
+		err = fmt.Errorf("unknown format %q", format)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// sniff guesses the format from the first bytes
+func sniff(br *bufio.Reader) (string, error) {
+	head, err := br.Peek(4)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+
+	if len(head) == 4 {
+		switch {
+		case bytes.Equal(head, []byte{0x0a, 0x0d, 0x0d, 0x0a}):
+			return "", errors.New("pcapng is not supported, convert it with: editcap -F pcap in.pcapng out.pcap")
+		case isPcapMagic(head):
+			return formatPcap, nil
+		}
+	}
+	if len(head) >= 2 && head[0] == 0x1f && head[1] == 0x8b {
+		return formatJournal, nil
+	}
+
+	// the first character that is not blank tells JSON lines from hex
+	for i := 1; ; i++ {
+		buf, err := br.Peek(i)
+		if len(buf) < i {
+			if err != nil && !errors.Is(err, io.EOF) {
+				return "", err
+			}
+			return formatHex, nil
+		}
+		switch buf[i-1] {
+		case ' ', '\t', '\r', '\n':
+			continue
+		case '{':
+			return formatJournal, nil
+		default:
+			return formatHex, nil
+		}
+	}
+}
+
+// readJournal emits the inbound frames of a journal file, gzipped or not
+func readJournal(br *bufio.Reader, emit emitFunc) error {
+	var r io.Reader = br
+	if head, _ := br.Peek(2); len(head) == 2 && head[0] == 0x1f && head[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+
+		var jf journal.Frame
+		if err := json.Unmarshal(text, &jf); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if jf.Direction != journal.DirectionIn {
+			continue
+		}
+		data, err := jf.Bytes()
+		if err != nil {
+			return fmt.Errorf("line %d: invalid data: %w", line, err)
+		}
+
+		if err := emit(&frame{at: jf.Time, imei: jf.IMEI, conn: jf.ConnectionID, data: data}); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// readHex emits the frames of a hex dump: the bytes of one connection, split in lines at will.
+// Bytes may be separated by blanks, commas or colons and prefixed by 0x, # starts a comment.
+func readHex(name string, br *bufio.Reader, emit emitFunc) error {
+	conn := name
+	if name == "-" {
+		conn = "stdin"
+	}
+
+	s := splitter{}
+	scanner := bufio.NewScanner(br)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+
+		var digits strings.Builder
+		for _, token := range strings.FieldsFunc(text, func(r rune) bool {
+			return r == ' ' || r == '\t' || r == ',' || r == ':' || r == '\r'
+		}) {
+			token = strings.TrimPrefix(strings.TrimPrefix(token, "0x"), "0X")
+			digits.WriteString(token)
+		}
+
+		data, err := hex.DecodeString(digits.String())
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+
+		err = s.write(data, func(data []byte) error {
+			return emit(&frame{conn: conn, data: data})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// splitter cuts a byte stream into frames by their length, skipping the bytes outside of a frame
+type splitter struct {
+	buf     []byte
+	skipped int
+}
+
+func (s *splitter) write(p []byte, emit func(data []byte) error) error {
+	s.buf = append(s.buf, p...)
+	for {
+		i := bytes.Index(s.buf, startBits)
+		if i < 0 {
+			// keep a trailing start byte, the next one may follow
+			keep := 0
+			if len(s.buf) > 0 && s.buf[len(s.buf)-1] == startBits[0] {
+				keep = 1
+			}
+			s.skipped += len(s.buf) - keep
+			s.buf = append(s.buf[:0], s.buf[len(s.buf)-keep:]...)
+			return nil
+		}
+		s.skipped += i
+		s.buf = s.buf[i:]
+
+		if len(s.buf) < 3 {
+			return nil
+		}
+		size := int(s.buf[2]) + 5
+		if len(s.buf) < size {
+			return nil
+		}
+
+		data := make([]byte, size)
+		copy(data, s.buf)
+		s.buf = s.buf[size:]
+		if err := emit(data); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package replay
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gt06/common"
+	"gt06/protocol"
+	"gt06/services"
+	"gt06/services/svc"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/logx"
+	"github.com/zeromicro/go-zero/core/proc"
+)
+
+// target receives the replayed frames
+type target interface {
+	send(f *frame) error // an error aborts the replay
+	close()
+}
+
+// stats counts the outcome of a replay
+type stats struct {
+	read     int64 // frames read from the input
+	filtered int64 // frames of other devices
+	sent     int64
+	invalid  int64        // frames that do not decode
+	skipped  int64        // frames of a connection closed by the server, until its next login
+	failed   atomic.Int64 // connections the server closed
+	acks     atomic.Int64
+}
+
+func (s *stats) String() string {
+	return fmt.Sprintf("read %d, filtered %d, sent %d, invalid %d, skipped %d, failed connections %d, acks %d",
+		s.read, s.filtered, s.sent, s.invalid, s.skipped, s.failed.Load(), s.acks.Load())
+}
+
+func isLogin(data []byte) bool {
+	return len(data) > 3 && data[3] == protocol.ProtocolLogin
+}
+
+// loginIMEI returns the IMEI of a valid login frame
+func loginIMEI(data []byte) (string, bool) {
+	if !isLogin(data) {
+		return "", false
+	}
+	packet, err := protocol.ParseAndValidatePacket(data)
+	if err != nil {
+		return "", false
+	}
+	login, err := protocol.ParseCONCOXLoginInfoContent(packet.Info())
+	if err != nil {
+		return "", false
+	}
+	return login.IMEI(), true
+}
+
+// dryRun prints the decoded frames as JSON lines
+type dryRun struct {
+	enc   *json.Encoder
+	stats *stats
+}
+
+// decodedFrame is a line of the dry run
+type decodedFrame struct {
+	Time           *time.Time `json:"ts,omitempty"`
+	IMEI           string     `json:"imei,omitempty"`
+	Conn           string     `json:"conn"`
+	Data           string     `json:"data"`
+	Protocol       string     `json:"protocol,omitempty"`
+	ProtocolNumber string     `json:"protocol_number,omitempty"`
+	Serial         *uint16    `json:"serial,omitempty"`
+	Content        any        `json:"content,omitempty"`
+	Error          string     `json:"error,omitempty"`
+}
+
+func newDryRun(w io.Writer, st *stats) *dryRun {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &dryRun{enc: enc, stats: st}
+}
+
+func (d *dryRun) send(f *frame) error {
+	out := decodedFrame{IMEI: f.imei, Conn: f.conn, Data: hex.EncodeToString(f.data)}
+	if !f.at.IsZero() {
+		out.Time = &f.at
+	}
+
+	// the frames are split by the reader, the inspection finds a single one
+	inspected := protocol.Inspect(f.data)[0]
+	out.Protocol = inspected.Protocol
+	out.ProtocolNumber = inspected.ProtocolNumber
+	out.Serial = inspected.Serial
+	out.Content = inspected.Content()
+	if err := inspected.Err(); err != nil {
+		d.stats.invalid++
+		out.Error = err.Error()
+	}
+
+	d.stats.sent++
+	return d.enc.Encode(out)
+}
+
+func (d *dryRun) close() {}
+
+// local processes the frames with the services of this process, as the GT06 listener does
+type local struct {
+	svc      *svc.ServiceContext
+	stats    *stats
+	sessions map[string]*replaySession
+}
+
+func newLocal(svcCtx *svc.ServiceContext, st *stats) *local {
+	return &local{svc: svcCtx, stats: st, sessions: make(map[string]*replaySession)}
+}
+
+func (l *local) send(f *frame) error {
+	packet, err := protocol.ParseAndValidatePacket(f.data)
+	if err != nil {
+		l.stats.invalid++
+		logx.Errorf("Invalid frame on %s: %v", f.conn, err)
+		return nil
+	}
+
+	session := l.sessions[f.conn]
+	if session == nil || session.failed.Load() {
+		if session != nil && packet.ProtocolNumber != protocol.ProtocolLogin {
+			l.stats.skipped++
+			return nil
+		}
+		session = newReplaySession(f.conn, l.stats)
+		l.sessions[f.conn] = session
+	}
+
+	l.stats.sent++
+	service := services.NewPacketService(session.ctx, l.svc, session, packet.ProtocolNumber)
+	if service == nil {
+		session.fail(fmt.Errorf("unknown protocol number 0x%02X", packet.ProtocolNumber))
+		return nil
+	}
+
+	out, err := service.ProcessPacket(packet)
+	if err != nil {
+		session.fail(err)
+		return nil
+	}
+	if out != nil {
+		session.Send(out)
+	}
+	return nil
+}
+
+// close writes the batched records and stops the background work of the services
+func (l *local) close() {
+	if l.svc.PositionWriter != nil {
+		l.svc.PositionWriter.Close()
+		l.svc.AlarmWriter.Close()
+	}
+	proc.Shutdown()
+}
+
+// replaySession is the session of a replayed connection, its acks are counted and dropped
+type replaySession struct {
+	id     string
+	ctx    context.Context
+	stats  *stats
+	failed atomic.Bool
+
+	mu   sync.Mutex
+	imei string
+}
+
+func newReplaySession(id string, st *stats) *replaySession {
+	ctx := logx.ContextWithFields(context.Background(), logx.LogField{Key: string(common.TraceID), Value: id})
+	return &replaySession{id: id, ctx: ctx, stats: st}
+}
+
+func (s *replaySession) ID() string {
+	return s.id
+}
+
+func (s *replaySession) IMEI() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.imei
+}
+
+func (s *replaySession) SetIMEI(imei string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.imei = imei
+}
+
+func (s *replaySession) SetModel(string) {}
+
+func (s *replaySession) RemoteAddr() string {
+	return "replay"
+}
+
+func (s *replaySession) Send(out []byte) error {
+	s.stats.acks.Add(1)
+	return nil
+}
+
+func (s *replaySession) Reply(out []byte, err error) {
+	if err != nil {
+		s.fail(err)
+		return
+	}
+	if len(out) > 0 {
+		s.Send(out)
+	}
+}
+
+func (s *replaySession) Replayed() {}
+
+// fail closes the session like the listener closes a connection, the device would log in again
+func (s *replaySession) fail(err error) {
+	if s.failed.CompareAndSwap(false, true) {
+		s.stats.failed.Add(1)
+		logx.WithContext(s.ctx).Errorf("Packet processing failed: %v", err)
+	}
+}
+
+// remote sends the frames to a running server, one TCP connection per replayed connection
+type remote struct {
+	addr  string
+	wait  time.Duration
+	stats *stats
+	conns map[string]*remoteConn
+	wg    sync.WaitGroup
+}
+
+type remoteConn struct {
+	conn   net.Conn
+	closed atomic.Bool
+}
+
+func newRemote(addr string, wait time.Duration, st *stats) *remote {
+	return &remote{addr: addr, wait: wait, stats: st, conns: make(map[string]*remoteConn)}
+}
+
+func (r *remote) send(f *frame) error {
+	c := r.conns[f.conn]
+	if c == nil || c.closed.Load() {
+		if c != nil && !isLogin(f.data) {
+			r.stats.skipped++
+			return nil
+		}
+
+		conn, err := net.DialTimeout("tcp", r.addr, 5*time.Second)
+		if err != nil {
+			return err
+		}
+		c = &remoteConn{conn: conn}
+		r.conns[f.conn] = c
+		r.wg.Add(1)
+		go r.readAcks(f.conn, c)
+	}
+
+	r.stats.sent++
+	if _, err := c.conn.Write(f.data); err != nil {
+		c.fail(r.stats)
+		logx.Errorf("Failed to send on %s: %v", f.conn, err)
+	}
+	return nil
+}
+
+// readAcks counts the frames answered by the server until it closes the connection
+func (r *remote) readAcks(id string, c *remoteConn) {
+	defer r.wg.Done()
+
+	s := splitter{}
+	buf := make([]byte, 4096)
+	for {
+		n, err := c.conn.Read(buf)
+		s.write(buf[:n], func([]byte) error {
+			r.stats.acks.Add(1)
+			return nil
+		})
+		if err != nil {
+			if !c.closed.Load() {
+				c.fail(r.stats)
+				if !errors.Is(err, io.EOF) {
+					logx.Errorf("Connection %s failed: %v", id, err)
+				} else {
+					logx.Errorf("Server closed connection %s", id)
+				}
+			}
+			return
+		}
+	}
+}
+
+func (c *remoteConn) fail(st *stats) {
+	if c.closed.CompareAndSwap(false, true) {
+		st.failed.Add(1)
+		c.conn.Close()
+	}
+}
+
+// close waits for the last acks before closing the connections
+func (r *remote) close() {
+	time.Sleep(r.wait)
+	for _, c := range r.conns {
+		if c.closed.CompareAndSwap(false, true) {
+			c.conn.Close()
+		}
+	}
+	r.wg.Wait()
+}
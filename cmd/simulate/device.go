@@ -0,0 +1,408 @@
+package simulate
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"gt06/protocol"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// alarmTypes are raised at random
+var alarmTypes = []uint8{protocol.AlarmSOS, protocol.AlarmPowerCut, protocol.AlarmVibration, protocol.AlarmOverspeed}
+
+// timeZoneLanguage reports GMT+0 in English
+var timeZoneLanguage = [2]byte{0x00, 0x01}
+
+var errLoginTimeout = errors.New("login was not acknowledged")
+
+// device is a virtual GT06 terminal
+type device struct {
+	imei      string
+	conf      *options
+	stats     *stats
+	rnd       *rand.Rand
+	route     route
+	interval  time.Duration // between locations
+	heartbeat time.Duration
+
+	// position reported, updated by the device loop and read by the command replies
+	positionMu sync.Mutex
+	position   fix
+	mileage    uint32 // meters
+
+	// writeMu guards the connection writes, the serial and the acks awaited
+	writeMu sync.Mutex
+	conn    net.Conn
+	serial  uint16
+	pending map[uint16]sent
+}
+
+// sent is a packet awaiting its ack
+type sent struct {
+	name string
+	at   time.Time
+}
+
+// run keeps the device connected until ctx is done, reconnecting with a growing delay
+func (d *device) run(ctx context.Context) {
+	attempt := 0
+	for {
+		loggedIn, err := d.session(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if loggedIn {
+			attempt = 0
+		}
+
+		delay := min(time.Minute, d.conf.reconnect<<min(attempt, 6))
+		delay = time.Duration(float64(delay) * (0.5 + d.rnd.Float64()))
+		attempt++
+		if d.conf.verbose {
+			fmt.Fprintf(os.Stderr, "%s: %v, reconnecting in %s\n", d.imei, err, delay.Round(time.Millisecond))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// session connects, logs in and reports until the connection fails, the device drops it or ctx is done
+func (d *device) session(ctx context.Context) (loggedIn bool, err error) {
+	dialer := net.Dialer{Timeout: d.conf.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", d.conf.addr)
+	d.stats.connected(err)
+	if err != nil {
+		return false, err
+	}
+
+	d.writeMu.Lock()
+	d.conn = conn
+	d.pending = make(map[uint16]sent)
+	d.writeMu.Unlock()
+
+	loginAcked := make(chan struct{})
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- d.read(conn, loginAcked)
+	}()
+	defer func() {
+		conn.Close()
+		<-readErr
+		d.stats.disconnected(loggedIn)
+	}()
+
+	login, err := protocol.BuildCONCOXLogin(d.imei, d.conf.model, timeZoneLanguage, d.nextSerial())
+	if err != nil {
+		return false, err
+	}
+	if err := d.send("login", login); err != nil {
+		return false, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case err := <-readErr:
+		readErr <- err
+		return false, err
+	case <-time.After(d.conf.timeout):
+		return false, errLoginTimeout
+	case <-loginAcked:
+	}
+	loggedIn = true
+	d.stats.loggedIn()
+
+	locations := time.NewTicker(d.interval)
+	defer locations.Stop()
+	heartbeats := time.NewTicker(d.heartbeat)
+	defer heartbeats.Stop()
+	checks := time.NewTicker(time.Second)
+	defer checks.Stop()
+	alarms := d.exponential(d.conf.alarms)
+	drops := d.exponential(d.conf.drops)
+
+	last := time.Now()
+	if err := d.sendLocation(0); err != nil {
+		return true, err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return true, ctx.Err()
+		case err := <-readErr:
+			readErr <- err
+			return true, err
+		case now := <-locations.C:
+			if err := d.sendLocation(now.Sub(last)); err != nil {
+				return true, err
+			}
+			last = now
+		case <-heartbeats.C:
+			if err := d.sendHeartbeat(); err != nil {
+				return true, err
+			}
+		case <-alarms:
+			if err := d.sendAlarm(); err != nil {
+				return true, err
+			}
+			alarms = d.exponential(d.conf.alarms)
+		case <-drops:
+			return true, errors.New("dropped the connection")
+		case <-checks.C:
+			d.expireAcks()
+		}
+	}
+}
+
+// exponential fires after a random delay for events happening perHour times an hour on average, never when 0
+func (d *device) exponential(perHour float64) <-chan time.Time {
+	if perHour <= 0 {
+		return nil
+	}
+	return time.After(time.Duration(d.rnd.ExpFloat64() / perHour * float64(time.Hour)))
+}
+
+func (d *device) nextSerial() uint16 {
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+	d.serial++
+	return d.serial
+}
+
+// send writes a packet, awaiting its ack when name is set
+func (d *device) send(name string, packet []byte) error {
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+
+	if name != "" {
+		serial := uint16(packet[len(packet)-6])<<8 | uint16(packet[len(packet)-5])
+		d.pending[serial] = sent{name: name, at: time.Now()}
+		d.stats.sent(name)
+	}
+
+	d.conn.SetWriteDeadline(time.Now().Add(d.conf.timeout))
+	_, err := d.conn.Write(packet)
+	return err
+}
+
+func (d *device) move(elapsed time.Duration) fix {
+	position := d.route.next(elapsed)
+
+	d.positionMu.Lock()
+	defer d.positionMu.Unlock()
+	d.mileage += uint32(float64(position.speed) / 3.6 * elapsed.Seconds())
+	d.position = position
+	return position
+}
+
+func (d *device) sendLocation(elapsed time.Duration) error {
+	position := d.move(elapsed)
+
+	packet := protocol.BuildCONCOXLocation(protocol.ProtocolLocationUTC, &protocol.CONCOXLocationInfoContent{
+		DateTime:            protocol.EncodeDateTime(time.Now()),
+		GPSSatellites:       uint8(6 + d.rnd.Intn(7)),
+		Latitude:            protocol.EncodeCoordinate(position.latitude),
+		Longitude:           protocol.EncodeCoordinate(position.longitude),
+		Speed:               uint8(min(255, position.speed)),
+		CourseStatus:        protocol.EncodeCourseStatus(position.course, position.latitude, position.longitude),
+		MCC:                 452,
+		MNC:                 1,
+		LAC:                 12345,
+		CellID:              uint32(d.rnd.Intn(1 << 24)),
+		ACCStatus:           accStatus(position),
+		GPSRealTimeReupload: 0,
+		Mileage:             d.mileageMeters(),
+	}, d.nextSerial())
+	return d.send("location", packet)
+}
+
+func (d *device) sendHeartbeat() error {
+	packet := protocol.BuildCONCOXHeartbeat(&protocol.CONCOXHeartbeatInfoContent{
+		TerminalInfo:        0x46, // GPS tracking on, charging, ACC high
+		ExternalVoltage:     1250, // 12.50 V
+		BatteryVoltageLevel: 6,
+		GSMSignalStrength:   4,
+		LanguageStatus:      0x0002,
+	}, d.nextSerial())
+	return d.send("heartbeat", packet)
+}
+
+func (d *device) sendAlarm() error {
+	d.positionMu.Lock()
+	position := d.position
+	d.positionMu.Unlock()
+
+	alarm := alarmTypes[d.rnd.Intn(len(alarmTypes))]
+	packet := protocol.BuildCONCOXAlarm(&protocol.CONCOXAlarmInfoContent{
+		DateTime:          protocol.EncodeDateTime(time.Now()),
+		GPSSatellites:     uint8(6 + d.rnd.Intn(7)),
+		Latitude:          protocol.EncodeCoordinate(position.latitude),
+		Longitude:         protocol.EncodeCoordinate(position.longitude),
+		Speed:             uint8(min(255, position.speed)),
+		CourseStatus:      protocol.EncodeCourseStatus(position.course, position.latitude, position.longitude),
+		LBSLength:         9,
+		MCC:               452,
+		MNC:               1,
+		LAC:               12345,
+		CellID:            uint32(d.rnd.Intn(1 << 24)),
+		TerminalInfo:      0x46,
+		VoltageLevel:      6,
+		GSMSignalStrength: 4,
+		AlarmLanguage:     uint16(alarm)<<8 | 0x02,
+		Mileage:           d.mileageMeters(),
+	}, d.nextSerial())
+	return d.send("alarm", packet)
+}
+
+func (d *device) mileageMeters() uint32 {
+	d.positionMu.Lock()
+	defer d.positionMu.Unlock()
+	return d.mileage
+}
+
+func accStatus(position fix) uint8 {
+	if position.speed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// expireAcks counts the packets not acknowledged within the timeout
+func (d *device) expireAcks() {
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+
+	for serial, p := range d.pending {
+		if time.Since(p.at) > d.conf.timeout {
+			delete(d.pending, serial)
+			d.stats.timedOut(p.name)
+		}
+	}
+}
+
+// read handles the acks and the online commands of the server until the connection fails
+func (d *device) read(conn net.Conn, loginAcked chan<- struct{}) error {
+	r := bufio.NewReader(conn)
+	for {
+		data, err := readFrame(r)
+		if err != nil {
+			return err
+		}
+
+		packet, err := protocol.ParseAndValidatePacket(data)
+		if err != nil {
+			return fmt.Errorf("invalid frame from the server: %w", err)
+		}
+
+		switch packet.ProtocolNumber {
+		case protocol.ProtocolOnlineCommand:
+			if err := d.answer(packet); err != nil {
+				return err
+			}
+		default:
+			if name, ok := d.acked(packet.InfoSerialNumber); ok && name == "login" {
+				close(loginAcked)
+			}
+		}
+	}
+}
+
+// acked matches an ack to the packet it answers
+func (d *device) acked(serial uint16) (string, bool) {
+	d.writeMu.Lock()
+	p, ok := d.pending[serial]
+	delete(d.pending, serial)
+	d.writeMu.Unlock()
+
+	if ok {
+		d.stats.acked(p.name, time.Since(p.at))
+	}
+	return p.name, ok
+}
+
+// answer replies to an online command the way a terminal does
+func (d *device) answer(packet *protocol.CONCOXPacket) error {
+	serverFlag, command, err := protocol.ParseCONCOXOnlineCommand(packet.Info())
+	if err != nil {
+		return err
+	}
+	d.stats.commanded()
+
+	reply, err := protocol.BuildCONCOXCommandReply(serverFlag, d.replyTo(command), d.nextSerial())
+	if err != nil {
+		return err
+	}
+	return d.send("", reply)
+}
+
+func (d *device) replyTo(command string) string {
+	d.positionMu.Lock()
+	position := d.position
+	d.positionMu.Unlock()
+
+	switch protocol.TextCommandName(command) {
+	case "STATUS":
+		acc := "OFF"
+		if accStatus(position) == 1 {
+			acc = "ON"
+		}
+		return "Battery:Normal;GPRS:Link Up;GSM Signal Level:Strong;GPS:Successful positioning;ACC:" + acc + ";Defense:OFF"
+	case "WHERE":
+		return fmt.Sprintf("Lat:%s%.6f,Lon:%s%.6f,Course:%d.00,Speed:%d.00km/h,DateTime:%s",
+			hemisphere(position.latitude, "N", "S"), math.Abs(position.latitude),
+			hemisphere(position.longitude, "E", "W"), math.Abs(position.longitude),
+			position.course, position.speed, time.Now().UTC().Format("2006-01-02 15:04:05"))
+	case "VERSION":
+		return "[VERSION]GT06_SIMULATOR_V1"
+	case "PARAM":
+		return fmt.Sprintf("IMEI:%s;TIMER:%d,%d;SOS:,,;CENTER:;TIMEZONE:E,0,0", d.imei, d.interval.Round(time.Second)/time.Second, d.heartbeat.Round(time.Second)/time.Second)
+	default:
+		return "OK!"
+	}
+}
+
+func hemisphere(v float64, positive, negative string) string {
+	if v < 0 {
+		return negative
+	}
+	return positive
+}
+
+// readFrame reads the next frame, skipping the bytes before its start bits
+func readFrame(r *bufio.Reader) ([]byte, error) {
+	previous := byte(0)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if previous == protocol.PacketStartBit && b == protocol.PacketStartBit {
+			break
+		}
+		previous = b
+	}
+
+	length, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	frame := make([]byte, int(length)+5)
+	frame[0], frame[1], frame[2] = protocol.PacketStartBit, protocol.PacketStartBit, length
+	if _, err := io.ReadFull(r, frame[3:]); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
@@ -0,0 +1,229 @@
+package simulate
+
+import (
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const earthRadius = 6371000.0 // meters
+
+// fix is a simulated GPS position
+type fix struct {
+	latitude  float64
+	longitude float64
+	speed     int // km/h
+	course    int // degrees
+}
+
+// route moves a device, next returns its position after elapsed
+type route interface {
+	next(elapsed time.Duration) fix
+}
+
+// randomWalk wanders around its start, turning and changing speed a little at every step
+type randomWalk struct {
+	rnd      *rand.Rand
+	position fix
+	speed    float64 // km/h
+	maxSpeed float64
+}
+
+func newRandomWalk(rnd *rand.Rand, latitude, longitude, radius, maxSpeed float64) *randomWalk {
+	// start anywhere in the circle, uniformly
+	distance := radius * 1000 * math.Sqrt(rnd.Float64())
+	lat, lon := move(latitude, longitude, rnd.Float64()*360, distance)
+
+	return &randomWalk{
+		rnd:      rnd,
+		position: fix{latitude: lat, longitude: lon, course: rnd.Intn(360)},
+		speed:    rnd.Float64() * maxSpeed,
+		maxSpeed: maxSpeed,
+	}
+}
+
+func (w *randomWalk) next(elapsed time.Duration) fix {
+	w.speed = math.Max(0, math.Min(w.maxSpeed, w.speed+(w.rnd.Float64()-0.5)*w.maxSpeed/5))
+	w.position.course = ((w.position.course+w.rnd.Intn(61)-30)%360 + 360) % 360
+
+	distance := w.speed / 3.6 * elapsed.Seconds()
+	w.position.latitude, w.position.longitude = move(w.position.latitude, w.position.longitude, float64(w.position.course), distance)
+	w.position.speed = int(math.Round(w.speed))
+	return w.position
+}
+
+// track follows the points of a track file one report after the other, looping at its end
+type track struct {
+	points []point
+	i      int
+}
+
+type point struct {
+	latitude  float64
+	longitude float64
+}
+
+func (t *track) next(elapsed time.Duration) fix {
+	from := t.points[t.i]
+	t.i = (t.i + 1) % len(t.points)
+	to := t.points[t.i]
+
+	distance, course := distanceCourse(from.latitude, from.longitude, to.latitude, to.longitude)
+	speed := 0.0
+	if elapsed > 0 {
+		speed = distance / elapsed.Seconds() * 3.6
+	}
+	return fix{latitude: to.latitude, longitude: to.longitude, speed: int(math.Min(255, math.Round(speed))), course: int(math.Round(course))}
+}
+
+// loadTrack reads the points of a GPX file, or of a CSV file of latitude and longitude columns
+func loadTrack(name string) ([]point, error) {
+	var points []point
+	var err error
+	if strings.EqualFold(filepath.Ext(name), ".gpx") {
+		points, err = loadGPX(name)
+	} else {
+		points, err = loadCSV(name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if len(points) < 2 {
+		return nil, fmt.Errorf("%s: a track needs at least 2 points", name)
+	}
+	return points, nil
+}
+
+type gpxFile struct {
+	Tracks []struct {
+		Segments []struct {
+			Points []gpxPoint `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+	Routes []struct {
+		Points []gpxPoint `xml:"rtept"`
+	} `xml:"rte"`
+}
+
+type gpxPoint struct {
+	Latitude  float64 `xml:"lat,attr"`
+	Longitude float64 `xml:"lon,attr"`
+}
+
+func loadGPX(name string) ([]point, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var gpx gpxFile
+	if err := xml.Unmarshal(data, &gpx); err != nil {
+		return nil, err
+	}
+
+	var points []point
+	for _, trk := range gpx.Tracks {
+		for _, segment := range trk.Segments {
+			for _, p := range segment.Points {
+				points = append(points, point{latitude: p.Latitude, longitude: p.Longitude})
+			}
+		}
+	}
+	for _, rte := range gpx.Routes {
+		for _, p := range rte.Points {
+			points = append(points, point{latitude: p.Latitude, longitude: p.Longitude})
+		}
+	}
+	return points, nil
+}
+
+// loadCSV reads the lat and lon columns named by a header, or the first two columns without one
+func loadCSV(name string) ([]point, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	latColumn, lonColumn := 0, 1
+	var points []point
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == ';' || r == '\t' })
+
+		if len(points) == 0 && line == 1 {
+			if lat, lon, ok := csvHeader(fields); ok {
+				latColumn, lonColumn = lat, lon
+				continue
+			}
+		}
+		if len(fields) <= max(latColumn, lonColumn) {
+			return nil, fmt.Errorf("line %d: expected at least %d columns", line, max(latColumn, lonColumn)+1)
+		}
+
+		lat, errLat := strconv.ParseFloat(strings.TrimSpace(fields[latColumn]), 64)
+		lon, errLon := strconv.ParseFloat(strings.TrimSpace(fields[lonColumn]), 64)
+		if err := errors.Join(errLat, errLon); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		points = append(points, point{latitude: lat, longitude: lon})
+	}
+	return points, scanner.Err()
+}
+
+func csvHeader(fields []string) (lat, lon int, ok bool) {
+	lat, lon = -1, -1
+	for i, field := range fields {
+		switch strings.ToLower(strings.TrimSpace(field)) {
+		case "lat", "latitude":
+			lat = i
+		case "lon", "lng", "long", "longitude":
+			lon = i
+		}
+	}
+	return lat, lon, lat >= 0 && lon >= 0
+}
+
+// move returns the position distance meters away from latitude, longitude towards course
+func move(latitude, longitude, course, distance float64) (float64, float64) {
+	lat1, lon1 := radians(latitude), radians(longitude)
+	bearing, angle := radians(course), distance/earthRadius
+
+	lat2 := math.Asin(math.Sin(lat1)*math.Cos(angle) + math.Cos(lat1)*math.Sin(angle)*math.Cos(bearing))
+	lon2 := lon1 + math.Atan2(math.Sin(bearing)*math.Sin(angle)*math.Cos(lat1), math.Cos(angle)-math.Sin(lat1)*math.Sin(lat2))
+	return degrees(lat2), math.Mod(degrees(lon2)+540, 360) - 180
+}
+
+// distanceCourse returns the distance in meters and the initial course in degrees between two positions
+func distanceCourse(lat1, lon1, lat2, lon2 float64) (float64, float64) {
+	rlat1, rlat2 := radians(lat1), radians(lat2)
+	dlat, dlon := radians(lat2-lat1), radians(lon2-lon1)
+
+	a := math.Sin(dlat/2)*math.Sin(dlat/2) + math.Cos(rlat1)*math.Cos(rlat2)*math.Sin(dlon/2)*math.Sin(dlon/2)
+	distance := 2 * earthRadius * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+
+	y := math.Sin(dlon) * math.Cos(rlat2)
+	x := math.Cos(rlat1)*math.Sin(rlat2) - math.Sin(rlat1)*math.Cos(rlat2)*math.Cos(dlon)
+	course := math.Mod(degrees(math.Atan2(y, x))+360, 360)
+	return distance, course
+}
+
+func radians(d float64) float64 {
+	return d * math.Pi / 180
+}
+
+func degrees(r float64) float64 {
+	return r * 180 / math.Pi
+}
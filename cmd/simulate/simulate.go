@@ -0,0 +1,259 @@
+// Package simulate implements the simulate subcommand, which connects many virtual GT06
+// devices to a server and reports the throughput and the ack latency it sustained.
+package simulate
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	routeRandom = "random"
+	routeTrack  = "track"
+)
+
+const usage = `Usage: gt06 simulate [flags]
+
+Connects virtual GT06 devices to a server. Each device logs in with its own IMEI,
+reports locations and heartbeats at its own jittered intervals along a random walk
+or a GPX/CSV track, raises alarms, answers online commands and reconnects when its
+connection drops. Progress goes to the standard error and the final report, on the
+acks and their latency, to the standard output.
+
+Flags:
+`
+
+// options of the simulation shared by the devices
+type options struct {
+	addr      string
+	model     [2]byte
+	timeout   time.Duration
+	reconnect time.Duration
+	alarms    float64 // per device per hour
+	drops     float64 // per device per hour
+	verbose   bool
+}
+
+// Run runs the simulate subcommand with its arguments
+func Run(args []string) error {
+	fs := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+
+	addr := fs.String("addr", "127.0.0.1:8000", "address of the GT06 listener of the server")
+	devices := fs.Int("devices", 100, "number of virtual devices")
+	imeiBase := fs.Uint64("imei-base", 860000000000000, "IMEI of the first device, the next ones count up from it")
+	model := fs.String("model", "0242", "model code of the login packets, 4 hex digits")
+	interval := fs.Duration("interval", 10*time.Second, "interval between the locations of a device")
+	heartbeat := fs.Duration("heartbeat", time.Minute, "interval between the heartbeats of a device")
+	jitter := fs.Float64("jitter", 0.2, "random spread of the intervals of each device, 0.2 is ±20%")
+	routeName := fs.String("route", routeRandom, "random walks around -center, or track to follow the -track files")
+	tracks := fs.String("track", "", "comma separated GPX or CSV (lat,lon) files, assigned to the devices in turn")
+	center := fs.String("center", "22.54,114.06", "latitude,longitude the random walks start around")
+	radius := fs.Float64("radius", 10, "radius in km of the area the random walks start in")
+	maxSpeed := fs.Float64("max-speed", 90, "top speed of the random walks in km/h")
+	alarms := fs.Float64("alarms", 0, "alarms raised per device per hour on average")
+	drops := fs.Float64("drops", 0, "connections dropped by the device per device per hour on average")
+	ramp := fs.Duration("ramp", 10*time.Second, "time over which the devices are started")
+	duration := fs.Duration("duration", 0, "length of the simulation, until interrupted when 0")
+	reconnect := fs.Duration("reconnect", time.Second, "first reconnection delay, doubled up to a minute while the server is unreachable")
+	timeout := fs.Duration("timeout", 10*time.Second, "time to wait for a connection, the login ack and the other acks")
+	reportEvery := fs.Duration("report", 10*time.Second, "interval of the progress lines, none when 0")
+	asJSON := fs.Bool("json", false, "print the final report as JSON")
+	seed := fs.Int64("seed", 0, "seed of the random routes and timings, from the clock when 0")
+	verbose := fs.Bool("v", false, "print the disconnections of the devices")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	if *devices <= 0 {
+		return fmt.Errorf("-devices must be positive")
+	}
+	if *interval <= 0 || *heartbeat <= 0 || *timeout <= 0 || *reconnect <= 0 {
+		return fmt.Errorf("-interval, -heartbeat, -timeout and -reconnect must be positive")
+	}
+	if *jitter < 0 || *jitter >= 1 {
+		return fmt.Errorf("-jitter must be in [0, 1)")
+	}
+	if *imeiBase+uint64(*devices) > 1e15 {
+		return fmt.Errorf("-imei-base %d leaves no room for %d 15 digit IMEIs", *imeiBase, *devices)
+	}
+
+	o := &options{
+		addr:      *addr,
+		timeout:   *timeout,
+		reconnect: *reconnect,
+		alarms:    *alarms,
+		drops:     *drops,
+		verbose:   *verbose,
+	}
+	code, err := hex.DecodeString(*model)
+	if err != nil || len(code) != 2 {
+		return fmt.Errorf("invalid -model %q, expected 4 hex digits", *model)
+	}
+	copy(o.model[:], code)
+
+	routes, err := newRouter(*routeName, *tracks, *center, *radius, *maxSpeed)
+	if err != nil {
+		return err
+	}
+
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	seeds := rand.New(rand.NewSource(*seed))
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	if *duration > 0 {
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	st := newStats()
+	stopProgress := progress(st, *devices, *reportEvery)
+
+	var wg sync.WaitGroup
+	step := *ramp / time.Duration(*devices)
+	for i := 0; i < *devices; i++ {
+		rnd := rand.New(rand.NewSource(seeds.Int63()))
+		d := &device{
+			imei:      fmt.Sprintf("%015d", *imeiBase+uint64(i)),
+			conf:      o,
+			stats:     st,
+			rnd:       rnd,
+			route:     routes(i, rnd),
+			interval:  spread(rnd, *interval, *jitter),
+			heartbeat: spread(rnd, *heartbeat, *jitter),
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.run(ctx)
+		}()
+
+		if step > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(step):
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	wg.Wait()
+	stopProgress()
+
+	report := st.report(*devices)
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	fmt.Print(report)
+	return nil
+}
+
+// newRouter returns the function giving its route to the i-th device
+func newRouter(name, tracks, center string, radius, maxSpeed float64) (func(i int, rnd *rand.Rand) route, error) {
+	switch name {
+	case routeRandom:
+		lat, lon, err := parseCenter(center)
+		if err != nil {
+			return nil, err
+		}
+		return func(_ int, rnd *rand.Rand) route {
+			return newRandomWalk(rnd, lat, lon, radius, maxSpeed)
+		}, nil
+
+	case routeTrack:
+		if tracks == "" {
+			return nil, fmt.Errorf("-route track needs -track files")
+		}
+		var loaded [][]point
+		for _, name := range strings.Split(tracks, ",") {
+			points, err := loadTrack(strings.TrimSpace(name))
+			if err != nil {
+				return nil, err
+			}
+			loaded = append(loaded, points)
+		}
+		return func(i int, rnd *rand.Rand) route {
+			points := loaded[i%len(loaded)]
+			// devices sharing a track start at different points
+			return &track{points: points, i: rnd.Intn(len(points))}
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown route %q, expected %s or %s", name, routeRandom, routeTrack)
+	}
+}
+
+func parseCenter(center string) (float64, float64, error) {
+	lat, lon, ok := strings.Cut(center, ",")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid -center %q, expected latitude,longitude", center)
+	}
+	latitude, err := strconv.ParseFloat(strings.TrimSpace(lat), 64)
+	if err != nil || latitude < -90 || latitude > 90 {
+		return 0, 0, fmt.Errorf("invalid -center latitude %q", lat)
+	}
+	longitude, err := strconv.ParseFloat(strings.TrimSpace(lon), 64)
+	if err != nil || longitude < -180 || longitude > 180 {
+		return 0, 0, fmt.Errorf("invalid -center longitude %q", lon)
+	}
+	return latitude, longitude, nil
+}
+
+// spread returns d changed by a random factor within ±jitter
+func spread(rnd *rand.Rand, d time.Duration, jitter float64) time.Duration {
+	return time.Duration(float64(d) * (1 + jitter*(2*rnd.Float64()-1)))
+}
+
+// progress prints a progress line every interval until the returned function is called
+func progress(st *stats, devices int, interval time.Duration) func() {
+	if interval <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				fmt.Fprintf(os.Stderr, "simulate: %s\n", st.progress(devices))
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
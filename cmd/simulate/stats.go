@@ -0,0 +1,274 @@
+package simulate
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	histogramMin     = 50 * time.Microsecond
+	histogramGrowth  = 1.05 // buckets are 5% apart
+	histogramBuckets = 320  // up to about 2 minutes
+)
+
+// histogram counts latencies in exponential buckets, percentiles are bucket upper bounds
+type histogram struct {
+	counts [histogramBuckets]uint64
+	count  uint64
+	sum    time.Duration
+	max    time.Duration
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := 0
+	if d > histogramMin {
+		i = min(histogramBuckets-1, int(math.Log(float64(d)/float64(histogramMin))/math.Log(histogramGrowth))+1)
+	}
+	h.counts[i]++
+	h.count++
+	h.sum += d
+	h.max = max(h.max, d)
+}
+
+func (h *histogram) merge(other *histogram) {
+	for i, c := range other.counts {
+		h.counts[i] += c
+	}
+	h.count += other.count
+	h.sum += other.sum
+	h.max = max(h.max, other.max)
+}
+
+func (h *histogram) percentile(p float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+
+	rank := uint64(math.Ceil(p / 100 * float64(h.count)))
+	var seen uint64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			upper := time.Duration(float64(histogramMin) * math.Pow(histogramGrowth, float64(i)))
+			return min(upper, h.max)
+		}
+	}
+	return h.max
+}
+
+// Latency summarizes the ack latency of a packet type, in milliseconds
+type Latency struct {
+	Mean float64 `json:"mean_ms"`
+	P50  float64 `json:"p50_ms"`
+	P90  float64 `json:"p90_ms"`
+	P99  float64 `json:"p99_ms"`
+	Max  float64 `json:"max_ms"`
+}
+
+func (h *histogram) latency() Latency {
+	l := Latency{P50: ms(h.percentile(50)), P90: ms(h.percentile(90)), P99: ms(h.percentile(99)), Max: ms(h.max)}
+	if h.count > 0 {
+		l.Mean = ms(h.sum / time.Duration(h.count))
+	}
+	return l
+}
+
+func (l Latency) String() string {
+	return fmt.Sprintf("mean %.2fms p50 %.2fms p90 %.2fms p99 %.2fms max %.2fms", l.Mean, l.P50, l.P90, l.P99, l.Max)
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// packetStats counts the packets of a type
+type packetStats struct {
+	sent     uint64
+	acked    uint64
+	timeouts uint64
+	window   histogram // since the last progress report
+	total    histogram
+}
+
+// PacketReport is the outcome of a packet type
+type PacketReport struct {
+	Sent     uint64  `json:"sent"`
+	Acked    uint64  `json:"acked"`
+	Timeouts uint64  `json:"timeouts"`
+	Rate     float64 `json:"rate"` // sent per second
+	Latency  Latency `json:"latency"`
+}
+
+// Report is the outcome of a simulation
+type Report struct {
+	Devices         int                     `json:"devices"`
+	Duration        float64                 `json:"duration_s"`
+	Connects        uint64                  `json:"connects"`
+	ConnectFailures uint64                  `json:"connect_failures"`
+	Disconnects     uint64                  `json:"disconnects"`
+	Commands        uint64                  `json:"commands"` // online commands answered
+	Sent            uint64                  `json:"sent"`
+	Acked           uint64                  `json:"acked"`
+	Rate            float64                 `json:"rate"` // packets sent per second
+	Packets         map[string]PacketReport `json:"packets"`
+	Latency         Latency                 `json:"latency"`
+}
+
+// stats is shared by the devices
+type stats struct {
+	mu              sync.Mutex
+	started         time.Time
+	windowStarted   time.Time
+	online          int
+	connects        uint64
+	connectFailures uint64
+	disconnects     uint64
+	commands        uint64
+	packets         map[string]*packetStats
+}
+
+func newStats() *stats {
+	now := time.Now()
+	return &stats{started: now, windowStarted: now, packets: make(map[string]*packetStats)}
+}
+
+func (s *stats) packet(name string) *packetStats {
+	p := s.packets[name]
+	if p == nil {
+		p = &packetStats{}
+		s.packets[name] = p
+	}
+	return p
+}
+
+func (s *stats) sent(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.packet(name).sent++
+}
+
+func (s *stats) acked(name string, latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := s.packet(name)
+	p.acked++
+	p.window.observe(latency)
+	p.total.observe(latency)
+}
+
+func (s *stats) timedOut(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.packet(name).timeouts++
+}
+
+func (s *stats) connected(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.connectFailures++
+		return
+	}
+	s.connects++
+}
+
+func (s *stats) loggedIn() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.online++
+}
+
+func (s *stats) disconnected(wasOnline bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.disconnects++
+	if wasOnline {
+		s.online--
+	}
+}
+
+func (s *stats) commanded() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commands++
+}
+
+// progress returns a line on the packets since the last call
+func (s *stats) progress(devices int) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elapsed := time.Since(s.windowStarted)
+	s.windowStarted = time.Now()
+
+	var sent, acked, timeouts uint64
+	var window histogram
+	for _, p := range s.packets {
+		sent += p.sent
+		acked += p.acked
+		timeouts += p.timeouts
+		window.merge(&p.window)
+		p.window = histogram{}
+	}
+
+	return fmt.Sprintf("online %d/%d, sent %d, acked %d, timeouts %d, commands %d, disconnects %d, %.1f acks/s, latency %s",
+		s.online, devices, sent, acked, timeouts, s.commands, s.disconnects,
+		float64(window.count)/elapsed.Seconds(), window.latency())
+}
+
+func (s *stats) report(devices int) Report {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elapsed := time.Since(s.started)
+	r := Report{
+		Devices:         devices,
+		Duration:        elapsed.Seconds(),
+		Connects:        s.connects,
+		ConnectFailures: s.connectFailures,
+		Disconnects:     s.disconnects,
+		Commands:        s.commands,
+		Packets:         make(map[string]PacketReport, len(s.packets)),
+	}
+
+	var total histogram
+	for name, p := range s.packets {
+		r.Sent += p.sent
+		r.Acked += p.acked
+		total.merge(&p.total)
+		r.Packets[name] = PacketReport{
+			Sent:     p.sent,
+			Acked:    p.acked,
+			Timeouts: p.timeouts,
+			Rate:     float64(p.sent) / elapsed.Seconds(),
+			Latency:  p.total.latency(),
+		}
+	}
+	r.Rate = float64(r.Sent) / elapsed.Seconds()
+	r.Latency = total.latency()
+	return r
+}
+
+func (r Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "devices %d, duration %.1fs, connects %d, connect failures %d, disconnects %d, commands %d\n",
+		r.Devices, r.Duration, r.Connects, r.ConnectFailures, r.Disconnects, r.Commands)
+	fmt.Fprintf(&b, "%-14s %10s %10s %9s %9s  %s\n", "packet", "sent", "acked", "timeouts", "rate/s", "ack latency")
+
+	names := make([]string, 0, len(r.Packets))
+	for name := range r.Packets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p := r.Packets[name]
+		fmt.Fprintf(&b, "%-14s %10d %10d %9d %9.1f  %s\n", name, p.Sent, p.Acked, p.Timeouts, p.Rate, p.Latency)
+	}
+	fmt.Fprintf(&b, "%-14s %10d %10d %9s %9.1f  %s\n", "total", r.Sent, r.Acked, "", r.Rate, r.Latency)
+	return b.String()
+}
@@ -0,0 +1,191 @@
+// Package webhookreceiver implements the webhook-receiver subcommand, a local stand-in for a
+// webhook endpoint that prints the deliveries, checks their signatures and fails on demand to
+// exercise the retries and the dead letters.
+package webhookreceiver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"gt06/webhook"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const usage = `Usage: gt06 webhook-receiver [flags]
+
+Listens for webhook deliveries and prints one line per request with its event, device,
+attempt and delivery id, followed by the payload with -v. Signatures are checked when
+-secret is given: a request with a missing, wrong or older than -max-age signature is
+answered 401. With -fail, that fraction of the requests is answered -status instead of
+204, so that the server retries them and dead-letters the ones out of attempts. The totals
+are printed on exit.
+
+Flags:
+`
+
+// Run runs the webhook-receiver subcommand with its arguments
+func Run(args []string) error {
+	fs := flag.NewFlagSet("webhook-receiver", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+
+	addr := fs.String("addr", "127.0.0.1:9000", "address to listen on")
+	secret := fs.String("secret", "", "secret of the endpoint, signatures are not checked when empty")
+	maxAge := fs.Duration("max-age", 5*time.Minute, "oldest signature timestamp accepted, 0 accepts any")
+	fail := fs.Float64("fail", 0, "fraction of the requests answered -status, between 0 and 1")
+	status := fs.Int("status", http.StatusInternalServerError, "status of the failed requests")
+	verbose := fs.Bool("v", false, "print the payloads")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	switch {
+	case fs.NArg() > 0:
+		return fmt.Errorf("unexpected arguments: %v", fs.Args())
+	case *fail < 0 || *fail > 1:
+		return fmt.Errorf("-fail must be between 0 and 1")
+	case *status < 100 || *status > 599:
+		return fmt.Errorf("-status must be an HTTP status")
+	}
+
+	r := &receiver{secret: *secret, maxAge: *maxAge, fail: *fail, status: *status, verbose: *verbose}
+	server := &http.Server{Addr: *addr, Handler: r, ReadHeaderTimeout: 10 * time.Second}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdown)
+	}()
+
+	fmt.Fprintf(os.Stderr, "listening on http://%s\n", *addr)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	r.summary()
+	return nil
+}
+
+// receiver answers the deliveries and counts them
+type receiver struct {
+	secret  string
+	maxAge  time.Duration
+	fail    float64
+	status  int
+	verbose bool
+
+	mu         sync.Mutex
+	accepted   int
+	failed     int
+	rejected   int
+	duplicates int                 // deliveries accepted more than once
+	seen       map[string]struct{} // ids of the accepted deliveries
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(req.Body, 16<<20))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	id := req.Header.Get(webhook.HeaderDelivery)
+	line := fmt.Sprintf("%s %-13s imei=%s attempt=%s id=%s", time.Now().Format("15:04:05.000"),
+		req.Header.Get(webhook.HeaderEvent), imei(body), req.Header.Get(webhook.HeaderAttempt), id)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	status := http.StatusNoContent
+	if problem := r.check(req, body); problem != "" {
+		r.rejected++
+		status = http.StatusUnauthorized
+		line += " rejected: " + problem
+	} else if rand.Float64() < r.fail {
+		r.failed++
+		status = r.status
+		line += fmt.Sprintf(" failed with %d", r.status)
+	} else {
+		r.accepted++
+		if r.seen == nil {
+			r.seen = make(map[string]struct{})
+		}
+		if _, ok := r.seen[id]; ok {
+			r.duplicates++
+			line += " (duplicate)"
+		}
+		r.seen[id] = struct{}{}
+	}
+
+	fmt.Println(line)
+	if r.verbose {
+		var indented bytes.Buffer
+		if json.Indent(&indented, body, "  ", "  ") == nil {
+			fmt.Printf("  %s\n", indented.String())
+		}
+	}
+	w.WriteHeader(status)
+}
+
+// check returns why the signature of a request is not accepted, nothing when it is
+func (r *receiver) check(req *http.Request, body []byte) string {
+	if r.secret == "" {
+		return ""
+	}
+	signature := req.Header.Get(webhook.HeaderSignature)
+	timestamp := req.Header.Get(webhook.HeaderTimestamp)
+	if signature == "" {
+		return "no signature"
+	}
+	if !webhook.Verify(r.secret, timestamp, body, signature) {
+		return "bad signature"
+	}
+	if r.maxAge > 0 {
+		unix, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return "bad timestamp"
+		}
+		if age := time.Since(time.Unix(unix, 0)); age > r.maxAge {
+			return fmt.Sprintf("signed %s ago", age.Round(time.Second))
+		}
+	}
+	return ""
+}
+
+func (r *receiver) summary() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fmt.Fprintf(os.Stderr, "%d accepted (%d unique, %d duplicates), %d failed on purpose, %d rejected\n",
+		r.accepted, len(r.seen), r.duplicates, r.failed, r.rejected)
+}
+
+// imei returns the device of a payload
+func imei(body []byte) string {
+	var p struct {
+		IMEI string `json:"imei"`
+	}
+	_ = json.Unmarshal(body, &p)
+	return p.IMEI
+}
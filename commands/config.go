@@ -0,0 +1,36 @@
+package commands
+
+import "gt06/protocol"
+
+// Conf configures the commands sent to terminals
+type Conf struct {
+	Timeout        int `json:"Timeout,optional" yaml:"Timeout"`               // seconds to wait for a reply when the request sets none
+	MaxTimeout     int `json:"MaxTimeout,optional" yaml:"MaxTimeout"`         // longest wait a request may ask for, in seconds
+	Expiry         int `json:"Expiry,optional" yaml:"Expiry"`                 // seconds a queued command waits for its device when the request sets none
+	MaxAttempts    int `json:"MaxAttempts,optional" yaml:"MaxAttempts"`       // deliveries of a queued command when the request sets none
+	ExpireInterval int `json:"ExpireInterval,optional" yaml:"ExpireInterval"` // seconds between checks for expired commands
+
+	// Operators may use the HTTP API and send commands, each with the bearer token it
+	// authenticates with. Without operators every API request but health is rejected.
+	Operators []Operator `json:"Operators,optional" yaml:"Operators"`
+
+	// Profiles override the command syntax of device models
+	Profiles []protocol.CommandProfile `json:"Profiles,optional" yaml:"Profiles"`
+}
+
+// Operator is a sender of commands, recorded as the sender of the commands it sends
+type Operator struct {
+	Name  string `json:"Name" yaml:"Name"`
+	Token string `json:"Token" yaml:"Token"`
+}
+
+// DefaultConf returns a Conf with default values
+func DefaultConf() Conf {
+	return Conf{
+		Timeout:        30,
+		MaxTimeout:     120,
+		Expiry:         86400,
+		MaxAttempts:    3,
+		ExpireInterval: 60,
+	}
+}
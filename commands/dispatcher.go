@@ -0,0 +1,244 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gt06/protocol"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrInvalidCommand = errors.New("invalid command")
+	ErrOffline        = errors.New("device is not connected")
+	ErrDisconnected   = errors.New("device disconnected before replying")
+)
+
+// Conn is the live session of a logged in device
+type Conn interface {
+	// ID returns the connection id
+	ID() string
+	// Send writes out to the device, it is safe to call from any goroutine
+	Send(out []byte) error
+}
+
+// Dispatcher delivers online commands to the live session of a device and hands the
+// replies back to the callers waiting for them
+type Dispatcher struct {
+	flag   atomic.Uint32
+	serial atomic.Uint32
+
+	mu       sync.Mutex
+	conns    map[string]Conn
+	pending  map[string][]*Call
+	flushing map[string]bool // running flushes, true when asked to run again
+}
+
+// NewDispatcher returns a Dispatcher without connected devices
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		conns:    make(map[string]Conn),
+		pending:  make(map[string][]*Call),
+		flushing: make(map[string]bool),
+	}
+}
+
+// Register makes conn the session commands for imei are delivered to
+func (d *Dispatcher) Register(imei string, conn Conn) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.conns[imei] = conn
+}
+
+// Unregister forgets the session of imei unless the device has reconnected on another
+// connection meanwhile, and fails the calls waiting for a reply on that connection
+func (d *Dispatcher) Unregister(imei, connectionID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if conn, ok := d.conns[imei]; ok && conn.ID() == connectionID {
+		delete(d.conns, imei)
+	}
+
+	calls := d.pending[imei][:0]
+	for _, call := range d.pending[imei] {
+		if call.ConnectionID == connectionID {
+			call.finish("", ErrDisconnected)
+			continue
+		}
+		calls = append(calls, call)
+	}
+	d.setPendingLocked(imei, calls)
+}
+
+// Online reports whether imei has a live session
+func (d *Dispatcher) Online(imei string) bool {
+	_, ok := d.ConnectionID(imei)
+	return ok
+}
+
+// ConnectionID returns the id of the live session of imei
+func (d *Dispatcher) ConnectionID(imei string) (string, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	conn, ok := d.conns[imei]
+	if !ok {
+		return "", false
+	}
+	return conn.ID(), true
+}
+
+// TryFlush claims the delivery of the queued commands of imei, so that a device never has two
+// flushes running. It returns false when one is running already, which then has to run again.
+// Otherwise done releases the claim, unless another flush was asked for meanwhile: it then
+// returns false and the caller keeps the claim and flushes again.
+func (d *Dispatcher) TryFlush(imei string) (done func() bool, ok bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, running := d.flushing[imei]; running {
+		d.flushing[imei] = true
+		return nil, false
+	}
+	d.flushing[imei] = false
+
+	return func() bool {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+
+		if d.flushing[imei] {
+			d.flushing[imei] = false
+			return false
+		}
+		delete(d.flushing, imei)
+		return true
+	}, true
+}
+
+// Validate reports whether command can be delivered, with an ErrInvalidCommand error
+func (d *Dispatcher) Validate(command string) error {
+	if _, err := protocol.BuildCONCOXOnlineCommand(0, command, 0); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidCommand, err)
+	}
+	return nil
+}
+
+// Deliver writes command to the live session of imei, the reply is awaited with Call.Wait
+func (d *Dispatcher) Deliver(imei, command string) (*Call, error) {
+	flag := d.flag.Add(1)
+	packet, err := protocol.BuildCONCOXOnlineCommand(flag, command, uint16(d.serial.Add(1)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCommand, err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	conn, ok := d.conns[imei]
+	if !ok {
+		return nil, ErrOffline
+	}
+
+	call := &Call{
+		IMEI:         imei,
+		ServerFlag:   flag,
+		ConnectionID: conn.ID(),
+		SentAt:       time.Now(),
+		dispatcher:   d,
+		done:         make(chan struct{}),
+	}
+	if err := conn.Send(packet); err != nil {
+		return nil, err
+	}
+	d.pending[imei] = append(d.pending[imei], call)
+
+	return call, nil
+}
+
+// HandleReply completes the call reply answers, matched by server flag. Firmwares that do not
+// echo the flag answer the oldest pending call of the device. It reports whether a call matched.
+func (d *Dispatcher) HandleReply(imei string, reply *protocol.CONCOXCommandReply) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	calls := d.pending[imei]
+	if len(calls) == 0 {
+		return false
+	}
+
+	match := 0
+	for i, call := range calls {
+		if call.ServerFlag == reply.ServerFlag {
+			match = i
+			break
+		}
+	}
+
+	calls[match].finish(reply.Content, nil)
+	d.setPendingLocked(imei, append(calls[:match], calls[match+1:]...))
+	return true
+}
+
+func (d *Dispatcher) cancel(call *Call) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	calls := d.pending[call.IMEI]
+	for i, pending := range calls {
+		if pending == call {
+			d.setPendingLocked(call.IMEI, append(calls[:i], calls[i+1:]...))
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) setPendingLocked(imei string, calls []*Call) {
+	if len(calls) == 0 {
+		delete(d.pending, imei)
+		return
+	}
+	d.pending[imei] = calls
+}
+
+// Call is a delivered command waiting for its reply
+type Call struct {
+	IMEI         string
+	ServerFlag   uint32
+	ConnectionID string
+	SentAt       time.Time
+
+	dispatcher *Dispatcher
+	done       chan struct{}
+	reply      string
+	repliedAt  time.Time
+	err        error
+}
+
+// Wait returns the reply of the device and when it arrived. It fails with the error of ctx
+// when no reply came in time, or with ErrDisconnected.
+func (c *Call) Wait(ctx context.Context) (string, time.Time, error) {
+	select {
+	case <-c.done:
+		return c.reply, c.repliedAt, c.err
+	case <-ctx.Done():
+		c.dispatcher.cancel(c)
+		// the reply may have won the race with the cancellation
+		select {
+		case <-c.done:
+			return c.reply, c.repliedAt, c.err
+		default:
+			return "", time.Time{}, ctx.Err()
+		}
+	}
+}
+
+// finish is called once with the dispatcher lock held, when the call leaves the pending list
+func (c *Call) finish(reply string, err error) {
+	c.reply = reply
+	c.repliedAt = time.Now()
+	c.err = err
+	close(c.done)
+}
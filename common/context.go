@@ -1,6 +1,7 @@
 package common
 
 import (
+	"context"
 	"crypto/rand"
 
 	"go.opentelemetry.io/otel/trace"
@@ -28,3 +29,19 @@ func GenerateSpanID() string {
 	}
 	return spanID.String()
 }
+
+// TraceIDOf returns the trace id of the span in ctx, or a random one when ctx holds none
+func TraceIDOf(ctx context.Context) string {
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		return sc.TraceID().String()
+	}
+	return GenerateTraceID()
+}
+
+// SpanIDOf returns the span id of the span in ctx, or a random one when ctx holds none
+func SpanIDOf(ctx context.Context) string {
+	if sc := trace.SpanContextFromContext(ctx); sc.HasSpanID() {
+		return sc.SpanID().String()
+	}
+	return GenerateSpanID()
+}
@@ -1,20 +1,72 @@
 package config
 
+import (
+	"gt06/commands"
+	"gt06/database"
+	"gt06/events"
+	"gt06/health"
+	"gt06/journal"
+	"gt06/sinks"
+	"gt06/spool"
+	"gt06/tracing"
+	"gt06/webhook"
+)
+
 type Config struct {
-	TCPServer         string   `json:"TCPServer" yaml:"TCPServer"`
-	MongoURI          string   `json:"MongoURI" yaml:"MongoURI"`
-	DBName            string   `json:"DBName" yaml:"DBName"`
-	LogLevel          string   `json:"LogLevel" yaml:"LogLevel"`
-	Timeout           int      `json:"Timeout" yaml:"Timeout"`
-	ScyllaHosts       []string `json:"ScyllaHosts" yaml:"ScyllaHosts"`
-	ScyllaKeyspace    string   `json:"ScyllaKeyspace" yaml:"ScyllaKeyspace"`
-	ScyllaConsistency string   `json:"ScyllaConsistency" yaml:"ScyllaConsistency"`
+	TCPServer         string             `json:"TCPServer" yaml:"TCPServer"`
+	StorageBackend    string             `json:"StorageBackend,optional,options=mongo|memory" yaml:"StorageBackend"`
+	MongoURI          string             `json:"MongoURI" yaml:"MongoURI"`
+	DBName            string             `json:"DBName" yaml:"DBName"`
+	LogLevel          string             `json:"LogLevel" yaml:"LogLevel"`
+	Timeout           int                `json:"Timeout" yaml:"Timeout"`
+	ScyllaHosts       []string           `json:"ScyllaHosts" yaml:"ScyllaHosts"`
+	ScyllaKeyspace    string             `json:"ScyllaKeyspace" yaml:"ScyllaKeyspace"`
+	ScyllaConsistency string             `json:"ScyllaConsistency" yaml:"ScyllaConsistency"`
+	ScyllaReplication string             `json:"ScyllaReplication,optional" yaml:"ScyllaReplication"`
+	PositionBackend   string             `json:"PositionBackend,optional,options=mongo|scylla|both" yaml:"PositionBackend"`
+	HeartbeatBackend  string             `json:"HeartbeatBackend,optional,options=mongo|scylla|both" yaml:"HeartbeatBackend"`
+	Spool             spool.Conf         `json:"Spool,optional" yaml:"Spool"`
+	Batch             database.BatchConf `json:"Batch,optional" yaml:"Batch"`
+	MongoIndexes      database.IndexConf `json:"MongoIndexes,optional" yaml:"MongoIndexes"`
+	API               APIConf            `json:"API,optional" yaml:"API"`
+	Admin             AdminConf          `json:"Admin,optional" yaml:"Admin"`
+	Metrics           MetricsConf        `json:"Metrics,optional" yaml:"Metrics"`
+	Tracing           tracing.Conf       `json:"Tracing,optional" yaml:"Tracing"`
+	Events            events.Conf        `json:"Events,optional" yaml:"Events"`
+	Commands          commands.Conf      `json:"Commands,optional" yaml:"Commands"`
+	Health            health.Conf        `json:"Health,optional" yaml:"Health"`
+	Journal           journal.Conf       `json:"Journal,optional" yaml:"Journal"`
+	Webhooks          webhook.Conf       `json:"Webhooks,optional" yaml:"Webhooks"`
+	Sinks             sinks.Conf         `json:"Sinks,optional" yaml:"Sinks"`
+}
+
+// APIConf configures the HTTP API served next to the GT06 listener
+type APIConf struct {
+	Enabled   bool   `json:"Enabled,optional" yaml:"Enabled"`
+	Addr      string `json:"Addr,optional" yaml:"Addr"`
+	Timeout   int    `json:"Timeout,optional" yaml:"Timeout"`     // seconds to write a response, streams are not bounded
+	KeepAlive int    `json:"KeepAlive,optional" yaml:"KeepAlive"` // seconds between keep-alive comments on event streams
+}
+
+// AdminConf configures the operator API, served on its own listener so that it can be kept private
+type AdminConf struct {
+	Enabled bool   `json:"Enabled,optional" yaml:"Enabled"`
+	Addr    string `json:"Addr,optional" yaml:"Addr"`
+	Timeout int    `json:"Timeout,optional" yaml:"Timeout"` // seconds to write a response
+	Token   string `json:"Token,optional" yaml:"Token"`     // bearer token required by every request when set
+}
+
+// MetricsConf configures the Prometheus metrics, served on the admin listener
+type MetricsConf struct {
+	Enabled bool   `json:"Enabled,optional" yaml:"Enabled"`
+	Path    string `json:"Path,optional" yaml:"Path"`
 }
 
 // Default returns a Config with default values
 func Default() Config {
 	return Config{
 		TCPServer:         "0.0.0.0:8000",
+		StorageBackend:    "mongo",
 		MongoURI:          "mongodb://localhost:27017",
 		DBName:            "gt06",
 		LogLevel:          "info",
@@ -22,5 +74,33 @@ func Default() Config {
 		ScyllaHosts:       []string{"localhost:9042"},
 		ScyllaKeyspace:    "gt06",
 		ScyllaConsistency: "LOCAL_ONE",
+		ScyllaReplication: "{'class': 'SimpleStrategy', 'replication_factor': 1}",
+		PositionBackend:   "mongo",
+		HeartbeatBackend:  "mongo",
+		Spool:             spool.DefaultConf(),
+		Batch:             database.DefaultBatchConf(),
+		MongoIndexes:      database.DefaultIndexConf(),
+		API: APIConf{
+			Enabled:   true,
+			Addr:      "0.0.0.0:8080",
+			Timeout:   30,
+			KeepAlive: 15,
+		},
+		Admin: AdminConf{
+			Enabled: true,
+			Addr:    "127.0.0.1:8081",
+			Timeout: 30,
+		},
+		Metrics: MetricsConf{
+			Enabled: true,
+			Path:    "/metrics",
+		},
+		Tracing:  tracing.DefaultConf(),
+		Events:   events.DefaultConf(),
+		Commands: commands.DefaultConf(),
+		Health:   health.DefaultConf(),
+		Journal:  journal.DefaultConf(),
+		Webhooks: webhook.DefaultConf(),
+		Sinks:    sinks.DefaultConf(),
 	}
 }
@@ -0,0 +1,228 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"gt06/metrics"
+	"gt06/tracing"
+	"sync"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/logx"
+	"github.com/zeromicro/go-zero/core/threading"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	ErrBatchWriterClosed = errors.New("batch writer is closed")
+	ErrBatchQueueFull    = errors.New("too many batches waiting for a flusher")
+)
+
+// BatchConf configures the batching of position and alarm inserts
+type BatchConf struct {
+	Enabled  bool `json:"Enabled,optional" yaml:"Enabled"`
+	Size     int  `json:"Size,optional" yaml:"Size"`         // records that trigger a flush
+	Interval int  `json:"Interval,optional" yaml:"Interval"` // milliseconds a record waits for a full batch
+	Flushers int  `json:"Flushers,optional" yaml:"Flushers"` // concurrent flushes
+	Queue    int  `json:"Queue,optional" yaml:"Queue"`       // full batches waiting for a flusher, further ones fail
+	Timeout  int  `json:"Timeout,optional" yaml:"Timeout"`   // seconds
+}
+
+// DefaultBatchConf returns a BatchConf with default values
+func DefaultBatchConf() BatchConf {
+	return BatchConf{
+		Enabled:  false,
+		Size:     500,
+		Interval: 50,
+		Flushers: 4,
+		Queue:    8,
+		Timeout:  10,
+	}
+}
+
+type batchItem[T any] struct {
+	record *T
+	done   func(err error)
+	span   trace.SpanContext // span that added the record, linked from the flush span
+}
+
+// BatchWriter groups records added from many sessions and inserts them in bulk once Size
+// records are pending or the oldest one waited Interval. Add never blocks: while every flusher
+// is busy the batches wait in a queue of Queue, and a batch that does not fit fails its records
+// with ErrBatchQueueFull, so a slow storage never holds the event loops.
+type BatchWriter[T any] struct {
+	name       string
+	queue      string // label of the queue depth metric
+	conf       BatchConf
+	insertMany func(ctx context.Context, records []*T) error
+
+	mu      sync.Mutex
+	pending []batchItem[T]
+	timer   *time.Timer
+	closed  bool
+
+	flushes chan []batchItem[T]
+	wg      sync.WaitGroup
+}
+
+// NewBatchWriter starts a BatchWriter flushing with insertMany, name is used in logs
+func NewBatchWriter[T any](name string, c BatchConf, insertMany func(ctx context.Context, records []*T) error) *BatchWriter[T] {
+	defaults := DefaultBatchConf()
+	if c.Size <= 0 {
+		c.Size = defaults.Size
+	}
+	if c.Interval <= 0 {
+		c.Interval = defaults.Interval
+	}
+	if c.Flushers <= 0 {
+		c.Flushers = defaults.Flushers
+	}
+	if c.Queue <= 0 {
+		c.Queue = defaults.Queue
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = defaults.Timeout
+	}
+
+	w := &BatchWriter[T]{
+		name:       name,
+		queue:      "batch_" + name,
+		conf:       c,
+		insertMany: insertMany,
+		pending:    make([]batchItem[T], 0, c.Size),
+		flushes:    make(chan []batchItem[T], c.Queue),
+	}
+
+	for i := 0; i < c.Flushers; i++ {
+		w.wg.Add(1)
+		threading.GoSafe(func() {
+			defer w.wg.Done()
+			for batch := range w.flushes {
+				w.flush(batch)
+			}
+		})
+	}
+
+	return w
+}
+
+// Add queues record, done is called once from a flusher with the outcome of its insert.
+// The span of ctx is linked from the span of the bulk insert.
+func (w *BatchWriter[T]) Add(ctx context.Context, record *T, done func(err error)) {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		done(ErrBatchWriterClosed)
+		return
+	}
+
+	w.pending = append(w.pending, batchItem[T]{record: record, done: done, span: trace.SpanContextFromContext(ctx)})
+	metrics.QueueDepth.Inc(w.queue)
+	var rejected []batchItem[T]
+	if len(w.pending) >= w.conf.Size {
+		rejected = w.dispatchLocked()
+	} else if w.timer == nil {
+		w.timer = time.AfterFunc(time.Duration(w.conf.Interval)*time.Millisecond, w.flushPending)
+	}
+	w.mu.Unlock()
+
+	w.reject(rejected)
+}
+
+// Close flushes the pending records and waits for the running flushes
+func (w *BatchWriter[T]) Close() {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return
+	}
+	w.closed = true
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	batch := w.pending
+	w.pending = nil
+	w.mu.Unlock()
+
+	// nothing is sent once closed is set, this last batch may wait for a flusher
+	if len(batch) > 0 {
+		w.flushes <- batch
+	}
+	close(w.flushes)
+	w.wg.Wait()
+}
+
+func (w *BatchWriter[T]) flushPending() {
+	w.mu.Lock()
+	var rejected []batchItem[T]
+	if !w.closed {
+		rejected = w.dispatchLocked()
+	}
+	w.mu.Unlock()
+
+	w.reject(rejected)
+}
+
+// dispatchLocked queues the pending records for a flusher without waiting, it returns them when
+// the queue is full. The send happens under the lock so that Close cannot close the channel in
+// between.
+func (w *BatchWriter[T]) dispatchLocked() []batchItem[T] {
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	if len(w.pending) == 0 {
+		return nil
+	}
+
+	batch := w.pending
+	w.pending = make([]batchItem[T], 0, w.conf.Size)
+	select {
+	case w.flushes <- batch:
+		return nil
+	default:
+		return batch
+	}
+}
+
+// reject fails the records of a batch that did not fit the queue
+func (w *BatchWriter[T]) reject(batch []batchItem[T]) {
+	if len(batch) == 0 {
+		return
+	}
+
+	logx.Errorf("Dropped a batch of %d %s: %v", len(batch), w.name, ErrBatchQueueFull)
+	metrics.QueueDepth.Sub(float64(len(batch)), w.queue)
+	for _, item := range batch {
+		item.done(ErrBatchQueueFull)
+	}
+}
+
+func (w *BatchWriter[T]) flush(batch []batchItem[T]) {
+	records := make([]*T, len(batch))
+	links := make([]trace.Link, 0, len(batch))
+	for i, item := range batch {
+		records[i] = item.record
+		if item.span.IsValid() {
+			links = append(links, trace.Link{SpanContext: item.span})
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(w.conf.Timeout)*time.Second)
+	defer cancel()
+
+	ctx, span := tracing.Tracer().Start(ctx, "batch "+w.name, trace.WithLinks(links...),
+		trace.WithAttributes(attribute.Int("gt06.batch.size", len(records))))
+	err := w.insertMany(ctx, records)
+	tracing.End(span, err)
+	if err != nil {
+		logx.Errorf("Bulk insert of %d %s failed: %v", len(records), w.name, err)
+	}
+
+	metrics.QueueDepth.Sub(float64(len(batch)), w.queue)
+	for i, item := range batch {
+		item.done(InsertErrorOf(err, i))
+	}
+}
@@ -0,0 +1,304 @@
+package database
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/logx"
+	"github.com/zeromicro/go-zero/core/threading"
+)
+
+// StateUpdate is a change to the state of a device, nil fields are left as they are
+type StateUpdate struct {
+	IMEI         string
+	At           time.Time // when the packet was received
+	ConnectionID string
+	RemoteAddr   string
+	// Online marks the device connected on ConnectionID, or disconnected from it. Going offline
+	// is ignored when the device has reconnected on another connection meanwhile.
+	Online *bool
+	// Position replaces the stored one only when its fix is newer
+	Position    *StatePosition
+	ACC         *bool
+	Battery     *int
+	GSMSignal   *int
+	LastAlarmAt *time.Time
+}
+
+// apply merges u into s, the same rules are implemented by the MongoDB update pipeline
+func (s *DeviceState) apply(u *StateUpdate) {
+	if u.At.After(s.LastSeen) {
+		s.LastSeen = u.At
+	}
+	if u.Online != nil {
+		if *u.Online {
+			s.Online = true
+			s.ConnectionID = u.ConnectionID
+			s.RemoteAddr = u.RemoteAddr
+		} else if s.ConnectionID == u.ConnectionID {
+			s.Online = false
+		}
+	}
+	if u.Position != nil && (s.Position == nil || u.Position.DateTime.After(s.Position.DateTime)) {
+		position := *u.Position
+		s.Position = &position
+	}
+	if u.ACC != nil {
+		acc := *u.ACC
+		s.ACC = &acc
+	}
+	if u.Battery != nil {
+		battery := *u.Battery
+		s.Battery = &battery
+	}
+	if u.GSMSignal != nil {
+		signal := *u.GSMSignal
+		s.GSMSignal = &signal
+	}
+	if u.LastAlarmAt != nil && (s.LastAlarmAt == nil || u.LastAlarmAt.After(*s.LastAlarmAt)) {
+		at := *u.LastAlarmAt
+		s.LastAlarmAt = &at
+	}
+	s.UpdatedAt = time.Now()
+}
+
+type memoryDeviceStateRepository struct {
+	mu     sync.RWMutex
+	states map[string]*DeviceState
+}
+
+// NewMemoryDeviceStateRepository initializes a DeviceStateRepository kept in process memory
+func NewMemoryDeviceStateRepository() DeviceStateRepository {
+	return newMemoryDeviceStateRepository()
+}
+
+func newMemoryDeviceStateRepository() *memoryDeviceStateRepository {
+	return &memoryDeviceStateRepository{states: make(map[string]*DeviceState)}
+}
+
+// Apply merges update into the state of its device
+func (r *memoryDeviceStateRepository) Apply(ctx context.Context, update *StateUpdate) (before, after *DeviceState, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state, ok := r.states[update.IMEI]
+	if ok {
+		before = state.copy()
+	} else {
+		state = &DeviceState{IMEI: update.IMEI}
+		r.states[update.IMEI] = state
+	}
+	state.apply(update)
+	return before, state.copy(), nil
+}
+
+// Get retrieves the state of a device
+func (r *memoryDeviceStateRepository) Get(ctx context.Context, imei string) (*DeviceState, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	state, ok := r.states[imei]
+	if !ok {
+		return nil, nil
+	}
+	return state.copy(), nil
+}
+
+// List retrieves the states of the fleet, most recently seen first
+func (r *memoryDeviceStateRepository) List(ctx context.Context, skip, limit int64) ([]*DeviceState, error) {
+	r.mu.RLock()
+	states := make([]*DeviceState, 0, len(r.states))
+	for _, state := range r.states {
+		states = append(states, state.copy())
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(states, func(i, j int) bool {
+		if !states[i].LastSeen.Equal(states[j].LastSeen) {
+			return states[i].LastSeen.After(states[j].LastSeen)
+		}
+		return states[i].IMEI < states[j].IMEI
+	})
+	return paginate(states, skip, limit), nil
+}
+
+// MarkAllOffline sets every device offline
+func (r *memoryDeviceStateRepository) MarkAllOffline(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, state := range r.states {
+		state.Online = false
+	}
+	return nil
+}
+
+// Save replaces the stored states
+func (r *memoryDeviceStateRepository) Save(ctx context.Context, states []*DeviceState) error {
+	copies := make([]*DeviceState, len(states))
+	for i, state := range states {
+		copies[i] = state.copy()
+	}
+	r.load(copies)
+	return nil
+}
+
+func (r *memoryDeviceStateRepository) load(states []*DeviceState) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, state := range states {
+		r.states[state.IMEI] = state
+	}
+}
+
+// copy returns a deep copy so callers cannot race with later updates
+func (s *DeviceState) copy() *DeviceState {
+	c := *s
+	if s.Position != nil {
+		position := *s.Position
+		c.Position = &position
+	}
+	if s.ACC != nil {
+		acc := *s.ACC
+		c.ACC = &acc
+	}
+	if s.Battery != nil {
+		battery := *s.Battery
+		c.Battery = &battery
+	}
+	if s.GSMSignal != nil {
+		signal := *s.GSMSignal
+		c.GSMSignal = &signal
+	}
+	if s.LastAlarmAt != nil {
+		at := *s.LastAlarmAt
+		c.LastAlarmAt = &at
+	}
+	return &c
+}
+
+// cachedDeviceStateRepository answers from memory and persists the changed states in the background
+type cachedDeviceStateRepository struct {
+	cache     *memoryDeviceStateRepository
+	persisted DeviceStateRepository
+
+	mu    sync.Mutex
+	dirty map[string]struct{}
+}
+
+// NewCachedDeviceStateRepository keeps the states of persisted in memory, loading them first.
+// Reads never reach persisted, the states changed since the last flush are saved every interval
+// until ctx is done and once more when the returned stop is called.
+func NewCachedDeviceStateRepository(ctx context.Context, persisted DeviceStateRepository,
+	interval time.Duration) (DeviceStateRepository, func(), error) {
+	states, err := persisted.List(ctx, 0, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r := &cachedDeviceStateRepository{
+		cache:     newMemoryDeviceStateRepository(),
+		persisted: persisted,
+		dirty:     make(map[string]struct{}),
+	}
+	r.cache.load(states)
+	logx.Infof("Loaded the state of %d devices", len(states))
+
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	threading.GoSafe(func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-ticker.C:
+				r.flush()
+			case <-done:
+				r.flush()
+				return
+			}
+		}
+	})
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			ticker.Stop()
+			close(done)
+			<-stopped
+		})
+	}
+	return r, stop, nil
+}
+
+// Apply merges update into the cached state, it is persisted with the next flush
+func (r *cachedDeviceStateRepository) Apply(ctx context.Context, update *StateUpdate) (before, after *DeviceState, err error) {
+	before, after, _ = r.cache.Apply(ctx, update)
+	r.markDirty(update.IMEI)
+	return before, after, nil
+}
+
+// Get retrieves the cached state of a device
+func (r *cachedDeviceStateRepository) Get(ctx context.Context, imei string) (*DeviceState, error) {
+	return r.cache.Get(ctx, imei)
+}
+
+// List retrieves the cached states of the fleet, most recently seen first
+func (r *cachedDeviceStateRepository) List(ctx context.Context, skip, limit int64) ([]*DeviceState, error) {
+	return r.cache.List(ctx, skip, limit)
+}
+
+// MarkAllOffline sets every device offline in the cache and the persisted store
+func (r *cachedDeviceStateRepository) MarkAllOffline(ctx context.Context) error {
+	_ = r.cache.MarkAllOffline(ctx)
+	return r.persisted.MarkAllOffline(ctx)
+}
+
+// Save replaces the cached states, they are persisted with the next flush
+func (r *cachedDeviceStateRepository) Save(ctx context.Context, states []*DeviceState) error {
+	r.cache.load(states)
+	for _, state := range states {
+		r.markDirty(state.IMEI)
+	}
+	return nil
+}
+
+func (r *cachedDeviceStateRepository) markDirty(imei string) {
+	r.mu.Lock()
+	r.dirty[imei] = struct{}{}
+	r.mu.Unlock()
+}
+
+// flush saves the states changed since the last flush, they are retried with the next one on failure
+func (r *cachedDeviceStateRepository) flush() {
+	r.mu.Lock()
+	dirty := r.dirty
+	r.dirty = make(map[string]struct{})
+	r.mu.Unlock()
+
+	if len(dirty) == 0 {
+		return
+	}
+
+	states := make([]*DeviceState, 0, len(dirty))
+	for imei := range dirty {
+		if state, _ := r.cache.Get(context.Background(), imei); state != nil {
+			states = append(states, state)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := r.persisted.Save(ctx, states); err != nil {
+		logx.Errorf("Failed to persist the state of %d devices: %v", len(states), err)
+		r.mu.Lock()
+		for imei := range dirty {
+			r.dirty[imei] = struct{}{}
+		}
+		r.mu.Unlock()
+	}
+}
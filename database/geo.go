@@ -0,0 +1,265 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// earthRadius is the mean Earth radius in meters used for spherical distances
+const earthRadius = 6371008.8
+
+var (
+	ErrGeoNotSupported = errors.New("geospatial queries are not supported by this storage")
+	ErrInvalidArea     = errors.New("invalid area")
+)
+
+// GeoPoint is a GeoJSON Point, Coordinates are [longitude, latitude]
+type GeoPoint struct {
+	Type        string    `bson:"type" json:"type"`
+	Coordinates []float64 `bson:"coordinates" json:"coordinates"`
+}
+
+// NewGeoPoint returns the GeoJSON Point of a position, nil when the coordinates are out of range
+// since a 2dsphere index refuses such documents
+func NewGeoPoint(latitude, longitude float64) *GeoPoint {
+	if !validLatLng(LatLng{Latitude: latitude, Longitude: longitude}) {
+		return nil
+	}
+	return &GeoPoint{Type: "Point", Coordinates: []float64{longitude, latitude}}
+}
+
+// LatLng is a coordinate in decimal degrees
+type LatLng struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// Area is a region positions can be searched in, one of Circle, Polygon or Box
+type Area interface {
+	// Contains reports whether p lies in the area
+	Contains(p LatLng) bool
+	// Validate returns an error wrapping ErrInvalidArea when the area cannot be searched
+	Validate() error
+	// geoWithin returns the $geoWithin operand selecting the area
+	geoWithin() (bson.M, error)
+}
+
+// Circle is the area within Radius meters of Center
+type Circle struct {
+	Center LatLng
+	Radius float64
+}
+
+func (c Circle) Contains(p LatLng) bool {
+	return distance(c.Center, p) <= c.Radius
+}
+
+func (c Circle) Validate() error {
+	if !validLatLng(c.Center) || c.Radius <= 0 {
+		return fmt.Errorf("%w: the center must be a valid coordinate and the radius positive", ErrInvalidArea)
+	}
+	return nil
+}
+
+func (c Circle) geoWithin() (bson.M, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	center := []float64{c.Center.Longitude, c.Center.Latitude}
+	return bson.M{"$centerSphere": bson.A{center, c.Radius / earthRadius}}, nil
+}
+
+// Polygon is the area enclosed by its vertices, the ring is closed automatically
+type Polygon []LatLng
+
+func (poly Polygon) Contains(p LatLng) bool {
+	// ray casting on the plane of the coordinates, adequate for areas well below a hemisphere
+	inside := false
+	for i, j := 0, len(poly)-1; i < len(poly); j, i = i, i+1 {
+		a, b := poly[i], poly[j]
+		if (a.Latitude > p.Latitude) != (b.Latitude > p.Latitude) {
+			lng := a.Longitude + (p.Latitude-a.Latitude)/(b.Latitude-a.Latitude)*(b.Longitude-a.Longitude)
+			if p.Longitude < lng {
+				inside = !inside
+			}
+		}
+	}
+	return inside
+}
+
+func (poly Polygon) Validate() error {
+	if len(poly) < 3 {
+		return fmt.Errorf("%w: a polygon needs at least 3 vertices, got %d", ErrInvalidArea, len(poly))
+	}
+	for _, p := range poly {
+		if !validLatLng(p) {
+			return fmt.Errorf("%w: polygon vertex %g,%g is out of range", ErrInvalidArea, p.Latitude, p.Longitude)
+		}
+	}
+	return nil
+}
+
+func (poly Polygon) geoWithin() (bson.M, error) {
+	if err := poly.Validate(); err != nil {
+		return nil, err
+	}
+
+	ring := make(bson.A, 0, len(poly)+1)
+	for _, p := range poly {
+		ring = append(ring, []float64{p.Longitude, p.Latitude})
+	}
+	if poly[0] != poly[len(poly)-1] {
+		ring = append(ring, []float64{poly[0].Longitude, poly[0].Latitude})
+	}
+
+	return bson.M{"$geometry": bson.M{"type": "Polygon", "coordinates": bson.A{ring}}}, nil
+}
+
+// Box is the area between the SouthWest and NorthEast corners, its edges are geodesics.
+// A box crossing the antimeridian has a SouthWest longitude greater than its NorthEast longitude.
+type Box struct {
+	SouthWest LatLng
+	NorthEast LatLng
+}
+
+func (b Box) Contains(p LatLng) bool {
+	if p.Latitude < b.SouthWest.Latitude || p.Latitude > b.NorthEast.Latitude {
+		return false
+	}
+	if b.SouthWest.Longitude <= b.NorthEast.Longitude {
+		return p.Longitude >= b.SouthWest.Longitude && p.Longitude <= b.NorthEast.Longitude
+	}
+	return p.Longitude >= b.SouthWest.Longitude || p.Longitude <= b.NorthEast.Longitude
+}
+
+func (b Box) Validate() error {
+	sw, ne := b.SouthWest, b.NorthEast
+	if !validLatLng(sw) || !validLatLng(ne) {
+		return fmt.Errorf("%w: box corners must be valid coordinates", ErrInvalidArea)
+	}
+	if sw.Latitude >= ne.Latitude || sw.Longitude == ne.Longitude {
+		return fmt.Errorf("%w: box south must be below north and west differ from east", ErrInvalidArea)
+	}
+
+	width := ne.Longitude - sw.Longitude
+	if width < 0 {
+		width += 360
+	}
+	if width >= 180 {
+		// MongoDB takes the smaller area a ring encloses, use a polygon for such areas
+		return fmt.Errorf("%w: box spans %.0f degrees of longitude, less than 180 are supported", ErrInvalidArea, width)
+	}
+	return nil
+}
+
+func (b Box) geoWithin() (bson.M, error) {
+	if err := b.Validate(); err != nil {
+		return nil, err
+	}
+	sw, ne := b.SouthWest, b.NorthEast
+
+	// a box is sent as a GeoJSON polygon, the legacy $box is planar and ignores the 2dsphere index
+	if sw.Longitude < ne.Longitude {
+		return bson.M{"$geometry": bson.M{"type": "Polygon", "coordinates": bson.A{boxRing(sw, ne)}}}, nil
+	}
+
+	// split at the antimeridian
+	west := boxRing(sw, LatLng{Latitude: ne.Latitude, Longitude: 180})
+	east := boxRing(LatLng{Latitude: sw.Latitude, Longitude: -180}, ne)
+	return bson.M{"$geometry": bson.M{"type": "MultiPolygon", "coordinates": bson.A{bson.A{west}, bson.A{east}}}}, nil
+}
+
+func boxRing(sw, ne LatLng) bson.A {
+	return bson.A{
+		[]float64{sw.Longitude, sw.Latitude},
+		[]float64{ne.Longitude, sw.Latitude},
+		[]float64{ne.Longitude, ne.Latitude},
+		[]float64{sw.Longitude, ne.Latitude},
+		[]float64{sw.Longitude, sw.Latitude},
+	}
+}
+
+// BackfillLocations sets the GeoJSON location of position and alarm documents stored before it
+// existed. Those documents were stored with unsigned coordinates, the hemispheres are taken from
+// the north and west bits of their course_status, as when decoding. Documents with out of range
+// coordinates are left without one.
+func BackfillLocations(ctx context.Context, db *mongo.Database) (int64, error) {
+	filter := bson.M{
+		"location":  bson.M{"$exists": false},
+		"latitude":  bson.M{"$gte": -90, "$lte": 90},
+		"longitude": bson.M{"$gte": -180, "$lte": 180},
+	}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"latitude":  signedCoordinate("$latitude", courseStatusNorth, false),
+			"longitude": signedCoordinate("$longitude", courseStatusWest, true),
+		}}},
+		{{Key: "$set", Value: bson.M{"location": bson.M{
+			"type":        "Point",
+			"coordinates": bson.A{"$longitude", "$latitude"},
+		}}}},
+	}
+
+	var total int64
+	for _, name := range []string{CollectionLocation, CollectionAlarm} {
+		ret, err := db.Collection(name).UpdateMany(ctx, filter, update)
+		if err != nil {
+			return total, fmt.Errorf("failed to backfill %s: %w", name, err)
+		}
+		total += ret.ModifiedCount
+	}
+	return total, nil
+}
+
+// Hemisphere bits of the course/status field
+const (
+	courseStatusNorth = 1 << 10 // north latitude, south when clear
+	courseStatusWest  = 1 << 11 // west longitude, east when clear
+)
+
+// signedCoordinate is the expression signing the unsigned coordinate field by the bit of
+// course_status, negative when the bit is set and negativeWhenSet or clear and not. Documents
+// without a course_status keep their value.
+func signedCoordinate(field string, bit int, negativeWhenSet bool) bson.M {
+	set := bson.M{"$eq": bson.A{bson.M{"$mod": bson.A{bson.M{"$trunc": bson.M{"$divide": bson.A{"$course_status", bit}}}, 2}}, 1}}
+	negative := set
+	if !negativeWhenSet {
+		negative = bson.M{"$not": bson.A{set}}
+	}
+	return bson.M{"$cond": bson.A{
+		bson.M{"$eq": bson.A{bson.M{"$type": "$course_status"}, "missing"}},
+		field,
+		bson.M{"$cond": bson.A{negative, bson.M{"$multiply": bson.A{-1, bson.M{"$abs": field}}}, bson.M{"$abs": field}}},
+	}}
+}
+
+// geoFilter adds the area of a geospatial query to the time-series filter of q
+func geoFilter(q Query, timeField string, area Area) (bson.M, error) {
+	within, err := area.geoWithin()
+	if err != nil {
+		return nil, err
+	}
+
+	filter := seriesFilter(q, timeField)
+	filter["location"] = bson.M{"$geoWithin": within}
+	return filter, nil
+}
+
+func validLatLng(p LatLng) bool {
+	return p.Latitude >= -90 && p.Latitude <= 90 && p.Longitude >= -180 && p.Longitude <= 180
+}
+
+// distance returns the great-circle distance between a and b in meters
+func distance(a, b LatLng) float64 {
+	lat1, lat2 := a.Latitude*math.Pi/180, b.Latitude*math.Pi/180
+	dLat := lat2 - lat1
+	dLng := (b.Longitude - a.Longitude) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
@@ -0,0 +1,218 @@
+package database
+
+import (
+	"context"
+	"gt06/metrics"
+	"gt06/tracing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// NewInstrumentedRepositories wraps every call of repos in a span and records its latency
+// and errors by operation, named collection.method
+func NewInstrumentedRepositories(repos Repositories) Repositories {
+	return Repositories{
+		Devices:    &instrumentedDeviceRepository{repos.Devices},
+		Logins:     &instrumentedLoginEventRepository{repos.Logins},
+		Positions:  &instrumentedPositionRepository{repos.Positions},
+		Alarms:     &instrumentedAlarmRepository{repos.Alarms},
+		Heartbeats: &instrumentedHeartbeatRepository{repos.Heartbeats},
+		States:     &instrumentedDeviceStateRepository{repos.States},
+		Commands:   &instrumentedCommandRepository{repos.Commands},
+		Blocklist:  &instrumentedBlocklistRepository{repos.Blocklist},
+	}
+}
+
+// observe runs call in a span of the operation and records its latency
+func observe(ctx context.Context, operation string, call func(ctx context.Context) error) error {
+	_, err := observeResult(ctx, operation, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, call(ctx)
+	})
+	return err
+}
+
+func observeResult[T any](ctx context.Context, operation string, call func(ctx context.Context) (T, error)) (T, error) {
+	ctx, span := tracing.Tracer().Start(ctx, operation, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBOperation(operation)))
+	start := time.Now()
+
+	result, err := call(ctx)
+	metrics.ObserveStorage(operation, start, err)
+	tracing.End(span, err)
+	return result, err
+}
+
+type instrumentedDeviceRepository struct {
+	next DeviceRepository
+}
+
+func (r *instrumentedDeviceRepository) RecordLogin(ctx context.Context, login *LoginEvent) error {
+	return observe(ctx, "devices.record_login", func(ctx context.Context) error { return r.next.RecordLogin(ctx, login) })
+}
+
+func (r *instrumentedDeviceRepository) Touch(ctx context.Context, imei string, at time.Time) error {
+	return observe(ctx, "devices.touch", func(ctx context.Context) error { return r.next.Touch(ctx, imei, at) })
+}
+
+func (r *instrumentedDeviceRepository) FindByIMEI(ctx context.Context, imei string) (*Device, error) {
+	return observeResult(ctx, "devices.find_by_imei", func(ctx context.Context) (*Device, error) { return r.next.FindByIMEI(ctx, imei) })
+}
+
+func (r *instrumentedDeviceRepository) List(ctx context.Context, skip, limit int64) ([]*Device, error) {
+	return observeResult(ctx, "devices.list", func(ctx context.Context) ([]*Device, error) { return r.next.List(ctx, skip, limit) })
+}
+
+type instrumentedLoginEventRepository struct {
+	next LoginEventRepository
+}
+
+func (r *instrumentedLoginEventRepository) Insert(ctx context.Context, login *LoginEvent) error {
+	return observe(ctx, "logins.insert", func(ctx context.Context) error { return r.next.Insert(ctx, login) })
+}
+
+func (r *instrumentedLoginEventRepository) Find(ctx context.Context, q Query) ([]*LoginEvent, error) {
+	return observeResult(ctx, "logins.find", func(ctx context.Context) ([]*LoginEvent, error) { return r.next.Find(ctx, q) })
+}
+
+func (r *instrumentedLoginEventRepository) FindLatest(ctx context.Context, imei string) (*LoginEvent, error) {
+	return observeResult(ctx, "logins.find_latest", func(ctx context.Context) (*LoginEvent, error) { return r.next.FindLatest(ctx, imei) })
+}
+
+type instrumentedPositionRepository struct {
+	next PositionRepository
+}
+
+func (r *instrumentedPositionRepository) Insert(ctx context.Context, position *Position) error {
+	return observe(ctx, "positions.insert", func(ctx context.Context) error { return r.next.Insert(ctx, position) })
+}
+
+func (r *instrumentedPositionRepository) InsertMany(ctx context.Context, positions []*Position) error {
+	return observe(ctx, "positions.insert_many", func(ctx context.Context) error { return r.next.InsertMany(ctx, positions) })
+}
+
+func (r *instrumentedPositionRepository) Find(ctx context.Context, q Query) ([]*Position, error) {
+	return observeResult(ctx, "positions.find", func(ctx context.Context) ([]*Position, error) { return r.next.Find(ctx, q) })
+}
+
+func (r *instrumentedPositionRepository) FindLatest(ctx context.Context, imei string) (*Position, error) {
+	return observeResult(ctx, "positions.find_latest", func(ctx context.Context) (*Position, error) { return r.next.FindLatest(ctx, imei) })
+}
+
+func (r *instrumentedPositionRepository) FindWithin(ctx context.Context, q Query, area Area) ([]*Position, error) {
+	return observeResult(ctx, "positions.find_within", func(ctx context.Context) ([]*Position, error) { return r.next.FindWithin(ctx, q, area) })
+}
+
+type instrumentedAlarmRepository struct {
+	next AlarmRepository
+}
+
+func (r *instrumentedAlarmRepository) Insert(ctx context.Context, alarm *Alarm) error {
+	return observe(ctx, "alarms.insert", func(ctx context.Context) error { return r.next.Insert(ctx, alarm) })
+}
+
+func (r *instrumentedAlarmRepository) InsertMany(ctx context.Context, alarms []*Alarm) error {
+	return observe(ctx, "alarms.insert_many", func(ctx context.Context) error { return r.next.InsertMany(ctx, alarms) })
+}
+
+func (r *instrumentedAlarmRepository) Find(ctx context.Context, q Query) ([]*Alarm, error) {
+	return observeResult(ctx, "alarms.find", func(ctx context.Context) ([]*Alarm, error) { return r.next.Find(ctx, q) })
+}
+
+func (r *instrumentedAlarmRepository) FindLatest(ctx context.Context, imei string) (*Alarm, error) {
+	return observeResult(ctx, "alarms.find_latest", func(ctx context.Context) (*Alarm, error) { return r.next.FindLatest(ctx, imei) })
+}
+
+type instrumentedHeartbeatRepository struct {
+	next HeartbeatRepository
+}
+
+func (r *instrumentedHeartbeatRepository) Insert(ctx context.Context, heartbeat *Heartbeat) error {
+	return observe(ctx, "heartbeats.insert", func(ctx context.Context) error { return r.next.Insert(ctx, heartbeat) })
+}
+
+func (r *instrumentedHeartbeatRepository) Find(ctx context.Context, q Query) ([]*Heartbeat, error) {
+	return observeResult(ctx, "heartbeats.find", func(ctx context.Context) ([]*Heartbeat, error) { return r.next.Find(ctx, q) })
+}
+
+func (r *instrumentedHeartbeatRepository) FindLatest(ctx context.Context, imei string) (*Heartbeat, error) {
+	return observeResult(ctx, "heartbeats.find_latest", func(ctx context.Context) (*Heartbeat, error) { return r.next.FindLatest(ctx, imei) })
+}
+
+type instrumentedDeviceStateRepository struct {
+	next DeviceStateRepository
+}
+
+func (r *instrumentedDeviceStateRepository) Apply(ctx context.Context, update *StateUpdate) (before, after *DeviceState, err error) {
+	err = observe(ctx, "device_state.apply", func(ctx context.Context) (err error) {
+		before, after, err = r.next.Apply(ctx, update)
+		return err
+	})
+	return before, after, err
+}
+
+func (r *instrumentedDeviceStateRepository) Get(ctx context.Context, imei string) (*DeviceState, error) {
+	return observeResult(ctx, "device_state.get", func(ctx context.Context) (*DeviceState, error) { return r.next.Get(ctx, imei) })
+}
+
+func (r *instrumentedDeviceStateRepository) List(ctx context.Context, skip, limit int64) ([]*DeviceState, error) {
+	return observeResult(ctx, "device_state.list", func(ctx context.Context) ([]*DeviceState, error) { return r.next.List(ctx, skip, limit) })
+}
+
+func (r *instrumentedDeviceStateRepository) MarkAllOffline(ctx context.Context) error {
+	return observe(ctx, "device_state.mark_all_offline", func(ctx context.Context) error { return r.next.MarkAllOffline(ctx) })
+}
+
+func (r *instrumentedDeviceStateRepository) Save(ctx context.Context, states []*DeviceState) error {
+	return observe(ctx, "device_state.save", func(ctx context.Context) error { return r.next.Save(ctx, states) })
+}
+
+type instrumentedCommandRepository struct {
+	next CommandRepository
+}
+
+func (r *instrumentedCommandRepository) Insert(ctx context.Context, command *Command) error {
+	return observe(ctx, "commands.insert", func(ctx context.Context) error { return r.next.Insert(ctx, command) })
+}
+
+func (r *instrumentedCommandRepository) Transition(ctx context.Context, command *Command, from string) (bool, error) {
+	return observeResult(ctx, "commands.transition", func(ctx context.Context) (bool, error) { return r.next.Transition(ctx, command, from) })
+}
+
+func (r *instrumentedCommandRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*Command, error) {
+	return observeResult(ctx, "commands.find_by_id", func(ctx context.Context) (*Command, error) { return r.next.FindByID(ctx, id) })
+}
+
+func (r *instrumentedCommandRepository) Find(ctx context.Context, q Query) ([]*Command, error) {
+	return observeResult(ctx, "commands.find", func(ctx context.Context) ([]*Command, error) { return r.next.Find(ctx, q) })
+}
+
+func (r *instrumentedCommandRepository) FindQueued(ctx context.Context, imei string) ([]*Command, error) {
+	return observeResult(ctx, "commands.find_queued", func(ctx context.Context) ([]*Command, error) { return r.next.FindQueued(ctx, imei) })
+}
+
+func (r *instrumentedCommandRepository) ExpireQueued(ctx context.Context, now time.Time) (int64, error) {
+	return observeResult(ctx, "commands.expire_queued", func(ctx context.Context) (int64, error) { return r.next.ExpireQueued(ctx, now) })
+}
+
+type instrumentedBlocklistRepository struct {
+	next BlocklistRepository
+}
+
+func (r *instrumentedBlocklistRepository) Block(ctx context.Context, device *BlockedDevice) error {
+	return observe(ctx, "blocklist.block", func(ctx context.Context) error { return r.next.Block(ctx, device) })
+}
+
+func (r *instrumentedBlocklistRepository) Unblock(ctx context.Context, imei string) (bool, error) {
+	return observeResult(ctx, "blocklist.unblock", func(ctx context.Context) (bool, error) { return r.next.Unblock(ctx, imei) })
+}
+
+func (r *instrumentedBlocklistRepository) Get(ctx context.Context, imei string) (*BlockedDevice, error) {
+	return observeResult(ctx, "blocklist.get", func(ctx context.Context) (*BlockedDevice, error) { return r.next.Get(ctx, imei) })
+}
+
+func (r *instrumentedBlocklistRepository) List(ctx context.Context, skip, limit int64) ([]*BlockedDevice, error) {
+	return observeResult(ctx, "blocklist.list", func(ctx context.Context) ([]*BlockedDevice, error) { return r.next.List(ctx, skip, limit) })
+}
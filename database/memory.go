@@ -0,0 +1,162 @@
+package database
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// NewMemoryRepositories initializes in-memory repositories, intended for development, demos and tests.
+// Nothing is persisted across restarts.
+func NewMemoryRepositories() Repositories {
+	return Repositories{
+		Devices:    NewMemoryDeviceRepository(),
+		Logins:     NewMemoryLoginEventRepository(),
+		Positions:  NewMemoryPositionRepository(),
+		Alarms:     NewMemoryAlarmRepository(),
+		Heartbeats: NewMemoryHeartbeatRepository(),
+		States:     NewMemoryDeviceStateRepository(),
+		Commands:   NewMemoryCommandRepository(),
+		Blocklist:  NewMemoryBlocklistRepository(),
+	}
+}
+
+// memorySeries keeps records in insertion order and answers time-series queries
+type memorySeries[T any] struct {
+	mu      sync.RWMutex
+	records []*T
+	key     func(*T) (imei string, at time.Time, id *primitive.ObjectID)
+}
+
+func newMemorySeries[T any](key func(*T) (string, time.Time, *primitive.ObjectID)) *memorySeries[T] {
+	return &memorySeries[T]{key: key}
+}
+
+func (m *memorySeries[T]) insert(record *T) {
+	stored := *record
+
+	_, _, id := m.key(&stored)
+	if id.IsZero() {
+		*id = primitive.NewObjectID()
+	}
+
+	m.mu.Lock()
+	m.records = append(m.records, &stored)
+	m.mu.Unlock()
+
+	_, _, original := m.key(record)
+	*original = *id
+}
+
+func (m *memorySeries[T]) find(q Query) []*T {
+	return m.findMatching(q, nil)
+}
+
+// findMatching is find restricted to the records accepted by match, all when match is nil
+func (m *memorySeries[T]) findMatching(q Query, match func(*T) bool) []*T {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	matched := make([]*T, 0)
+	for _, record := range m.records {
+		imei, at, _ := m.key(record)
+		if q.IMEI != "" && imei != q.IMEI {
+			continue
+		}
+		if !q.From.IsZero() && at.Before(q.From) {
+			continue
+		}
+		if !q.To.IsZero() && !at.Before(q.To) {
+			continue
+		}
+		if match != nil && !match(record) {
+			continue
+		}
+
+		copied := *record
+		matched = append(matched, &copied)
+	}
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		_, ti, _ := m.key(matched[i])
+		_, tj, _ := m.key(matched[j])
+		if q.Ascending {
+			return ti.Before(tj)
+		}
+		return ti.After(tj)
+	})
+
+	return paginate(matched, q.Skip, q.Limit)
+}
+
+// get returns a copy of the record with id
+func (m *memorySeries[T]) get(id primitive.ObjectID) *T {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, record := range m.records {
+		if _, _, recordID := m.key(record); *recordID == id {
+			copied := *record
+			return &copied
+		}
+	}
+	return nil
+}
+
+// replace stores record in place of the one with the same id when match accepts it, it reports
+// whether it did
+func (m *memorySeries[T]) replace(record *T, match func(stored *T) bool) bool {
+	stored := *record
+	_, _, id := m.key(&stored)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, existing := range m.records {
+		if _, _, existingID := m.key(existing); *existingID == *id {
+			if !match(existing) {
+				return false
+			}
+			m.records[i] = &stored
+			return true
+		}
+	}
+	return false
+}
+
+// update applies apply to every record in place, it returns how many records it changed
+func (m *memorySeries[T]) update(apply func(*T) bool) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var changed int64
+	for _, record := range m.records {
+		if apply(record) {
+			changed++
+		}
+	}
+	return changed
+}
+
+func (m *memorySeries[T]) latest(imei string) *T {
+	records := m.find(Query{IMEI: imei, Limit: 1})
+	if len(records) == 0 {
+		return nil
+	}
+	return records[0]
+}
+
+func paginate[T any](records []*T, skip, limit int64) []*T {
+	if skip > 0 {
+		if skip >= int64(len(records)) {
+			return records[:0]
+		}
+		records = records[skip:]
+	}
+	if limit > 0 && limit < int64(len(records)) {
+		records = records[:limit]
+	}
+	return records
+}
@@ -0,0 +1,45 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type memoryAlarmRepository struct {
+	series *memorySeries[Alarm]
+}
+
+// NewMemoryAlarmRepository initializes a AlarmRepository kept in process memory
+func NewMemoryAlarmRepository() AlarmRepository {
+	return &memoryAlarmRepository{
+		series: newMemorySeries(func(alarm *Alarm) (string, time.Time, *primitive.ObjectID) {
+			return alarm.IMEI, alarm.DateTime, &alarm.ID
+		}),
+	}
+}
+
+// Insert stores an alarm
+func (r *memoryAlarmRepository) Insert(ctx context.Context, alarm *Alarm) error {
+	r.series.insert(alarm)
+	return nil
+}
+
+// InsertMany stores alarms
+func (r *memoryAlarmRepository) InsertMany(ctx context.Context, alarms []*Alarm) error {
+	for _, alarm := range alarms {
+		r.series.insert(alarm)
+	}
+	return nil
+}
+
+// Find retrieves the alarms matching q
+func (r *memoryAlarmRepository) Find(ctx context.Context, q Query) ([]*Alarm, error) {
+	return r.series.find(q), nil
+}
+
+// FindLatest retrieves the newest alarm of a device
+func (r *memoryAlarmRepository) FindLatest(ctx context.Context, imei string) (*Alarm, error) {
+	return r.series.latest(imei), nil
+}
@@ -0,0 +1,71 @@
+package database
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+type memoryBlocklistRepository struct {
+	mu      sync.RWMutex
+	devices map[string]*BlockedDevice
+}
+
+// NewMemoryBlocklistRepository initializes a BlocklistRepository kept in process memory
+func NewMemoryBlocklistRepository() BlocklistRepository {
+	return &memoryBlocklistRepository{devices: make(map[string]*BlockedDevice)}
+}
+
+// Block adds or replaces the entry of device.IMEI
+func (r *memoryBlocklistRepository) Block(ctx context.Context, device *BlockedDevice) error {
+	copied := *device
+
+	r.mu.Lock()
+	r.devices[device.IMEI] = &copied
+	r.mu.Unlock()
+	return nil
+}
+
+// Unblock removes the entry of imei
+func (r *memoryBlocklistRepository) Unblock(ctx context.Context, imei string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.devices[imei]
+	delete(r.devices, imei)
+	return ok, nil
+}
+
+// Get retrieves the entry of imei
+func (r *memoryBlocklistRepository) Get(ctx context.Context, imei string) (*BlockedDevice, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	device, ok := r.devices[imei]
+	if !ok {
+		return nil, nil
+	}
+
+	copied := *device
+	return &copied, nil
+}
+
+// List retrieves the blocked devices, most recently blocked first
+func (r *memoryBlocklistRepository) List(ctx context.Context, skip, limit int64) ([]*BlockedDevice, error) {
+	r.mu.RLock()
+	devices := make([]*BlockedDevice, 0, len(r.devices))
+	for _, device := range r.devices {
+		copied := *device
+		devices = append(devices, &copied)
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(devices, func(i, j int) bool {
+		if !devices[i].BlockedAt.Equal(devices[j].BlockedAt) {
+			return devices[i].BlockedAt.After(devices[j].BlockedAt)
+		}
+		return devices[i].IMEI < devices[j].IMEI
+	})
+
+	return paginate(devices, skip, limit), nil
+}
@@ -0,0 +1,66 @@
+package database
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type memoryCommandRepository struct {
+	series *memorySeries[Command]
+}
+
+// NewMemoryCommandRepository initializes a CommandRepository kept in process memory
+func NewMemoryCommandRepository() CommandRepository {
+	return &memoryCommandRepository{
+		series: newMemorySeries(func(command *Command) (string, time.Time, *primitive.ObjectID) {
+			return command.IMEI, command.CreatedAt, &command.ID
+		}),
+	}
+}
+
+// Insert stores a command
+func (r *memoryCommandRepository) Insert(ctx context.Context, command *Command) error {
+	r.series.insert(command)
+	return nil
+}
+
+// Transition replaces the stored command with the same ID while its status is still from
+func (r *memoryCommandRepository) Transition(ctx context.Context, command *Command, from string) (bool, error) {
+	return r.series.replace(command, func(stored *Command) bool { return stored.Status == from }), nil
+}
+
+// FindByID retrieves a command
+func (r *memoryCommandRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*Command, error) {
+	return r.series.get(id), nil
+}
+
+// Find retrieves the commands matching q
+func (r *memoryCommandRepository) Find(ctx context.Context, q Query) ([]*Command, error) {
+	return r.series.find(q), nil
+}
+
+// FindQueued retrieves the queued commands of a device, highest priority then oldest first
+func (r *memoryCommandRepository) FindQueued(ctx context.Context, imei string) ([]*Command, error) {
+	queued := r.series.findMatching(Query{IMEI: imei, Ascending: true}, func(command *Command) bool {
+		return command.Status == CommandQueued
+	})
+	sort.SliceStable(queued, func(i, j int) bool {
+		return queued[i].Priority > queued[j].Priority
+	})
+	return queued, nil
+}
+
+// ExpireQueued marks the queued commands that expired at now
+func (r *memoryCommandRepository) ExpireQueued(ctx context.Context, now time.Time) (int64, error) {
+	return r.series.update(func(command *Command) bool {
+		if command.Status != CommandQueued || command.ExpiresAt == nil || command.ExpiresAt.After(now) {
+			return false
+		}
+		command.Status = CommandExpired
+		command.UpdatedAt = now
+		return true
+	}), nil
+}
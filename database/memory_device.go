@@ -0,0 +1,92 @@
+package database
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+type memoryDeviceRepository struct {
+	mu      sync.RWMutex
+	devices map[string]*Device
+}
+
+// NewMemoryDeviceRepository initializes a DeviceRepository kept in process memory
+func NewMemoryDeviceRepository() DeviceRepository {
+	return &memoryDeviceRepository{devices: make(map[string]*Device)}
+}
+
+// RecordLogin upserts the device reported by login and updates its login statistics
+func (r *memoryDeviceRepository) RecordLogin(ctx context.Context, login *LoginEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	device, ok := r.devices[login.IMEI]
+	if !ok {
+		device = &Device{IMEI: login.IMEI, FirstSeen: login.CreatedAt}
+		r.devices[login.IMEI] = device
+	}
+	if !login.ID.IsZero() && device.LastLoginID == login.ID {
+		return nil
+	}
+
+	device.TerminalID = login.TerminalID
+	device.ModelCode = login.ModelCode
+	device.TimeZoneLanguage = login.TimeZoneLanguage
+	device.GMT = login.GMT
+	device.Region = login.Region
+	device.Language = login.Language
+	device.LastLogin = login.CreatedAt
+	device.LastSeen = login.CreatedAt
+	device.LoginCount++
+	device.LastLoginID = login.ID
+	device.LastRemoteAddr = login.RemoteAddr
+
+	return nil
+}
+
+// Touch updates the last time the device was heard from
+func (r *memoryDeviceRepository) Touch(ctx context.Context, imei string, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if device, ok := r.devices[imei]; ok && device.LastSeen.Before(at) {
+		device.LastSeen = at
+	}
+	return nil
+}
+
+// FindByIMEI retrieves a device
+func (r *memoryDeviceRepository) FindByIMEI(ctx context.Context, imei string) (*Device, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	device, ok := r.devices[imei]
+	if !ok {
+		return nil, nil
+	}
+
+	copied := *device
+	return &copied, nil
+}
+
+// List retrieves devices, most recently seen first
+func (r *memoryDeviceRepository) List(ctx context.Context, skip, limit int64) ([]*Device, error) {
+	r.mu.RLock()
+	devices := make([]*Device, 0, len(r.devices))
+	for _, device := range r.devices {
+		copied := *device
+		devices = append(devices, &copied)
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(devices, func(i, j int) bool {
+		if !devices[i].LastSeen.Equal(devices[j].LastSeen) {
+			return devices[i].LastSeen.After(devices[j].LastSeen)
+		}
+		return devices[i].IMEI < devices[j].IMEI
+	})
+
+	return paginate(devices, skip, limit), nil
+}
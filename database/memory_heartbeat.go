@@ -0,0 +1,37 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type memoryHeartbeatRepository struct {
+	series *memorySeries[Heartbeat]
+}
+
+// NewMemoryHeartbeatRepository initializes a HeartbeatRepository kept in process memory
+func NewMemoryHeartbeatRepository() HeartbeatRepository {
+	return &memoryHeartbeatRepository{
+		series: newMemorySeries(func(heartbeat *Heartbeat) (string, time.Time, *primitive.ObjectID) {
+			return heartbeat.IMEI, heartbeat.CreatedAt, &heartbeat.ID
+		}),
+	}
+}
+
+// Insert stores a heartbeat
+func (r *memoryHeartbeatRepository) Insert(ctx context.Context, heartbeat *Heartbeat) error {
+	r.series.insert(heartbeat)
+	return nil
+}
+
+// Find retrieves the heartbeats matching q
+func (r *memoryHeartbeatRepository) Find(ctx context.Context, q Query) ([]*Heartbeat, error) {
+	return r.series.find(q), nil
+}
+
+// FindLatest retrieves the newest heartbeat of a device
+func (r *memoryHeartbeatRepository) FindLatest(ctx context.Context, imei string) (*Heartbeat, error) {
+	return r.series.latest(imei), nil
+}
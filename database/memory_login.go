@@ -0,0 +1,37 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type memoryLoginEventRepository struct {
+	series *memorySeries[LoginEvent]
+}
+
+// NewMemoryLoginEventRepository initializes a LoginEventRepository kept in process memory
+func NewMemoryLoginEventRepository() LoginEventRepository {
+	return &memoryLoginEventRepository{
+		series: newMemorySeries(func(login *LoginEvent) (string, time.Time, *primitive.ObjectID) {
+			return login.IMEI, login.CreatedAt, &login.ID
+		}),
+	}
+}
+
+// Insert stores a login
+func (r *memoryLoginEventRepository) Insert(ctx context.Context, login *LoginEvent) error {
+	r.series.insert(login)
+	return nil
+}
+
+// Find retrieves the logins matching q
+func (r *memoryLoginEventRepository) Find(ctx context.Context, q Query) ([]*LoginEvent, error) {
+	return r.series.find(q), nil
+}
+
+// FindLatest retrieves the newest login of a device
+func (r *memoryLoginEventRepository) FindLatest(ctx context.Context, imei string) (*LoginEvent, error) {
+	return r.series.latest(imei), nil
+}
@@ -0,0 +1,55 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type memoryPositionRepository struct {
+	series *memorySeries[Position]
+}
+
+// NewMemoryPositionRepository initializes a PositionRepository kept in process memory
+func NewMemoryPositionRepository() PositionRepository {
+	return &memoryPositionRepository{
+		series: newMemorySeries(func(position *Position) (string, time.Time, *primitive.ObjectID) {
+			return position.IMEI, position.DateTime, &position.ID
+		}),
+	}
+}
+
+// Insert stores a position
+func (r *memoryPositionRepository) Insert(ctx context.Context, position *Position) error {
+	r.series.insert(position)
+	return nil
+}
+
+// InsertMany stores positions
+func (r *memoryPositionRepository) InsertMany(ctx context.Context, positions []*Position) error {
+	for _, position := range positions {
+		r.series.insert(position)
+	}
+	return nil
+}
+
+// Find retrieves the positions matching q
+func (r *memoryPositionRepository) Find(ctx context.Context, q Query) ([]*Position, error) {
+	return r.series.find(q), nil
+}
+
+// FindWithin retrieves the positions matching q that lie in area
+func (r *memoryPositionRepository) FindWithin(ctx context.Context, q Query, area Area) ([]*Position, error) {
+	if err := area.Validate(); err != nil {
+		return nil, err
+	}
+	return r.series.findMatching(q, func(position *Position) bool {
+		return area.Contains(LatLng{Latitude: position.Latitude, Longitude: position.Longitude})
+	}), nil
+}
+
+// FindLatest retrieves the newest position of a device
+func (r *memoryPositionRepository) FindLatest(ctx context.Context, imei string) (*Position, error) {
+	return r.series.latest(imei), nil
+}
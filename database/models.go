@@ -0,0 +1,163 @@
+package database
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Device is the registry entry of a terminal, keyed by IMEI and refreshed on every login
+type Device struct {
+	IMEI             string    `bson:"_id" json:"imei"`
+	TerminalID       string    `bson:"terminal_id" json:"terminal_id"`
+	ModelCode        string    `bson:"model_code" json:"model_code"`
+	TimeZoneLanguage string    `bson:"time_zone_language" json:"time_zone_language"`
+	GMT              float64   `bson:"gmt" json:"gmt"`
+	Region           string    `bson:"region" json:"region"`
+	Language         string    `bson:"language" json:"language"`
+	FirstSeen        time.Time `bson:"first_seen" json:"first_seen"`
+	LastLogin        time.Time `bson:"last_login" json:"last_login"`
+	LastSeen         time.Time `bson:"last_seen" json:"last_seen"`
+	LoginCount       int64     `bson:"login_count" json:"login_count"`
+	LastRemoteAddr   string    `bson:"last_remote_addr" json:"last_remote_addr"`
+
+	// LastLoginID is the id of the last login event recorded, so that recording it again is a no-op
+	LastLoginID primitive.ObjectID `bson:"last_login_id,omitempty" json:"-"`
+}
+
+// LoginEvent is a single login reported by a terminal
+type LoginEvent struct {
+	ID               primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	IMEI             string             `bson:"imei" json:"imei"`
+	TerminalID       string             `bson:"terminal_id" json:"terminal_id"`
+	ModelCode        string             `bson:"model_code" json:"model_code"`
+	TimeZoneLanguage string             `bson:"time_zone_language" json:"time_zone_language"`
+	GMT              float64            `bson:"gmt" json:"gmt"`
+	Region           string             `bson:"region" json:"region"`
+	Language         string             `bson:"language" json:"language"`
+	RemoteAddr       string             `bson:"remote_addr" json:"remote_addr"`
+	CreatedAt        time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// Position is a decoded GPS location report
+type Position struct {
+	ID                  primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	IMEI                string             `bson:"imei" json:"imei"`
+	DateTime            time.Time          `bson:"date_time" json:"date_time"`
+	GPSSatellites       int                `bson:"gps_satellites" json:"gps_satellites"`
+	Latitude            float64            `bson:"latitude" json:"latitude"`
+	Longitude           float64            `bson:"longitude" json:"longitude"`
+	Location            *GeoPoint          `bson:"location,omitempty" json:"location,omitempty"`
+	Speed               int                `bson:"speed" json:"speed"`
+	CourseStatus        int                `bson:"course_status" json:"course_status"`
+	MCC                 int                `bson:"mcc" json:"mcc"`
+	MNC                 int                `bson:"mnc" json:"mnc"`
+	LAC                 int                `bson:"lac" json:"lac"`
+	CellID              int                `bson:"cell_id" json:"cell_id"`
+	ACCStatus           int                `bson:"acc_status" json:"acc_status"`
+	UploadMode          int                `bson:"upload_mode" json:"upload_mode"`
+	GPSRealTimeReupload int                `bson:"gps_real_time_reupload" json:"gps_real_time_reupload"`
+	Mileage             int                `bson:"mileage" json:"mileage"`
+	CreatedAt           time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// Alarm is a decoded alarm report, a location with terminal status
+type Alarm struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	IMEI          string             `bson:"imei" json:"imei"`
+	DateTime      time.Time          `bson:"date_time" json:"date_time"`
+	GPSSatellites int                `bson:"gps_satellites" json:"gps_satellites"`
+	Latitude      float64            `bson:"latitude" json:"latitude"`
+	Longitude     float64            `bson:"longitude" json:"longitude"`
+	Location      *GeoPoint          `bson:"location,omitempty" json:"location,omitempty"`
+	Speed         int                `bson:"speed" json:"speed"`
+	CourseStatus  int                `bson:"course_status" json:"course_status"`
+	LBSLength     int                `bson:"lbs_length" json:"lbs_length"`
+	MCC           int                `bson:"mcc" json:"mcc"`
+	MNC           int                `bson:"mnc" json:"mnc"`
+	LAC           int                `bson:"lac" json:"lac"`
+	CellID        int                `bson:"cell_id" json:"cell_id"`
+	TerminalInfo  int                `bson:"terminal_info" json:"terminal_info"`
+	VoltageLevel  int                `bson:"voltage_level" json:"voltage_level"`
+	GSMSignal     int                `bson:"gsm_signal" json:"gsm_signal"`
+	AlarmLanguage int                `bson:"alarm_language" json:"alarm_language"`
+	Mileage       int                `bson:"mileage" json:"mileage"`
+	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// Heartbeat is a decoded terminal status report
+type Heartbeat struct {
+	ID                  primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	IMEI                string             `bson:"imei" json:"imei"`
+	TerminalInfo        int                `bson:"terminal_info" json:"terminal_info"`
+	ExternalVoltage     int                `bson:"external_voltage" json:"external_voltage"`
+	BatteryVoltageLevel int                `bson:"battery_voltage_level" json:"battery_voltage_level"`
+	GSMSignal           int                `bson:"gsm_signal" json:"gsm_signal"`
+	LanguageStatus      int                `bson:"language_status" json:"language_status"`
+	CreatedAt           time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// DeviceState is the last known state of a device, _id is the IMEI
+type DeviceState struct {
+	IMEI         string         `bson:"_id" json:"imei"`
+	Online       bool           `bson:"online" json:"online"`
+	ConnectionID string         `bson:"connection_id,omitempty" json:"connection_id,omitempty"`
+	RemoteAddr   string         `bson:"remote_addr,omitempty" json:"remote_addr,omitempty"`
+	Position     *StatePosition `bson:"position,omitempty" json:"position,omitempty"`
+	ACC          *bool          `bson:"acc,omitempty" json:"acc,omitempty"`
+	Battery      *int           `bson:"battery,omitempty" json:"battery,omitempty"`       // voltage level, 0 to 6
+	GSMSignal    *int           `bson:"gsm_signal,omitempty" json:"gsm_signal,omitempty"` // 0 to 4
+	LastAlarmAt  *time.Time     `bson:"last_alarm_at,omitempty" json:"last_alarm_at,omitempty"`
+	LastSeen     time.Time      `bson:"last_seen" json:"last_seen"`
+	UpdatedAt    time.Time      `bson:"updated_at" json:"updated_at"`
+}
+
+// StatePosition is the last GPS fix of a device
+type StatePosition struct {
+	DateTime      time.Time `bson:"date_time" json:"date_time"`
+	Latitude      float64   `bson:"latitude" json:"latitude"`
+	Longitude     float64   `bson:"longitude" json:"longitude"`
+	Location      *GeoPoint `bson:"location,omitempty" json:"location,omitempty"`
+	Speed         int       `bson:"speed" json:"speed"`     // km/h
+	Heading       int       `bson:"heading" json:"heading"` // degrees from north
+	GPSSatellites int       `bson:"gps_satellites" json:"gps_satellites"`
+}
+
+const (
+	CommandPending   = "pending"   // recorded, not written to the device yet
+	CommandQueued    = "queued"    // waiting for the device to log in
+	CommandDelivered = "delivered" // written to the live session, waiting for the reply
+	CommandReplied   = "replied"   // the terminal answered
+	CommandTimeout   = "timeout"   // no reply before the deadline
+	CommandExpired   = "expired"   // still queued when it expired
+	CommandFailed    = "failed"    // the device was offline, the write failed or every attempt went unanswered
+)
+
+// Command is a text command sent to a terminal and its outcome
+type Command struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	IMEI         string             `bson:"imei" json:"imei"`
+	Command      string             `bson:"command" json:"command"`
+	Sender       string             `bson:"sender" json:"sender"`
+	Status       string             `bson:"status" json:"status"`
+	Priority     int                `bson:"priority" json:"priority"`                             // queued commands are delivered highest first
+	Attempts     int                `bson:"attempts" json:"attempts"`                             // deliveries so far
+	MaxAttempts  int                `bson:"max_attempts,omitempty" json:"max_attempts,omitempty"` // deliveries of a queued command before it fails
+	ExpiresAt    *time.Time         `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	ServerFlag   uint32             `bson:"server_flag" json:"server_flag"`
+	ConnectionID string             `bson:"connection_id,omitempty" json:"connection_id,omitempty"`
+	Reply        string             `bson:"reply,omitempty" json:"reply,omitempty"`
+	Error        string             `bson:"error,omitempty" json:"error,omitempty"`
+	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+	DeliveredAt  *time.Time         `bson:"delivered_at,omitempty" json:"delivered_at,omitempty"`
+	RepliedAt    *time.Time         `bson:"replied_at,omitempty" json:"replied_at,omitempty"`
+	UpdatedAt    time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// BlockedDevice is an IMEI whose logins are refused
+type BlockedDevice struct {
+	IMEI      string    `bson:"_id" json:"imei"`
+	Reason    string    `bson:"reason,omitempty" json:"reason,omitempty"`
+	BlockedBy string    `bson:"blocked_by" json:"blocked_by"`
+	BlockedAt time.Time `bson:"blocked_at" json:"blocked_at"`
+}
@@ -2,84 +2,151 @@ package database
 
 import (
 	"context"
-	"time"
+	"errors"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
-// MongoDBModel defines an interface for MongoDB CRUD operations
-type MongoDBModel interface {
-	Insert(ctx context.Context, collectionName string, data bson.M) (*mongo.InsertOneResult, error)
-	Update(ctx context.Context, collectionName string, filter bson.M, update bson.M) (*mongo.UpdateResult, error)
-	Get(ctx context.Context, collectionName string, filter bson.M) (bson.M, error)
-	Delete(ctx context.Context, collectionName string, filter bson.M) (*mongo.DeleteResult, error)
+const (
+	CollectionDevice      = "devices"
+	CollectionLogin       = "CONCOXLoginInfoContent"
+	CollectionLocation    = "CONCOXLocationInfoContent"
+	CollectionAlarm       = "CONCOXAlarmInfoContent"
+	CollectionHeartbeat   = "CONCOXHeartbeatInfoContent"
+	CollectionDeviceState = "device_state"
+	CollectionCommand     = "commands"
+	CollectionBlocklist   = "blocked_devices"
+)
+
+// NewMongoRepositories initializes the MongoDB implementation of every repository
+func NewMongoRepositories(db *mongo.Database) Repositories {
+	return Repositories{
+		Devices:    NewMongoDeviceRepository(db),
+		Logins:     NewMongoLoginEventRepository(db),
+		Positions:  NewMongoPositionRepository(db),
+		Alarms:     NewMongoAlarmRepository(db),
+		Heartbeats: NewMongoHeartbeatRepository(db),
+		States:     NewMongoDeviceStateRepository(db),
+		Commands:   NewMongoCommandRepository(db),
+		Blocklist:  NewMongoBlocklistRepository(db),
+	}
 }
 
-// mongoDBModel is the implementation of MongoDBModel
-type mongoDBModel struct {
-	db *mongo.Database
+// PingMongo returns a probe reporting whether the MongoDB primary is reachable
+func PingMongo(db *mongo.Database) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		return db.Client().Ping(ctx, readpref.Primary())
+	}
 }
 
-// TODO: Split into individual interface methods for each table to improve maintainability and clarity for business logic.
-type CONCOXLoginInfoContent struct {
-	TerminalID       string `bson:"terminal_id"`
-	ModelCode        string `bson:"model_code"`
-	TimeZoneLanguage string `bson:"time_zone_language"`
+// insertOne inserts doc and reports the generated id back through id
+func insertOne(ctx context.Context, collection *mongo.Collection, doc any, id *primitive.ObjectID) error {
+	ret, err := collection.InsertOne(ctx, doc)
+	if err != nil {
+		return err
+	}
+
+	if oid, ok := ret.InsertedID.(primitive.ObjectID); ok {
+		*id = oid
+	}
+	return nil
 }
 
-type CONCOXLocationInfoContent struct {
-	DateTime            time.Time `bson:"date_time"`
-	GPSSatellites       int       `bson:"gps_satellites"`
-	Latitude            float64   `bson:"latitude"`
-	Longitude           float64   `bson:"longitude"`
-	Speed               int       `bson:"speed"`
-	CourseStatus        int       `bson:"course_status"`
-	MCC                 int       `bson:"mcc"`
-	MNC                 int       `bson:"mnc"`
-	LAC                 int       `bson:"lac"`
-	CellID              int       `bson:"cell_id"`
-	ACCStatus           int       `bson:"acc_status"`
-	UploadMode          int       `bson:"upload_mode"`
-	GPSRealTimeReupload int       `bson:"gps_real_time_reupload"`
-	Mileage             int       `bson:"mileage"`
-	SignalStrength      int       `bson:"signal_strength"`
-	Altitude            int       `bson:"altitude"`
-	BatteryLevel        int       `bson:"battery_level"`
-	NetworkType         int       `bson:"network_type"`
-	Temperature         int       `bson:"temperature"`
+// insertMany inserts records unordered, so one failing document does not stop the others,
+// and maps the write errors back to the records
+func insertMany[T any](ctx context.Context, collection *mongo.Collection, records []*T, id func(*T) *primitive.ObjectID) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	docs := make([]any, len(records))
+	for i, record := range records {
+		if oid := id(record); oid.IsZero() {
+			*oid = primitive.NewObjectID()
+		}
+		docs[i] = record
+	}
+
+	_, err := collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) && bulkErr.WriteConcernError == nil && len(bulkErr.WriteErrors) > 0 {
+		errs := make(InsertErrors, len(bulkErr.WriteErrors))
+		for _, writeErr := range bulkErr.WriteErrors {
+			errs[writeErr.Index] = writeErr.WriteError
+		}
+		return errs
+	}
+	return err
 }
 
-// NewMongoDBModel initializes a new MongoDBModel instance
-func NewMongoDBModel(client *mongo.Client, dbName string) MongoDBModel {
-	return &mongoDBModel{db: client.Database(dbName)}
+// seriesFilter builds the filter for a time-series query on timeField
+func seriesFilter(q Query, timeField string) bson.M {
+	filter := bson.M{}
+	if q.IMEI != "" {
+		filter["imei"] = q.IMEI
+	}
+
+	timeRange := bson.M{}
+	if !q.From.IsZero() {
+		timeRange["$gte"] = q.From
+	}
+	if !q.To.IsZero() {
+		timeRange["$lt"] = q.To
+	}
+	if len(timeRange) > 0 {
+		filter[timeField] = timeRange
+	}
+
+	return filter
 }
 
-// Insert inserts a document into the specified collection
-func (m *mongoDBModel) Insert(ctx context.Context, collectionName string, data bson.M) (*mongo.InsertOneResult, error) {
-	collection := m.db.Collection(collectionName)
-	return collection.InsertOne(ctx, data)
+// findSeries runs a time-series query against collection
+func findSeries[T any](ctx context.Context, collection *mongo.Collection, q Query, timeField string) ([]*T, error) {
+	return findFiltered[T](ctx, collection, q, seriesFilter(q, timeField), timeField)
 }
 
-// Update updates documents in the specified collection based on a filter
-func (m *mongoDBModel) Update(ctx context.Context, collectionName string, filter bson.M, update bson.M) (*mongo.UpdateResult, error) {
-	collection := m.db.Collection(collectionName)
-	return collection.UpdateOne(ctx, filter, bson.M{"$set": update})
+// findFiltered runs filter with the ordering and paging of q
+func findFiltered[T any](ctx context.Context, collection *mongo.Collection, q Query, filter bson.M, timeField string) ([]*T, error) {
+	order := -1
+	if q.Ascending {
+		order = 1
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: timeField, Value: order}})
+	if q.Skip > 0 {
+		opts.SetSkip(q.Skip)
+	}
+	if q.Limit > 0 {
+		opts.SetLimit(q.Limit)
+	}
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*T, 0)
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
 }
 
-// Get retrieves a single document based on a filter
-func (m *mongoDBModel) Get(ctx context.Context, collectionName string, filter bson.M) (bson.M, error) {
-	collection := m.db.Collection(collectionName)
-	var result bson.M
-	err := collection.FindOne(ctx, filter).Decode(&result)
+// findLatest retrieves the newest record of a device
+func findLatest[T any](ctx context.Context, collection *mongo.Collection, imei string, timeField string) (*T, error) {
+	opts := options.FindOne().SetSort(bson.D{{Key: timeField, Value: -1}})
+
+	var result T
+	err := collection.FindOne(ctx, bson.M{"imei": imei}, opts).Decode(&result)
 	if err == mongo.ErrNoDocuments {
 		return nil, nil
 	}
-	return result, err
-}
-
-// Delete removes documents from the specified collection based on a filter
-func (m *mongoDBModel) Delete(ctx context.Context, collectionName string, filter bson.M) (*mongo.DeleteResult, error) {
-	collection := m.db.Collection(collectionName)
-	return collection.DeleteOne(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
 }
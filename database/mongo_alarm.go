@@ -0,0 +1,37 @@
+package database
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type mongoAlarmRepository struct {
+	collection *mongo.Collection
+}
+
+// NewMongoAlarmRepository initializes a AlarmRepository backed by MongoDB
+func NewMongoAlarmRepository(db *mongo.Database) AlarmRepository {
+	return &mongoAlarmRepository{collection: db.Collection(CollectionAlarm)}
+}
+
+// Insert stores an alarm
+func (r *mongoAlarmRepository) Insert(ctx context.Context, alarm *Alarm) error {
+	return insertOne(ctx, r.collection, alarm, &alarm.ID)
+}
+
+// InsertMany stores alarms with one unordered bulk insert
+func (r *mongoAlarmRepository) InsertMany(ctx context.Context, alarms []*Alarm) error {
+	return insertMany(ctx, r.collection, alarms, func(alarm *Alarm) *primitive.ObjectID { return &alarm.ID })
+}
+
+// Find retrieves the alarms matching q
+func (r *mongoAlarmRepository) Find(ctx context.Context, q Query) ([]*Alarm, error) {
+	return findSeries[Alarm](ctx, r.collection, q, "date_time")
+}
+
+// FindLatest retrieves the newest alarm of a device
+func (r *mongoAlarmRepository) FindLatest(ctx context.Context, imei string) (*Alarm, error) {
+	return findLatest[Alarm](ctx, r.collection, imei, "date_time")
+}
@@ -0,0 +1,68 @@
+package database
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoBlocklistRepository struct {
+	collection *mongo.Collection
+}
+
+// NewMongoBlocklistRepository initializes a BlocklistRepository backed by MongoDB
+func NewMongoBlocklistRepository(db *mongo.Database) BlocklistRepository {
+	return &mongoBlocklistRepository{collection: db.Collection(CollectionBlocklist)}
+}
+
+// Block adds or replaces the entry of device.IMEI
+func (r *mongoBlocklistRepository) Block(ctx context.Context, device *BlockedDevice) error {
+	_, err := r.collection.ReplaceOne(ctx, bson.M{"_id": device.IMEI}, device, options.Replace().SetUpsert(true))
+	return err
+}
+
+// Unblock removes the entry of imei
+func (r *mongoBlocklistRepository) Unblock(ctx context.Context, imei string) (bool, error) {
+	ret, err := r.collection.DeleteOne(ctx, bson.M{"_id": imei})
+	if err != nil {
+		return false, err
+	}
+	return ret.DeletedCount > 0, nil
+}
+
+// Get retrieves the entry of imei
+func (r *mongoBlocklistRepository) Get(ctx context.Context, imei string) (*BlockedDevice, error) {
+	var device BlockedDevice
+	err := r.collection.FindOne(ctx, bson.M{"_id": imei}).Decode(&device)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+// List retrieves the blocked devices, most recently blocked first
+func (r *mongoBlocklistRepository) List(ctx context.Context, skip, limit int64) ([]*BlockedDevice, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "blocked_at", Value: -1}, {Key: "_id", Value: 1}})
+	if skip > 0 {
+		opts.SetSkip(skip)
+	}
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+
+	cursor, err := r.collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	devices := make([]*BlockedDevice, 0)
+	if err := cursor.All(ctx, &devices); err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
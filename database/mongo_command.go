@@ -0,0 +1,79 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoCommandRepository struct {
+	collection *mongo.Collection
+}
+
+// NewMongoCommandRepository initializes a CommandRepository backed by MongoDB
+func NewMongoCommandRepository(db *mongo.Database) CommandRepository {
+	return &mongoCommandRepository{collection: db.Collection(CollectionCommand)}
+}
+
+// Insert stores a command
+func (r *mongoCommandRepository) Insert(ctx context.Context, command *Command) error {
+	return insertOne(ctx, r.collection, command, &command.ID)
+}
+
+// Transition replaces the stored command with the same ID while its status is still from
+func (r *mongoCommandRepository) Transition(ctx context.Context, command *Command, from string) (bool, error) {
+	ret, err := r.collection.ReplaceOne(ctx, bson.M{"_id": command.ID, "status": from}, command)
+	if err != nil {
+		return false, err
+	}
+	return ret.MatchedCount > 0, nil
+}
+
+// FindByID retrieves a command
+func (r *mongoCommandRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*Command, error) {
+	var command Command
+	err := r.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&command)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &command, nil
+}
+
+// Find retrieves the commands matching q
+func (r *mongoCommandRepository) Find(ctx context.Context, q Query) ([]*Command, error) {
+	return findSeries[Command](ctx, r.collection, q, "created_at")
+}
+
+// FindQueued retrieves the queued commands of a device, highest priority then oldest first
+func (r *mongoCommandRepository) FindQueued(ctx context.Context, imei string) ([]*Command, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "priority", Value: -1}, {Key: "created_at", Value: 1}})
+	cursor, err := r.collection.Find(ctx, bson.M{"imei": imei, "status": CommandQueued}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	queued := make([]*Command, 0)
+	if err := cursor.All(ctx, &queued); err != nil {
+		return nil, err
+	}
+	return queued, nil
+}
+
+// ExpireQueued marks the queued commands that expired at now
+func (r *mongoCommandRepository) ExpireQueued(ctx context.Context, now time.Time) (int64, error) {
+	ret, err := r.collection.UpdateMany(ctx,
+		bson.M{"status": CommandQueued, "expires_at": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"status": CommandExpired, "updated_at": now}},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return ret.ModifiedCount, nil
+}
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoDeviceRepository struct {
+	collection *mongo.Collection
+}
+
+// NewMongoDeviceRepository initializes a DeviceRepository backed by MongoDB
+func NewMongoDeviceRepository(db *mongo.Database) DeviceRepository {
+	return &mongoDeviceRepository{collection: db.Collection(CollectionDevice)}
+}
+
+// RecordLogin upserts the device reported by login and updates its login statistics, once per
+// login event id
+func (r *mongoDeviceRepository) RecordLogin(ctx context.Context, login *LoginEvent) error {
+	update := bson.M{
+		"$set": bson.M{
+			"terminal_id":        login.TerminalID,
+			"model_code":         login.ModelCode,
+			"time_zone_language": login.TimeZoneLanguage,
+			"gmt":                login.GMT,
+			"region":             login.Region,
+			"language":           login.Language,
+			"last_login":         login.CreatedAt,
+			"last_seen":          login.CreatedAt,
+			"last_remote_addr":   login.RemoteAddr,
+		},
+		"$setOnInsert": bson.M{"first_seen": login.CreatedAt},
+		"$inc":         bson.M{"login_count": 1},
+	}
+
+	filter := bson.M{"_id": login.IMEI}
+	if !login.ID.IsZero() {
+		filter["last_login_id"] = bson.M{"$ne": login.ID}
+		update["$set"].(bson.M)["last_login_id"] = login.ID
+	}
+
+	_, err := r.collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) && !login.ID.IsZero() {
+		// the device exists and the login was already recorded, the upsert tried to insert it again
+		return nil
+	}
+	return err
+}
+
+// Touch updates the last time the device was heard from
+func (r *mongoDeviceRepository) Touch(ctx context.Context, imei string, at time.Time) error {
+	filter := bson.M{"_id": imei, "last_seen": bson.M{"$lt": at}}
+	_, err := r.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"last_seen": at}})
+	return err
+}
+
+// FindByIMEI retrieves a device
+func (r *mongoDeviceRepository) FindByIMEI(ctx context.Context, imei string) (*Device, error) {
+	var device Device
+	err := r.collection.FindOne(ctx, bson.M{"_id": imei}).Decode(&device)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+// List retrieves devices, most recently seen first
+func (r *mongoDeviceRepository) List(ctx context.Context, skip, limit int64) ([]*Device, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "last_seen", Value: -1}, {Key: "_id", Value: 1}})
+	if skip > 0 {
+		opts.SetSkip(skip)
+	}
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+
+	cursor, err := r.collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	devices := make([]*Device, 0)
+	if err := cursor.All(ctx, &devices); err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
@@ -0,0 +1,139 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoDeviceStateRepository struct {
+	collection *mongo.Collection
+}
+
+// NewMongoDeviceStateRepository initializes a DeviceStateRepository backed by MongoDB
+func NewMongoDeviceStateRepository(db *mongo.Database) DeviceStateRepository {
+	return &mongoDeviceStateRepository{collection: db.Collection(CollectionDeviceState)}
+}
+
+// Apply merges update into the state of its device with a single atomic upsert. The update
+// pipeline compares against the stored document so that stale positions are not applied. The
+// state after is the state before, returned by the upsert, with the same rules applied.
+func (r *mongoDeviceStateRepository) Apply(ctx context.Context, update *StateUpdate) (before, after *DeviceState, err error) {
+	set := bson.M{
+		"last_seen":  bson.M{"$max": bson.A{"$last_seen", update.At}},
+		"updated_at": time.Now(),
+	}
+
+	if update.Online != nil {
+		if *update.Online {
+			set["online"] = true
+			set["connection_id"] = bson.M{"$literal": update.ConnectionID}
+			set["remote_addr"] = bson.M{"$literal": update.RemoteAddr}
+		} else {
+			set["online"] = bson.M{"$cond": bson.A{
+				bson.M{"$eq": bson.A{bson.M{"$ifNull": bson.A{"$connection_id", ""}}, update.ConnectionID}},
+				false,
+				bson.M{"$ifNull": bson.A{"$online", false}},
+			}}
+		}
+	}
+	if update.Position != nil {
+		set["position"] = bson.M{"$cond": bson.A{
+			bson.M{"$or": bson.A{
+				bson.M{"$eq": bson.A{bson.M{"$type": "$position"}, "missing"}},
+				bson.M{"$lt": bson.A{"$position.date_time", update.Position.DateTime}},
+			}},
+			bson.M{"$literal": update.Position},
+			"$position",
+		}}
+	}
+	if update.ACC != nil {
+		set["acc"] = *update.ACC
+	}
+	if update.Battery != nil {
+		set["battery"] = *update.Battery
+	}
+	if update.GSMSignal != nil {
+		set["gsm_signal"] = *update.GSMSignal
+	}
+	if update.LastAlarmAt != nil {
+		set["last_alarm_at"] = bson.M{"$max": bson.A{"$last_alarm_at", *update.LastAlarmAt}}
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$set", Value: set}},
+		{{Key: "$set", Value: bson.M{"online": bson.M{"$ifNull": bson.A{"$online", false}}}}},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before)
+	var stored DeviceState
+	err = r.collection.FindOneAndUpdate(ctx, bson.M{"_id": update.IMEI}, pipeline, opts).Decode(&stored)
+	switch {
+	case err == mongo.ErrNoDocuments:
+		after = &DeviceState{IMEI: update.IMEI}
+	case err != nil:
+		return nil, nil, err
+	default:
+		before = &stored
+		after = stored.copy()
+	}
+	after.apply(update)
+	return before, after, nil
+}
+
+// Get retrieves the state of a device
+func (r *mongoDeviceStateRepository) Get(ctx context.Context, imei string) (*DeviceState, error) {
+	var state DeviceState
+	err := r.collection.FindOne(ctx, bson.M{"_id": imei}).Decode(&state)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// List retrieves the states of the fleet, most recently seen first
+func (r *mongoDeviceStateRepository) List(ctx context.Context, skip, limit int64) ([]*DeviceState, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "last_seen", Value: -1}, {Key: "_id", Value: 1}})
+	if skip > 0 {
+		opts.SetSkip(skip)
+	}
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+
+	cursor, err := r.collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]*DeviceState, 0)
+	if err := cursor.All(ctx, &states); err != nil {
+		return nil, err
+	}
+	return states, nil
+}
+
+// Save replaces the stored states with one unordered bulk write
+func (r *mongoDeviceStateRepository) Save(ctx context.Context, states []*DeviceState) error {
+	if len(states) == 0 {
+		return nil
+	}
+
+	models := make([]mongo.WriteModel, len(states))
+	for i, state := range states {
+		models[i] = mongo.NewReplaceOneModel().SetFilter(bson.M{"_id": state.IMEI}).SetReplacement(state).SetUpsert(true)
+	}
+	_, err := r.collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+// MarkAllOffline sets every device offline
+func (r *mongoDeviceStateRepository) MarkAllOffline(ctx context.Context) error {
+	_, err := r.collection.UpdateMany(ctx, bson.M{"online": true}, bson.M{"$set": bson.M{"online": false}})
+	return err
+}
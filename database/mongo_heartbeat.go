@@ -0,0 +1,31 @@
+package database
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type mongoHeartbeatRepository struct {
+	collection *mongo.Collection
+}
+
+// NewMongoHeartbeatRepository initializes a HeartbeatRepository backed by MongoDB
+func NewMongoHeartbeatRepository(db *mongo.Database) HeartbeatRepository {
+	return &mongoHeartbeatRepository{collection: db.Collection(CollectionHeartbeat)}
+}
+
+// Insert stores a heartbeat
+func (r *mongoHeartbeatRepository) Insert(ctx context.Context, heartbeat *Heartbeat) error {
+	return insertOne(ctx, r.collection, heartbeat, &heartbeat.ID)
+}
+
+// Find retrieves the heartbeats matching q
+func (r *mongoHeartbeatRepository) Find(ctx context.Context, q Query) ([]*Heartbeat, error) {
+	return findSeries[Heartbeat](ctx, r.collection, q, "created_at")
+}
+
+// FindLatest retrieves the newest heartbeat of a device
+func (r *mongoHeartbeatRepository) FindLatest(ctx context.Context, imei string) (*Heartbeat, error) {
+	return findLatest[Heartbeat](ctx, r.collection, imei, "created_at")
+}
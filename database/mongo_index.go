@@ -0,0 +1,260 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/logx"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	IndexCreated  = "created"
+	IndexExists   = "exists"
+	IndexUpdated  = "updated"
+	IndexDropped  = "dropped"
+	IndexMissing  = "missing"
+	IndexOutdated = "outdated"
+	IndexFailed   = "failed"
+)
+
+// mongoNamespaceNotFound is returned when listing the indexes of a collection that does not exist yet
+const mongoNamespaceNotFound = 26
+
+// IndexConf configures the indexes, retention and backfills of the MongoDB collections
+type IndexConf struct {
+	Apply             bool          `json:"Apply,optional" yaml:"Apply"` // create and update indexes, otherwise only report them
+	Retention         RetentionConf `json:"Retention,optional" yaml:"Retention"`
+	BackfillLocations bool          `json:"BackfillLocations,optional" yaml:"BackfillLocations"`
+}
+
+// RetentionConf is the number of days documents are kept per collection, 0 keeps them forever
+type RetentionConf struct {
+	Logins     int `json:"Logins,optional" yaml:"Logins"`
+	Positions  int `json:"Positions,optional" yaml:"Positions"`
+	Alarms     int `json:"Alarms,optional" yaml:"Alarms"`
+	Heartbeats int `json:"Heartbeats,optional" yaml:"Heartbeats"`
+}
+
+// DefaultIndexConf returns an IndexConf with default values
+func DefaultIndexConf() IndexConf {
+	return IndexConf{Apply: true, BackfillLocations: true}
+}
+
+// IndexSpec declares an index. A TTL index with a zero TTL is not wanted and is dropped when present.
+type IndexSpec struct {
+	Name string
+	Keys bson.D
+	TTL  bool
+}
+
+// IndexStatus reports the state of a declared index after EnsureMongoIndexes
+type IndexStatus struct {
+	Collection string
+	Name       string
+	Status     string
+	Err        error
+}
+
+type collectionIndexes struct {
+	collection string
+	retention  time.Duration
+	indexes    []IndexSpec
+}
+
+// mongoIndexes declares the indexes of every collection, names are stable so they can be compared
+func mongoIndexes(c RetentionConf) []collectionIndexes {
+	days := func(n int) time.Duration { return time.Duration(n) * 24 * time.Hour }
+
+	return []collectionIndexes{
+		{
+			collection: CollectionDevice,
+			indexes: []IndexSpec{
+				{Name: "last_seen_-1", Keys: bson.D{{Key: "last_seen", Value: -1}}},
+			},
+		},
+		{
+			collection: CollectionDeviceState,
+			indexes: []IndexSpec{
+				{Name: "last_seen_-1__id_1", Keys: bson.D{{Key: "last_seen", Value: -1}, {Key: "_id", Value: 1}}},
+			},
+		},
+		{
+			collection: CollectionCommand,
+			indexes: []IndexSpec{
+				{Name: "imei_1_created_at_-1", Keys: bson.D{{Key: "imei", Value: 1}, {Key: "created_at", Value: -1}}},
+				{Name: "imei_1_status_1_priority_-1_created_at_1", Keys: bson.D{
+					{Key: "imei", Value: 1}, {Key: "status", Value: 1}, {Key: "priority", Value: -1}, {Key: "created_at", Value: 1},
+				}},
+				{Name: "status_1_expires_at_1", Keys: bson.D{{Key: "status", Value: 1}, {Key: "expires_at", Value: 1}}},
+			},
+		},
+		{
+			collection: CollectionLogin,
+			retention:  days(c.Logins),
+			indexes: []IndexSpec{
+				{Name: "imei_1_created_at_-1", Keys: bson.D{{Key: "imei", Value: 1}, {Key: "created_at", Value: -1}}},
+				{Name: "created_at_ttl", Keys: bson.D{{Key: "created_at", Value: 1}}, TTL: true},
+			},
+		},
+		{
+			collection: CollectionLocation,
+			retention:  days(c.Positions),
+			indexes: []IndexSpec{
+				{Name: "imei_1_date_time_-1", Keys: bson.D{{Key: "imei", Value: 1}, {Key: "date_time", Value: -1}}},
+				{Name: "location_2dsphere", Keys: bson.D{{Key: "location", Value: "2dsphere"}}},
+				{Name: "created_at_ttl", Keys: bson.D{{Key: "created_at", Value: 1}}, TTL: true},
+			},
+		},
+		{
+			collection: CollectionAlarm,
+			retention:  days(c.Alarms),
+			indexes: []IndexSpec{
+				{Name: "imei_1_date_time_-1", Keys: bson.D{{Key: "imei", Value: 1}, {Key: "date_time", Value: -1}}},
+				{Name: "location_2dsphere", Keys: bson.D{{Key: "location", Value: "2dsphere"}}},
+				{Name: "created_at_ttl", Keys: bson.D{{Key: "created_at", Value: 1}}, TTL: true},
+			},
+		},
+		{
+			collection: CollectionHeartbeat,
+			retention:  days(c.Heartbeats),
+			indexes: []IndexSpec{
+				{Name: "imei_1_created_at_-1", Keys: bson.D{{Key: "imei", Value: 1}, {Key: "created_at", Value: -1}}},
+				{Name: "created_at_ttl", Keys: bson.D{{Key: "created_at", Value: 1}}, TTL: true},
+			},
+		},
+	}
+}
+
+// EnsureMongoIndexes compares the declared indexes with the ones in db. With c.Apply missing indexes
+// are created, TTLs are changed to the configured retention and unwanted TTL indexes are dropped,
+// otherwise the differences are only reported.
+func EnsureMongoIndexes(ctx context.Context, db *mongo.Database, c IndexConf) []IndexStatus {
+	report := make([]IndexStatus, 0)
+	for _, coll := range mongoIndexes(c.Retention) {
+		collection := db.Collection(coll.collection)
+
+		existing, err := listIndexes(ctx, collection)
+		if err != nil {
+			for _, spec := range coll.indexes {
+				report = append(report, IndexStatus{Collection: coll.collection, Name: spec.Name, Status: IndexFailed, Err: err})
+			}
+			continue
+		}
+
+		for _, spec := range coll.indexes {
+			status := ensureIndex(ctx, db, collection, spec, coll.retention, existing, c.Apply)
+			if status.Status == "" {
+				continue
+			}
+			status.Collection = coll.collection
+			status.Name = spec.Name
+			report = append(report, status)
+		}
+	}
+	return report
+}
+
+// LogIndexReport logs the outcome of EnsureMongoIndexes
+func LogIndexReport(report []IndexStatus) {
+	for _, status := range report {
+		switch status.Status {
+		case IndexFailed:
+			logx.Errorf("MongoDB index %s.%s: %s: %v", status.Collection, status.Name, status.Status, status.Err)
+		case IndexMissing, IndexOutdated:
+			logx.Errorf("MongoDB index %s.%s: %s", status.Collection, status.Name, status.Status)
+		default:
+			logx.Infof("MongoDB index %s.%s: %s", status.Collection, status.Name, status.Status)
+		}
+	}
+}
+
+func ensureIndex(ctx context.Context, db *mongo.Database, collection *mongo.Collection, spec IndexSpec,
+	retention time.Duration, existing map[string]bson.M, apply bool) IndexStatus {
+	current, exists := existing[spec.Name]
+	ttl := int32(retention / time.Second)
+
+	switch {
+	case spec.TTL && ttl == 0:
+		// retention is disabled
+		if !exists {
+			return IndexStatus{}
+		}
+		if !apply {
+			return IndexStatus{Status: IndexOutdated}
+		}
+		if _, err := collection.Indexes().DropOne(ctx, spec.Name); err != nil {
+			return IndexStatus{Status: IndexFailed, Err: err}
+		}
+		return IndexStatus{Status: IndexDropped}
+
+	case !exists:
+		if !apply {
+			return IndexStatus{Status: IndexMissing}
+		}
+		opts := options.Index().SetName(spec.Name)
+		if spec.TTL {
+			opts.SetExpireAfterSeconds(ttl)
+		}
+		if _, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: spec.Keys, Options: opts}); err != nil {
+			return IndexStatus{Status: IndexFailed, Err: err}
+		}
+		return IndexStatus{Status: IndexCreated}
+
+	case spec.TTL && expireAfter(current) != ttl:
+		if !apply {
+			return IndexStatus{Status: IndexOutdated}
+		}
+		err := db.RunCommand(ctx, bson.D{
+			{Key: "collMod", Value: collection.Name()},
+			{Key: "index", Value: bson.D{{Key: "name", Value: spec.Name}, {Key: "expireAfterSeconds", Value: ttl}}},
+		}).Err()
+		if err != nil {
+			return IndexStatus{Status: IndexFailed, Err: err}
+		}
+		return IndexStatus{Status: IndexUpdated}
+
+	default:
+		return IndexStatus{Status: IndexExists}
+	}
+}
+
+// listIndexes returns the indexes of collection by name
+func listIndexes(ctx context.Context, collection *mongo.Collection) (map[string]bson.M, error) {
+	cursor, err := collection.Indexes().List(ctx)
+	if err != nil {
+		if se, ok := err.(mongo.ServerError); ok && se.HasErrorCode(mongoNamespaceNotFound) {
+			return map[string]bson.M{}, nil
+		}
+		return nil, fmt.Errorf("failed to list indexes: %w", err)
+	}
+
+	var indexes []bson.M
+	if err := cursor.All(ctx, &indexes); err != nil {
+		return nil, fmt.Errorf("failed to list indexes: %w", err)
+	}
+
+	byName := make(map[string]bson.M, len(indexes))
+	for _, index := range indexes {
+		if name, ok := index["name"].(string); ok {
+			byName[name] = index
+		}
+	}
+	return byName, nil
+}
+
+// expireAfter reads expireAfterSeconds of an index, which the server may return as any number type
+func expireAfter(index bson.M) int32 {
+	switch v := index["expireAfterSeconds"].(type) {
+	case int32:
+		return v
+	case int64:
+		return int32(v)
+	case float64:
+		return int32(v)
+	}
+	return 0
+}
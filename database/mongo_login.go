@@ -0,0 +1,31 @@
+package database
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type mongoLoginEventRepository struct {
+	collection *mongo.Collection
+}
+
+// NewMongoLoginEventRepository initializes a LoginEventRepository backed by MongoDB
+func NewMongoLoginEventRepository(db *mongo.Database) LoginEventRepository {
+	return &mongoLoginEventRepository{collection: db.Collection(CollectionLogin)}
+}
+
+// Insert stores a login
+func (r *mongoLoginEventRepository) Insert(ctx context.Context, login *LoginEvent) error {
+	return insertOne(ctx, r.collection, login, &login.ID)
+}
+
+// Find retrieves the logins matching q
+func (r *mongoLoginEventRepository) Find(ctx context.Context, q Query) ([]*LoginEvent, error) {
+	return findSeries[LoginEvent](ctx, r.collection, q, "created_at")
+}
+
+// FindLatest retrieves the newest login of a device
+func (r *mongoLoginEventRepository) FindLatest(ctx context.Context, imei string) (*LoginEvent, error) {
+	return findLatest[LoginEvent](ctx, r.collection, imei, "created_at")
+}
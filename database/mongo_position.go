@@ -0,0 +1,46 @@
+package database
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type mongoPositionRepository struct {
+	collection *mongo.Collection
+}
+
+// NewMongoPositionRepository initializes a PositionRepository backed by MongoDB
+func NewMongoPositionRepository(db *mongo.Database) PositionRepository {
+	return &mongoPositionRepository{collection: db.Collection(CollectionLocation)}
+}
+
+// Insert stores a position
+func (r *mongoPositionRepository) Insert(ctx context.Context, position *Position) error {
+	return insertOne(ctx, r.collection, position, &position.ID)
+}
+
+// InsertMany stores positions with one unordered bulk insert
+func (r *mongoPositionRepository) InsertMany(ctx context.Context, positions []*Position) error {
+	return insertMany(ctx, r.collection, positions, func(position *Position) *primitive.ObjectID { return &position.ID })
+}
+
+// Find retrieves the positions matching q
+func (r *mongoPositionRepository) Find(ctx context.Context, q Query) ([]*Position, error) {
+	return findSeries[Position](ctx, r.collection, q, "date_time")
+}
+
+// FindWithin retrieves the positions matching q that lie in area, using the 2dsphere index on location
+func (r *mongoPositionRepository) FindWithin(ctx context.Context, q Query, area Area) ([]*Position, error) {
+	filter, err := geoFilter(q, "date_time", area)
+	if err != nil {
+		return nil, err
+	}
+	return findFiltered[Position](ctx, r.collection, q, filter, "date_time")
+}
+
+// FindLatest retrieves the newest position of a device
+func (r *mongoPositionRepository) FindLatest(ctx context.Context, imei string) (*Position, error) {
+	return findLatest[Position](ctx, r.collection, imei, "date_time")
+}
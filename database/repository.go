@@ -0,0 +1,149 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	StorageMongo  = "mongo"
+	StorageMemory = "memory"
+)
+
+// Lookups of a single record return nil without an error when nothing matches.
+
+// InsertErrors is returned by InsertMany when only some of the records failed, keyed by
+// the index of the record. Any other error applies to every record.
+type InsertErrors map[int]error
+
+func (e InsertErrors) Error() string {
+	first := -1
+	for i := range e {
+		if first < 0 || i < first {
+			first = i
+		}
+	}
+	return fmt.Sprintf("%d records failed to insert, first at %d: %v", len(e), first, e[first])
+}
+
+// InsertErrorOf returns the error of the i-th record of a bulk insert that returned err
+func InsertErrorOf(err error, i int) error {
+	var errs InsertErrors
+	if errors.As(err, &errs) {
+		return errs[i]
+	}
+	return err
+}
+
+// Query filters time-series records, zero values are not applied.
+// Results are ordered newest first unless Ascending is set.
+type Query struct {
+	IMEI      string
+	From      time.Time // inclusive
+	To        time.Time // exclusive
+	Skip      int64
+	Limit     int64
+	Ascending bool
+}
+
+// DeviceRepository maintains the device registry
+type DeviceRepository interface {
+	// RecordLogin upserts the device reported by login and updates its login statistics. A login
+	// with an id is only counted once, recording it again changes nothing.
+	RecordLogin(ctx context.Context, login *LoginEvent) error
+	// Touch updates the last time the device was heard from
+	Touch(ctx context.Context, imei string, at time.Time) error
+	FindByIMEI(ctx context.Context, imei string) (*Device, error)
+	// List retrieves devices, most recently seen first
+	List(ctx context.Context, skip, limit int64) ([]*Device, error)
+}
+
+// LoginEventRepository keeps the login history of the devices
+type LoginEventRepository interface {
+	Insert(ctx context.Context, login *LoginEvent) error
+	Find(ctx context.Context, q Query) ([]*LoginEvent, error)
+	FindLatest(ctx context.Context, imei string) (*LoginEvent, error)
+}
+
+// PositionRepository stores GPS location reports
+type PositionRepository interface {
+	Insert(ctx context.Context, position *Position) error
+	// InsertMany stores positions in bulk, see InsertErrors for partial failures
+	InsertMany(ctx context.Context, positions []*Position) error
+	Find(ctx context.Context, q Query) ([]*Position, error)
+	FindLatest(ctx context.Context, imei string) (*Position, error)
+	// FindWithin retrieves the positions matching q that lie in area
+	FindWithin(ctx context.Context, q Query, area Area) ([]*Position, error)
+}
+
+// AlarmRepository stores alarm reports
+type AlarmRepository interface {
+	Insert(ctx context.Context, alarm *Alarm) error
+	// InsertMany stores alarms in bulk, see InsertErrors for partial failures
+	InsertMany(ctx context.Context, alarms []*Alarm) error
+	Find(ctx context.Context, q Query) ([]*Alarm, error)
+	FindLatest(ctx context.Context, imei string) (*Alarm, error)
+}
+
+// HeartbeatRepository stores terminal status reports
+type HeartbeatRepository interface {
+	Insert(ctx context.Context, heartbeat *Heartbeat) error
+	Find(ctx context.Context, q Query) ([]*Heartbeat, error)
+	FindLatest(ctx context.Context, imei string) (*Heartbeat, error)
+}
+
+// DeviceStateRepository keeps the last known state of every device
+type DeviceStateRepository interface {
+	// Apply merges update into the state of its device atomically, it returns the state before,
+	// nil for a new device, and after the update
+	Apply(ctx context.Context, update *StateUpdate) (before, after *DeviceState, err error)
+	Get(ctx context.Context, imei string) (*DeviceState, error)
+	// List retrieves the states of the fleet, most recently seen first
+	List(ctx context.Context, skip, limit int64) ([]*DeviceState, error)
+	// MarkAllOffline sets every device offline, used at startup when no session is connected
+	MarkAllOffline(ctx context.Context) error
+	// Save replaces the stored states with states
+	Save(ctx context.Context, states []*DeviceState) error
+}
+
+// CommandRepository keeps the commands sent to terminals with their outcome
+type CommandRepository interface {
+	Insert(ctx context.Context, command *Command) error
+	// Transition replaces the stored command with the same ID while its status is still from, it
+	// reports false when the command moved to another status meanwhile, such as expired
+	Transition(ctx context.Context, command *Command, from string) (bool, error)
+	FindByID(ctx context.Context, id primitive.ObjectID) (*Command, error)
+	// Find retrieves the commands matching q by creation time
+	Find(ctx context.Context, q Query) ([]*Command, error)
+	// FindQueued retrieves the queued commands of a device, highest priority then oldest first
+	FindQueued(ctx context.Context, imei string) ([]*Command, error)
+	// ExpireQueued marks the queued commands that expired at now, it returns how many did
+	ExpireQueued(ctx context.Context, now time.Time) (int64, error)
+}
+
+// BlocklistRepository keeps the devices that are not allowed to log in
+type BlocklistRepository interface {
+	// Block adds or replaces the entry of device.IMEI
+	Block(ctx context.Context, device *BlockedDevice) error
+	// Unblock removes the entry of imei, it reports whether there was one
+	Unblock(ctx context.Context, imei string) (bool, error)
+	Get(ctx context.Context, imei string) (*BlockedDevice, error)
+	// List retrieves the blocked devices, most recently blocked first
+	List(ctx context.Context, skip, limit int64) ([]*BlockedDevice, error)
+}
+
+// Repositories groups the repositories of one storage backend
+type Repositories struct {
+	Devices    DeviceRepository
+	Logins     LoginEventRepository
+	Positions  PositionRepository
+	Alarms     AlarmRepository
+	Heartbeats HeartbeatRepository
+	States     DeviceStateRepository
+	Commands   CommandRepository
+	Blocklist  BlocklistRepository
+}
@@ -0,0 +1,123 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// scyllaMaxQueryDays bounds the day partitions scanned when a query has no lower time bound
+const scyllaMaxQueryDays = 31
+
+// ErrIMEIRequired is returned by the storage that can only query the records of one device
+var ErrIMEIRequired = errors.New("imei is required on this storage backend")
+
+// scyllaDay returns the day partition of t
+func scyllaDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+// scyllaDays lists the day partitions covering q, in the order results are returned
+func scyllaDays(q Query) (from time.Time, to time.Time, days []time.Time) {
+	to = q.To
+	if to.IsZero() {
+		to = time.Now().Add(24 * time.Hour)
+	}
+	from = q.From
+	if from.IsZero() {
+		from = to.Add(-scyllaMaxQueryDays * 24 * time.Hour)
+	}
+
+	for day := scyllaDay(from); day.Before(to); day = day.Add(24 * time.Hour) {
+		days = append(days, day)
+	}
+
+	if !q.Ascending {
+		for i, j := 0, len(days)-1; i < j; i, j = i+1, j-1 {
+			days[i], days[j] = days[j], days[i]
+		}
+	}
+	return from, to, days
+}
+
+// scyllaFindSeries walks the day partitions of a device with one of the two ordered
+// statements, both binding (imei, day, from, to, limit), and applies the paging of q.
+func scyllaFindSeries[T any](ctx context.Context, session *gocql.Session, q Query, selectAsc, selectDesc string,
+	scan func(iter *gocql.Iter) (*T, bool)) ([]*T, error) {
+	if q.IMEI == "" {
+		return nil, ErrIMEIRequired
+	}
+
+	stmt := selectDesc
+	if q.Ascending {
+		stmt = selectAsc
+	}
+
+	want := math.MaxInt32
+	if q.Limit > 0 {
+		want = int(q.Skip + q.Limit)
+	}
+
+	from, to, days := scyllaDays(q)
+	results := make([]*T, 0)
+	for _, day := range days {
+		iter := session.Query(stmt, q.IMEI, day, from, to, want-len(results)).WithContext(ctx).Iter()
+		for {
+			record, ok := scan(iter)
+			if !ok {
+				break
+			}
+			results = append(results, record)
+		}
+		if err := iter.Close(); err != nil {
+			return nil, err
+		}
+
+		if len(results) >= want {
+			break
+		}
+	}
+
+	return paginate(results, q.Skip, q.Limit), nil
+}
+
+// scyllaInsertBatches writes n records with unlogged batches, one per partition as named by
+// partition. A failed batch fails each of its records.
+func scyllaInsertBatches(ctx context.Context, session *gocql.Session, n int, partition func(i int) string,
+	add func(batch *gocql.Batch, i int)) error {
+	groups := make(map[string][]int)
+	order := make([]string, 0)
+	for i := 0; i < n; i++ {
+		key := partition(i)
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], i)
+	}
+
+	errs := make(InsertErrors)
+	for _, key := range order {
+		indexes := groups[key]
+		for start := 0; start < len(indexes); start += scyllaMaxBatchSize {
+			chunk := indexes[start:min(start+scyllaMaxBatchSize, len(indexes))]
+
+			batch := session.NewBatch(gocql.UnloggedBatch).WithContext(ctx)
+			for _, i := range chunk {
+				add(batch, i)
+			}
+			if err := session.ExecuteBatch(batch); err != nil {
+				for _, i := range chunk {
+					errs[i] = err
+				}
+			}
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
@@ -0,0 +1,73 @@
+package database
+
+import (
+	"context"
+
+	"github.com/gocql/gocql"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	heartbeatColumns = `id, imei, created_at, terminal_info, external_voltage, battery_voltage_level,
+		gsm_signal, language_status`
+
+	insertHeartbeatCQL = `INSERT INTO heartbeats (day, ` + heartbeatColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	selectHeartbeatsAscCQL = `SELECT ` + heartbeatColumns + ` FROM heartbeats
+		WHERE imei = ? AND day = ? AND created_at >= ? AND created_at < ? ORDER BY created_at ASC, id ASC LIMIT ?`
+
+	selectHeartbeatsDescCQL = `SELECT ` + heartbeatColumns + ` FROM heartbeats
+		WHERE imei = ? AND day = ? AND created_at >= ? AND created_at < ? ORDER BY created_at DESC, id DESC LIMIT ?`
+)
+
+type scyllaHeartbeatRepository struct {
+	session *gocql.Session
+}
+
+// NewScyllaHeartbeatRepository initializes a HeartbeatRepository backed by the ScyllaDB heartbeats table
+func NewScyllaHeartbeatRepository(session *gocql.Session) HeartbeatRepository {
+	return &scyllaHeartbeatRepository{session: session}
+}
+
+// Insert stores a heartbeat in the partition of its device and day
+func (r *scyllaHeartbeatRepository) Insert(ctx context.Context, heartbeat *Heartbeat) error {
+	if heartbeat.ID.IsZero() {
+		heartbeat.ID = primitive.NewObjectID()
+	}
+
+	return r.session.Query(insertHeartbeatCQL,
+		scyllaDay(heartbeat.CreatedAt), heartbeat.ID.Hex(), heartbeat.IMEI, heartbeat.CreatedAt,
+		heartbeat.TerminalInfo, heartbeat.ExternalVoltage, heartbeat.BatteryVoltageLevel, heartbeat.GSMSignal,
+		heartbeat.LanguageStatus,
+	).WithContext(ctx).Exec()
+}
+
+// Find retrieves the heartbeats matching q, an IMEI is required
+func (r *scyllaHeartbeatRepository) Find(ctx context.Context, q Query) ([]*Heartbeat, error) {
+	return scyllaFindSeries(ctx, r.session, q, selectHeartbeatsAscCQL, selectHeartbeatsDescCQL, scanHeartbeat)
+}
+
+// FindLatest retrieves the newest heartbeat of a device within the last scyllaMaxQueryDays
+func (r *scyllaHeartbeatRepository) FindLatest(ctx context.Context, imei string) (*Heartbeat, error) {
+	heartbeats, err := r.Find(ctx, Query{IMEI: imei, Limit: 1})
+	if err != nil || len(heartbeats) == 0 {
+		return nil, err
+	}
+	return heartbeats[0], nil
+}
+
+func scanHeartbeat(iter *gocql.Iter) (*Heartbeat, bool) {
+	var (
+		heartbeat Heartbeat
+		id        string
+	)
+
+	ok := iter.Scan(&id, &heartbeat.IMEI, &heartbeat.CreatedAt, &heartbeat.TerminalInfo, &heartbeat.ExternalVoltage,
+		&heartbeat.BatteryVoltageLevel, &heartbeat.GSMSignal, &heartbeat.LanguageStatus)
+	if !ok {
+		return nil, false
+	}
+
+	heartbeat.ID, _ = primitive.ObjectIDFromHex(id)
+	return &heartbeat, true
+}
@@ -0,0 +1,100 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/gocql/gocql"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	positionColumns = `id, imei, date_time, gps_satellites, latitude, longitude, speed, course_status,
+		mcc, mnc, lac, cell_id, acc_status, upload_mode, gps_real_time_reupload, mileage, created_at`
+
+	insertPositionCQL = `INSERT INTO positions (day, ` + positionColumns + `)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	selectPositionsAscCQL = `SELECT ` + positionColumns + ` FROM positions
+		WHERE imei = ? AND day = ? AND date_time >= ? AND date_time < ? ORDER BY date_time ASC, id ASC LIMIT ?`
+
+	selectPositionsDescCQL = `SELECT ` + positionColumns + ` FROM positions
+		WHERE imei = ? AND day = ? AND date_time >= ? AND date_time < ? ORDER BY date_time DESC, id DESC LIMIT ?`
+)
+
+type scyllaPositionRepository struct {
+	session *gocql.Session
+}
+
+// NewScyllaPositionRepository initializes a PositionRepository backed by the ScyllaDB positions table
+func NewScyllaPositionRepository(session *gocql.Session) PositionRepository {
+	return &scyllaPositionRepository{session: session}
+}
+
+// Insert stores a position in the partition of its device and day
+func (r *scyllaPositionRepository) Insert(ctx context.Context, position *Position) error {
+	return r.session.Query(insertPositionCQL, positionArgs(position)...).WithContext(ctx).Exec()
+}
+
+// InsertMany stores positions with an unlogged batch per device and day
+func (r *scyllaPositionRepository) InsertMany(ctx context.Context, positions []*Position) error {
+	return scyllaInsertBatches(ctx, r.session, len(positions), func(i int) string {
+		return positions[i].IMEI + "/" + scyllaDay(positions[i].DateTime).Format(time.DateOnly)
+	}, func(batch *gocql.Batch, i int) {
+		batch.Query(insertPositionCQL, positionArgs(positions[i])...)
+	})
+}
+
+// Find retrieves the positions matching q, an IMEI is required
+func (r *scyllaPositionRepository) Find(ctx context.Context, q Query) ([]*Position, error) {
+	return scyllaFindSeries(ctx, r.session, q, selectPositionsAscCQL, selectPositionsDescCQL, scanPosition)
+}
+
+// FindWithin is not supported, the positions table has no spatial index
+func (r *scyllaPositionRepository) FindWithin(ctx context.Context, q Query, area Area) ([]*Position, error) {
+	return nil, ErrGeoNotSupported
+}
+
+// FindLatest retrieves the newest position of a device within the last scyllaMaxQueryDays
+func (r *scyllaPositionRepository) FindLatest(ctx context.Context, imei string) (*Position, error) {
+	positions, err := r.Find(ctx, Query{IMEI: imei, Limit: 1})
+	if err != nil || len(positions) == 0 {
+		return nil, err
+	}
+	return positions[0], nil
+}
+
+// positionArgs binds position to insertPositionCQL, assigning its id when missing
+func positionArgs(position *Position) []interface{} {
+	if position.ID.IsZero() {
+		position.ID = primitive.NewObjectID()
+	}
+
+	return []interface{}{
+		scyllaDay(position.DateTime), position.ID.Hex(), position.IMEI, position.DateTime,
+		position.GPSSatellites, position.Latitude, position.Longitude, position.Speed, position.CourseStatus,
+		position.MCC, position.MNC, position.LAC, position.CellID, position.ACCStatus, position.UploadMode,
+		position.GPSRealTimeReupload, int64(position.Mileage), position.CreatedAt,
+	}
+}
+
+func scanPosition(iter *gocql.Iter) (*Position, bool) {
+	var (
+		position Position
+		id       string
+		mileage  int64
+	)
+
+	ok := iter.Scan(&id, &position.IMEI, &position.DateTime, &position.GPSSatellites, &position.Latitude,
+		&position.Longitude, &position.Speed, &position.CourseStatus, &position.MCC, &position.MNC, &position.LAC,
+		&position.CellID, &position.ACCStatus, &position.UploadMode, &position.GPSRealTimeReupload, &mileage,
+		&position.CreatedAt)
+	if !ok {
+		return nil, false
+	}
+
+	position.ID, _ = primitive.ObjectIDFromHex(id)
+	position.Mileage = int(mileage)
+	position.Location = NewGeoPoint(position.Latitude, position.Longitude)
+	return &position, true
+}
@@ -0,0 +1,136 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+const DefaultScyllaReplication = "{'class': 'SimpleStrategy', 'replication_factor': 1}"
+
+var keyspacePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]{0,47}$`)
+
+// ScyllaMigration is a versioned set of schema statements. Statements must be idempotent
+// (IF NOT EXISTS) so a migration interrupted half-way can safely be applied again.
+type ScyllaMigration struct {
+	Version     int
+	Description string
+	Statements  []string
+}
+
+// scyllaMigrations is the schema history, append new versions at the end and never edit applied ones
+var scyllaMigrations = []ScyllaMigration{
+	{
+		Version:     1,
+		Description: "create positions table partitioned by device and day",
+		Statements: []string{`CREATE TABLE IF NOT EXISTS positions (
+			imei text,
+			day date,
+			date_time timestamp,
+			id text,
+			gps_satellites int,
+			latitude double,
+			longitude double,
+			speed int,
+			course_status int,
+			mcc int,
+			mnc int,
+			lac int,
+			cell_id int,
+			acc_status int,
+			upload_mode int,
+			gps_real_time_reupload int,
+			mileage bigint,
+			created_at timestamp,
+			PRIMARY KEY ((imei, day), date_time, id)
+		) WITH CLUSTERING ORDER BY (date_time DESC, id DESC)`},
+	},
+	{
+		Version:     2,
+		Description: "create heartbeats table partitioned by device and day",
+		Statements: []string{`CREATE TABLE IF NOT EXISTS heartbeats (
+			imei text,
+			day date,
+			created_at timestamp,
+			id text,
+			terminal_info int,
+			external_voltage int,
+			battery_voltage_level int,
+			gsm_signal int,
+			language_status int,
+			PRIMARY KEY ((imei, day), created_at, id)
+		) WITH CLUSTERING ORDER BY (created_at DESC, id DESC)`},
+	},
+}
+
+// CreateScyllaKeyspace creates keyspace when missing, it has to run before a session bound
+// to the keyspace can be opened.
+func CreateScyllaKeyspace(hosts []string, keyspace string, replication string) error {
+	if !keyspacePattern.MatchString(keyspace) {
+		return fmt.Errorf("invalid keyspace name: %q", keyspace)
+	}
+	if replication == "" {
+		replication = DefaultScyllaReplication
+	}
+
+	cluster := gocql.NewCluster(hosts...)
+	cluster.ConnectTimeout = 10 * time.Second
+	cluster.Timeout = 10 * time.Second
+
+	session, err := cluster.CreateSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	stmt := fmt.Sprintf("CREATE KEYSPACE IF NOT EXISTS %s WITH replication = %s", keyspace, replication)
+	return session.Query(stmt).Exec()
+}
+
+// MigrateScylla applies the schema migrations newer than the recorded schema version
+func MigrateScylla(ctx context.Context, session *gocql.Session) error {
+	err := session.Query(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version int PRIMARY KEY,
+		description text,
+		applied_at timestamp
+	)`).WithContext(ctx).Exec()
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	applied := make(map[int]bool)
+	var version int
+	iter := session.Query(`SELECT version FROM schema_migrations`).WithContext(ctx).Iter()
+	for iter.Scan(&version) {
+		applied[version] = true
+	}
+	if err := iter.Close(); err != nil {
+		return fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+
+	for _, migration := range scyllaMigrations {
+		if applied[migration.Version] {
+			continue
+		}
+
+		for _, stmt := range migration.Statements {
+			if err := session.Query(stmt).WithContext(ctx).Exec(); err != nil {
+				return fmt.Errorf("migration %d (%s) failed: %w", migration.Version, migration.Description, err)
+			}
+		}
+
+		err := session.Query(`INSERT INTO schema_migrations (version, description, applied_at) VALUES (?, ?, ?)`,
+			migration.Version, migration.Description, time.Now()).WithContext(ctx).Exec()
+		if err != nil {
+			return fmt.Errorf("failed to record migration %d: %w", migration.Version, err)
+		}
+
+		logx.Infof("Applied ScyllaDB migration %d: %s", migration.Version, migration.Description)
+	}
+
+	return nil
+}
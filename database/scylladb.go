@@ -3,26 +3,84 @@ package database
 import (
 	"context"
 	"fmt"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gocql/gocql"
 	"github.com/zeromicro/go-zero/core/logx"
 )
 
-// ScyllaDBModel defines an interface for ScyllaDB operations
+// scyllaMaxBatchSize bounds the statements sent in one unlogged batch
+const scyllaMaxBatchSize = 100
+
+// identifierPattern matches the unquoted CQL identifiers accepted for tables and columns
+var identifierPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,47}$`)
+
+// ScyllaDBModel defines an interface for ScyllaDB operations.
+// Table and column names are validated, values are always bound as statement arguments.
 type ScyllaDBModel interface {
-	Insert(ctx context.Context, tableName string, data map[string]interface{}) error
-	Update(ctx context.Context, tableName string, filter map[string]interface{}, data map[string]interface{}) error
+	Insert(ctx context.Context, tableName string, data map[string]interface{}, opts ...ScyllaWriteOption) error
+	InsertBatch(ctx context.Context, tableName string, partitionKey []string, rows []map[string]interface{}, opts ...ScyllaWriteOption) error
+	Update(ctx context.Context, tableName string, filter map[string]interface{}, data map[string]interface{}, opts ...ScyllaWriteOption) error
 	Get(ctx context.Context, tableName string, filter map[string]interface{}) (map[string]interface{}, error)
+	Query(ctx context.Context, tableName string, q ScyllaQuery) (*ScyllaPage, error)
 	Delete(ctx context.Context, tableName string, filter map[string]interface{}) error
+	Ping(ctx context.Context) error
+	Session() *gocql.Session
 	Close() error
 }
 
+// ScyllaWriteOption customizes an insert or update
+type ScyllaWriteOption func(o *scyllaWriteOptions)
+
+type scyllaWriteOptions struct {
+	ttl time.Duration
+}
+
+// WithTTL expires the written cells after ttl, rounded down to seconds
+func WithTTL(ttl time.Duration) ScyllaWriteOption {
+	return func(o *scyllaWriteOptions) {
+		o.ttl = ttl
+	}
+}
+
+// ScyllaCondition is a single WHERE clause term
+type ScyllaCondition struct {
+	Column string
+	Op     string // =, <, <=, >, >= or IN
+	Value  interface{}
+}
+
+// ScyllaQuery selects rows of a table one page at a time
+type ScyllaQuery struct {
+	Columns   []string // selected columns, all when empty
+	Where     []ScyllaCondition
+	OrderBy   string // clustering column to order by
+	Desc      bool
+	Limit     int    // total rows over all pages, 0 for no limit
+	PageSize  int    // rows per page, the driver default when 0
+	PageState []byte // state returned with the previous page
+}
+
+// ScyllaPage is one page of query results, PageState is empty on the last page
+type ScyllaPage struct {
+	Rows      []map[string]interface{}
+	PageState []byte
+}
+
+var scyllaOperators = map[string]bool{"=": true, "<": true, "<=": true, ">": true, ">=": true, "IN": true}
+
 // scyllaDBModel is the implementation of ScyllaDBModel
 type scyllaDBModel struct {
 	session  *gocql.Session
 	keyspace string
+
+	// statements caches the generated CQL so that identical shapes map to the same text
+	// and hit the driver's prepared statement cache
+	statements sync.Map
 }
 
 // NewScyllaDBModel initializes a new ScyllaDBModel instance
@@ -49,101 +107,239 @@ func NewScyllaDBModel(hosts []string, keyspace string, consistency gocql.Consist
 }
 
 // Insert inserts a document into the specified table
-func (s *scyllaDBModel) Insert(ctx context.Context, tableName string, data map[string]interface{}) error {
-	columns := make([]string, 0, len(data))
-	values := make([]interface{}, 0, len(data))
-	placeholders := make([]string, 0, len(data))
+func (s *scyllaDBModel) Insert(ctx context.Context, tableName string, data map[string]interface{}, opts ...ScyllaWriteOption) error {
+	stmt, args, err := s.insertStatement(tableName, data, opts)
+	if err != nil {
+		return err
+	}
+	return s.session.Query(stmt, args...).WithContext(ctx).Exec()
+}
 
-	for k, v := range data {
-		columns = append(columns, k)
-		values = append(values, v)
-		placeholders = append(placeholders, "?")
+// InsertBatch inserts rows with unlogged batches, one per partition. partitionKey lists the
+// partition key columns, rows sharing their values are sent to the same replica together.
+func (s *scyllaDBModel) InsertBatch(ctx context.Context, tableName string, partitionKey []string,
+	rows []map[string]interface{}, opts ...ScyllaWriteOption) error {
+	if len(partitionKey) == 0 {
+		return fmt.Errorf("partition key of %s is required", tableName)
 	}
 
-	query := fmt.Sprintf(
-		"INSERT INTO %s (%s) VALUES (%s)",
-		tableName,
-		strings.Join(columns, ","),
-		strings.Join(placeholders, ","),
-	)
+	partitions := make(map[string][]map[string]interface{})
+	order := make([]string, 0)
+	for _, row := range rows {
+		key, err := partitionOf(row, partitionKey)
+		if err != nil {
+			return err
+		}
+		if _, ok := partitions[key]; !ok {
+			order = append(order, key)
+		}
+		partitions[key] = append(partitions[key], row)
+	}
+
+	for _, key := range order {
+		partition := partitions[key]
+		for start := 0; start < len(partition); start += scyllaMaxBatchSize {
+			end := min(start+scyllaMaxBatchSize, len(partition))
+
+			batch := s.session.NewBatch(gocql.UnloggedBatch).WithContext(ctx)
+			for _, row := range partition[start:end] {
+				stmt, args, err := s.insertStatement(tableName, row, opts)
+				if err != nil {
+					return err
+				}
+				batch.Query(stmt, args...)
+			}
 
-	return s.session.Query(query, values...).WithContext(ctx).Exec()
+			if err := s.session.ExecuteBatch(batch); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
 }
 
 // Update updates documents in the specified table
-func (s *scyllaDBModel) Update(ctx context.Context, tableName string, filter map[string]interface{}, data map[string]interface{}) error {
-	setValues := make([]string, 0, len(data))
-	args := make([]interface{}, 0, len(data)+len(filter))
+func (s *scyllaDBModel) Update(ctx context.Context, tableName string, filter map[string]interface{},
+	data map[string]interface{}, opts ...ScyllaWriteOption) error {
+	if len(data) == 0 || len(filter) == 0 {
+		return fmt.Errorf("update of %s needs both values and a filter", tableName)
+	}
 
-	for k, v := range data {
-		setValues = append(setValues, fmt.Sprintf("%s = ?", k))
-		args = append(args, v)
+	o := writeOptions(opts)
+	setColumns, setArgs, err := sortedColumns(data)
+	if err != nil {
+		return err
+	}
+	whereColumns, whereArgs, err := sortedColumns(filter)
+	if err != nil {
+		return err
 	}
 
-	whereValues := make([]string, 0, len(filter))
-	for k, v := range filter {
-		whereValues = append(whereValues, fmt.Sprintf("%s = ?", k))
-		args = append(args, v)
+	key := fmt.Sprintf("update|%s|%t|%v|%v", tableName, o.ttl > 0, setColumns, whereColumns)
+	stmt, err := s.statement(tableName, key, func() string {
+		using := ""
+		if o.ttl > 0 {
+			using = " USING TTL ?"
+		}
+		return fmt.Sprintf("UPDATE %s%s SET %s WHERE %s", tableName, using,
+			joinTerms(setColumns, " = ?", ", "), joinTerms(whereColumns, " = ?", " AND "))
+	})
+	if err != nil {
+		return err
 	}
 
-	query := fmt.Sprintf(
-		"UPDATE %s SET %s WHERE %s",
-		tableName,
-		strings.Join(setValues, ","),
-		strings.Join(whereValues, " AND "),
-	)
+	args := make([]interface{}, 0, len(setArgs)+len(whereArgs)+1)
+	if o.ttl > 0 {
+		args = append(args, int(o.ttl.Seconds()))
+	}
+	args = append(args, setArgs...)
+	args = append(args, whereArgs...)
 
-	return s.session.Query(query, args...).WithContext(ctx).Exec()
+	return s.session.Query(stmt, args...).WithContext(ctx).Exec()
 }
 
-// Get retrieves a single document based on filter
+// Get retrieves a single document based on filter, nil when there is none
 func (s *scyllaDBModel) Get(ctx context.Context, tableName string, filter map[string]interface{}) (map[string]interface{}, error) {
-	whereValues := make([]string, 0, len(filter))
-	args := make([]interface{}, 0, len(filter))
+	where := make([]ScyllaCondition, 0, len(filter))
+	for column, value := range filter {
+		where = append(where, ScyllaCondition{Column: column, Op: "=", Value: value})
+	}
 
-	for k, v := range filter {
-		whereValues = append(whereValues, fmt.Sprintf("%s = ?", k))
-		args = append(args, v)
+	page, err := s.Query(ctx, tableName, ScyllaQuery{Where: where, Limit: 1})
+	if err != nil || len(page.Rows) == 0 {
+		return nil, err
 	}
+	return page.Rows[0], nil
+}
 
-	query := fmt.Sprintf(
-		"SELECT * FROM %s WHERE %s LIMIT 1",
-		tableName,
-		strings.Join(whereValues, " AND "),
-	)
+// Query retrieves one page of the rows matching q. To continue, repeat the same query
+// with the returned PageState, the server keeps counting Limit across pages.
+func (s *scyllaDBModel) Query(ctx context.Context, tableName string, q ScyllaQuery) (*ScyllaPage, error) {
+	where := append([]ScyllaCondition(nil), q.Where...)
+	sort.SliceStable(where, func(i, j int) bool {
+		if where[i].Column != where[j].Column {
+			return where[i].Column < where[j].Column
+		}
+		return where[i].Op < where[j].Op
+	})
 
-	iter := s.session.Query(query, args...).WithContext(ctx).Iter()
-	defer iter.Close()
+	terms := make([]string, 0, len(where))
+	args := make([]interface{}, 0, len(where)+1)
+	for _, cond := range where {
+		if err := validateIdentifier(cond.Column); err != nil {
+			return nil, err
+		}
+		op := strings.ToUpper(cond.Op)
+		if !scyllaOperators[op] {
+			return nil, fmt.Errorf("unsupported operator %q on %s", cond.Op, cond.Column)
+		}
+		terms = append(terms, fmt.Sprintf("%s %s ?", cond.Column, op))
+		args = append(args, cond.Value)
+	}
 
-	result := make(map[string]interface{})
-	for iter.Scan(result) {
-		return result, nil
+	columns := append([]string(nil), q.Columns...)
+	for _, column := range columns {
+		if err := validateIdentifier(column); err != nil {
+			return nil, err
+		}
+	}
+	if q.OrderBy != "" {
+		if err := validateIdentifier(q.OrderBy); err != nil {
+			return nil, err
+		}
 	}
 
+	key := fmt.Sprintf("select|%s|%v|%v|%s|%t|%t", tableName, columns, terms, q.OrderBy, q.Desc, q.Limit > 0)
+	stmt, err := s.statement(tableName, key, func() string {
+		var b strings.Builder
+		b.WriteString("SELECT ")
+		if len(columns) == 0 {
+			b.WriteString("*")
+		} else {
+			b.WriteString(strings.Join(columns, ", "))
+		}
+		b.WriteString(" FROM ")
+		b.WriteString(tableName)
+		if len(terms) > 0 {
+			b.WriteString(" WHERE ")
+			b.WriteString(strings.Join(terms, " AND "))
+		}
+		if q.OrderBy != "" {
+			b.WriteString(" ORDER BY ")
+			b.WriteString(q.OrderBy)
+			if q.Desc {
+				b.WriteString(" DESC")
+			} else {
+				b.WriteString(" ASC")
+			}
+		}
+		if q.Limit > 0 {
+			b.WriteString(" LIMIT ?")
+		}
+		return b.String()
+	})
+	if err != nil {
+		return nil, err
+	}
+	if q.Limit > 0 {
+		args = append(args, q.Limit)
+	}
+
+	query := s.session.Query(stmt, args...).WithContext(ctx).PageState(q.PageState)
+	if q.PageSize > 0 {
+		query = query.PageSize(q.PageSize)
+	}
+
+	iter := query.Iter()
+	page := &ScyllaPage{
+		Rows:      make([]map[string]interface{}, 0, iter.NumRows()),
+		PageState: iter.PageState(),
+	}
+	for {
+		row := make(map[string]interface{})
+		if !iter.MapScan(row) {
+			break
+		}
+		page.Rows = append(page.Rows, row)
+	}
 	if err := iter.Close(); err != nil {
 		return nil, err
 	}
 
-	return nil, nil
+	return page, nil
 }
 
 // Delete removes documents from the specified table based on filter
 func (s *scyllaDBModel) Delete(ctx context.Context, tableName string, filter map[string]interface{}) error {
-	whereValues := make([]string, 0, len(filter))
-	args := make([]interface{}, 0, len(filter))
+	if len(filter) == 0 {
+		return fmt.Errorf("delete from %s needs a filter", tableName)
+	}
+
+	columns, args, err := sortedColumns(filter)
+	if err != nil {
+		return err
+	}
 
-	for k, v := range filter {
-		whereValues = append(whereValues, fmt.Sprintf("%s = ?", k))
-		args = append(args, v)
+	key := fmt.Sprintf("delete|%s|%v", tableName, columns)
+	stmt, err := s.statement(tableName, key, func() string {
+		return fmt.Sprintf("DELETE FROM %s WHERE %s", tableName, joinTerms(columns, " = ?", " AND "))
+	})
+	if err != nil {
+		return err
 	}
 
-	query := fmt.Sprintf(
-		"DELETE FROM %s WHERE %s",
-		tableName,
-		strings.Join(whereValues, " AND "),
-	)
+	return s.session.Query(stmt, args...).WithContext(ctx).Exec()
+}
+
+// Ping checks that the cluster answers queries
+func (s *scyllaDBModel) Ping(ctx context.Context) error {
+	return s.session.Query("SELECT now() FROM system.local").WithContext(ctx).Exec()
+}
 
-	return s.session.Query(query, args...).WithContext(ctx).Exec()
+// Session returns the underlying session bound to the keyspace
+func (s *scyllaDBModel) Session() *gocql.Session {
+	return s.session
 }
 
 // Close closes the ScyllaDB session
@@ -153,3 +349,110 @@ func (s *scyllaDBModel) Close() error {
 	}
 	return nil
 }
+
+// insertStatement builds the INSERT of data with its arguments in column order
+func (s *scyllaDBModel) insertStatement(tableName string, data map[string]interface{}, opts []ScyllaWriteOption) (string, []interface{}, error) {
+	if len(data) == 0 {
+		return "", nil, fmt.Errorf("insert into %s has no values", tableName)
+	}
+
+	o := writeOptions(opts)
+	columns, args, err := sortedColumns(data)
+	if err != nil {
+		return "", nil, err
+	}
+
+	key := fmt.Sprintf("insert|%s|%t|%v", tableName, o.ttl > 0, columns)
+	stmt, err := s.statement(tableName, key, func() string {
+		using := ""
+		if o.ttl > 0 {
+			using = " USING TTL ?"
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+		return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)%s",
+			tableName, strings.Join(columns, ", "), placeholders, using)
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	if o.ttl > 0 {
+		args = append(args, int(o.ttl.Seconds()))
+	}
+	return stmt, args, nil
+}
+
+// statement returns the cached CQL of a statement shape, building it on first use
+func (s *scyllaDBModel) statement(tableName string, key string, build func() string) (string, error) {
+	if err := validateIdentifier(tableName); err != nil {
+		return "", err
+	}
+
+	if stmt, ok := s.statements.Load(key); ok {
+		return stmt.(string), nil
+	}
+
+	stmt := build()
+	s.statements.Store(key, stmt)
+	return stmt, nil
+}
+
+func writeOptions(opts []ScyllaWriteOption) scyllaWriteOptions {
+	var o scyllaWriteOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.ttl > 0 && o.ttl < time.Second {
+		o.ttl = time.Second
+	}
+	return o
+}
+
+// sortedColumns returns the validated column names of data in sorted order with their values
+func sortedColumns(data map[string]interface{}) ([]string, []interface{}, error) {
+	columns := make([]string, 0, len(data))
+	for column := range data {
+		if err := validateIdentifier(column); err != nil {
+			return nil, nil, err
+		}
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+
+	values := make([]interface{}, 0, len(columns))
+	for _, column := range columns {
+		values = append(values, data[column])
+	}
+	return columns, values, nil
+}
+
+// partitionOf renders the partition key values of row as a grouping key
+func partitionOf(row map[string]interface{}, partitionKey []string) (string, error) {
+	parts := make([]string, 0, len(partitionKey))
+	for _, column := range partitionKey {
+		value, ok := row[column]
+		if !ok {
+			return "", fmt.Errorf("row is missing partition key column %s", column)
+		}
+		if t, ok := value.(time.Time); ok {
+			value = t.UTC().UnixNano()
+		}
+		parts = append(parts, fmt.Sprintf("%v", value))
+	}
+	return strings.Join(parts, "\x00"), nil
+}
+
+func joinTerms(columns []string, suffix string, sep string) string {
+	terms := make([]string, 0, len(columns))
+	for _, column := range columns {
+		terms = append(terms, column+suffix)
+	}
+	return strings.Join(terms, sep)
+}
+
+func validateIdentifier(name string) error {
+	if !identifierPattern.MatchString(name) {
+		return fmt.Errorf("invalid CQL identifier: %q", name)
+	}
+	return nil
+}
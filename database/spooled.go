@@ -0,0 +1,221 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gt06/spool"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/logx"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	spoolKindDevice    = "device_login"
+	spoolKindLogin     = "login"
+	spoolKindPosition  = "position"
+	spoolKindAlarm     = "alarm"
+	spoolKindHeartbeat = "heartbeat"
+)
+
+// errSpoolBacklog marks records queued behind the spool backlog without an insert attempt
+var errSpoolBacklog = errors.New("spool has a backlog")
+
+// spooler writes through to the repositories, falling back to the spool on failure.
+// While the spool holds a backlog, new records are appended behind it to keep their order.
+type spooler struct {
+	spool    *spool.Spool
+	timeout  time.Duration // of a direct write, so that an outage does not hold the packet for long
+	handlers map[string]func(ctx context.Context, data []byte) error
+}
+
+// NewSpooledRepositories wraps the inserts of repos so that records are spooled to disk
+// while the storage is unavailable and replayed in order once probe succeeds again. A direct
+// write that takes longer than timeout is spooled.
+func NewSpooledRepositories(repos Repositories, sp *spool.Spool, probe spool.Probe, timeout time.Duration) Repositories {
+	s := &spooler{
+		spool:   sp,
+		timeout: timeout,
+		handlers: map[string]func(ctx context.Context, data []byte) error{
+			// a login recorded before the checkpoint was persisted is not counted again
+			spoolKindDevice:    replayAs(repos.Devices.RecordLogin),
+			spoolKindLogin:     replayAs(repos.Logins.Insert),
+			spoolKindPosition:  replayAs(repos.Positions.Insert),
+			spoolKindAlarm:     replayAs(repos.Alarms.Insert),
+			spoolKindHeartbeat: replayAs(repos.Heartbeats.Insert),
+		},
+	}
+	sp.StartReplay(probe, s.replay)
+
+	return Repositories{
+		Devices:    &spooledDeviceRepository{DeviceRepository: repos.Devices, spooler: s},
+		Logins:     &spooledLoginEventRepository{LoginEventRepository: repos.Logins, spooler: s},
+		Positions:  &spooledPositionRepository{PositionRepository: repos.Positions, spooler: s},
+		Alarms:     &spooledAlarmRepository{AlarmRepository: repos.Alarms, spooler: s},
+		Heartbeats: &spooledHeartbeatRepository{HeartbeatRepository: repos.Heartbeats, spooler: s},
+		// the state is derived from the other records and is not spooled
+		States: repos.States,
+		// commands are sent synchronously and their outcome is not worth delaying
+		Commands: repos.Commands,
+		// blocking is an operator action that must not be deferred
+		Blocklist: repos.Blocklist,
+	}
+}
+
+// write runs insert unless the spool has a backlog, and spools record when that fails.
+// The id is assigned up front so a replay after a crash is idempotent.
+func (s *spooler) write(ctx context.Context, kind string, id *primitive.ObjectID, record any, insert func(ctx context.Context) error) error {
+	if id.IsZero() {
+		*id = primitive.NewObjectID()
+	}
+
+	if s.spool.Depth() == 0 {
+		insertCtx, cancel := context.WithTimeout(ctx, s.timeout)
+		err := insert(insertCtx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		logx.WithContext(ctx).Errorf("Insert of %s failed, spooling: %v", kind, err)
+	}
+
+	return s.append(kind, record)
+}
+
+func (s *spooler) append(kind string, record any) error {
+	raw, err := bson.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s for spool: %w", kind, err)
+	}
+
+	if err := s.spool.Append(kind, raw); err != nil {
+		return fmt.Errorf("failed to spool %s: %w", kind, err)
+	}
+	return nil
+}
+
+// writeMany is write for a bulk insert, only the records the insert failed on are spooled
+func writeMany[T any](ctx context.Context, s *spooler, kind string, records []*T, id func(*T) *primitive.ObjectID,
+	insertMany func() error) error {
+	for _, record := range records {
+		if oid := id(record); oid.IsZero() {
+			*oid = primitive.NewObjectID()
+		}
+	}
+
+	var insertErr error = errSpoolBacklog
+	if s.spool.Depth() == 0 {
+		insertErr = insertMany()
+		if insertErr == nil {
+			return nil
+		}
+		logx.WithContext(ctx).Errorf("Bulk insert of %s failed, spooling: %v", kind, insertErr)
+	}
+
+	errs := make(InsertErrors)
+	for i, record := range records {
+		if InsertErrorOf(insertErr, i) == nil {
+			continue
+		}
+		if err := s.append(kind, record); err != nil {
+			errs[i] = err
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func (s *spooler) replay(ctx context.Context, rec spool.Record) error {
+	handle, ok := s.handlers[rec.Kind]
+	if !ok {
+		return fmt.Errorf("unknown spool record kind: %s", rec.Kind)
+	}
+	return handle(ctx, rec.Data)
+}
+
+func replayAs[T any](insert func(ctx context.Context, record *T) error) func(ctx context.Context, data []byte) error {
+	return func(ctx context.Context, data []byte) error {
+		record := new(T)
+		if err := bson.Unmarshal(data, record); err != nil {
+			return fmt.Errorf("failed to decode spooled record: %w", err)
+		}
+
+		err := insert(ctx, record)
+		if mongo.IsDuplicateKeyError(err) {
+			// already written before the checkpoint was persisted
+			return nil
+		}
+		return err
+	}
+}
+
+type spooledDeviceRepository struct {
+	DeviceRepository
+	spooler *spooler
+}
+
+func (r *spooledDeviceRepository) RecordLogin(ctx context.Context, login *LoginEvent) error {
+	return r.spooler.write(ctx, spoolKindDevice, &login.ID, login, func(ctx context.Context) error {
+		return r.DeviceRepository.RecordLogin(ctx, login)
+	})
+}
+
+type spooledLoginEventRepository struct {
+	LoginEventRepository
+	spooler *spooler
+}
+
+func (r *spooledLoginEventRepository) Insert(ctx context.Context, login *LoginEvent) error {
+	return r.spooler.write(ctx, spoolKindLogin, &login.ID, login, func(ctx context.Context) error {
+		return r.LoginEventRepository.Insert(ctx, login)
+	})
+}
+
+type spooledPositionRepository struct {
+	PositionRepository
+	spooler *spooler
+}
+
+func (r *spooledPositionRepository) Insert(ctx context.Context, position *Position) error {
+	return r.spooler.write(ctx, spoolKindPosition, &position.ID, position, func(ctx context.Context) error {
+		return r.PositionRepository.Insert(ctx, position)
+	})
+}
+
+func (r *spooledPositionRepository) InsertMany(ctx context.Context, positions []*Position) error {
+	return writeMany(ctx, r.spooler, spoolKindPosition, positions, func(p *Position) *primitive.ObjectID { return &p.ID },
+		func() error { return r.PositionRepository.InsertMany(ctx, positions) })
+}
+
+type spooledAlarmRepository struct {
+	AlarmRepository
+	spooler *spooler
+}
+
+func (r *spooledAlarmRepository) Insert(ctx context.Context, alarm *Alarm) error {
+	return r.spooler.write(ctx, spoolKindAlarm, &alarm.ID, alarm, func(ctx context.Context) error {
+		return r.AlarmRepository.Insert(ctx, alarm)
+	})
+}
+
+func (r *spooledAlarmRepository) InsertMany(ctx context.Context, alarms []*Alarm) error {
+	return writeMany(ctx, r.spooler, spoolKindAlarm, alarms, func(a *Alarm) *primitive.ObjectID { return &a.ID },
+		func() error { return r.AlarmRepository.InsertMany(ctx, alarms) })
+}
+
+type spooledHeartbeatRepository struct {
+	HeartbeatRepository
+	spooler *spooler
+}
+
+func (r *spooledHeartbeatRepository) Insert(ctx context.Context, heartbeat *Heartbeat) error {
+	return r.spooler.write(ctx, spoolKindHeartbeat, &heartbeat.ID, heartbeat, func(ctx context.Context) error {
+		return r.HeartbeatRepository.Insert(ctx, heartbeat)
+	})
+}
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	BackendMongo  = "mongo"
+	BackendScylla = "scylla"
+	BackendBoth   = "both"
+)
+
+// teeWrite runs every insert, a duplicate key means the record is already stored there
+func teeWrite(inserts ...func() error) error {
+	var errs []error
+	for _, insert := range inserts {
+		if err := insert(); err != nil && !mongo.IsDuplicateKeyError(err) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// teeWriteMany runs every bulk insert of n records and merges their per-record errors
+func teeWriteMany(n int, inserts ...func() error) error {
+	errs := make(InsertErrors)
+	for _, insert := range inserts {
+		err := insert()
+		if err == nil {
+			continue
+		}
+		for i := 0; i < n; i++ {
+			if recordErr := InsertErrorOf(err, i); recordErr != nil && !mongo.IsDuplicateKeyError(recordErr) {
+				errs[i] = errors.Join(errs[i], recordErr)
+			}
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+type teePositionRepository struct {
+	PositionRepository
+	secondary PositionRepository
+}
+
+// NewTeePositionRepository writes positions to both repositories and reads from primary
+func NewTeePositionRepository(primary, secondary PositionRepository) PositionRepository {
+	return &teePositionRepository{PositionRepository: primary, secondary: secondary}
+}
+
+func (r *teePositionRepository) Insert(ctx context.Context, position *Position) error {
+	if position.ID.IsZero() {
+		position.ID = primitive.NewObjectID()
+	}
+
+	return teeWrite(
+		func() error { return r.PositionRepository.Insert(ctx, position) },
+		func() error { return r.secondary.Insert(ctx, position) },
+	)
+}
+
+func (r *teePositionRepository) InsertMany(ctx context.Context, positions []*Position) error {
+	for _, position := range positions {
+		if position.ID.IsZero() {
+			position.ID = primitive.NewObjectID()
+		}
+	}
+
+	return teeWriteMany(len(positions),
+		func() error { return r.PositionRepository.InsertMany(ctx, positions) },
+		func() error { return r.secondary.InsertMany(ctx, positions) },
+	)
+}
+
+type teeHeartbeatRepository struct {
+	HeartbeatRepository
+	secondary HeartbeatRepository
+}
+
+// NewTeeHeartbeatRepository writes heartbeats to both repositories and reads from primary
+func NewTeeHeartbeatRepository(primary, secondary HeartbeatRepository) HeartbeatRepository {
+	return &teeHeartbeatRepository{HeartbeatRepository: primary, secondary: secondary}
+}
+
+func (r *teeHeartbeatRepository) Insert(ctx context.Context, heartbeat *Heartbeat) error {
+	if heartbeat.ID.IsZero() {
+		heartbeat.ID = primitive.NewObjectID()
+	}
+
+	return teeWrite(
+		func() error { return r.HeartbeatRepository.Insert(ctx, heartbeat) },
+		func() error { return r.secondary.Insert(ctx, heartbeat) },
+	)
+}
@@ -0,0 +1,18 @@
+package events
+
+// Conf configures the event hub
+type Conf struct {
+	Buffer         int                 `json:"Buffer,optional" yaml:"Buffer"`                 // events queued per subscriber
+	DropLimit      int                 `json:"DropLimit,optional" yaml:"DropLimit"`           // consecutive dropped events that disconnect a subscriber, 0 never does
+	MaxSubscribers int                 `json:"MaxSubscribers,optional" yaml:"MaxSubscribers"` // 0 is unlimited
+	Groups         map[string][]string `json:"Groups,optional" yaml:"Groups"`                 // device IMEIs by group name
+}
+
+// DefaultConf returns a Conf with default values
+func DefaultConf() Conf {
+	return Conf{
+		Buffer:         256,
+		DropLimit:      1024,
+		MaxSubscribers: 1000,
+	}
+}
@@ -0,0 +1,178 @@
+package events
+
+import (
+	"gt06/database"
+	"time"
+)
+
+// Type is the kind of an event
+type Type string
+
+const (
+	TypeLogin         Type = "login"
+	TypeSessionOpened Type = "session.opened"
+	TypeSessionClosed Type = "session.closed"
+	TypePosition      Type = "position"
+	TypeAlarm         Type = "alarm"
+	TypeHeartbeat     Type = "heartbeat"
+	TypeStateChanged  Type = "state.changed"
+)
+
+var types = map[Type]struct{}{
+	TypeLogin:         {},
+	TypeSessionOpened: {},
+	TypeSessionClosed: {},
+	TypePosition:      {},
+	TypeAlarm:         {},
+	TypeHeartbeat:     {},
+	TypeStateChanged:  {},
+}
+
+// Known reports whether t is a type of event published by the services
+func (t Type) Known() bool {
+	_, ok := types[t]
+	return ok
+}
+
+// lowBattery is the highest voltage level, from 0 to 6, reported as a low battery
+const lowBattery = 2
+
+// Event is published to the hub when something happens to a device
+type Event struct {
+	Seq  uint64    `json:"seq"`
+	Type Type      `json:"type"`
+	IMEI string    `json:"imei"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data,omitempty"`
+
+	// Location is where the event happened, used to filter by area
+	Location *database.LatLng `json:"-"`
+}
+
+// Session is the data of session events
+type Session struct {
+	ConnectionID string `json:"connection_id"`
+	RemoteAddr   string `json:"remote_addr"`
+}
+
+// PositionEvent returns the event of a stored position, it holds a copy of the record
+func PositionEvent(position *database.Position) Event {
+	copied := *position
+	return Event{
+		Type:     TypePosition,
+		IMEI:     position.IMEI,
+		Time:     position.CreatedAt,
+		Data:     &copied,
+		Location: &database.LatLng{Latitude: position.Latitude, Longitude: position.Longitude},
+	}
+}
+
+// AlarmEvent returns the event of a stored alarm, it holds a copy of the record
+func AlarmEvent(alarm *database.Alarm) Event {
+	copied := *alarm
+	return Event{
+		Type:     TypeAlarm,
+		IMEI:     alarm.IMEI,
+		Time:     alarm.CreatedAt,
+		Data:     &copied,
+		Location: &database.LatLng{Latitude: alarm.Latitude, Longitude: alarm.Longitude},
+	}
+}
+
+// LoginEvent returns the event of a stored login, it holds a copy of the record
+func LoginEvent(login *database.LoginEvent) Event {
+	copied := *login
+	return Event{
+		Type: TypeLogin,
+		IMEI: login.IMEI,
+		Time: login.CreatedAt,
+		Data: &copied,
+	}
+}
+
+// HeartbeatEvent returns the event of a stored heartbeat, it holds a copy of the record
+func HeartbeatEvent(heartbeat *database.Heartbeat) Event {
+	copied := *heartbeat
+	return Event{
+		Type: TypeHeartbeat,
+		IMEI: heartbeat.IMEI,
+		Time: heartbeat.CreatedAt,
+		Data: &copied,
+	}
+}
+
+// SessionEvent returns a session.opened or session.closed event
+func SessionEvent(t Type, imei, connectionID, remoteAddr string, at time.Time) Event {
+	return Event{
+		Type: t,
+		IMEI: imei,
+		Time: at,
+		Data: Session{ConnectionID: connectionID, RemoteAddr: remoteAddr},
+	}
+}
+
+// StateChange is the data of state.changed events
+type StateChange struct {
+	Field string `json:"field"` // online, acc or low_battery
+	From  bool   `json:"from"`
+	To    bool   `json:"to"`
+}
+
+// StateChanges returns a state.changed event for every tracked field that differs between the
+// states of a device before and after an update. A device without state was offline, and ACC
+// and the battery only change once they were known.
+func StateChanges(before, after *database.DeviceState, at time.Time) []Event {
+	if after == nil {
+		return nil
+	}
+	if before == nil {
+		before = &database.DeviceState{}
+	}
+
+	var changes []Event
+	change := func(field string, from, to bool) {
+		if from != to {
+			changes = append(changes, Event{
+				Type: TypeStateChanged,
+				IMEI: after.IMEI,
+				Time: at,
+				Data: StateChange{Field: field, From: from, To: to},
+			})
+		}
+	}
+
+	change("online", before.Online, after.Online)
+	if before.ACC != nil && after.ACC != nil {
+		change("acc", *before.ACC, *after.ACC)
+	}
+	if before.Battery != nil && after.Battery != nil {
+		change("low_battery", *before.Battery <= lowBattery, *after.Battery <= lowBattery)
+	}
+	return changes
+}
+
+// Filter selects the events a subscriber receives, empty fields match every event
+type Filter struct {
+	IMEIs map[string]struct{}
+	Types map[Type]struct{}
+	// Area only matches events with a location inside it
+	Area database.Area
+}
+
+// Match reports whether e passes the filter
+func (f Filter) Match(e *Event) bool {
+	if len(f.IMEIs) > 0 {
+		if _, ok := f.IMEIs[e.IMEI]; !ok {
+			return false
+		}
+	}
+	if len(f.Types) > 0 {
+		if _, ok := f.Types[e.Type]; !ok {
+			return false
+		}
+	}
+	if f.Area != nil && (e.Location == nil || !f.Area.Contains(*e.Location)) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,178 @@
+package events
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+var (
+	ErrSlowConsumer   = errors.New("subscriber is too slow, events were dropped")
+	ErrHubClosed      = errors.New("event hub is closed")
+	ErrTooManyStreams = errors.New("too many subscribers")
+)
+
+// Hub fans out the events published by the services to the subscribers whose filter matches.
+// Publish never blocks: a subscriber with a full buffer misses the event, and one that misses
+// DropLimit events in a row is disconnected with ErrSlowConsumer.
+type Hub struct {
+	conf Conf
+	seq  atomic.Uint64
+
+	mu          sync.RWMutex
+	subscribers map[*Subscription]struct{}
+	listeners   []func(e Event)
+	closed      bool
+}
+
+// NewHub returns an empty Hub
+func NewHub(c Conf) *Hub {
+	if c.Buffer <= 0 {
+		c.Buffer = DefaultConf().Buffer
+	}
+
+	return &Hub{
+		conf:        c,
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Group returns the IMEIs of a configured group
+func (h *Hub) Group(name string) ([]string, bool) {
+	imeis, ok := h.conf.Groups[name]
+	return imeis, ok
+}
+
+// Publish numbers e and queues it for every matching subscriber
+func (h *Hub) Publish(e Event) {
+	e.Seq = h.seq.Add(1)
+
+	var slow []*Subscription
+	h.mu.RLock()
+	for _, listener := range h.listeners {
+		listener(e)
+	}
+	for sub := range h.subscribers {
+		if !sub.filter.Match(&e) {
+			continue
+		}
+
+		select {
+		case sub.events <- e:
+			sub.missed.Store(0)
+		default:
+			sub.dropped.Add(1)
+			if missed := sub.missed.Add(1); h.conf.DropLimit > 0 && missed == int64(h.conf.DropLimit) {
+				slow = append(slow, sub)
+			}
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, sub := range slow {
+		logx.Errorf("Disconnecting slow event subscriber after %d dropped events", sub.dropped.Load())
+		h.remove(sub, ErrSlowConsumer)
+	}
+}
+
+// Listen calls listener with every published event from the publishing goroutine, unlike a
+// Subscription it misses none. The listener must not block.
+func (h *Hub) Listen(listener func(e Event)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.listeners = append(h.listeners, listener)
+}
+
+// Subscribe registers a subscriber receiving the events matching f
+func (h *Hub) Subscribe(f Filter) (*Subscription, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil, ErrHubClosed
+	}
+	if h.conf.MaxSubscribers > 0 && len(h.subscribers) >= h.conf.MaxSubscribers {
+		return nil, ErrTooManyStreams
+	}
+
+	sub := &Subscription{
+		hub:    h,
+		filter: f,
+		events: make(chan Event, h.conf.Buffer),
+	}
+	h.subscribers[sub] = struct{}{}
+	return sub, nil
+}
+
+// Subscribers returns the number of subscribers
+func (h *Hub) Subscribers() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.subscribers)
+}
+
+// Close disconnects every subscriber with ErrHubClosed
+func (h *Hub) Close() {
+	h.mu.Lock()
+	h.closed = true
+	subscribers := h.subscribers
+	h.subscribers = make(map[*Subscription]struct{})
+	h.mu.Unlock()
+
+	for sub := range subscribers {
+		sub.close(ErrHubClosed)
+	}
+}
+
+func (h *Hub) remove(sub *Subscription, err error) {
+	h.mu.Lock()
+	_, ok := h.subscribers[sub]
+	delete(h.subscribers, sub)
+	h.mu.Unlock()
+
+	if ok {
+		sub.close(err)
+	}
+}
+
+// Subscription receives the events of a Subscribe until it is closed
+type Subscription struct {
+	hub     *Hub
+	filter  Filter
+	events  chan Event
+	dropped atomic.Uint64
+	missed  atomic.Int64 // events dropped since the last delivered one
+
+	once sync.Once
+	err  error
+}
+
+// Events returns the channel of events, closed when the subscription ends
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Err returns why the hub ended the subscription once Events is closed, nil after Close
+func (s *Subscription) Err() error {
+	return s.err
+}
+
+// Dropped returns the number of events missed because the buffer was full
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close unsubscribes
+func (s *Subscription) Close() {
+	s.hub.remove(s, nil)
+}
+
+// close is called once the subscription is no longer in the hub, so no publisher sends to it anymore
+func (s *Subscription) close(err error) {
+	s.once.Do(func() {
+		s.err = err
+		close(s.events)
+	})
+}
@@ -0,0 +1,22 @@
+package health
+
+// Conf configures the health checks and the readiness criteria
+type Conf struct {
+	Interval        int   `json:"Interval,optional" yaml:"Interval"`               // seconds between storage pings
+	Timeout         int   `json:"Timeout,optional" yaml:"Timeout"`                 // seconds allowed for one ping
+	RequireStorage  bool  `json:"RequireStorage,optional" yaml:"RequireStorage"`   // not ready until every configured storage backend answers
+	RequireListener bool  `json:"RequireListener,optional" yaml:"RequireListener"` // not ready until the GT06 listener accepts connections
+	MaxSpoolBacklog int64 `json:"MaxSpoolBacklog,optional" yaml:"MaxSpoolBacklog"` // spooled records above which the server is not ready, 0 ignores the backlog
+	StallTimeout    int   `json:"StallTimeout,optional" yaml:"StallTimeout"`       // seconds a packet may be processed before its event loop counts as stuck
+}
+
+// DefaultConf returns a Conf with default values
+func DefaultConf() Conf {
+	return Conf{
+		Interval:        5,
+		Timeout:         2,
+		RequireStorage:  true,
+		RequireListener: true,
+		StallTimeout:    30,
+	}
+}
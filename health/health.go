@@ -0,0 +1,242 @@
+// Package health tracks the state of the server and its dependencies for the liveness and
+// readiness endpoints. Remote dependencies are pinged in the background, local components
+// are checked on every request.
+package health
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/logx"
+	"github.com/zeromicro/go-zero/core/threading"
+)
+
+const (
+	StatusUp      = "up"
+	StatusDown    = "down"
+	StatusUnknown = "unknown" // not pinged yet
+)
+
+// Check inspects a local component, details are reported as is
+type Check func() (details any, err error)
+
+// Probe pings a remote dependency
+type Probe func(ctx context.Context) error
+
+// Result is the state of one component
+type Result struct {
+	Name       string    `json:"name"`
+	Status     string    `json:"status"`
+	Required   bool      `json:"required"` // whether readiness depends on it
+	Error      string    `json:"error,omitempty"`
+	Details    any       `json:"details,omitempty"`
+	CheckedAt  time.Time `json:"checked_at"`
+	DurationMs float64   `json:"duration_ms"`
+}
+
+// Report is the answer of a liveness or readiness check
+type Report struct {
+	OK     bool     `json:"ok"`
+	Checks []Result `json:"checks"`
+}
+
+type check struct {
+	name     string
+	required bool
+	check    Check
+}
+
+type probe struct {
+	name     string
+	required bool
+	probe    Probe
+}
+
+// Checker runs the registered checks
+type Checker struct {
+	conf Conf
+
+	mu        sync.RWMutex
+	liveness  []check
+	readiness []check
+	probes    []probe
+	results   map[string]Result
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewChecker builds a Checker, the probes are not run until Start
+func NewChecker(c Conf) *Checker {
+	defaults := DefaultConf()
+	if c.Interval <= 0 {
+		c.Interval = defaults.Interval
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = defaults.Timeout
+	}
+	if c.StallTimeout <= 0 {
+		c.StallTimeout = defaults.StallTimeout
+	}
+
+	return &Checker{
+		conf:    c,
+		results: make(map[string]Result),
+		stop:    make(chan struct{}),
+	}
+}
+
+// Conf returns the configuration of the checker with defaults applied
+func (h *Checker) Conf() Conf {
+	return h.conf
+}
+
+// AddLiveness registers a check failing both the liveness and the readiness
+func (h *Checker) AddLiveness(name string, c Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.liveness = append(h.liveness, check{name: name, required: true, check: c})
+}
+
+// AddReadiness registers a check of the readiness, only a required one can fail it
+func (h *Checker) AddReadiness(name string, required bool, c Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.readiness = append(h.readiness, check{name: name, required: required, check: c})
+}
+
+// AddProbe registers a dependency pinged every Interval, only a required one can fail the readiness
+func (h *Checker) AddProbe(name string, required bool, p Probe) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.probes = append(h.probes, probe{name: name, required: required, probe: p})
+	h.results[name] = Result{Name: name, Status: StatusUnknown, Required: required}
+}
+
+// Unavailable is the probe of a dependency that could not be set up
+func Unavailable(err error) Probe {
+	return func(ctx context.Context) error {
+		return fmt.Errorf("not connected: %w", err)
+	}
+}
+
+// Start pings the probes now and then every Interval until Stop
+func (h *Checker) Start() {
+	threading.GoSafe(func() {
+		ticker := time.NewTicker(time.Duration(h.conf.Interval) * time.Second)
+		defer ticker.Stop()
+
+		for {
+			h.ping()
+			select {
+			case <-h.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// Stop stops the pings
+func (h *Checker) Stop() {
+	h.stopOnce.Do(func() {
+		close(h.stop)
+	})
+}
+
+// ping runs every probe concurrently and stores the results
+func (h *Checker) ping() {
+	h.mu.RLock()
+	probes := append([]probe(nil), h.probes...)
+	h.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, p := range probes {
+		wg.Add(1)
+		threading.GoSafe(func() {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Duration(h.conf.Timeout)*time.Second)
+			defer cancel()
+
+			result := run(p.name, p.required, func() (any, error) {
+				return nil, p.probe(ctx)
+			})
+
+			h.mu.Lock()
+			previous := h.results[p.name]
+			h.results[p.name] = result
+			h.mu.Unlock()
+
+			if previous.Status != result.Status {
+				if result.Status == StatusUp {
+					logx.Infof("Health of %s is %s", p.name, result.Status)
+				} else {
+					logx.Errorf("Health of %s is %s: %s", p.name, result.Status, result.Error)
+				}
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// Liveness reports whether the server is able to make progress
+func (h *Checker) Liveness() Report {
+	h.mu.RLock()
+	checks := append([]check(nil), h.liveness...)
+	h.mu.RUnlock()
+
+	return report(runAll(checks))
+}
+
+// Readiness reports whether the server can take traffic: every required check is up
+func (h *Checker) Readiness() Report {
+	h.mu.RLock()
+	checks := append(append([]check(nil), h.liveness...), h.readiness...)
+	results := make([]Result, 0, len(h.probes))
+	for _, p := range h.probes {
+		results = append(results, h.results[p.name])
+	}
+	h.mu.RUnlock()
+
+	return report(append(runAll(checks), results...))
+}
+
+func runAll(checks []check) []Result {
+	results := make([]Result, 0, len(checks))
+	for _, c := range checks {
+		results = append(results, run(c.name, c.required, c.check))
+	}
+	return results
+}
+
+func run(name string, required bool, c Check) Result {
+	start := time.Now()
+	details, err := c()
+
+	result := Result{
+		Name:       name,
+		Status:     StatusUp,
+		Required:   required,
+		Details:    details,
+		CheckedAt:  start,
+		DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = StatusDown
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func report(results []Result) Report {
+	ok := true
+	for _, result := range results {
+		if result.Required && result.Status != StatusUp {
+			ok = false
+		}
+	}
+	return Report{OK: ok, Checks: results}
+}
@@ -0,0 +1,30 @@
+package journal
+
+// Conf configures the raw frame journal
+type Conf struct {
+	Enabled        bool     `json:"Enabled,optional" yaml:"Enabled"`
+	Dir            string   `json:"Dir,optional" yaml:"Dir"`
+	AllDevices     bool     `json:"AllDevices,optional" yaml:"AllDevices"`         // journal every connection, otherwise only Devices
+	Devices        []string `json:"Devices,optional" yaml:"Devices"`               // IMEIs journaled when AllDevices is off
+	MaxFileBytes   int64    `json:"MaxFileBytes,optional" yaml:"MaxFileBytes"`     // size at which the file is rotated
+	RotateInterval int      `json:"RotateInterval,optional" yaml:"RotateInterval"` // seconds after which the file is rotated
+	Compress       bool     `json:"Compress,optional" yaml:"Compress"`             // gzip rotated files
+	MaxBytes       int64    `json:"MaxBytes,optional" yaml:"MaxBytes"`             // total size kept, oldest files are deleted first
+	MaxAge         int      `json:"MaxAge,optional" yaml:"MaxAge"`                 // hours a file is kept, 0 keeps them until MaxBytes
+	Buffer         int      `json:"Buffer,optional" yaml:"Buffer"`                 // frames queued for the writer, further frames are dropped
+}
+
+// DefaultConf returns a Conf with default values
+func DefaultConf() Conf {
+	return Conf{
+		Enabled:        false,
+		Dir:            "data/journal",
+		AllDevices:     true,
+		MaxFileBytes:   64 << 20,
+		RotateInterval: 3600,
+		Compress:       true,
+		MaxBytes:       1 << 30,
+		MaxAge:         168,
+		Buffer:         4096,
+	}
+}
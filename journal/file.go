@@ -0,0 +1,303 @@
+package journal
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/logx"
+	"github.com/zeromicro/go-zero/core/threading"
+)
+
+const (
+	filePrefix     = "frames-"
+	fileExt        = ".ndjson"
+	compressedExt  = ".ndjson.gz"
+	fileTimeLayout = "20060102T150405.000Z"
+	fileSeqLimit   = 1000000 // the sequence number takes six digits
+
+	// maxOpenAttempts bounds the names tried when they are taken
+	maxOpenAttempts = 100
+
+	// pruneInterval bounds how often the age limit is enforced between rotations
+	pruneInterval = time.Minute
+)
+
+// writer appends frames to the current file, it is only used by the journal goroutine
+// except for the background compression of rotated files
+type writer struct {
+	conf Conf
+
+	file      *os.File
+	buf       *bufio.Writer
+	size      int64
+	openedAt  time.Time
+	lastPrune time.Time
+	active    atomic.Value // name of the current file, read by prune
+	seq       int          // of the last file opened, tells apart the files opened within a millisecond
+
+	// files serializes compression and pruning
+	files       sync.Mutex
+	compressing sync.WaitGroup
+}
+
+func newWriter(c Conf) (*writer, error) {
+	w := &writer{conf: c}
+	w.active.Store("")
+
+	// files left by a previous run, the last one was still being written
+	leftovers, err := filepath.Glob(filepath.Join(c.Dir, filePrefix+"*"+fileExt))
+	if err != nil {
+		return nil, err
+	}
+	w.finish(leftovers...)
+	return w, nil
+}
+
+// Files returns the journal files of dir, oldest first
+func Files(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && isJournalFile(name) {
+			files = append(files, filepath.Join(dir, name))
+		}
+	}
+	// names start with the time the file was opened followed by a sequence number
+	sort.Strings(files)
+	return files, nil
+}
+
+func isJournalFile(name string) bool {
+	return strings.HasPrefix(name, filePrefix) && (strings.HasSuffix(name, fileExt) || strings.HasSuffix(name, compressedExt))
+}
+
+func (w *writer) write(frame *Frame) error {
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return err
+		}
+	}
+
+	line, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	n, err := w.buf.Write(line)
+	w.size += int64(n)
+	if err != nil {
+		return err
+	}
+
+	if w.size >= w.conf.MaxFileBytes {
+		return w.rotate()
+	}
+	return nil
+}
+
+// tick flushes the buffered frames, rotates the file once it is RotateInterval old and applies the age limit
+func (w *writer) tick() error {
+	if w.file != nil {
+		if time.Since(w.openedAt) >= time.Duration(w.conf.RotateInterval)*time.Second {
+			if err := w.rotate(); err != nil {
+				return err
+			}
+		} else if err := w.buf.Flush(); err != nil {
+			return fmt.Errorf("failed to flush %s: %w", w.file.Name(), err)
+		}
+	}
+
+	if time.Since(w.lastPrune) >= pruneInterval {
+		w.lastPrune = time.Now()
+		if w.files.TryLock() {
+			w.prune()
+			w.files.Unlock()
+		}
+	}
+	return nil
+}
+
+// open creates the next file, named after the time it is opened and a sequence number. A name
+// already taken, by a file of a previous run, is skipped.
+func (w *writer) open() error {
+	now := time.Now()
+	for attempt := 0; ; attempt++ {
+		w.seq = (w.seq + 1) % fileSeqLimit
+		name := filepath.Join(w.conf.Dir, fmt.Sprintf("%s%s-%06d%s", filePrefix, now.UTC().Format(fileTimeLayout), w.seq, fileExt))
+		file, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if errors.Is(err, os.ErrExist) && attempt < maxOpenAttempts {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create journal file: %w", err)
+		}
+
+		w.file = file
+		w.active.Store(name)
+		w.buf = bufio.NewWriterSize(file, 64<<10)
+		w.size = 0
+		w.openedAt = now
+		return nil
+	}
+}
+
+// rotate closes the current file and compresses it in the background, the next frame opens a new one
+func (w *writer) rotate() error {
+	name, err := w.closeFile()
+	if err != nil {
+		return err
+	}
+	w.finish(name)
+	return nil
+}
+
+func (w *writer) closeFile() (string, error) {
+	if w.file == nil {
+		return "", nil
+	}
+
+	file, buf := w.file, w.buf
+	w.file, w.buf = nil, nil
+	w.active.Store("")
+	if err := buf.Flush(); err != nil {
+		file.Close()
+		return "", fmt.Errorf("failed to flush %s: %w", file.Name(), err)
+	}
+	if err := file.Close(); err != nil {
+		return "", fmt.Errorf("failed to close %s: %w", file.Name(), err)
+	}
+	return file.Name(), nil
+}
+
+// finish compresses the closed files when configured and applies the retention limits
+func (w *writer) finish(names ...string) {
+	w.compressing.Add(1)
+	threading.GoSafe(func() {
+		defer w.compressing.Done()
+
+		w.files.Lock()
+		defer w.files.Unlock()
+
+		if w.conf.Compress {
+			for _, name := range names {
+				if name == "" {
+					continue
+				}
+				if err := compress(name); err != nil {
+					logx.Errorf("[JOURNAL] Failed to compress %s: %v", name, err)
+				}
+			}
+		}
+		w.prune()
+	})
+}
+
+// close flushes the current file and waits for the pending compressions,
+// the current file is left uncompressed until the next start
+func (w *writer) close() error {
+	_, err := w.closeFile()
+	w.compressing.Wait()
+	return err
+}
+
+// prune deletes the oldest files beyond MaxBytes and the files older than MaxAge, the current file is kept
+func (w *writer) prune() {
+	files, err := Files(w.conf.Dir)
+	if err != nil {
+		logx.Errorf("[JOURNAL] Failed to list files: %v", err)
+		return
+	}
+
+	type entry struct {
+		name    string
+		size    int64
+		modTime time.Time
+	}
+
+	var total int64
+	entries := make([]entry, 0, len(files))
+	for _, name := range files {
+		info, err := os.Stat(name)
+		if err != nil {
+			continue
+		}
+		total += info.Size()
+		entries = append(entries, entry{name: name, size: info.Size(), modTime: info.ModTime()})
+	}
+
+	maxAge := time.Duration(w.conf.MaxAge) * time.Hour
+	for _, e := range entries {
+		expired := w.conf.MaxAge > 0 && time.Since(e.modTime) > maxAge
+		if total <= w.conf.MaxBytes && !expired {
+			continue
+		}
+		if e.name == w.active.Load().(string) {
+			continue
+		}
+
+		if err := os.Remove(e.name); err != nil {
+			logx.Errorf("[JOURNAL] Failed to delete %s: %v", e.name, err)
+			continue
+		}
+		total -= e.size
+		logx.Infof("[JOURNAL] Deleted %s", e.name)
+	}
+}
+
+// compress replaces name by its gzip, keeping its modification time for the age limit
+func compress(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	target := strings.TrimSuffix(name, fileExt) + compressedExt
+	tmp := target + ".tmp"
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, target); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	_ = os.Chtimes(target, info.ModTime(), info.ModTime())
+	return os.Remove(name)
+}
@@ -0,0 +1,224 @@
+// Package journal records the raw GT06 frames exchanged with the devices into rotating
+// newline-delimited JSON files, so that what a device actually sent can be inspected and replayed.
+package journal
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/logx"
+	"github.com/zeromicro/go-zero/core/threading"
+)
+
+const (
+	DirectionIn  = "in"  // sent by the device
+	DirectionOut = "out" // sent to the device
+)
+
+// Frame is one journaled frame, a line of the journal files
+type Frame struct {
+	Time         time.Time `json:"ts"`
+	Direction    string    `json:"dir"`
+	IMEI         string    `json:"imei,omitempty"` // empty before login
+	ConnectionID string    `json:"conn"`
+	Data         string    `json:"data"`            // hex
+	Error        string    `json:"error,omitempty"` // why the bytes failed validation, empty for valid frames
+}
+
+// Bytes decodes the frame data
+func (f *Frame) Bytes() ([]byte, error) {
+	return hex.DecodeString(f.Data)
+}
+
+// Journal writes frames in the background, recording never blocks the event loops
+type Journal struct {
+	conf Conf
+
+	mu      sync.RWMutex
+	devices map[string]struct{}
+
+	frames    chan Frame
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+
+	// owned by the writer goroutine
+	writer *writer
+}
+
+// Open opens the journal in c.Dir and starts the writer
+func Open(c Conf) (*Journal, error) {
+	if c.Dir == "" {
+		return nil, errors.New("journal directory is not configured")
+	}
+
+	defaults := DefaultConf()
+	if c.MaxFileBytes <= 0 {
+		c.MaxFileBytes = defaults.MaxFileBytes
+	}
+	if c.RotateInterval <= 0 {
+		c.RotateInterval = defaults.RotateInterval
+	}
+	if c.MaxBytes <= 0 {
+		c.MaxBytes = defaults.MaxBytes
+	}
+	if c.Buffer <= 0 {
+		c.Buffer = defaults.Buffer
+	}
+
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create journal directory: %w", err)
+	}
+
+	w, err := newWriter(c)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &Journal{
+		conf:    c,
+		devices: make(map[string]struct{}, len(c.Devices)),
+		frames:  make(chan Frame, c.Buffer),
+		done:    make(chan struct{}),
+		writer:  w,
+	}
+	for _, imei := range c.Devices {
+		j.devices[imei] = struct{}{}
+	}
+
+	j.wg.Add(1)
+	threading.GoSafe(j.run)
+
+	logx.Infof("[JOURNAL] Opened %s, all devices: %t, devices: %d", c.Dir, c.AllDevices, len(j.devices))
+	return j, nil
+}
+
+// AllDevices reports whether every connection is journaled
+func (j *Journal) AllDevices() bool {
+	return j.conf.AllDevices
+}
+
+// Enabled reports whether the frames of imei are journaled, imei is empty before login
+func (j *Journal) Enabled(imei string) bool {
+	if j == nil {
+		return false
+	}
+	if j.conf.AllDevices {
+		return true
+	}
+	if imei == "" {
+		return false
+	}
+
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	_, ok := j.devices[imei]
+	return ok
+}
+
+// EnableDevice journals the frames of imei from now on, it is not persisted across restarts
+func (j *Journal) EnableDevice(imei string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.devices[imei] = struct{}{}
+}
+
+// DisableDevice stops journaling imei, it reports whether it was enabled
+func (j *Journal) DisableDevice(imei string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	_, ok := j.devices[imei]
+	delete(j.devices, imei)
+	return ok
+}
+
+// Devices returns the IMEIs enabled one by one, sorted
+func (j *Journal) Devices() []string {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	devices := make([]string, 0, len(j.devices))
+	for imei := range j.devices {
+		devices = append(devices, imei)
+	}
+	sort.Strings(devices)
+	return devices
+}
+
+// Record queues a frame when its device is journaled. data is copied, the frame is dropped
+// when the writer falls behind. It is safe to call on a nil Journal and from any goroutine.
+func (j *Journal) Record(direction, imei, connectionID string, at time.Time, data []byte) {
+	j.record(Frame{Time: at, Direction: direction, IMEI: imei, ConnectionID: connectionID}, data)
+}
+
+// RecordInvalid queues received bytes that failed validation with the reason they were discarded
+func (j *Journal) RecordInvalid(imei, connectionID string, at time.Time, data []byte, reason string) {
+	j.record(Frame{Time: at, Direction: DirectionIn, IMEI: imei, ConnectionID: connectionID, Error: reason}, data)
+}
+
+func (j *Journal) record(frame Frame, data []byte) {
+	if !j.Enabled(frame.IMEI) {
+		return
+	}
+	frame.Data = hex.EncodeToString(data)
+
+	select {
+	case <-j.done:
+		metricDropped.Inc("closed")
+	case j.frames <- frame:
+	default:
+		metricDropped.Inc("full")
+	}
+}
+
+// Close writes the queued frames and closes the current file
+func (j *Journal) Close() error {
+	j.closeOnce.Do(func() {
+		close(j.done)
+	})
+	j.wg.Wait()
+	return j.writer.close()
+}
+
+// run writes the queued frames, flushing every second and rotating and pruning the files on schedule
+func (j *Journal) run() {
+	defer j.wg.Done()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case frame := <-j.frames:
+			j.write(frame)
+		case <-ticker.C:
+			if err := j.writer.tick(); err != nil {
+				logx.Errorf("[JOURNAL] %v", err)
+			}
+		case <-j.done:
+			for {
+				select {
+				case frame := <-j.frames:
+					j.write(frame)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (j *Journal) write(frame Frame) {
+	if err := j.writer.write(&frame); err != nil {
+		metricDropped.Inc("error")
+		logx.Errorf("[JOURNAL] Failed to write frame: %v", err)
+		return
+	}
+	metricFrames.Inc(frame.Direction)
+}
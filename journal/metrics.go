@@ -0,0 +1,23 @@
+package journal
+
+import "github.com/zeromicro/go-zero/core/metric"
+
+const metricNamespace = "gt06"
+
+var (
+	metricFrames = metric.NewCounterVec(&metric.CounterVecOpts{
+		Namespace: metricNamespace,
+		Subsystem: "journal",
+		Name:      "frames_total",
+		Help:      "Frames written to the journal by direction.",
+		Labels:    []string{"direction"},
+	})
+
+	metricDropped = metric.NewCounterVec(&metric.CounterVecOpts{
+		Namespace: metricNamespace,
+		Subsystem: "journal",
+		Name:      "dropped_total",
+		Help:      "Frames the journal could not write, by reason.",
+		Labels:    []string{"reason"},
+	})
+)
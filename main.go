@@ -2,19 +2,88 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"gt06/api"
+	"gt06/cmd/decode"
+	"gt06/cmd/replay"
+	"gt06/cmd/simulate"
+	"gt06/cmd/webhookreceiver"
 	"gt06/conf"
 	"gt06/config"
+	"gt06/metrics"
+	"gt06/services/svc"
 	"gt06/tcp"
+	"gt06/tracing"
+	"log"
+	"os"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/logx"
+	"github.com/zeromicro/go-zero/core/proc"
 )
 
 var configFile = flag.String("c", "etc/server.yaml", "the config file path")
 
+// subcommands run instead of the server when named by the first argument
+var subcommands = map[string]func(args []string) error{
+	"decode":           decode.Run,
+	"replay":           replay.Run,
+	"simulate":         simulate.Run,
+	"webhook-receiver": webhookreceiver.Run,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			if err := run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[1], err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	flag.Parse()
 
 	c := config.Default()
 	conf.MustLoad(*configFile, &c)
 
-	tcpServer := tcp.NewTCPServer(c.TCPServer)
-	tcpServer.Start(c)
+	if c.Metrics.Enabled {
+		metrics.Enable()
+	}
+
+	if err := tracing.Start(c.Tracing); err != nil {
+		logx.Errorf("Tracing is disabled: %v", err)
+	}
+
+	serviceContext := svc.NewServiceContext(c)
+	if serviceContext == nil {
+		log.Fatalf("Failed to create service context")
+	}
+	serviceContext.MarkAllOffline()
+
+	if c.API.Enabled {
+		apiServer := api.NewServer(c.API, serviceContext)
+		apiServer.Start()
+		proc.AddShutdownListener(apiServer.Stop)
+	}
+
+	tcpServer := tcp.NewTCPServer(c.TCPServer, serviceContext)
+
+	stallTimeout := time.Duration(serviceContext.Health.Conf().StallTimeout) * time.Second
+	serviceContext.Health.AddLiveness("event_loop", func() (any, error) {
+		return tcpServer.ProtocolHandler.EventLoopStatus(stallTimeout)
+	})
+	serviceContext.Health.AddReadiness("listener", c.Health.RequireListener, tcpServer.ProtocolHandler.ListenerStatus)
+
+	if c.Admin.Enabled {
+		adminServer := api.NewAdminServer(c.Admin, serviceContext, tcpServer.ProtocolHandler)
+		if c.Metrics.Enabled {
+			adminServer.Handle("GET "+c.Metrics.Path, metrics.Handler())
+		}
+		adminServer.Start()
+		proc.AddShutdownListener(adminServer.Stop)
+	}
+
+	tcpServer.Start()
 }
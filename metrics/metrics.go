@@ -0,0 +1,128 @@
+// Package metrics declares the Prometheus metrics of the server. They are only recorded
+// once Enable is called, so the instrumentation costs nothing when metrics are off.
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/zeromicro/go-zero/core/metric"
+	"github.com/zeromicro/go-zero/core/prometheus"
+)
+
+const namespace = "gt06"
+
+// latencyBuckets are in milliseconds
+var latencyBuckets = []float64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+var (
+	Connections = metric.NewCounterVec(&metric.CounterVecOpts{
+		Namespace: namespace,
+		Subsystem: "tcp",
+		Name:      "connections_total",
+		Help:      "GT06 connections accepted.",
+	})
+	ActiveConnections = metric.NewGaugeVec(&metric.GaugeVecOpts{
+		Namespace: namespace,
+		Subsystem: "tcp",
+		Name:      "active_connections",
+		Help:      "GT06 connections currently open.",
+	})
+	Packets = metric.NewCounterVec(&metric.CounterVecOpts{
+		Namespace: namespace,
+		Subsystem: "tcp",
+		Name:      "packets_total",
+		Help:      "Valid packets received by protocol number.",
+		Labels:    []string{"protocol"},
+	})
+	ParseFailures = metric.NewCounterVec(&metric.CounterVecOpts{
+		Namespace: namespace,
+		Subsystem: "tcp",
+		Name:      "parse_failures_total",
+		Help:      "Inbound data rejected by the framing checks, by reason.",
+		Labels:    []string{"reason"},
+	})
+	CRCErrors = metric.NewCounterVec(&metric.CounterVecOpts{
+		Namespace: namespace,
+		Subsystem: "tcp",
+		Name:      "crc_errors_total",
+		Help:      "Packets whose error check did not match.",
+	})
+	AckWriteFailures = metric.NewCounterVec(&metric.CounterVecOpts{
+		Namespace: namespace,
+		Subsystem: "tcp",
+		Name:      "ack_write_failures_total",
+		Help:      "Responses that could not be written to the connection.",
+	})
+	SessionsByModel = metric.NewGaugeVec(&metric.GaugeVecOpts{
+		Namespace: namespace,
+		Subsystem: "tcp",
+		Name:      "sessions",
+		Help:      "Logged in sessions by device model code.",
+		Labels:    []string{"model"},
+	})
+
+	Logins = metric.NewCounterVec(&metric.CounterVecOpts{
+		Namespace: namespace,
+		Subsystem: "services",
+		Name:      "logins_total",
+		Help:      "Device logins by result.",
+		Labels:    []string{"result"},
+	})
+	PacketFailures = metric.NewCounterVec(&metric.CounterVecOpts{
+		Namespace: namespace,
+		Subsystem: "services",
+		Name:      "packet_failures_total",
+		Help:      "Packets whose processing failed and closed the connection, by protocol number.",
+		Labels:    []string{"protocol"},
+	})
+
+	StorageDuration = metric.NewHistogramVec(&metric.HistogramVecOpts{
+		Namespace: namespace,
+		Subsystem: "storage",
+		Name:      "duration_ms",
+		Help:      "Storage call latency in milliseconds by operation.",
+		Labels:    []string{"operation"},
+		Buckets:   latencyBuckets,
+	})
+	StorageErrors = metric.NewCounterVec(&metric.CounterVecOpts{
+		Namespace: namespace,
+		Subsystem: "storage",
+		Name:      "errors_total",
+		Help:      "Failed storage calls by operation.",
+		Labels:    []string{"operation"},
+	})
+
+	QueueDepth = metric.NewGaugeVec(&metric.GaugeVecOpts{
+		Namespace: namespace,
+		Subsystem: "worker",
+		Name:      "queue_depth",
+		Help:      "Tasks or records waiting to be processed, by queue.",
+		Labels:    []string{"queue"},
+	})
+)
+
+// Enable starts recording the metrics
+func Enable() {
+	prometheus.Enable()
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ProtocolLabel is the protocol number label of a packet
+func ProtocolLabel(protocolNumber uint8) string {
+	return fmt.Sprintf("0x%02X", protocolNumber)
+}
+
+// ObserveStorage records the latency and outcome of a storage call started at start
+func ObserveStorage(operation string, start time.Time, err error) {
+	StorageDuration.ObserveFloat(float64(time.Since(start).Microseconds())/1000, operation)
+	if err != nil {
+		StorageErrors.Inc(operation)
+	}
+}
@@ -0,0 +1,32 @@
+package protocol
+
+import "fmt"
+
+// Alarm types, the high byte of the alarm and language word of the alarm packets
+const (
+	AlarmNormal     = 0x00
+	AlarmSOS        = 0x01
+	AlarmPowerCut   = 0x02
+	AlarmVibration  = 0x03
+	AlarmEnterFence = 0x04
+	AlarmExitFence  = 0x05
+	AlarmOverspeed  = 0x06
+)
+
+var alarmTypeNames = map[uint8]string{
+	AlarmNormal:     "normal",
+	AlarmSOS:        "sos",
+	AlarmPowerCut:   "power_cut",
+	AlarmVibration:  "vibration",
+	AlarmEnterFence: "enter_fence",
+	AlarmExitFence:  "exit_fence",
+	AlarmOverspeed:  "overspeed",
+}
+
+// AlarmTypeName returns a stable name of an alarm type for payloads and filters, such as power_cut
+func AlarmTypeName(alarm uint8) string {
+	if name, ok := alarmTypeNames[alarm]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02X", alarm)
+}
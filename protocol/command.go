@@ -0,0 +1,120 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	// ProtocolOnlineCommand is sent by the server to run a text command on the terminal
+	ProtocolOnlineCommand = 0x80
+	// ProtocolCommandReply carries the text reply of the terminal to an online command
+	ProtocolCommandReply = 0x15
+	// ProtocolCommandReplyAlt is the reply of firmwares that report the text encoding instead of the language
+	ProtocolCommandReplyAlt = 0x21
+
+	// CommandLanguageEnglish is the language field of an online command
+	CommandLanguageEnglish = 0x0002
+
+	replyEncodingASCII = 0x01
+	replyEncodingUTF16 = 0x02
+
+	// MaxCommandLength is the longest command that fits the one byte packet length
+	MaxCommandLength = 0xFF - 12
+)
+
+// CONCOXCommandReply is the text a terminal answered to an online command
+type CONCOXCommandReply struct {
+	// ServerFlag echoes the flag of the online command being answered
+	ServerFlag uint32
+	Content    string
+}
+
+// Info returns the information content of the packet, without the unused part of the buffer
+func (p *CONCOXPacket) Info() []byte {
+	length := int(p.PacketLength) - 5
+	if length < 0 {
+		length = 0
+	}
+	return p.InfoContent[:length]
+}
+
+// BuildCONCOXOnlineCommand builds an online command packet. The terminal echoes serverFlag in
+// its reply so that the reply can be matched to the command.
+func BuildCONCOXOnlineCommand(serverFlag uint32, command string, serial uint16) ([]byte, error) {
+	if command == "" {
+		return nil, fmt.Errorf("empty command")
+	}
+	if len(command) > MaxCommandLength {
+		return nil, fmt.Errorf("command is %d bytes long, at most %d fit in a packet", len(command), MaxCommandLength)
+	}
+	for i := 0; i < len(command); i++ {
+		if command[i] < 0x20 || command[i] > 0x7E {
+			return nil, fmt.Errorf("command has a non printable ASCII byte 0x%02X at offset %d", command[i], i)
+		}
+	}
+
+	packet := make([]byte, 0, len(command)+17)
+	packet = append(packet, PacketStartBit, PacketStartBit)
+	packet = append(packet, byte(len(command)+12)) // Packet Length
+	packet = append(packet, ProtocolOnlineCommand) // Protocol Number
+	packet = append(packet, byte(len(command)+4))  // Length of Command: server flag + command
+	packet = binary.BigEndian.AppendUint32(packet, serverFlag)
+	packet = append(packet, command...)
+	packet = binary.BigEndian.AppendUint16(packet, CommandLanguageEnglish)
+	packet = binary.BigEndian.AppendUint16(packet, serial)
+
+	crc := calculateCRC(packet[2:])
+	packet = binary.BigEndian.AppendUint16(packet, crc)
+	packet = append(packet, PacketStopBit0, PacketStopBit1)
+
+	return packet, nil
+}
+
+// ParseCONCOXCommandReply parses the information content of a ProtocolCommandReply or
+// ProtocolCommandReplyAlt packet
+func ParseCONCOXCommandReply(protocolNumber uint8, buffer []byte) (*CONCOXCommandReply, error) {
+	reply := &CONCOXCommandReply{}
+
+	switch protocolNumber {
+	case ProtocolCommandReply:
+		// length(1) + server flag(4) + content + language(2)
+		if len(buffer) < 5 {
+			return nil, fmt.Errorf("buffer too small for command reply: %d bytes", len(buffer))
+		}
+		length := int(buffer[0])
+		if length < 4 || 1+length > len(buffer) {
+			return nil, fmt.Errorf("invalid command reply length %d for %d bytes", length, len(buffer))
+		}
+		reply.ServerFlag = binary.BigEndian.Uint32(buffer[1:5])
+		reply.Content = string(buffer[5 : 1+length])
+
+	case ProtocolCommandReplyAlt:
+		// server flag(4) + encoding(1) + content
+		if len(buffer) < 5 {
+			return nil, fmt.Errorf("buffer too small for command reply: %d bytes", len(buffer))
+		}
+		reply.ServerFlag = binary.BigEndian.Uint32(buffer[:4])
+		switch buffer[4] {
+		case replyEncodingASCII:
+			reply.Content = string(buffer[5:])
+		case replyEncodingUTF16:
+			content := buffer[5:]
+			units := make([]uint16, len(content)/2)
+			for i := range units {
+				units[i] = binary.BigEndian.Uint16(content[2*i:])
+			}
+			reply.Content = string(utf16.Decode(units))
+		default:
+			return nil, fmt.Errorf("unsupported command reply encoding 0x%02X", buffer[4])
+		}
+
+	default:
+		return nil, fmt.Errorf("protocol 0x%02X is not a command reply", protocolNumber)
+	}
+
+	reply.Content = strings.TrimRight(reply.Content, "\x00")
+	return reply, nil
+}
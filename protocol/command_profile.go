@@ -0,0 +1,70 @@
+package protocol
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CommandProfile renders text commands for the firmware of some device models. Syntax overrides
+// a command by name, or by name and first argument such as "RELAY,1", with a template where
+// {0}, {1}, ... are replaced by the arguments. Commands without an override use NAME,ARGS#.
+type CommandProfile struct {
+	Name   string            `json:"Name" yaml:"Name"`
+	Models []string          `json:"Models" yaml:"Models"` // model codes of the login packet, in hex such as "0242"
+	Syntax map[string]string `json:"Syntax,optional" yaml:"Syntax"`
+}
+
+// DefaultCommandProfile renders the standard GT06 syntax
+var DefaultCommandProfile = &CommandProfile{Name: "default"}
+
+var (
+	profilesMu sync.RWMutex
+	profiles   = map[string]*CommandProfile{}
+)
+
+// RegisterCommandProfile makes p the profile of its models, replacing any previous one
+func RegisterCommandProfile(p *CommandProfile) {
+	profilesMu.Lock()
+	defer profilesMu.Unlock()
+
+	for _, model := range p.Models {
+		profiles[strings.ToLower(model)] = p
+	}
+}
+
+// CommandProfileFor returns the profile of a model code, DefaultCommandProfile when none is registered
+func CommandProfileFor(modelCode string) *CommandProfile {
+	profilesMu.RLock()
+	defer profilesMu.RUnlock()
+
+	if p, ok := profiles[strings.ToLower(modelCode)]; ok {
+		return p
+	}
+	return DefaultCommandProfile
+}
+
+// Format renders c in the syntax of the profile
+func (p *CommandProfile) Format(c TextCommand) string {
+	if template, ok := p.override(c); ok {
+		for i, arg := range c.Args {
+			template = strings.ReplaceAll(template, "{"+strconv.Itoa(i)+"}", arg)
+		}
+		return template
+	}
+
+	if len(c.Args) == 0 {
+		return c.Name + "#"
+	}
+	return c.Name + "," + strings.Join(c.Args, ",") + "#"
+}
+
+func (p *CommandProfile) override(c TextCommand) (string, bool) {
+	if len(c.Args) > 0 {
+		if template, ok := p.Syntax[c.Name+","+c.Args[0]]; ok {
+			return template, true
+		}
+	}
+	template, ok := p.Syntax[c.Name]
+	return template, ok
+}
@@ -0,0 +1,260 @@
+package protocol
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StatusReply is the answer to STATUS#, such as
+// Battery:Normal;GPRS:Link Up;GSM Signal Level:Strong;GPS:Successful positioning;ACC:OFF;Defense:OFF
+type StatusReply struct {
+	Battery   string            `json:"battery,omitempty"`
+	GPRS      string            `json:"gprs,omitempty"`
+	GSMSignal string            `json:"gsm_signal,omitempty"`
+	GPS       string            `json:"gps,omitempty"`
+	ACC       *bool             `json:"acc,omitempty"`
+	Defense   *bool             `json:"defense,omitempty"`
+	Fields    map[string]string `json:"fields"` // every field by its lower case name
+}
+
+// ParamReply is the answer to PARAM#, such as IMEI:868120145233604;TIMER:10,180;SOS:,,;CENTER:;TIMEZONE:E,8,0
+type ParamReply struct {
+	IMEI     string            `json:"imei,omitempty"`
+	TimerOn  *int              `json:"timer_on,omitempty"`  // seconds between uploads with ACC on
+	TimerOff *int              `json:"timer_off,omitempty"` // seconds between uploads with ACC off
+	SOS      []string          `json:"sos,omitempty"`
+	Center   string            `json:"center,omitempty"`
+	TimeZone string            `json:"time_zone,omitempty"`
+	Fields   map[string]string `json:"fields"`
+}
+
+// VersionReply is the answer to VERSION#, such as [VERSION]GT06N_20_60DC_B25E_V14
+type VersionReply struct {
+	Version string `json:"version"`
+}
+
+// WhereReply is the answer to WHERE#, either
+// Lat:N23.111111,Lon:E114.409000,Course:0.00,Speed:0.00km/h,DateTime:2012-04-27 10:10:12
+// or a map link such as http://maps.google.com/maps?q=N22.5,E113.9
+type WhereReply struct {
+	Latitude  float64    `json:"latitude"`
+	Longitude float64    `json:"longitude"`
+	Course    *float64   `json:"course,omitempty"`
+	Speed     *float64   `json:"speed,omitempty"` // km/h
+	DateTime  *time.Time `json:"date_time,omitempty"`
+}
+
+var (
+	wherePattern    = regexp.MustCompile(`q=([NS]?-?[0-9.]+),([EW]?-?[0-9.]+)`)
+	leadingNumber   = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?`)
+	whereTimeLayout = "2006-01-02 15:04:05"
+)
+
+// TextCommandName returns the upper case name of a text command, STATUS for STATUS#
+func TextCommandName(command string) string {
+	name := command
+	if i := strings.IndexAny(name, ",#"); i >= 0 {
+		name = name[:i]
+	}
+	return strings.ToUpper(strings.TrimSpace(name))
+}
+
+// ParseTextReply parses the reply to a STATUS, PARAM, VERSION or WHERE command. It returns nil
+// without an error for the replies of other commands, which are plain text.
+func ParseTextReply(command, reply string) (any, error) {
+	switch TextCommandName(command) {
+	case CommandStatus:
+		return ParseStatusReply(reply)
+	case CommandParam:
+		return ParseParamReply(reply)
+	case CommandVersion:
+		return ParseVersionReply(reply)
+	case CommandWhere:
+		return ParseWhereReply(reply)
+	}
+	return nil, nil
+}
+
+// ParseStatusReply parses the answer to STATUS#
+func ParseStatusReply(reply string) (*StatusReply, error) {
+	fields, err := replyFields(reply)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &StatusReply{Fields: fields}
+	for key, value := range fields {
+		switch {
+		case key == "battery":
+			status.Battery = value
+		case key == "gprs":
+			status.GPRS = value
+		case strings.HasPrefix(key, "gsm"):
+			status.GSMSignal = value
+		case key == "gps":
+			status.GPS = value
+		case key == "acc":
+			status.ACC = onOff(value)
+		case key == "defense" || key == "defence":
+			status.Defense = onOff(value)
+		}
+	}
+	return status, nil
+}
+
+// ParseParamReply parses the answer to PARAM#
+func ParseParamReply(reply string) (*ParamReply, error) {
+	fields, err := replyFields(reply)
+	if err != nil {
+		return nil, err
+	}
+
+	param := &ParamReply{Fields: fields}
+	for key, value := range fields {
+		switch key {
+		case "imei":
+			param.IMEI = value
+		case "timer":
+			parts := strings.Split(value, ",")
+			if on, err := strconv.Atoi(strings.TrimSpace(parts[0])); err == nil {
+				param.TimerOn = &on
+			}
+			if len(parts) > 1 {
+				if off, err := strconv.Atoi(strings.TrimSpace(parts[1])); err == nil {
+					param.TimerOff = &off
+				}
+			}
+		case "sos":
+			for _, number := range strings.Split(value, ",") {
+				if number = strings.TrimSpace(number); number != "" {
+					param.SOS = append(param.SOS, number)
+				}
+			}
+		case "center":
+			param.Center = value
+		case "timezone":
+			param.TimeZone = value
+		}
+	}
+	return param, nil
+}
+
+// ParseVersionReply parses the answer to VERSION#
+func ParseVersionReply(reply string) (*VersionReply, error) {
+	version := strings.TrimSpace(reply)
+	for _, prefix := range []string{"[VERSION]", "VERSION:", "Version:"} {
+		version = strings.TrimSpace(strings.TrimPrefix(version, prefix))
+	}
+	if version == "" {
+		return nil, fmt.Errorf("empty version reply")
+	}
+	return &VersionReply{Version: version}, nil
+}
+
+// ParseWhereReply parses the answer to WHERE#
+func ParseWhereReply(reply string) (*WhereReply, error) {
+	if m := wherePattern.FindStringSubmatch(reply); m != nil {
+		latitude, err := parseCoordinate(m[1], "N", "S")
+		if err != nil {
+			return nil, err
+		}
+		longitude, err := parseCoordinate(m[2], "E", "W")
+		if err != nil {
+			return nil, err
+		}
+		return &WhereReply{Latitude: latitude, Longitude: longitude}, nil
+	}
+
+	where := &WhereReply{}
+	var hasLat, hasLon bool
+	for _, field := range strings.Split(reply, ",") {
+		key, value, ok := strings.Cut(field, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		var err error
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "lat":
+			where.Latitude, err = parseCoordinate(value, "N", "S")
+			hasLat = err == nil
+		case "lon", "lng":
+			where.Longitude, err = parseCoordinate(value, "E", "W")
+			hasLon = err == nil
+		case "course":
+			where.Course = parseLeadingNumber(value)
+		case "speed":
+			where.Speed = parseLeadingNumber(value)
+		case "datetime":
+			if t, err := time.Parse(whereTimeLayout, value); err == nil {
+				where.DateTime = &t
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !hasLat || !hasLon {
+		return nil, fmt.Errorf("no position in reply %q", reply)
+	}
+	return where, nil
+}
+
+// replyFields splits Key:Value;Key:Value replies, keys are lower cased
+func replyFields(reply string) (map[string]string, error) {
+	fields := make(map[string]string)
+	for _, field := range strings.FieldsFunc(reply, func(r rune) bool { return r == ';' || r == '\n' || r == '\r' }) {
+		key, value, ok := strings.Cut(field, ":")
+		if !ok {
+			continue
+		}
+		fields[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+	}
+
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no fields in reply %q", reply)
+	}
+	return fields, nil
+}
+
+// parseCoordinate parses N23.111111, S23.111111 or -23.111111
+func parseCoordinate(v, positive, negative string) (float64, error) {
+	sign := 1.0
+	switch {
+	case strings.HasPrefix(v, positive):
+		v = v[len(positive):]
+	case strings.HasPrefix(v, negative):
+		v, sign = v[len(negative):], -1
+	}
+
+	f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid coordinate %q", v)
+	}
+	return sign * f, nil
+}
+
+func parseLeadingNumber(v string) *float64 {
+	f, err := strconv.ParseFloat(leadingNumber.FindString(v), 64)
+	if err != nil {
+		return nil
+	}
+	return &f
+}
+
+func onOff(v string) *bool {
+	switch strings.ToUpper(v) {
+	case "ON", "1":
+		on := true
+		return &on
+	case "OFF", "0":
+		off := false
+		return &off
+	}
+	return nil
+}
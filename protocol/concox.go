@@ -2,8 +2,10 @@ package protocol
 
 import (
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"strings"
 )
 
 const (
@@ -25,6 +27,15 @@ const (
 	ProtocolAlarm         = 0x26
 )
 
+// Framing errors of ParseAndValidatePacket
+var (
+	ErrShortPacket      = errors.New("buffer too small")
+	ErrInvalidStartBits = errors.New("invalid start bits")
+	ErrInvalidLength    = errors.New("invalid packet length")
+	ErrInvalidStopBits  = errors.New("invalid stop bits")
+	ErrCRCMismatch      = errors.New("CRC mismatch")
+)
+
 var crcTable = [256]uint16{
 	0x0000, 0x1189, 0x2312, 0x329B, 0x4624, 0x57AD, 0x6536, 0x74BF,
 	0x8C48, 0x9DC1, 0xAF5A, 0xBED3, 0xCA6C, 0xDBE5, 0xE97E, 0xF8F7,
@@ -125,6 +136,28 @@ type CONCOXAlarmInfoContent struct {
 	Mileage           uint32
 }
 
+// ProtocolName returns a stable name of a protocol number for logs and statistics
+func ProtocolName(protocolNumber uint8) string {
+	switch protocolNumber {
+	case ProtocolLogin:
+		return "login"
+	case ProtocolHeartbeat, ProtocolHeartbeatAlt:
+		return "heartbeat"
+	case ProtocolLocation:
+		return "location"
+	case ProtocolLocationUTC:
+		return "location_utc"
+	case ProtocolAlarm:
+		return "alarm"
+	case ProtocolCommandReply, ProtocolCommandReplyAlt:
+		return "command_reply"
+	case ProtocolOnlineCommand:
+		return "online_command"
+	default:
+		return fmt.Sprintf("0x%02X", protocolNumber)
+	}
+}
+
 func calculateCRC(data []byte) uint16 {
 	crc := uint16(0xFFFF)
 
@@ -138,14 +171,14 @@ func calculateCRC(data []byte) uint16 {
 func ParseAndValidatePacket(buffer []byte) (*CONCOXPacket, error) {
 
 	if len(buffer) < 10 {
-		return nil, fmt.Errorf("buffer too small for packet: %d", len(buffer))
+		return nil, fmt.Errorf("%w: buffer too small for packet: %d", ErrShortPacket, len(buffer))
 	}
 
 	packet := &CONCOXPacket{}
 
 	copy(packet.StartBit[:], buffer[:2])
 	if packet.StartBit[0] != PacketStartBit || packet.StartBit[1] != PacketStartBit {
-		return nil, errors.New("invalid start bits")
+		return nil, ErrInvalidStartBits
 	}
 
 	packet.PacketLength = buffer[2]
@@ -153,16 +186,16 @@ func ParseAndValidatePacket(buffer []byte) (*CONCOXPacket, error) {
 
 	infoLength := int(packet.PacketLength) - 5
 	if infoLength < 0 {
-		return nil, fmt.Errorf("invalid packet length: %d", packet.PacketLength)
+		return nil, fmt.Errorf("%w: %d", ErrInvalidLength, packet.PacketLength)
 	}
 	if infoLength > MAX_INFO_CONTENT {
-		return nil, errors.New("info content exceeds maximum size")
+		return nil, fmt.Errorf("%w: info content exceeds maximum size", ErrInvalidLength)
 	}
 
 	// Total packet size: header(2) + length(1) + protocol(1) + info(infoLength) + serial(2) + crc(2) + stop(2)
 	totalPacketSize := 10 + infoLength
 	if len(buffer) < totalPacketSize {
-		return nil, fmt.Errorf("buffer too small: expected at least %d bytes, got %d", totalPacketSize, len(buffer))
+		return nil, fmt.Errorf("%w: expected at least %d bytes, got %d", ErrShortPacket, totalPacketSize, len(buffer))
 	}
 
 	copy(packet.InfoContent[:infoLength], buffer[4:4+infoLength])
@@ -173,12 +206,12 @@ func ParseAndValidatePacket(buffer []byte) (*CONCOXPacket, error) {
 
 	copy(packet.StopBit[:], buffer[8+infoLength:10+infoLength])
 	if packet.StopBit[0] != PacketStopBit0 || packet.StopBit[1] != PacketStopBit1 {
-		return nil, errors.New("invalid stop bits")
+		return nil, ErrInvalidStopBits
 	}
 
 	calculatedCRC := calculateCRC(buffer[2 : 2+packet.PacketLength-1])
 	if calculatedCRC != packet.ErrorCheck {
-		return nil, fmt.Errorf("CRC mismatch: expected 0x%04X got 0x%04X", packet.ErrorCheck, calculatedCRC)
+		return nil, fmt.Errorf("%w: expected 0x%04X got 0x%04X", ErrCRCMismatch, packet.ErrorCheck, calculatedCRC)
 	}
 
 	return packet, nil
@@ -198,6 +231,11 @@ func ParseCONCOXLoginInfoContent(buffer []byte) (*CONCOXLoginInfoContent, error)
 	return loginInfo, nil
 }
 
+// IMEI decodes the BCD terminal ID, dropping the padding nibble in front of the 15 digit IMEI
+func (l *CONCOXLoginInfoContent) IMEI() string {
+	return strings.TrimPrefix(hex.EncodeToString(l.TerminalID[:]), "0")
+}
+
 func ParseCONCOXAlarmInfoContent(buffer []byte) (*CONCOXAlarmInfoContent, error) {
 	// Minimum size required for Alarm Info Packet
 	if len(buffer) < 36 {
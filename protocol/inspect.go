@@ -0,0 +1,732 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// PacketStartBitLong starts the frames whose packet length takes two bytes
+const PacketStartBitLong = 0x79
+
+// InspectedFrame is a frame decoded field by field. Offsets count from the start of the inspected data.
+type InspectedFrame struct {
+	Offset         int            `json:"offset"`
+	Length         int            `json:"length"`
+	Data           string         `json:"data"`
+	Protocol       string         `json:"protocol,omitempty"`
+	ProtocolNumber string         `json:"protocol_number,omitempty"`
+	Serial         *uint16        `json:"serial,omitempty"`
+	Valid          bool           `json:"valid"`
+	Fields         []Field        `json:"fields,omitempty"`
+	Errors         []InspectError `json:"errors,omitempty"`
+}
+
+// Field is a decoded field of a frame, or a bit field of one when Bits is set
+type Field struct {
+	Name   string  `json:"name"`
+	Offset int     `json:"offset"`
+	Length int     `json:"length,omitempty"` // bytes
+	Bits   string  `json:"bits,omitempty"`   // such as 7 or 5-3
+	Raw    string  `json:"raw"`              // hex of the bytes, binary of the bits
+	Value  any     `json:"value,omitempty"`
+	Text   string  `json:"text,omitempty"` // meaning of the value
+	Fields []Field `json:"fields,omitempty"`
+}
+
+// InspectError is a framing or encoding problem at a byte offset
+type InspectError struct {
+	Offset  int    `json:"offset"`
+	Message string `json:"message"`
+}
+
+func (e InspectError) Error() string {
+	return fmt.Sprintf("offset %d: %s", e.Offset, e.Message)
+}
+
+// Err returns the first problem of the frame, nil when it is valid
+func (f *InspectedFrame) Err() error {
+	if len(f.Errors) == 0 {
+		return nil
+	}
+	return f.Errors[0]
+}
+
+// Content returns the values of the information content by field name, bit fields nested under their field
+func (f *InspectedFrame) Content() map[string]any {
+	for _, field := range f.Fields {
+		if field.Name == "information_content" {
+			return fieldValues(field.Fields)
+		}
+	}
+	return nil
+}
+
+func fieldValues(fields []Field) map[string]any {
+	values := make(map[string]any, len(fields))
+	for _, field := range fields {
+		if len(field.Fields) == 0 {
+			values[field.Name] = field.Value
+			continue
+		}
+		nested := fieldValues(field.Fields)
+		nested["value"] = field.Value
+		values[field.Name] = nested
+	}
+	return values
+}
+
+func (f *InspectedFrame) errorf(offset int, format string, args ...any) {
+	f.Errors = append(f.Errors, InspectError{Offset: offset, Message: fmt.Sprintf(format, args...)})
+}
+
+// Inspect splits data into frames and decodes every field of each, reporting the problems
+// of the framing, the error check and the information content with their offsets. Bytes
+// outside of a frame are reported as a frame of their own.
+func Inspect(data []byte) []*InspectedFrame {
+	var frames []*InspectedFrame
+	for offset := 0; offset < len(data); {
+		start := nextStart(data, offset)
+		if start > offset {
+			f := &InspectedFrame{Offset: offset, Length: start - offset, Data: hex.EncodeToString(data[offset:start])}
+			f.errorf(offset, "%d bytes without start bits", start-offset)
+			frames = append(frames, f)
+		}
+		if start == len(data) {
+			break
+		}
+
+		f := inspectFrame(data, start)
+		frames = append(frames, f)
+		offset = start + f.Length
+	}
+	return frames
+}
+
+// nextStart returns the offset of the next start bits at or after offset, len(data) without any
+func nextStart(data []byte, offset int) int {
+	for i := offset; i+1 < len(data); i++ {
+		if data[i] == data[i+1] && (data[i] == PacketStartBit || data[i] == PacketStartBitLong) {
+			return i
+		}
+	}
+	return len(data)
+}
+
+// frameEnd looks for the stop bits of a frame whose packet length is wrong: the first ones
+// at the end of data or before other start bits
+func frameEnd(data []byte, from int) (int, bool) {
+	for i := from; i+1 < len(data); i++ {
+		if data[i] != PacketStopBit0 || data[i+1] != PacketStopBit1 {
+			continue
+		}
+		if end := i + 2; end == len(data) || nextStart(data, end) == end {
+			return end, true
+		}
+	}
+	return 0, false
+}
+
+func inspectFrame(data []byte, start int) *InspectedFrame {
+	f := &InspectedFrame{Offset: start}
+	r := &fieldReader{data: data, frame: f, offset: start, end: len(data), fields: &f.Fields}
+
+	startBits, _ := r.take("start_bits", 2, nil)
+	lengthBytes := 1
+	if startBits[0] == PacketStartBitLong {
+		lengthBytes = 2
+	}
+
+	var length int
+	if b, ok := r.take("packet_length", lengthBytes, func(b []byte, field *Field) error {
+		field.Value = beUint(b)
+		field.Text = "bytes from the protocol number to the error check"
+		return nil
+	}); ok {
+		length = int(beUint(b))
+	}
+
+	// the frame ends where its length says, or at its stop bits when they are elsewhere
+	header := start + 2 + lengthBytes
+	end := header + length + 2
+	truncated := r.truncated
+	switch {
+	case truncated:
+		end = len(data)
+	case length >= 5 && end <= len(data) && data[end-2] == PacketStopBit0 && data[end-1] == PacketStopBit1:
+	default:
+		if actual, found := frameEnd(data, header+5); found {
+			f.errorf(start+2, "packet length %d does not match the %d bytes before the stop bits at offset %d", length, actual-2-header, actual-2)
+			end = actual
+		} else if length < 5 || end > len(data) {
+			f.errorf(start+2, "packet length %d needs a %d byte frame, %d bytes are left", length, max(end-start, 0), len(data)-start)
+			end = len(data)
+			truncated = true
+		}
+	}
+	f.Length = end - start
+	f.Data = hex.EncodeToString(data[start:end])
+
+	// the trailer of a truncated frame is missing, its bytes are decoded as the information content
+	trailer := 6
+	if truncated {
+		trailer = 0
+	}
+	r.end = end - trailer
+	if n, ok := r.take("protocol_number", 1, func(b []byte, field *Field) error {
+		field.Value = fmt.Sprintf("0x%02X", b[0])
+		field.Text = ProtocolName(b[0])
+		return nil
+	}); ok {
+		f.ProtocolNumber = fmt.Sprintf("0x%02X", n[0])
+		f.Protocol = ProtocolName(n[0])
+
+		field := Field{Name: "information_content", Offset: r.offset, Length: r.end - r.offset, Raw: hex.EncodeToString(data[r.offset:r.end])}
+		info := r.region(r.end)
+		info.fields = &field.Fields
+		if !inspectContent(info, n[0]) {
+			field.Text = "not decoded, unknown protocol"
+		}
+		if field.Length > 0 {
+			*r.fields = append(*r.fields, field)
+		}
+		r.offset = r.end
+	}
+	if truncated {
+		f.Valid = false
+		return f
+	}
+
+	r.end = end
+	if b, ok := r.take("serial_number", 2, uintField); ok {
+		serial := binary.BigEndian.Uint16(b)
+		f.Serial = &serial
+	}
+	r.take("error_check", 2, func(b []byte, field *Field) error {
+		expected := beUint(b)
+		field.Value = fmt.Sprintf("0x%04X", expected)
+		crc := calculateCRC(data[start+2 : field.Offset])
+		if uint64(crc) != expected {
+			field.Text = fmt.Sprintf("mismatch, the CRC is 0x%04X", crc)
+			return fmt.Errorf("0x%04X does not match the CRC 0x%04X", expected, crc)
+		}
+		field.Text = "valid"
+		return nil
+	})
+	r.take("stop_bits", 2, func(b []byte, field *Field) error {
+		if b[0] != PacketStopBit0 || b[1] != PacketStopBit1 {
+			return fmt.Errorf("invalid stop bits %s", field.Raw)
+		}
+		return nil
+	})
+
+	f.Valid = len(f.Errors) == 0
+	return f
+}
+
+// inspectContent decodes the information content of the protocols it knows
+func inspectContent(r *fieldReader, protocolNumber uint8) bool {
+	switch protocolNumber {
+	case ProtocolLogin:
+		inspectLogin(r)
+	case ProtocolHeartbeat, ProtocolHeartbeatAlt:
+		inspectHeartbeat(r)
+	case ProtocolLocation, ProtocolLocationUTC:
+		inspectLocation(r)
+	case ProtocolAlarm:
+		inspectAlarm(r)
+	case ProtocolCommandReply:
+		inspectTextCommand(r, "content")
+	case ProtocolOnlineCommand:
+		inspectTextCommand(r, "command")
+	case ProtocolCommandReplyAlt:
+		inspectCommandReplyAlt(r)
+	default:
+		return false
+	}
+
+	if !r.truncated && r.offset < r.end {
+		r.take("extra", r.end-r.offset, func(b []byte, field *Field) error {
+			field.Text = "not decoded"
+			return nil
+		})
+	}
+	return true
+}
+
+func inspectLogin(r *fieldReader) {
+	r.take("terminal_id", 8, func(b []byte, field *Field) error {
+		digits := hex.EncodeToString(b)
+		for i := 0; i < len(digits); i++ {
+			if digits[i] > '9' {
+				return fmt.Errorf("terminal ID has the non decimal digit %c at offset %d", digits[i], field.Offset+i/2)
+			}
+		}
+		field.Value = strings.TrimPrefix(digits, "0")
+		field.Text = "IMEI"
+		return nil
+	})
+	r.take("model_code", 2, hexField)
+	r.take("time_zone_language", 2, func(b []byte, field *Field) error {
+		word := beUint(b)
+		field.Value = word
+
+		zone := int(word >> 4)
+		sign := "+"
+		if word&0x08 != 0 {
+			sign = "-"
+		}
+		language := languageName(word & 0x03)
+		field.Text = fmt.Sprintf("GMT%s%d:%02d, %s", sign, zone/100, zone%100, language)
+		field.Fields = []Field{
+			bitField(field, 15, 4, uint64(zone), fmt.Sprintf("%d:%02d hours", zone/100, zone%100), "time_zone"),
+			flagField(field, word, 3, "west", "west of Greenwich", "east of Greenwich"),
+			bitField(field, 1, 0, word&0x03, language, "language"),
+		}
+		if zone%100 >= 60 || zone > 1400 {
+			return fmt.Errorf("invalid time zone %d:%02d", zone/100, zone%100)
+		}
+		return nil
+	})
+}
+
+// languageName names the language bits of the login, as the login service reads them
+func languageName(bits uint64) string {
+	switch bits {
+	case 0x1:
+		return "English"
+	case 0x2:
+		return "Chinese"
+	default:
+		return "unknown language"
+	}
+}
+
+func inspectHeartbeat(r *fieldReader) {
+	r.take("terminal_info", 1, terminalInfoField)
+	r.take("external_voltage", 2, func(b []byte, field *Field) error {
+		field.Value = beUint(b)
+		field.Text = fmt.Sprintf("%.2f V", float64(beUint(b))/100)
+		return nil
+	})
+	r.take("battery_voltage_level", 1, voltageLevelField)
+	r.take("gsm_signal_strength", 1, gsmSignalField)
+	r.take("language", 2, func(b []byte, field *Field) error {
+		field.Value = beUint(b)
+		field.Text = alarmLanguageName(b[1])
+		return nil
+	})
+}
+
+func inspectLocation(r *fieldReader) {
+	inspectGPS(r)
+	r.take("mcc", 2, uintField)
+	r.take("mnc", 1, uintField)
+	r.take("lac", 2, uintField)
+	r.take("cell_id", 3, uintField)
+	r.take("acc", 1, func(b []byte, field *Field) error {
+		field.Value = b[0]
+		field.Text = map[bool]string{true: "high", false: "low"}[b[0] != 0]
+		return nil
+	})
+	r.take("upload_mode", 1, func(b []byte, field *Field) error {
+		field.Value = b[0]
+		field.Text = uploadModeName(b[0])
+		return nil
+	})
+	r.take("gps_real_time_reupload", 1, func(b []byte, field *Field) error {
+		field.Value = b[0]
+		field.Text = map[bool]string{true: "re-upload", false: "real time"}[b[0] != 0]
+		return nil
+	})
+	r.take("mileage", 4, uintField)
+}
+
+func inspectAlarm(r *fieldReader) {
+	inspectGPS(r)
+	r.take("lbs_length", 1, uintField)
+	r.take("mcc", 2, uintField)
+	r.take("mnc", 1, uintField)
+	r.take("lac", 2, uintField)
+	r.take("cell_id", 3, uintField)
+	r.take("terminal_info", 1, terminalInfoField)
+	r.take("voltage_level", 1, voltageLevelField)
+	r.take("gsm_signal_strength", 1, gsmSignalField)
+	r.take("alarm_language", 2, func(b []byte, field *Field) error {
+		field.Value = beUint(b)
+		field.Text = fmt.Sprintf("%s, %s", alarmName(b[0]), alarmLanguageName(b[1]))
+		field.Fields = []Field{
+			bitField(field, 15, 8, uint64(b[0]), alarmName(b[0]), "alarm"),
+			bitField(field, 7, 0, uint64(b[1]), alarmLanguageName(b[1]), "language"),
+		}
+		return nil
+	})
+	r.take("mileage", 4, uintField)
+}
+
+// inspectGPS decodes the date, time and GPS fields shared by the location and alarm packets
+func inspectGPS(r *fieldReader) {
+	r.take("date_time", 6, func(b []byte, field *Field) error {
+		year, month, day := 2000+int(b[0]), int(b[1]), int(b[2])
+		at := time.Date(year, time.Month(month), day, int(b[3]), int(b[4]), int(b[5]), 0, time.UTC)
+		switch {
+		case month < 1 || month > 12:
+			return fmt.Errorf("invalid month %d at offset %d", month, field.Offset+1)
+		case day < 1 || at.Day() != day:
+			return fmt.Errorf("invalid day %d at offset %d", day, field.Offset+2)
+		case b[3] > 23 || b[4] > 59 || b[5] > 59:
+			return fmt.Errorf("invalid time %02d:%02d:%02d at offset %d", b[3], b[4], b[5], field.Offset+3)
+		}
+		field.Value = at.Format(time.RFC3339)
+		field.Text = "UTC"
+		return nil
+	})
+	r.take("gps_info", 1, func(b []byte, field *Field) error {
+		field.Value = b[0]
+		field.Text = fmt.Sprintf("%d satellites", b[0]&0x0F)
+		field.Fields = []Field{
+			bitField(field, 7, 4, uint64(b[0]>>4), "", "gps_info_length"),
+			bitField(field, 3, 0, uint64(b[0]&0x0F), "", "satellites"),
+		}
+		return nil
+	})
+
+	// the hemispheres are in the course and status word that follows
+	latitude := len(*r.fields)
+	r.take("latitude", 4, coordinateField(90))
+	longitude := len(*r.fields)
+	r.take("longitude", 4, coordinateField(180))
+	r.take("speed", 1, func(b []byte, field *Field) error {
+		field.Value = b[0]
+		field.Text = "km/h"
+		return nil
+	})
+	r.take("course_status", 2, func(b []byte, field *Field) error {
+		word := beUint(b)
+		field.Value = word
+		north, west := word&CourseStatusNorth != 0, word&CourseStatusWest != 0
+
+		fix := "not positioned"
+		if word&CourseStatusPositioned != 0 {
+			fix = "positioned"
+		}
+		field.Text = fmt.Sprintf("course %d°, %s", word&CourseMask, fix)
+		field.Fields = []Field{
+			flagField(field, word, 13, "differential", "differential positioning", "real-time GPS"),
+			flagField(field, word, 12, "positioned", "GPS positioned", "GPS not positioned"),
+			flagField(field, word, 11, "west", "west longitude", "east longitude"),
+			flagField(field, word, 10, "north", "north latitude", "south latitude"),
+			bitField(field, 9, 0, word&CourseMask, "degrees", "course"),
+		}
+		signCoordinate(*r.fields, latitude, !north, "N", "S")
+		signCoordinate(*r.fields, longitude, west, "E", "W")
+
+		if word&0xC000 != 0 {
+			return fmt.Errorf("reserved bits 15-14 are set")
+		}
+		if word&CourseMask > 359 {
+			return fmt.Errorf("course %d is beyond 359", word&CourseMask)
+		}
+		return nil
+	})
+}
+
+// signCoordinate applies the sign of the course and status word to the coordinate at index i of fields
+func signCoordinate(fields []Field, i int, negative bool, positive, negativeName string) {
+	if i >= len(fields) {
+		return
+	}
+	degrees, ok := fields[i].Value.(float64)
+	if !ok {
+		return
+	}
+	fields[i].Text = positive
+	if negative {
+		fields[i].Value = -degrees
+		fields[i].Text = negativeName
+	}
+}
+
+func coordinateField(limit float64) func(b []byte, field *Field) error {
+	return func(b []byte, field *Field) error {
+		degrees := float64(beUint(b)) / coordinateScale
+		if degrees > limit {
+			return fmt.Errorf("%s %.6f is beyond %.0f degrees", field.Name, degrees, limit)
+		}
+		field.Value = degrees
+		return nil
+	}
+}
+
+func inspectTextCommand(r *fieldReader, name string) {
+	var length int
+	if b, ok := r.take("length", 1, func(b []byte, field *Field) error {
+		field.Value = b[0]
+		field.Text = "bytes of the server flag and the " + name
+		if b[0] < 4 {
+			return fmt.Errorf("length %d is shorter than the server flag", b[0])
+		}
+		return nil
+	}); ok {
+		length = int(b[0])
+	}
+	r.take("server_flag", 4, uintField)
+	if length > 4 {
+		r.take(name, length-4, textField)
+	}
+	r.take("language", 2, func(b []byte, field *Field) error {
+		field.Value = beUint(b)
+		field.Text = alarmLanguageName(b[1])
+		return nil
+	})
+}
+
+func inspectCommandReplyAlt(r *fieldReader) {
+	r.take("server_flag", 4, uintField)
+	b, ok := r.take("encoding", 1, func(b []byte, field *Field) error {
+		field.Value = b[0]
+		switch b[0] {
+		case replyEncodingASCII:
+			field.Text = "ASCII"
+		case replyEncodingUTF16:
+			field.Text = "UTF-16"
+		default:
+			return fmt.Errorf("unsupported encoding 0x%02X", b[0])
+		}
+		return nil
+	})
+	if !ok || r.offset >= r.end {
+		return
+	}
+	if b[0] != replyEncodingUTF16 {
+		r.take("content", r.end-r.offset, textField)
+		return
+	}
+	r.take("content", r.end-r.offset, func(b []byte, field *Field) error {
+		if len(b)%2 != 0 {
+			return fmt.Errorf("UTF-16 content has an odd length %d", len(b))
+		}
+		units := make([]uint16, len(b)/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(b[2*i:])
+		}
+		field.Value = strings.TrimRight(string(utf16.Decode(units)), "\x00")
+		return nil
+	})
+}
+
+func terminalInfoField(b []byte, field *Field) error {
+	info := b[0]
+	alarm := uint64(info>>3) & 0x07
+	field.Value = info
+	field.Fields = []Field{
+		flagField(field, uint64(info), 7, "oil_electricity_cut", "oil and electricity disconnected", "oil and electricity connected"),
+		flagField(field, uint64(info), 6, "gps_tracking", "GPS tracking on", "GPS tracking off"),
+		bitField(field, 5, 3, alarm, terminalAlarmName(alarm), "alarm"),
+		flagField(field, uint64(info), 2, "charging", "charging", "not charging"),
+		flagField(field, uint64(info), 1, "acc", "ACC high", "ACC low"),
+		flagField(field, uint64(info), 0, "defense", "defense activated", "defense deactivated"),
+	}
+	texts := make([]string, 0, len(field.Fields))
+	for _, bit := range field.Fields {
+		texts = append(texts, bit.Text)
+	}
+	field.Text = strings.Join(texts, ", ")
+	if alarm > 4 {
+		return fmt.Errorf("undefined terminal alarm bits %03b", alarm)
+	}
+	return nil
+}
+
+func terminalAlarmName(bits uint64) string {
+	switch bits {
+	case 0:
+		return "normal"
+	case 1:
+		return "vibration alarm"
+	case 2:
+		return "power cut alarm"
+	case 3:
+		return "low battery alarm"
+	case 4:
+		return "SOS"
+	default:
+		return "undefined alarm"
+	}
+}
+
+func voltageLevelField(b []byte, field *Field) error {
+	names := []string{"no power", "extremely low battery", "very low battery", "low battery", "medium", "high", "very high"}
+	field.Value = b[0]
+	if int(b[0]) >= len(names) {
+		return fmt.Errorf("voltage level %d is beyond 6", b[0])
+	}
+	field.Text = names[b[0]]
+	return nil
+}
+
+func gsmSignalField(b []byte, field *Field) error {
+	names := []string{"no signal", "extremely weak", "very weak", "good", "strong"}
+	field.Value = b[0]
+	if int(b[0]) >= len(names) {
+		return fmt.Errorf("GSM signal strength %d is beyond 4", b[0])
+	}
+	field.Text = names[b[0]]
+	return nil
+}
+
+// alarmName describes an alarm type
+func alarmName(alarm uint8) string {
+	switch _, known := alarmTypeNames[alarm]; {
+	case !known:
+		return fmt.Sprintf("alarm 0x%02X", alarm)
+	case alarm == AlarmSOS:
+		return "SOS"
+	default:
+		return strings.ReplaceAll(AlarmTypeName(alarm), "_", " ")
+	}
+}
+
+// alarmLanguageName names the language byte of the alarm, heartbeat and command packets
+func alarmLanguageName(language uint8) string {
+	switch language {
+	case 0x01:
+		return "Chinese"
+	case 0x02:
+		return "English"
+	default:
+		return fmt.Sprintf("language 0x%02X", language)
+	}
+}
+
+func uploadModeName(mode uint8) string {
+	switch mode {
+	case 0x00:
+		return "fixed time interval"
+	case 0x01:
+		return "fixed distance"
+	case 0x02:
+		return "turning point"
+	case 0x03:
+		return "ACC status change"
+	case 0x04:
+		return "last point after stopping"
+	case 0x05:
+		return "last point after network recovery"
+	case 0x06:
+		return "ephemeris refresh"
+	case 0x07:
+		return "button press"
+	case 0x08:
+		return "power on"
+	default:
+		return fmt.Sprintf("mode 0x%02X", mode)
+	}
+}
+
+func uintField(b []byte, field *Field) error {
+	field.Value = beUint(b)
+	return nil
+}
+
+func hexField(b []byte, field *Field) error {
+	field.Value = hex.EncodeToString(b)
+	return nil
+}
+
+func textField(b []byte, field *Field) error {
+	for i, c := range b {
+		if (c < 0x20 || c > 0x7E) && c != 0 && c != '\r' && c != '\n' {
+			field.Value = string(b)
+			return fmt.Errorf("non printable byte 0x%02X at offset %d", c, field.Offset+i)
+		}
+	}
+	field.Value = strings.TrimRight(string(b), "\x00")
+	return nil
+}
+
+// bitField is the bits hi to lo of parent
+func bitField(parent *Field, hi, lo int, value uint64, text, name string) Field {
+	bits := fmt.Sprint(hi)
+	if hi != lo {
+		bits = fmt.Sprintf("%d-%d", hi, lo)
+	}
+	return Field{
+		Name:   name,
+		Offset: parent.Offset,
+		Bits:   bits,
+		Raw:    fmt.Sprintf("%0*b", hi-lo+1, value),
+		Value:  value,
+		Text:   text,
+	}
+}
+
+// flagField is the bit of word in parent, described by set or clear
+func flagField(parent *Field, word uint64, bit int, name, set, clear string) Field {
+	on := word&(1<<bit) != 0
+	text := clear
+	if on {
+		text = set
+	}
+	field := bitField(parent, bit, bit, word>>bit&1, text, name)
+	field.Value = on
+	return field
+}
+
+func byteCount(n int) string {
+	if n == 1 {
+		return "1 byte"
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
+
+func beUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// fieldReader decodes consecutive fields of a frame up to end
+type fieldReader struct {
+	data      []byte
+	frame     *InspectedFrame
+	offset    int
+	end       int
+	fields    *[]Field
+	truncated bool // a field did not fit, the following ones are not reported
+}
+
+// region returns a reader of the fields from the current offset up to end
+func (r *fieldReader) region(end int) *fieldReader {
+	return &fieldReader{data: r.data, frame: r.frame, offset: r.offset, end: end, fields: r.fields}
+}
+
+// take reads the next field of n bytes and decodes it with decode, which may be nil for raw bytes
+func (r *fieldReader) take(name string, n int, decode func(b []byte, field *Field) error) ([]byte, bool) {
+	if r.truncated {
+		return nil, false
+	}
+	if r.offset+n > r.end {
+		r.frame.errorf(r.offset, "%s needs %s, %s left", strings.ReplaceAll(name, "_", " "), byteCount(n), byteCount(r.end-r.offset))
+		r.truncated = true
+		return nil, false
+	}
+
+	b := r.data[r.offset : r.offset+n]
+	field := Field{Name: name, Offset: r.offset, Length: n, Raw: hex.EncodeToString(b)}
+	ok := true
+	if decode != nil {
+		if err := decode(b, &field); err != nil {
+			r.frame.errorf(r.offset, "%s: %v", strings.ReplaceAll(name, "_", " "), err)
+			ok = false
+		}
+	}
+	*r.fields = append(*r.fields, field)
+	r.offset += n
+	return b, ok
+}
@@ -0,0 +1,151 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Bits of the course and status word of the location and alarm packets, the course in degrees is in the low 10 bits
+const (
+	CourseMask               = 0x03FF
+	CourseStatusNorth        = 1 << 10 // north latitude, south when clear
+	CourseStatusWest         = 1 << 11 // west longitude, east when clear
+	CourseStatusPositioned   = 1 << 12 // the GPS has a fix
+	CourseStatusDifferential = 1 << 13 // differential positioning, real-time GPS when clear
+)
+
+// coordinateScale converts degrees to the fixed-point coordinates of the packets
+const coordinateScale = 1800000.0
+
+// BuildCONCOXPacket frames the information content of a terminal packet
+func BuildCONCOXPacket(protocolNumber uint8, info []byte, serial uint16) []byte {
+	packet := make([]byte, 0, len(info)+10)
+	packet = append(packet, PacketStartBit, PacketStartBit)
+	packet = append(packet, byte(len(info)+5)) // protocol number + info + serial + crc
+	packet = append(packet, protocolNumber)
+	packet = append(packet, info...)
+	packet = binary.BigEndian.AppendUint16(packet, serial)
+
+	crc := calculateCRC(packet[2:])
+	packet = binary.BigEndian.AppendUint16(packet, crc)
+	return append(packet, PacketStopBit0, PacketStopBit1)
+}
+
+// BuildCONCOXLogin builds the login packet of a terminal, the IMEI is padded to the 8 byte BCD terminal ID
+func BuildCONCOXLogin(imei string, modelCode [2]byte, timeZoneLanguage [2]byte, serial uint16) ([]byte, error) {
+	if len(imei) > 16 {
+		return nil, fmt.Errorf("IMEI %s is longer than 16 digits", imei)
+	}
+	terminalID, err := hex.DecodeString(fmt.Sprintf("%016s", imei))
+	if err != nil {
+		return nil, fmt.Errorf("invalid IMEI %s: %w", imei, err)
+	}
+
+	info := make([]byte, 0, 12)
+	info = append(info, terminalID...)
+	info = append(info, modelCode[:]...)
+	info = append(info, timeZoneLanguage[:]...)
+	return BuildCONCOXPacket(ProtocolLogin, info, serial), nil
+}
+
+// BuildCONCOXHeartbeat builds the heartbeat packet of a terminal
+func BuildCONCOXHeartbeat(h *CONCOXHeartbeatInfoContent, serial uint16) []byte {
+	info := make([]byte, 0, 7)
+	info = append(info, h.TerminalInfo)
+	info = binary.BigEndian.AppendUint16(info, h.ExternalVoltage)
+	info = append(info, h.BatteryVoltageLevel, h.GSMSignalStrength)
+	info = binary.BigEndian.AppendUint16(info, h.LanguageStatus)
+	return BuildCONCOXPacket(ProtocolHeartbeat, info, serial)
+}
+
+// BuildCONCOXLocation builds a ProtocolLocation or ProtocolLocationUTC packet of a terminal
+func BuildCONCOXLocation(protocolNumber uint8, l *CONCOXLocationInfoContent, serial uint16) []byte {
+	info := make([]byte, 0, 33)
+	info = append(info, l.DateTime[:]...)
+	info = append(info, l.GPSSatellites)
+	info = binary.BigEndian.AppendUint32(info, l.Latitude)
+	info = binary.BigEndian.AppendUint32(info, l.Longitude)
+	info = append(info, l.Speed)
+	info = binary.BigEndian.AppendUint16(info, l.CourseStatus)
+	info = binary.BigEndian.AppendUint16(info, l.MCC)
+	info = append(info, l.MNC)
+	info = binary.BigEndian.AppendUint16(info, l.LAC)
+	info = append(info, byte(l.CellID>>16), byte(l.CellID>>8), byte(l.CellID))
+	info = append(info, l.ACCStatus, l.UploadMode, l.GPSRealTimeReupload)
+	info = binary.BigEndian.AppendUint32(info, l.Mileage)
+	return BuildCONCOXPacket(protocolNumber, info, serial)
+}
+
+// BuildCONCOXAlarm builds the alarm packet of a terminal
+func BuildCONCOXAlarm(a *CONCOXAlarmInfoContent, serial uint16) []byte {
+	info := make([]byte, 0, 36)
+	info = append(info, a.DateTime[:]...)
+	info = append(info, a.GPSSatellites)
+	info = binary.BigEndian.AppendUint32(info, a.Latitude)
+	info = binary.BigEndian.AppendUint32(info, a.Longitude)
+	info = append(info, a.Speed)
+	info = binary.BigEndian.AppendUint16(info, a.CourseStatus)
+	info = append(info, a.LBSLength)
+	info = binary.BigEndian.AppendUint16(info, a.MCC)
+	info = append(info, a.MNC)
+	info = binary.BigEndian.AppendUint16(info, a.LAC)
+	info = append(info, byte(a.CellID>>16), byte(a.CellID>>8), byte(a.CellID))
+	info = append(info, a.TerminalInfo, a.VoltageLevel, a.GSMSignalStrength)
+	info = binary.BigEndian.AppendUint16(info, a.AlarmLanguage)
+	info = binary.BigEndian.AppendUint32(info, a.Mileage)
+	return BuildCONCOXPacket(ProtocolAlarm, info, serial)
+}
+
+// BuildCONCOXCommandReply builds the ProtocolCommandReply packet answering the online command of serverFlag
+func BuildCONCOXCommandReply(serverFlag uint32, content string, serial uint16) ([]byte, error) {
+	if len(content) > MaxCommandLength {
+		return nil, fmt.Errorf("reply is %d bytes long, at most %d fit in a packet", len(content), MaxCommandLength)
+	}
+
+	info := make([]byte, 0, len(content)+7)
+	info = append(info, byte(len(content)+4)) // server flag + content
+	info = binary.BigEndian.AppendUint32(info, serverFlag)
+	info = append(info, content...)
+	info = binary.BigEndian.AppendUint16(info, CommandLanguageEnglish)
+	return BuildCONCOXPacket(ProtocolCommandReply, info, serial), nil
+}
+
+// ParseCONCOXOnlineCommand parses the information content of a ProtocolOnlineCommand packet
+func ParseCONCOXOnlineCommand(buffer []byte) (serverFlag uint32, command string, err error) {
+	// length(1) + server flag(4) + command + language(2)
+	if len(buffer) < 5 {
+		return 0, "", fmt.Errorf("buffer too small for online command: %d bytes", len(buffer))
+	}
+	length := int(buffer[0])
+	if length < 4 || 1+length > len(buffer) {
+		return 0, "", fmt.Errorf("invalid online command length %d for %d bytes", length, len(buffer))
+	}
+	return binary.BigEndian.Uint32(buffer[1:5]), string(buffer[5 : 1+length]), nil
+}
+
+// EncodeDateTime encodes t in the 6 byte date and time of the packets, in UTC
+func EncodeDateTime(t time.Time) [6]byte {
+	t = t.UTC()
+	return [6]byte{byte(t.Year() - 2000), byte(t.Month()), byte(t.Day()), byte(t.Hour()), byte(t.Minute()), byte(t.Second())}
+}
+
+// EncodeCoordinate encodes the magnitude of a latitude or longitude in degrees, its sign goes to the course and status
+func EncodeCoordinate(degrees float64) uint32 {
+	return uint32(math.Round(math.Abs(degrees) * coordinateScale))
+}
+
+// EncodeCourseStatus builds the course and status word of a real-time position with a GPS fix
+func EncodeCourseStatus(course int, latitude, longitude float64) uint16 {
+	status := uint16(((course%360)+360)%360) & CourseMask
+	status |= CourseStatusPositioned
+	if latitude >= 0 {
+		status |= CourseStatusNorth
+	}
+	if longitude < 0 {
+		status |= CourseStatusWest
+	}
+	return status
+}
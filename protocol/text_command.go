@@ -0,0 +1,313 @@
+package protocol
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Text command names of the GT06 dialect
+const (
+	CommandRelay   = "RELAY"
+	CommandTimer   = "TIMER"
+	CommandSOS     = "SOS"
+	CommandFence   = "FENCE"
+	CommandAPN     = "APN"
+	CommandServer  = "SERVER"
+	CommandStatus  = "STATUS"
+	CommandParam   = "PARAM"
+	CommandVersion = "VERSION"
+	CommandWhere   = "WHERE"
+)
+
+// Fence triggers
+const (
+	FenceIn   = "IN"
+	FenceOut  = "OUT"
+	FenceBoth = "IN OR OUT"
+)
+
+const (
+	minTimerSeconds = 5
+	maxTimerSeconds = 18000
+	maxSOSNumbers   = 3
+	// fenceRadiusUnit is the step of the fence radius in meters
+	fenceRadiusUnit = 100
+	maxFenceRadius  = 9999 * fenceRadiusUnit
+	maxAPNField     = 32
+)
+
+var phoneNumberPattern = regexp.MustCompile(`^\+?[0-9]{3,20}$`)
+
+// TextCommand is a command of the GT06 text dialect, NAME,ARG1,ARG2#. Build it with the
+// constructors, which validate the arguments, and render it with a CommandProfile.
+type TextCommand struct {
+	Name string
+	Args []string
+}
+
+// String renders the command with the default profile
+func (c TextCommand) String() string {
+	return DefaultCommandProfile.Format(c)
+}
+
+// RelayCommand cuts (true) or restores (false) the fuel or power relay
+func RelayCommand(cut bool) TextCommand {
+	state := "0"
+	if cut {
+		state = "1"
+	}
+	return TextCommand{Name: CommandRelay, Args: []string{state}}
+}
+
+// TimerCommand sets the upload interval in seconds while ACC is on and off
+func TimerCommand(accOn, accOff int) (TextCommand, error) {
+	for _, v := range []int{accOn, accOff} {
+		if v < minTimerSeconds || v > maxTimerSeconds {
+			return TextCommand{}, fmt.Errorf("timer interval %d is out of range %d to %d seconds", v, minTimerSeconds, maxTimerSeconds)
+		}
+	}
+	return TextCommand{Name: CommandTimer, Args: []string{strconv.Itoa(accOn), strconv.Itoa(accOff)}}, nil
+}
+
+// SOSAddCommand sets up to three SOS numbers
+func SOSAddCommand(numbers ...string) (TextCommand, error) {
+	if len(numbers) == 0 || len(numbers) > maxSOSNumbers {
+		return TextCommand{}, fmt.Errorf("between 1 and %d SOS numbers are required, got %d", maxSOSNumbers, len(numbers))
+	}
+	for _, number := range numbers {
+		if !phoneNumberPattern.MatchString(number) {
+			return TextCommand{}, fmt.Errorf("invalid SOS number %q", number)
+		}
+	}
+	return TextCommand{Name: CommandSOS, Args: append([]string{"A"}, numbers...)}, nil
+}
+
+// SOSDeleteCommand removes the SOS numbers at the 1-based positions
+func SOSDeleteCommand(positions ...int) (TextCommand, error) {
+	if len(positions) == 0 || len(positions) > maxSOSNumbers {
+		return TextCommand{}, fmt.Errorf("between 1 and %d SOS positions are required, got %d", maxSOSNumbers, len(positions))
+	}
+
+	args := []string{"D"}
+	for _, position := range positions {
+		if position < 1 || position > maxSOSNumbers {
+			return TextCommand{}, fmt.Errorf("SOS position %d is out of range 1 to %d", position, maxSOSNumbers)
+		}
+		args = append(args, strconv.Itoa(position))
+	}
+	return TextCommand{Name: CommandSOS, Args: args}, nil
+}
+
+// FenceCircleCommand enables a circular geofence of radius meters, rounded up to 100 m steps,
+// alarming when the device crosses it in the trigger direction
+func FenceCircleCommand(latitude, longitude float64, radius int, trigger string) (TextCommand, error) {
+	if latitude < -90 || latitude > 90 || longitude < -180 || longitude > 180 {
+		return TextCommand{}, fmt.Errorf("invalid fence center %f,%f", latitude, longitude)
+	}
+	if radius <= 0 || radius > maxFenceRadius {
+		return TextCommand{}, fmt.Errorf("fence radius %d is out of range 1 to %d meters", radius, maxFenceRadius)
+	}
+	switch trigger {
+	case FenceIn, FenceOut, FenceBoth:
+	default:
+		return TextCommand{}, fmt.Errorf("invalid fence trigger %q", trigger)
+	}
+
+	steps := (radius + fenceRadiusUnit - 1) / fenceRadiusUnit
+	return TextCommand{Name: CommandFence, Args: []string{
+		"ON", "0", hemisphere(latitude, "N", "S"), hemisphere(longitude, "E", "W"), strconv.Itoa(steps), trigger, "1",
+	}}, nil
+}
+
+// FenceOffCommand disables the geofence
+func FenceOffCommand() TextCommand {
+	return TextCommand{Name: CommandFence, Args: []string{"OFF"}}
+}
+
+// APNCommand sets the access point, user and password may be empty
+func APNCommand(name, user, password string) (TextCommand, error) {
+	if name == "" {
+		return TextCommand{}, fmt.Errorf("APN name is required")
+	}
+	if (user == "") != (password == "") {
+		return TextCommand{}, fmt.Errorf("APN user and password go together")
+	}
+
+	args := []string{name}
+	if user != "" {
+		args = append(args, user, password)
+	}
+	for _, arg := range args {
+		if err := validateArg(arg, maxAPNField); err != nil {
+			return TextCommand{}, fmt.Errorf("invalid APN field: %w", err)
+		}
+	}
+	return TextCommand{Name: CommandAPN, Args: args}, nil
+}
+
+// ServerCommand points the device at host and port over TCP, host is an IP address or a domain
+func ServerCommand(host string, port int) (TextCommand, error) {
+	if port < 1 || port > 65535 {
+		return TextCommand{}, fmt.Errorf("port %d is out of range", port)
+	}
+
+	mode := "1" // domain
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.To4() == nil {
+			return TextCommand{}, fmt.Errorf("only IPv4 servers are supported, got %s", host)
+		}
+		mode = "0"
+	} else if err := validateArg(host, 63); err != nil || !strings.Contains(host, ".") {
+		return TextCommand{}, fmt.Errorf("invalid server host %q", host)
+	}
+
+	return TextCommand{Name: CommandServer, Args: []string{mode, host, strconv.Itoa(port), "0"}}, nil
+}
+
+// StatusCommand asks for the battery, GPRS, GSM, GPS and ACC status
+func StatusCommand() TextCommand {
+	return TextCommand{Name: CommandStatus}
+}
+
+// ParamCommand asks for the configured parameters
+func ParamCommand() TextCommand {
+	return TextCommand{Name: CommandParam}
+}
+
+// VersionCommand asks for the firmware version
+func VersionCommand() TextCommand {
+	return TextCommand{Name: CommandVersion}
+}
+
+// WhereCommand asks for the current position
+func WhereCommand() TextCommand {
+	return TextCommand{Name: CommandWhere}
+}
+
+// hemisphere formats a coordinate as N23.111111 or W113.111111
+func hemisphere(v float64, positive, negative string) string {
+	prefix := positive
+	if v < 0 {
+		prefix, v = negative, -v
+	}
+	return prefix + strconv.FormatFloat(v, 'f', 6, 64)
+}
+
+// validateArg rejects arguments that would break the command syntax
+func validateArg(arg string, maxLength int) error {
+	if arg == "" || len(arg) > maxLength {
+		return fmt.Errorf("%q must be 1 to %d characters", arg, maxLength)
+	}
+	for i := 0; i < len(arg); i++ {
+		if c := arg[i]; c < 0x21 || c > 0x7E || c == ',' || c == '#' {
+			return fmt.Errorf("%q has the invalid character %q", arg, c)
+		}
+	}
+	return nil
+}
+
+// NewTextCommand builds a command from its name and textual arguments, validating them like the
+// typed constructors:
+//
+//	RELAY 1|0
+//	TIMER accOn accOff
+//	SOS A number... | SOS D position...
+//	FENCE OFF | FENCE ON latitude longitude radius IN|OUT|IN OR OUT
+//	APN name [user password]
+//	SERVER host port
+//	STATUS, PARAM, VERSION, WHERE
+func NewTextCommand(name string, args []string) (TextCommand, error) {
+	name = strings.ToUpper(name)
+
+	switch name {
+	case CommandRelay:
+		if len(args) != 1 || (args[0] != "0" && args[0] != "1") {
+			return TextCommand{}, fmt.Errorf("RELAY takes 1 to cut or 0 to restore")
+		}
+		return RelayCommand(args[0] == "1"), nil
+
+	case CommandTimer:
+		ints, err := atoiAll(args, 2)
+		if err != nil {
+			return TextCommand{}, fmt.Errorf("TIMER takes the ACC on and ACC off intervals: %w", err)
+		}
+		return TimerCommand(ints[0], ints[1])
+
+	case CommandSOS:
+		if len(args) < 2 {
+			return TextCommand{}, fmt.Errorf("SOS takes A with numbers or D with positions")
+		}
+		switch strings.ToUpper(args[0]) {
+		case "A":
+			return SOSAddCommand(args[1:]...)
+		case "D":
+			positions, err := atoiAll(args[1:], len(args)-1)
+			if err != nil {
+				return TextCommand{}, fmt.Errorf("SOS D takes positions: %w", err)
+			}
+			return SOSDeleteCommand(positions...)
+		}
+		return TextCommand{}, fmt.Errorf("SOS takes A with numbers or D with positions")
+
+	case CommandFence:
+		if len(args) == 1 && strings.ToUpper(args[0]) == "OFF" {
+			return FenceOffCommand(), nil
+		}
+		if len(args) != 5 || strings.ToUpper(args[0]) != "ON" {
+			return TextCommand{}, fmt.Errorf("FENCE takes OFF, or ON with latitude, longitude, radius and trigger")
+		}
+		latitude, errLat := strconv.ParseFloat(args[1], 64)
+		longitude, errLng := strconv.ParseFloat(args[2], 64)
+		radius, errRadius := strconv.Atoi(args[3])
+		if errLat != nil || errLng != nil || errRadius != nil {
+			return TextCommand{}, fmt.Errorf("FENCE ON takes a numeric latitude, longitude and radius")
+		}
+		return FenceCircleCommand(latitude, longitude, radius, strings.ToUpper(args[4]))
+
+	case CommandAPN:
+		switch len(args) {
+		case 1:
+			return APNCommand(args[0], "", "")
+		case 3:
+			return APNCommand(args[0], args[1], args[2])
+		}
+		return TextCommand{}, fmt.Errorf("APN takes a name, optionally with user and password")
+
+	case CommandServer:
+		if len(args) != 2 {
+			return TextCommand{}, fmt.Errorf("SERVER takes a host and a port")
+		}
+		port, err := strconv.Atoi(args[1])
+		if err != nil {
+			return TextCommand{}, fmt.Errorf("invalid port %q", args[1])
+		}
+		return ServerCommand(args[0], port)
+
+	case CommandStatus, CommandParam, CommandVersion, CommandWhere:
+		if len(args) > 0 {
+			return TextCommand{}, fmt.Errorf("%s takes no arguments", name)
+		}
+		return TextCommand{Name: name}, nil
+	}
+
+	return TextCommand{}, fmt.Errorf("unknown command %q", name)
+}
+
+func atoiAll(args []string, n int) ([]int, error) {
+	if len(args) != n {
+		return nil, fmt.Errorf("expected %d values, got %d", n, len(args))
+	}
+
+	ints := make([]int, n)
+	for i, arg := range args {
+		v, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", arg)
+		}
+		ints[i] = v
+	}
+	return ints, nil
+}
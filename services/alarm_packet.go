@@ -4,36 +4,44 @@ import (
 	"context"
 	"fmt"
 	"gt06/common"
+	"gt06/database"
+	"gt06/events"
 	"gt06/protocol"
 	"gt06/services/svc"
 	"time"
 
 	"github.com/zeromicro/go-zero/core/logx"
-	"go.mongodb.org/mongo-driver/bson"
 )
 
 type AlarmService struct {
 	context context.Context
 	log     logx.Logger
 	svc     *svc.ServiceContext
+	session Session
 }
 
-func NewAlarmService(c context.Context, svc *svc.ServiceContext) *AlarmService {
+func NewAlarmService(c context.Context, svc *svc.ServiceContext, session Session) *AlarmService {
 	c = logx.ContextWithFields(c, logx.LogField{
 		Key:   string(common.SpanID),
-		Value: common.GenerateSpanID(),
+		Value: common.SpanIDOf(c),
 	})
 
 	return &AlarmService{
 		context: c,
 		log:     logx.WithContext(c),
 		svc:     svc,
+		session: session,
 	}
 }
 
 func (s *AlarmService) ProcessPacket(packet *protocol.CONCOXPacket) (buf []byte, err error) {
 	s.log.Info("Processing Alarm Packet")
 
+	imei := s.session.IMEI()
+	if imei == "" {
+		return nil, ErrNotLoggedIn
+	}
+
 	alarmInfo, err := protocol.ParseCONCOXAlarmInfoContent(packet.InfoContent[:])
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse alarm info: %w", err)
@@ -52,36 +60,56 @@ func (s *AlarmService) ProcessPacket(packet *protocol.CONCOXPacket) (buf []byte,
 		time.UTC,
 	)
 
-	// Decode latitude and longitude from fixed-point format (decimal_degrees * 1800000)
-	latitude := float64(alarmInfo.Latitude) / 1800000.0
-	longitude := float64(alarmInfo.Longitude) / 1800000.0
-
-	document := bson.M{
-		"date_time":        dateTime,
-		"gps_satellites":   alarmInfo.GPSSatellites,
-		"latitude":         latitude,
-		"longitude":        longitude,
-		"speed":            alarmInfo.Speed,
-		"course_status":    alarmInfo.CourseStatus,
-		"lbs_length":       alarmInfo.LBSLength,
-		"mcc":              alarmInfo.MCC,
-		"mnc":              alarmInfo.MNC,
-		"lac":              alarmInfo.LAC,
-		"cell_id":          alarmInfo.CellID,
-		"terminal_info":    alarmInfo.TerminalInfo,
-		"voltage_level":    alarmInfo.VoltageLevel,
-		"gsm_signal":       alarmInfo.GSMSignalStrength,
-		"alarm_language":   alarmInfo.AlarmLanguage,
-		"mileage":          alarmInfo.Mileage,
-		"created_at":       time.Now(),
+	latitude, longitude := decodeCoordinates(alarmInfo.Latitude, alarmInfo.Longitude, alarmInfo.CourseStatus)
+
+	alarm := &database.Alarm{
+		IMEI:          imei,
+		DateTime:      dateTime,
+		GPSSatellites: int(alarmInfo.GPSSatellites),
+		Latitude:      latitude,
+		Longitude:     longitude,
+		Location:      database.NewGeoPoint(latitude, longitude),
+		Speed:         int(alarmInfo.Speed),
+		CourseStatus:  int(alarmInfo.CourseStatus),
+		LBSLength:     int(alarmInfo.LBSLength),
+		MCC:           int(alarmInfo.MCC),
+		MNC:           int(alarmInfo.MNC),
+		LAC:           int(alarmInfo.LAC),
+		CellID:        int(alarmInfo.CellID),
+		TerminalInfo:  int(alarmInfo.TerminalInfo),
+		VoltageLevel:  int(alarmInfo.VoltageLevel),
+		GSMSignal:     int(alarmInfo.GSMSignalStrength),
+		AlarmLanguage: int(alarmInfo.AlarmLanguage),
+		Mileage:       int(alarmInfo.Mileage),
+		CreatedAt:     time.Now(),
 	}
 
-	_, err = s.svc.MongoDBModel.Insert(s.context, "CONCOXAlarmInfoContent", document)
-	if err != nil {
+	// the event and the state only follow a stored record
+	stored := func() {
+		s.svc.Publisher.Publish(events.AlarmEvent(alarm))
+		UpdateDeviceState(s.context, s.svc, &database.StateUpdate{
+			IMEI:        imei,
+			At:          alarm.CreatedAt,
+			Position:    statePosition(dateTime, latitude, longitude, alarm.Speed, alarm.CourseStatus, alarm.GPSSatellites),
+			ACC:         accFromTerminalInfo(alarm.TerminalInfo),
+			Battery:     ptr(alarm.VoltageLevel),
+			GSMSignal:   ptr(alarm.GSMSignal),
+			LastAlarmAt: ptr(alarm.CreatedAt),
+		})
+	}
+
+	// with batching the ack is sent once the batch holding the record is stored
+	if s.svc.AlarmWriter != nil {
+		s.svc.AlarmWriter.Add(s.context, alarm, ackWhenStored(s.log, s.session, "alarm data", protocol.BuildCONCOXResponseAlarm(packet), stored))
+		return nil, nil
+	}
+
+	if err := s.svc.AlarmRepository.Insert(s.context, alarm); err != nil {
 		s.log.Errorf("Failed to insert alarm info: %w", err)
 		return nil, fmt.Errorf("failed to save alarm data: %w", err)
 	}
 
+	stored()
 	s.log.Infof("Alarm data saved: lat=%.6f, lng=%.6f, voltage=0x%02X", latitude, longitude, alarmInfo.VoltageLevel)
 
 	response := protocol.BuildCONCOXResponseAlarm(packet)
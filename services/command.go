@@ -0,0 +1,322 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gt06/commands"
+	"gt06/common"
+	"gt06/database"
+	"gt06/protocol"
+	"gt06/services/svc"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/logx"
+	"github.com/zeromicro/go-zero/core/threading"
+)
+
+// CommandService sends text commands to terminals on behalf of an operator
+type CommandService struct {
+	context context.Context
+	log     logx.Logger
+	svc     *svc.ServiceContext
+}
+
+func NewCommandService(c context.Context, svc *svc.ServiceContext) *CommandService {
+	c = logx.ContextWithFields(c, logx.LogField{
+		Key:   string(common.SpanID),
+		Value: common.SpanIDOf(c),
+	})
+
+	return &CommandService{
+		context: c,
+		log:     logx.WithContext(c),
+		svc:     svc,
+	}
+}
+
+// Send delivers text to the live session of imei as an online command and waits up to timeout
+// for the reply. The command is recorded with sender before it is sent and updated with its
+// outcome, which is returned along with commands.ErrInvalidCommand, commands.ErrOffline, context.DeadlineExceeded or
+// commands.ErrDisconnected when there is no reply.
+func (s *CommandService) Send(imei, text, sender string, timeout time.Duration) (*database.Command, error) {
+	if err := s.svc.Commands.Validate(text); err != nil {
+		return nil, err
+	}
+
+	command := &database.Command{
+		IMEI:      imei,
+		Command:   text,
+		Sender:    sender,
+		Status:    database.CommandPending,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	if err := s.svc.CommandRepository.Insert(s.context, command); err != nil {
+		return nil, fmt.Errorf("failed to save command: %w", err)
+	}
+
+	call, err := s.svc.Commands.Deliver(imei, text)
+	if err != nil {
+		command.Status = database.CommandFailed
+		command.Error = err.Error()
+		s.saveOutcome(command, database.CommandPending)
+		return command, err
+	}
+
+	s.log.Infof("Sent command %s to %s: %q", command.ID.Hex(), imei, text)
+	command.Attempts++
+	s.delivered(command, call, database.CommandPending)
+
+	err = s.await(command, call, timeout)
+	switch {
+	case err == nil:
+	case errors.Is(err, context.DeadlineExceeded):
+		command.Status = database.CommandTimeout
+	default:
+		command.Status = database.CommandFailed
+	}
+	s.saveOutcome(command, database.CommandDelivered)
+
+	return command, err
+}
+
+// Format renders c in the syntax of the device model of imei
+func (s *CommandService) Format(imei string, c protocol.TextCommand) (string, error) {
+	device, err := s.svc.DeviceRepository.FindByIMEI(s.context, imei)
+	if err != nil {
+		return "", fmt.Errorf("failed to load device: %w", err)
+	}
+	if device == nil {
+		return protocol.DefaultCommandProfile.Format(c), nil
+	}
+	return protocol.CommandProfileFor(device.ModelCode).Format(c), nil
+}
+
+// Enqueue records text for delivery the next time imei logs in, or right away when it is online.
+// Queued commands are delivered highest priority first, up to maxAttempts times until one is
+// answered, and expire when still queued after expiry.
+func (s *CommandService) Enqueue(imei, text, sender string, priority int, expiry time.Duration, maxAttempts int) (*database.Command, error) {
+	if err := s.svc.Commands.Validate(text); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(expiry)
+	command := &database.Command{
+		IMEI:        imei,
+		Command:     text,
+		Sender:      sender,
+		Status:      database.CommandQueued,
+		Priority:    priority,
+		MaxAttempts: maxAttempts,
+		ExpiresAt:   &expiresAt,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := s.svc.CommandRepository.Insert(s.context, command); err != nil {
+		return nil, fmt.Errorf("failed to save command: %w", err)
+	}
+	s.log.Infof("Queued command %s for %s: %q", command.ID.Hex(), imei, text)
+
+	if s.svc.Commands.Online(imei) {
+		// the flush outlives the caller
+		flusher := NewCommandService(context.WithoutCancel(s.context), s.svc)
+		threading.GoSafe(func() {
+			flusher.FlushQueued(imei)
+		})
+	}
+	return command, nil
+}
+
+// FlushQueued delivers the queued commands of imei one at a time, waiting for each reply.
+// A command left unanswered is queued again for the next login until it used its attempts.
+// Delivery stops when the device goes offline, the remaining commands wait for the next login.
+func (s *CommandService) FlushQueued(imei string) {
+	done, ok := s.svc.Commands.TryFlush(imei)
+	if !ok {
+		return
+	}
+
+	for {
+		s.flushQueued(imei)
+		if done() {
+			return
+		}
+	}
+}
+
+func (s *CommandService) flushQueued(imei string) {
+	timeout := time.Duration(s.svc.Config.Commands.Timeout) * time.Second
+	for {
+		connectionID, online := s.svc.Commands.ConnectionID(imei)
+		if !online {
+			return
+		}
+
+		queued, err := s.svc.CommandRepository.FindQueued(s.context, imei)
+		if err != nil {
+			s.log.Errorf("Failed to load queued commands: %v", err)
+			return
+		}
+
+		// a command left unanswered is retried on the next connection
+		pending := make([]*database.Command, 0, len(queued))
+		for _, command := range queued {
+			if command.Attempts == 0 || command.ConnectionID != connectionID {
+				pending = append(pending, command)
+			}
+		}
+		if len(pending) == 0 {
+			return
+		}
+
+		for _, command := range pending {
+			if !s.deliverQueued(command, timeout) {
+				return
+			}
+		}
+	}
+}
+
+// deliverQueued delivers a queued command and records the outcome, it returns false once the
+// device is offline
+func (s *CommandService) deliverQueued(command *database.Command, timeout time.Duration) bool {
+	if command.ExpiresAt != nil && !command.ExpiresAt.After(time.Now()) {
+		command.Status = database.CommandExpired
+		s.saveOutcome(command, database.CommandQueued)
+		return true
+	}
+
+	// the command is claimed before it is written, it may have expired since it was loaded
+	command.Status = database.CommandDelivered
+	command.Attempts++
+	command.Error = ""
+	if !s.saveOutcome(command, database.CommandQueued) {
+		return true
+	}
+
+	call, err := s.svc.Commands.Deliver(command.IMEI, command.Command)
+	if errors.Is(err, commands.ErrOffline) {
+		// it was not written, it waits for the next login with the attempt given back
+		command.Status = database.CommandQueued
+		command.Attempts--
+		s.saveOutcome(command, database.CommandDelivered)
+		return false
+	}
+	if err != nil {
+		command.Status = database.CommandFailed
+		command.Error = err.Error()
+		s.saveOutcome(command, database.CommandDelivered)
+		return true
+	}
+
+	s.log.Infof("Delivered queued command %s, attempt %d", command.ID.Hex(), command.Attempts)
+	s.delivered(command, call, database.CommandDelivered)
+
+	err = s.await(command, call, timeout)
+	switch {
+	case err == nil:
+	case command.MaxAttempts > 0 && command.Attempts >= command.MaxAttempts:
+		command.Status = database.CommandFailed
+		command.Error = fmt.Sprintf("%s after %d attempts", command.Error, command.Attempts)
+	default:
+		command.Status = database.CommandQueued
+	}
+	s.saveOutcome(command, database.CommandDelivered)
+
+	return !errors.Is(err, commands.ErrDisconnected)
+}
+
+// delivered records that call wrote command to the device, command being in status from
+func (s *CommandService) delivered(command *database.Command, call *commands.Call, from string) {
+	command.Status = database.CommandDelivered
+	command.ServerFlag = call.ServerFlag
+	command.ConnectionID = call.ConnectionID
+	command.DeliveredAt = &call.SentAt
+	command.Error = ""
+	s.saveOutcome(command, from)
+}
+
+// await waits up to timeout for the reply to call. The reply is set on command, otherwise the error
+// describing why there is none, the status is left to the caller.
+func (s *CommandService) await(command *database.Command, call *commands.Call, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(s.context, timeout)
+	defer cancel()
+
+	reply, repliedAt, err := call.Wait(ctx)
+	switch {
+	case err == nil:
+		command.Status = database.CommandReplied
+		command.Reply = reply
+		command.RepliedAt = &repliedAt
+	case errors.Is(err, context.DeadlineExceeded):
+		command.Error = fmt.Sprintf("no reply within %s", timeout)
+	default:
+		command.Error = err.Error()
+	}
+	return err
+}
+
+// saveOutcome updates the recorded command while it is still in status from, it reports whether
+// it did. The caller may have gone already so its context is not used.
+func (s *CommandService) saveOutcome(command *database.Command, from string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	command.UpdatedAt = time.Now()
+	saved, err := s.svc.CommandRepository.Transition(ctx, command, from)
+	if err != nil {
+		s.log.Errorf("Failed to update command %s: %v", command.ID.Hex(), err)
+		return false
+	}
+	if !saved {
+		s.log.Infof("Command %s is no longer %s, it is left as stored", command.ID.Hex(), from)
+	}
+	return saved
+}
+
+// CommandReplyService hands the text replies of a terminal to the command waiting for them
+type CommandReplyService struct {
+	context context.Context
+	log     logx.Logger
+	svc     *svc.ServiceContext
+	session Session
+}
+
+func NewCommandReplyService(c context.Context, svc *svc.ServiceContext, session Session) *CommandReplyService {
+	c = logx.ContextWithFields(c, logx.LogField{
+		Key:   string(common.SpanID),
+		Value: common.SpanIDOf(c),
+	})
+
+	return &CommandReplyService{
+		context: c,
+		log:     logx.WithContext(c),
+		svc:     svc,
+		session: session,
+	}
+}
+
+// ProcessPacket matches the reply to its command, replies are not acknowledged
+func (s *CommandReplyService) ProcessPacket(packet *protocol.CONCOXPacket) ([]byte, error) {
+	s.log.Info("Processing Command Reply Packet")
+
+	imei := s.session.IMEI()
+	if imei == "" {
+		return nil, ErrNotLoggedIn
+	}
+
+	reply, err := protocol.ParseCONCOXCommandReply(packet.ProtocolNumber, packet.Info())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse command reply: %w", err)
+	}
+
+	if !s.svc.Commands.HandleReply(imei, reply) {
+		s.log.Infof("Dropping reply without a pending command: %q", reply.Content)
+		return nil, nil
+	}
+	s.log.Infof("Command reply: %q", reply.Content)
+
+	return nil, nil
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"context"
+	"gt06/database"
+	"gt06/events"
+	"gt06/protocol"
+	"gt06/services/svc"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+const (
+	// courseMask selects the heading bits of the course/status field
+	courseMask = 0x03FF
+	// terminalInfoACC is the ACC high bit of the terminal information byte
+	terminalInfoACC = 0x02
+)
+
+// UpdateDeviceState applies update to the last known state of the device and publishes the
+// state.changed events it causes. The state is derived from the stored records, so a failure
+// is logged without failing the packet.
+func UpdateDeviceState(ctx context.Context, svc *svc.ServiceContext, update *database.StateUpdate) {
+	before, after, err := svc.DeviceStateRepository.Apply(ctx, update)
+	if err != nil {
+		logx.WithContext(ctx).Errorf("Failed to update device state: %v", err)
+		return
+	}
+	for _, e := range events.StateChanges(before, after, update.At) {
+		svc.Publisher.Publish(e)
+	}
+}
+
+func statePosition(dateTime time.Time, latitude, longitude float64, speed, courseStatus, satellites int) *database.StatePosition {
+	return &database.StatePosition{
+		DateTime:      dateTime,
+		Latitude:      latitude,
+		Longitude:     longitude,
+		Location:      database.NewGeoPoint(latitude, longitude),
+		Speed:         speed,
+		Heading:       courseStatus & courseMask,
+		GPSSatellites: satellites,
+	}
+}
+
+// decodeCoordinates converts the fixed-point coordinates (decimal_degrees * 1800000) to signed
+// degrees, south of the equator and west of Greenwich being negative as told by the course/status
+func decodeCoordinates(latitude, longitude uint32, courseStatus uint16) (float64, float64) {
+	lat := float64(latitude) / 1800000.0
+	lng := float64(longitude) / 1800000.0
+	if courseStatus&protocol.CourseStatusNorth == 0 {
+		lat = -lat
+	}
+	if courseStatus&protocol.CourseStatusWest != 0 {
+		lng = -lng
+	}
+	return lat, lng
+}
+
+func accFromTerminalInfo(terminalInfo int) *bool {
+	acc := terminalInfo&terminalInfoACC != 0
+	return &acc
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
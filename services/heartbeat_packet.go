@@ -2,8 +2,13 @@ package services
 
 import (
 	"context"
+	"fmt"
 	"gt06/common"
+	"gt06/database"
+	"gt06/events"
 	"gt06/protocol"
+	"gt06/services/svc"
+	"time"
 
 	"github.com/zeromicro/go-zero/core/logx"
 )
@@ -11,23 +16,61 @@ import (
 type HeartbeatService struct {
 	context context.Context
 	log     logx.Logger
+	svc     *svc.ServiceContext
+	session Session
 }
 
-func NewHeartbeatService(c context.Context) *HeartbeatService {
+func NewHeartbeatService(c context.Context, svc *svc.ServiceContext, session Session) *HeartbeatService {
 	c = logx.ContextWithFields(c, logx.LogField{
 		Key:   string(common.SpanID),
-		Value: common.GenerateSpanID(),
+		Value: common.SpanIDOf(c),
 	})
 
 	return &HeartbeatService{
 		context: c,
 		log:     logx.WithContext(c),
+		svc:     svc,
+		session: session,
 	}
 }
 
 func (s *HeartbeatService) ProcessPacket(packet *protocol.CONCOXPacket) (buf []byte, err error) {
 	s.log.Info("Processing Heartbeat Packet")
 
+	imei := s.session.IMEI()
+	if imei == "" {
+		return nil, ErrNotLoggedIn
+	}
+
+	heartbeatInfo, err := protocol.ParseCONCOXHeartbeatInfoContent(packet.InfoContent[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse heartbeat info: %w", err)
+	}
+
+	heartbeat := &database.Heartbeat{
+		IMEI:                imei,
+		TerminalInfo:        int(heartbeatInfo.TerminalInfo),
+		ExternalVoltage:     int(heartbeatInfo.ExternalVoltage),
+		BatteryVoltageLevel: int(heartbeatInfo.BatteryVoltageLevel),
+		GSMSignal:           int(heartbeatInfo.GSMSignalStrength),
+		LanguageStatus:      int(heartbeatInfo.LanguageStatus),
+		CreatedAt:           time.Now(),
+	}
+
+	if err := s.svc.HeartbeatRepository.Insert(s.context, heartbeat); err != nil {
+		s.log.Errorf("Failed to insert heartbeat info: %w", err)
+		return nil, fmt.Errorf("failed to save heartbeat data: %w", err)
+	}
+	s.svc.Publisher.Publish(events.HeartbeatEvent(heartbeat))
+
+	UpdateDeviceState(s.context, s.svc, &database.StateUpdate{
+		IMEI:      imei,
+		At:        heartbeat.CreatedAt,
+		ACC:       accFromTerminalInfo(heartbeat.TerminalInfo),
+		Battery:   ptr(heartbeat.BatteryVoltageLevel),
+		GSMSignal: ptr(heartbeat.GSMSignal),
+	})
+
 	response := protocol.BuildCONCOXResponseHeartbeat(packet)
 	return response, nil
 }
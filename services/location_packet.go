@@ -4,36 +4,44 @@ import (
 	"context"
 	"fmt"
 	"gt06/common"
+	"gt06/database"
+	"gt06/events"
 	"gt06/protocol"
 	"gt06/services/svc"
 	"time"
 
 	"github.com/zeromicro/go-zero/core/logx"
-	"go.mongodb.org/mongo-driver/bson"
 )
 
 type LocationService struct {
 	context context.Context
 	log     logx.Logger
 	svc     *svc.ServiceContext
+	session Session
 }
 
-func NewLocationService(c context.Context, svc *svc.ServiceContext) *LocationService {
+func NewLocationService(c context.Context, svc *svc.ServiceContext, session Session) *LocationService {
 	c = logx.ContextWithFields(c, logx.LogField{
 		Key:   string(common.SpanID),
-		Value: common.GenerateSpanID(),
+		Value: common.SpanIDOf(c),
 	})
 
 	return &LocationService{
 		context: c,
 		log:     logx.WithContext(c),
 		svc:     svc,
+		session: session,
 	}
 }
 
 func (s *LocationService) ProcessPacket(packet *protocol.CONCOXPacket) (buf []byte, err error) {
 	s.log.Infof("Processing Location Packet")
 
+	imei := s.session.IMEI()
+	if imei == "" {
+		return nil, ErrNotLoggedIn
+	}
+
 	locationInfo, err := protocol.ParseCONCOXLocationInfoContent(packet.InfoContent[:])
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse location info: %w", err)
@@ -52,34 +60,51 @@ func (s *LocationService) ProcessPacket(packet *protocol.CONCOXPacket) (buf []by
 		time.UTC,
 	)
 
-	// Decode latitude and longitude from fixed-point format (decimal_degrees * 1800000)
-	latitude := float64(locationInfo.Latitude) / 1800000.0
-	longitude := float64(locationInfo.Longitude) / 1800000.0
-
-	document := bson.M{
-		"date_time":              dateTime,
-		"gps_satellites":         locationInfo.GPSSatellites,
-		"latitude":               latitude,
-		"longitude":              longitude,
-		"speed":                  locationInfo.Speed,
-		"course_status":          locationInfo.CourseStatus,
-		"mcc":                    locationInfo.MCC,
-		"mnc":                    locationInfo.MNC,
-		"lac":                    locationInfo.LAC,
-		"cell_id":                locationInfo.CellID,
-		"acc_status":             locationInfo.ACCStatus,
-		"upload_mode":            locationInfo.UploadMode,
-		"gps_real_time_reupload": locationInfo.GPSRealTimeReupload,
-		"mileage":                locationInfo.Mileage,
-		"created_at":             time.Now(),
+	latitude, longitude := decodeCoordinates(locationInfo.Latitude, locationInfo.Longitude, locationInfo.CourseStatus)
+
+	position := &database.Position{
+		IMEI:                imei,
+		DateTime:            dateTime,
+		GPSSatellites:       int(locationInfo.GPSSatellites),
+		Latitude:            latitude,
+		Longitude:           longitude,
+		Location:            database.NewGeoPoint(latitude, longitude),
+		Speed:               int(locationInfo.Speed),
+		CourseStatus:        int(locationInfo.CourseStatus),
+		MCC:                 int(locationInfo.MCC),
+		MNC:                 int(locationInfo.MNC),
+		LAC:                 int(locationInfo.LAC),
+		CellID:              int(locationInfo.CellID),
+		ACCStatus:           int(locationInfo.ACCStatus),
+		UploadMode:          int(locationInfo.UploadMode),
+		GPSRealTimeReupload: int(locationInfo.GPSRealTimeReupload),
+		Mileage:             int(locationInfo.Mileage),
+		CreatedAt:           time.Now(),
 	}
 
-	_, err = s.svc.MongoDBModel.Insert(s.context, "CONCOXLocationInfoContent", document)
-	if err != nil {
+	// the event and the state only follow a stored record
+	stored := func() {
+		s.svc.Publisher.Publish(events.PositionEvent(position))
+		UpdateDeviceState(s.context, s.svc, &database.StateUpdate{
+			IMEI:     imei,
+			At:       position.CreatedAt,
+			Position: statePosition(dateTime, latitude, longitude, position.Speed, position.CourseStatus, position.GPSSatellites),
+			ACC:      ptr(position.ACCStatus == 1),
+		})
+	}
+
+	// with batching the ack is sent once the batch holding the record is stored
+	if s.svc.PositionWriter != nil {
+		s.svc.PositionWriter.Add(s.context, position, ackWhenStored(s.log, s.session, "location data", protocol.BuildCONCOXResponseLocation(packet), stored))
+		return nil, nil
+	}
+
+	if err := s.svc.PositionRepository.Insert(s.context, position); err != nil {
 		s.log.Errorf("Failed to insert location info: %w", err)
 		return nil, fmt.Errorf("failed to save location data: %w", err)
 	}
 
+	stored()
 	s.log.Infof("Location data saved: lat=%.6f, lng=%.6f", latitude, longitude)
 
 	response := protocol.BuildCONCOXResponseLocation(packet)
@@ -4,31 +4,43 @@ import (
 	"context"
 	"fmt"
 	"gt06/common"
+	"gt06/database"
+	"gt06/events"
+	"gt06/metrics"
 	"gt06/protocol"
 	"gt06/services/svc"
 	"time"
 
 	"github.com/zeromicro/go-zero/core/logx"
-	"go.mongodb.org/mongo-driver/bson"
+	"github.com/zeromicro/go-zero/core/threading"
+)
+
+// results of the logins metric
+const (
+	loginAccepted = "accepted"
+	loginBlocked  = "blocked"
+	loginFailed   = "failed"
 )
 
 type LoginDeviceService struct {
 	context context.Context
 	log     logx.Logger
 	svc     *svc.ServiceContext
+	session Session
 }
 
-func NewLoginDeviceService(c context.Context, svc *svc.ServiceContext) *LoginDeviceService {
+func NewLoginDeviceService(c context.Context, svc *svc.ServiceContext, session Session) *LoginDeviceService {
 
 	c = logx.ContextWithFields(c, logx.LogField{
 		Key:   string(common.SpanID),
-		Value: common.GenerateSpanID(),
+		Value: common.SpanIDOf(c),
 	})
 
 	return &LoginDeviceService{
 		context: c,
 		log:     logx.WithContext(c),
 		svc:     svc,
+		session: session,
 	}
 }
 
@@ -42,6 +54,19 @@ func (s *LoginDeviceService) ProcessPacket(packet *protocol.CONCOXPacket) (buf [
 		return nil, fmt.Errorf("failed to parse login info: %w", err)
 	}
 
+	// a lookup failure lets the device in, the blocklist must not take the fleet offline. The
+	// lookup runs on the event loop, it is bounded so a slow store does not stall its connections.
+	lookupCtx, cancel := context.WithTimeout(s.context, time.Duration(s.svc.Config.Timeout)*time.Second)
+	blocked, err := s.svc.BlocklistRepository.Get(lookupCtx, infoContent.IMEI())
+	cancel()
+	if err != nil {
+		s.log.Errorf("Failed to check the blocklist: %v", err)
+	} else if blocked != nil {
+		s.log.Infof("Refusing login of blocked device %s: %s", blocked.IMEI, blocked.Reason)
+		metrics.Logins.Inc(loginBlocked)
+		return nil, ErrDeviceBlocked
+	}
+
 	// build login info
 	gmt, region, language, err := decodeRawTimeZone(infoContent.TimeZoneLanguage)
 	if err != nil {
@@ -49,34 +74,62 @@ func (s *LoginDeviceService) ProcessPacket(packet *protocol.CONCOXPacket) (buf [
 		return nil, fmt.Errorf("invalid timezone data: %w", err)
 	}
 
-	document := bson.M{
-		"terminal_id":        common.ConvertToHexString(infoContent.TerminalID[:]),
-		"model_code":         common.ConvertToHexString(infoContent.ModelCode[:]),
-		"time_zone_language": infoContent.TimeZoneLanguage,
-		"gmt":                gmt,
-		"region":             region,
-		"language":           language,
-		"created_at":         time.Now(),
+	login := &database.LoginEvent{
+		IMEI:             infoContent.IMEI(),
+		TerminalID:       common.ConvertToHexString(infoContent.TerminalID[:]),
+		ModelCode:        common.ConvertToHexString(infoContent.ModelCode[:]),
+		TimeZoneLanguage: common.ConvertToHexString(infoContent.TimeZoneLanguage[:]),
+		GMT:              gmt,
+		Region:           region,
+		Language:         language,
+		RemoteAddr:       s.session.RemoteAddr(),
+		CreatedAt:        time.Now(),
 	}
 
-	ret, err := s.svc.MongoDBModel.Insert(s.context, "CONCOXLoginInfoContent", document)
-	if err != nil {
-		s.log.Errorf("Failed to insert device login info: %w", err)
+	if err := s.svc.DeviceRepository.RecordLogin(s.context, login); err != nil {
+		s.log.Errorf("Failed to upsert device: %w", err)
+		metrics.Logins.Inc(loginFailed)
 		return nil, fmt.Errorf("failed to save device info: %w", err)
 	}
 
-	// get inserted document
-	filter := bson.M{"_id": ret.InsertedID}
-	a, err := s.svc.MongoDBModel.Get(s.context, "CONCOXLoginInfoContent", filter)
-	if err != nil {
-		s.log.Errorf("Failed to retrieve inserted device: %w", err)
-		return nil, fmt.Errorf("failed to retrieve device info: %w", err)
+	if err := s.svc.LoginEventRepository.Insert(s.context, login); err != nil {
+		s.log.Errorf("Failed to insert device login info: %w", err)
+		metrics.Logins.Inc(loginFailed)
+		return nil, fmt.Errorf("failed to save login event: %w", err)
 	}
 
-	s.log.Infof("Device login info saved: %+v", a)
+	s.session.SetIMEI(login.IMEI)
+	s.session.SetModel(login.ModelCode)
+	metrics.Logins.Inc(loginAccepted)
+	s.svc.Publisher.Publish(events.LoginEvent(login))
+	_, replayed := s.session.(ReplaySession)
+	if !replayed {
+		// a replayed login does not bring the device online, no session of it is connected
+		UpdateDeviceState(s.context, s.svc, &database.StateUpdate{
+			IMEI:         login.IMEI,
+			At:           login.CreatedAt,
+			ConnectionID: s.session.ID(),
+			RemoteAddr:   login.RemoteAddr,
+			Online:       ptr(true),
+		})
+	}
+	s.svc.Publisher.Publish(events.SessionEvent(events.TypeSessionOpened, login.IMEI, s.session.ID(), login.RemoteAddr, login.CreatedAt))
+	s.log.Infof("Device login info saved: %+v", login)
 
-	buildLoginInfo := protocol.BuildCONCOXResponseLogin(packet)
-	return buildLoginInfo, nil
+	// the ack is written here rather than returned so that commands are only sent after it
+	if err := s.session.Send(protocol.BuildCONCOXResponseLogin(packet)); err != nil {
+		metrics.AckWriteFailures.Inc()
+		return nil, fmt.Errorf("failed to send login response: %w", err)
+	}
+	if replayed {
+		return nil, nil
+	}
+	s.svc.Commands.Register(login.IMEI, s.session)
+
+	threading.GoSafe(func() {
+		NewCommandService(s.context, s.svc).FlushQueued(login.IMEI)
+	})
+	return nil, nil
 }
 
 func decodeRawTimeZone(data [2]byte) (float64, string, string, error) {
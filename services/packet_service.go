@@ -1,7 +1,75 @@
 package services
 
-import "gt06/protocol"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gt06/protocol"
+	"gt06/services/svc"
+
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+var (
+	ErrNotLoggedIn   = errors.New("device is not logged in")
+	ErrDeviceBlocked = errors.New("device is blocked")
+)
 
 type PacketService interface {
 	ProcessPacket(packet *protocol.CONCOXPacket) ([]byte, error)
 }
+
+// NewPacketService returns the service of a protocol number, nil when the protocol is not supported
+func NewPacketService(c context.Context, svc *svc.ServiceContext, session Session, protocolNumber uint8) PacketService {
+	switch protocolNumber {
+	case protocol.ProtocolLogin:
+		return NewLoginDeviceService(c, svc, session)
+	case protocol.ProtocolHeartbeat, protocol.ProtocolHeartbeatAlt:
+		return NewHeartbeatService(c, svc, session)
+	case protocol.ProtocolLocation, protocol.ProtocolLocationUTC:
+		return NewLocationService(c, svc, session)
+	case protocol.ProtocolAlarm:
+		return NewAlarmService(c, svc, session)
+	case protocol.ProtocolCommandReply, protocol.ProtocolCommandReplyAlt:
+		return NewCommandReplyService(c, svc, session)
+	default:
+		return nil
+	}
+}
+
+// Session is the state of a device connection shared by its packet services
+type Session interface {
+	// ID identifies the connection
+	ID() string
+	IMEI() string
+	SetIMEI(imei string)
+	// SetModel records the model code reported at login
+	SetModel(model string)
+	RemoteAddr() string
+	// Send writes out to the device outside of a packet ack, it is safe to call from any goroutine
+	Send(out []byte) error
+	// Reply completes a packet whose ProcessPacket returned no ack: out is sent as the ack,
+	// or the connection is closed when err is set so that the device resends the packet
+	Reply(out []byte, err error)
+}
+
+// ReplaySession is a session of replayed traffic, it is not registered for commands since no device answers them
+// and its login does not bring the device online
+type ReplaySession interface {
+	Session
+	Replayed()
+}
+
+// ackWhenStored returns the completion of a batched insert, calling stored and acking with
+// response once the record is stored
+func ackWhenStored(log logx.Logger, session Session, what string, response []byte, stored func()) func(err error) {
+	return func(err error) {
+		if err != nil {
+			log.Errorf("Failed to insert %s: %v", what, err)
+			session.Reply(nil, fmt.Errorf("failed to save %s: %w", what, err))
+			return
+		}
+		stored()
+		session.Reply(response, nil)
+	}
+}
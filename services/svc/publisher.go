@@ -0,0 +1,16 @@
+package svc
+
+import "gt06/events"
+
+// Publisher emits the typed events of the packet services: login, session.opened and
+// session.closed, position, alarm, heartbeat and state.changed. Publish must not block.
+type Publisher interface {
+	Publish(e events.Event)
+}
+
+// Discard is a Publisher dropping every event, for the processes that must not emit them
+var Discard Publisher = discard{}
+
+type discard struct{}
+
+func (discard) Publish(events.Event) {}
@@ -2,57 +2,393 @@ package svc
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"gt06/commands"
 	"gt06/config"
 	"gt06/database"
+	"gt06/events"
+	"gt06/health"
+	"gt06/journal"
+	"gt06/protocol"
+	"gt06/sinks"
+	"gt06/spool"
+	"gt06/webhook"
 	"time"
 
 	"github.com/gocql/gocql"
 	"github.com/zeromicro/go-zero/core/logx"
+	"github.com/zeromicro/go-zero/core/proc"
+	"github.com/zeromicro/go-zero/core/threading"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type ServiceContext struct {
-	Config        config.Config
-	MongoDBModel  database.MongoDBModel
-	ScyllaDBModel database.ScyllaDBModel
+	Config                config.Config
+	DeviceRepository      database.DeviceRepository
+	LoginEventRepository  database.LoginEventRepository
+	PositionRepository    database.PositionRepository
+	AlarmRepository       database.AlarmRepository
+	HeartbeatRepository   database.HeartbeatRepository
+	DeviceStateRepository database.DeviceStateRepository
+	ScyllaDBModel         database.ScyllaDBModel
+	Spool                 *spool.Spool
+	Events                *events.Hub
+	Publisher             Publisher // the services publish their events through it, to Events by default
+	CommandRepository     database.CommandRepository
+	Commands              *commands.Dispatcher
+	BlocklistRepository   database.BlocklistRepository
+	Health                *health.Checker
+	Journal               *journal.Journal    // nil when the frame journal is disabled
+	Webhooks              *webhook.Dispatcher // nil when webhooks are disabled
+
+	// PositionWriter and AlarmWriter batch inserts across sessions, nil when batching is disabled
+	PositionWriter *database.BatchWriter[database.Position]
+	AlarmWriter    *database.BatchWriter[database.Alarm]
 }
 
 func NewServiceContext(c config.Config) *ServiceContext {
 	svc := &ServiceContext{
-		Config: c,
+		Config:   c,
+		Events:   events.NewHub(c.Events),
+		Commands: commands.NewDispatcher(),
+		Health:   health.NewChecker(c.Health),
 	}
+	svc.Publisher = svc.Events
+	proc.AddShutdownListener(svc.Events.Close)
 
-	// Initialize MongoDB if configured
-	if c.MongoURI != "" {
-		client, err := initMongoClient(c.MongoURI)
-		if err != nil {
-			logx.Errorf("Failed to initialize MongoClient: %v", err)
-		} else {
-			dbName := c.DBName
-			if dbName == "" {
-				dbName = "gt06"
-			}
-			svc.MongoDBModel = database.NewMongoDBModel(client, dbName)
+	// Initialize ScyllaDB if configured
+	if len(c.ScyllaHosts) > 0 {
+		svc.ScyllaDBModel = initScylla(c)
+	}
+
+	repos := initRepositories(c, svc)
+	svc.DeviceRepository = repos.Devices
+	svc.LoginEventRepository = repos.Logins
+	svc.PositionRepository = repos.Positions
+	svc.AlarmRepository = repos.Alarms
+	svc.HeartbeatRepository = repos.Heartbeats
+	svc.DeviceStateRepository = repos.States
+	svc.CommandRepository = repos.Commands
+	svc.BlocklistRepository = repos.Blocklist
+
+	for i := range c.Commands.Profiles {
+		protocol.RegisterCommandProfile(&c.Commands.Profiles[i])
+	}
+	startCommandExpiry(svc.CommandRepository, time.Duration(c.Commands.ExpireInterval)*time.Second)
+
+	if c.Batch.Enabled {
+		svc.PositionWriter = database.NewBatchWriter("positions", c.Batch, repos.Positions.InsertMany)
+		svc.AlarmWriter = database.NewBatchWriter("alarms", c.Batch, repos.Alarms.InsertMany)
+		proc.AddShutdownListener(func() {
+			svc.PositionWriter.Close()
+			svc.AlarmWriter.Close()
+		})
+	}
+
+	if c.Journal.Enabled {
+		svc.Journal = initJournal(c.Journal)
+	}
+
+	initSinks(c.Sinks, svc.Events)
+
+	if c.Webhooks.Enabled {
+		svc.Webhooks = initWebhooks(c.Webhooks, svc.Events)
+	}
+
+	initHealth(c, svc)
+
+	return svc
+}
+
+// MarkAllOffline records that no session survived the restart of the GT06 listener
+func (svc *ServiceContext) MarkAllOffline() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := svc.DeviceStateRepository.MarkAllOffline(ctx); err != nil {
+		logx.Errorf("Failed to mark devices offline: %v", err)
+	}
+}
+
+// initJournal opens the raw frame journal, frames are not journaled when it cannot be opened
+func initJournal(c journal.Conf) *journal.Journal {
+	j, err := journal.Open(c)
+	if err != nil {
+		logx.Errorf("Failed to open frame journal: %v", err)
+		return nil
+	}
+
+	proc.AddShutdownListener(func() {
+		if err := j.Close(); err != nil {
+			logx.Errorf("Failed to close frame journal: %v", err)
 		}
+	})
+	return j
+}
+
+// initSinks publishes the events of hub to the sinks enabled in c, none are when one fails to open
+func initSinks(c sinks.Conf, hub *events.Hub) {
+	opened, err := sinks.Open(c)
+	if err != nil {
+		logx.Errorf("Failed to open event sinks: %v", err)
+		return
 	}
 
-	// Initialize ScyllaDB if configured
-	if len(c.ScyllaHosts) > 0 {
-		consistency := gocql.LocalOne // Default consistency
-		if c.ScyllaConsistency != "" {
-			parsed := gocql.ParseConsistency(c.ScyllaConsistency)
-			consistency = parsed
+	for _, sink := range opened {
+		hub.Listen(sink.Publish)
+		proc.AddShutdownListener(func() {
+			if err := sink.Close(); err != nil {
+				logx.Errorf("Failed to close %s event sink: %v", sink.Name(), err)
+			}
+		})
+	}
+}
+
+// initWebhooks starts delivering the events of hub to the webhook endpoints, none are delivered
+// when the configuration is invalid
+func initWebhooks(c webhook.Conf, hub *events.Hub) *webhook.Dispatcher {
+	d, err := webhook.NewDispatcher(c)
+	if err != nil {
+		logx.Errorf("Failed to start webhooks: %v", err)
+		return nil
+	}
+
+	hub.Listen(d.Notify)
+	proc.AddShutdownListener(d.Close)
+	return d
+}
+
+// initHealth registers the spool backlog and starts pinging the storage probes registered by initRepositories
+func initHealth(c config.Config, svc *ServiceContext) {
+	if svc.Spool != nil {
+		backlog := c.Health.MaxSpoolBacklog
+		svc.Health.AddReadiness("spool", backlog > 0, func() (any, error) {
+			stats := svc.Spool.Stats()
+			if backlog > 0 && stats.Depth > backlog {
+				return stats, fmt.Errorf("spool backlog of %d records exceeds %d", stats.Depth, backlog)
+			}
+			return stats, nil
+		})
+	}
+
+	svc.Health.Start()
+	proc.AddShutdownListener(svc.Health.Stop)
+}
+
+// initScylla connects to ScyllaDB and applies the keyspace and table migrations,
+// it returns nil when the cluster cannot be used.
+func initScylla(c config.Config) database.ScyllaDBModel {
+	consistency := gocql.LocalOne // Default consistency
+	if c.ScyllaConsistency != "" {
+		parsed := gocql.ParseConsistency(c.ScyllaConsistency)
+		consistency = parsed
+	}
+
+	if err := database.CreateScyllaKeyspace(c.ScyllaHosts, c.ScyllaKeyspace, c.ScyllaReplication); err != nil {
+		logx.Errorf("Failed to create ScyllaDB keyspace: %v", err)
+		return nil
+	}
+
+	scyllaModel, err := database.NewScyllaDBModel(c.ScyllaHosts, c.ScyllaKeyspace, consistency)
+	if err != nil {
+		logx.Errorf("Failed to initialize ScyllaDBModel: %v", err)
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if err := database.MigrateScylla(ctx, scyllaModel.Session()); err != nil {
+		logx.Errorf("Failed to migrate ScyllaDB schema: %v", err)
+		scyllaModel.Close()
+		return nil
+	}
+
+	return scyllaModel
+}
+
+// initRepositories selects the storage backend. The in-memory repositories are also used when
+// MongoDB is selected but cannot be set up, so the services never see a nil repository.
+func initRepositories(c config.Config, svc *ServiceContext) database.Repositories {
+	if c.StorageBackend == database.StorageMemory {
+		logx.Info("Using in-memory storage, data is not persisted")
+		return memoryRepositories()
+	}
+
+	// the server is never ready on the fallback, the data would not be persisted
+	required := c.Health.RequireStorage
+	if c.MongoURI == "" {
+		logx.Error("MongoURI is not configured, falling back to in-memory storage")
+		svc.Health.AddProbe("mongo", required, health.Unavailable(errors.New("MongoURI is not configured")))
+		return memoryRepositories()
+	}
+
+	client, err := initMongoClient(c.MongoURI)
+	if err != nil {
+		logx.Errorf("Failed to initialize MongoClient, falling back to in-memory storage: %v", err)
+		svc.Health.AddProbe("mongo", required, health.Unavailable(err))
+		return memoryRepositories()
+	}
+
+	dbName := c.DBName
+	if dbName == "" {
+		dbName = "gt06"
+	}
+	db := client.Database(dbName)
+	initMongoIndexes(c, db)
+	repos := database.NewMongoRepositories(db)
+	probes := []spool.Probe{database.PingMongo(db)}
+	svc.Health.AddProbe("mongo", required, database.PingMongo(db))
+
+	// Route the high-volume time series to ScyllaDB where configured
+	if c.PositionBackend != database.BackendMongo || c.HeartbeatBackend != database.BackendMongo {
+		if svc.ScyllaDBModel == nil {
+			logx.Error("ScyllaDB is not available, storing positions and heartbeats in MongoDB")
+			svc.Health.AddProbe("scylla", required, health.Unavailable(errors.New("ScyllaDB could not be set up")))
+		} else {
+			session := svc.ScyllaDBModel.Session()
+			repos.Positions = selectBackend(c.PositionBackend, repos.Positions,
+				database.NewScyllaPositionRepository(session), database.NewTeePositionRepository)
+			repos.Heartbeats = selectBackend(c.HeartbeatBackend, repos.Heartbeats,
+				database.NewScyllaHeartbeatRepository(session), database.NewTeeHeartbeatRepository)
+			probes = append(probes, svc.ScyllaDBModel.Ping)
+			svc.Health.AddProbe("scylla", required, svc.ScyllaDBModel.Ping)
 		}
-		scyllaModel, err := database.NewScyllaDBModel(c.ScyllaHosts, c.ScyllaKeyspace, consistency)
+	}
+
+	// measure the backends themselves, not the state cache and the spool
+	repos = database.NewInstrumentedRepositories(repos)
+	repos.States = initDeviceStates(repos.States)
+
+	// Buffer inserts on local disk while MongoDB is unavailable
+	if c.Spool.Enabled {
+		sp, err := spool.Open(c.Spool)
 		if err != nil {
-			logx.Errorf("Failed to initialize ScyllaDBModel: %v", err)
-		} else {
-			svc.ScyllaDBModel = scyllaModel
+			logx.Errorf("Failed to open spool: %v", err)
+			return repos
 		}
+
+		svc.Spool = sp
+		proc.AddShutdownListener(func() {
+			if err := sp.Close(); err != nil {
+				logx.Errorf("Failed to close spool: %v", err)
+			}
+		})
+		repos = database.NewSpooledRepositories(repos, sp, pingAll(probes...), time.Duration(c.Timeout)*time.Second)
 	}
 
-	return svc
+	return repos
+}
+
+// memoryRepositories returns the instrumented in-memory repositories
+func memoryRepositories() database.Repositories {
+	return database.NewInstrumentedRepositories(database.NewMemoryRepositories())
+}
+
+// initMongoIndexes applies the declared indexes and retention TTLs and logs what changed or is missing.
+// It is skipped when MongoDB is not reachable so that startup is not held up.
+func initMongoIndexes(c config.Config, db *mongo.Database) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	pingCtx, pingCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer pingCancel()
+	if err := database.PingMongo(db)(pingCtx); err != nil {
+		logx.Errorf("Skipping MongoDB index check, MongoDB is not reachable: %v", err)
+		return
+	}
+
+	database.LogIndexReport(database.EnsureMongoIndexes(ctx, db, c.MongoIndexes))
+
+	if c.MongoIndexes.BackfillLocations {
+		threading.GoSafe(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+			defer cancel()
+
+			count, err := database.BackfillLocations(ctx, db)
+			if err != nil {
+				logx.Errorf("Failed to backfill GeoJSON locations: %v", err)
+				return
+			}
+			if count > 0 {
+				logx.Infof("Backfilled GeoJSON location of %d documents", count)
+			}
+		})
+	}
+}
+
+// initDeviceStates keeps the device states in memory, falling back to updating MongoDB
+// directly when they cannot be loaded
+func initDeviceStates(persisted database.DeviceStateRepository) database.DeviceStateRepository {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	states, stop, err := database.NewCachedDeviceStateRepository(ctx, persisted, time.Second)
+	if err != nil {
+		logx.Errorf("Failed to load device states, updating them in MongoDB directly: %v", err)
+		return persisted
+	}
+	proc.AddShutdownListener(stop)
+	return states
+}
+
+// startCommandExpiry marks the queued commands that expired every interval until shutdown
+func startCommandExpiry(repo database.CommandRepository, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	stop := make(chan struct{})
+	proc.AddShutdownListener(func() {
+		close(stop)
+	})
+
+	threading.GoSafe(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), interval)
+				count, err := repo.ExpireQueued(ctx, time.Now())
+				cancel()
+				if err != nil {
+					logx.Errorf("Failed to expire queued commands: %v", err)
+				} else if count > 0 {
+					logx.Infof("Expired %d queued commands", count)
+				}
+			}
+		}
+	})
+}
+
+// selectBackend picks the repository for a data type, writing to both for BackendBoth
+func selectBackend[T any](backend string, mongoRepo, scyllaRepo T, tee func(primary, secondary T) T) T {
+	switch backend {
+	case database.BackendScylla:
+		return scyllaRepo
+	case database.BackendBoth:
+		return tee(mongoRepo, scyllaRepo)
+	default:
+		return mongoRepo
+	}
+}
+
+// pingAll reports healthy only when every probe succeeds
+func pingAll(probes ...spool.Probe) spool.Probe {
+	return func(ctx context.Context) error {
+		for _, probe := range probes {
+			if err := probe(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 }
 
 // initMongoClient sets up the MongoDB client
@@ -68,8 +404,11 @@ func initMongoClient(mongoURI string) (*mongo.Client, error) {
 		return nil, err
 	}
 
+	// the driver keeps reconnecting in the background, so an unreachable server
+	// at startup is not fatal; writes fail (and are spooled) until it is back
 	if err := client.Ping(ctx, nil); err != nil {
-		return nil, err
+		logx.Errorf("MongoDB is not reachable yet: %v", err)
+		return client, nil
 	}
 
 	logx.Info("Connected to MongoDB")
@@ -0,0 +1,58 @@
+package sinks
+
+// Conf configures the sinks the events are published to
+type Conf struct {
+	Topic  string            `json:"Topic,optional" yaml:"Topic"`   // pattern of the topics, with {imei} and {type}
+	Topics map[string]string `json:"Topics,optional" yaml:"Topics"` // patterns by event type, overriding Topic
+	Events []string          `json:"Events,optional" yaml:"Events"` // event types published, every type when empty
+	Buffer int               `json:"Buffer,optional" yaml:"Buffer"` // events queued per sink before they are dropped
+	File   FileConf          `json:"File,optional" yaml:"File"`
+	Stdout StdoutConf        `json:"Stdout,optional" yaml:"Stdout"`
+	MQTT   MQTTConf          `json:"MQTT,optional" yaml:"MQTT"`
+}
+
+// FileConf configures the NDJSON file sink
+type FileConf struct {
+	Enabled bool   `json:"Enabled,optional" yaml:"Enabled"`
+	Path    string `json:"Path,optional" yaml:"Path"` // appended to, one event per line
+}
+
+// StdoutConf configures the NDJSON sink on the standard output
+type StdoutConf struct {
+	Enabled bool `json:"Enabled,optional" yaml:"Enabled"`
+}
+
+// MQTTConf configures the MQTT 3.1.1 sink
+type MQTTConf struct {
+	Enabled     bool   `json:"Enabled,optional" yaml:"Enabled"`
+	Broker      string `json:"Broker,optional" yaml:"Broker"` // tcp://host:port, or ssl://host:port for TLS
+	ClientID    string `json:"ClientID,optional" yaml:"ClientID"`
+	Username    string `json:"Username,optional" yaml:"Username"`
+	Password    string `json:"Password,optional" yaml:"Password"`
+	QoS         int    `json:"QoS,optional,options=0|1" yaml:"QoS"`
+	Retain      bool   `json:"Retain,optional" yaml:"Retain"`
+	KeepAlive   int    `json:"KeepAlive,optional" yaml:"KeepAlive"`     // seconds between pings
+	Timeout     int    `json:"Timeout,optional" yaml:"Timeout"`         // seconds to connect, write or get an ack
+	MaxInflight int    `json:"MaxInflight,optional" yaml:"MaxInflight"` // QoS 1 messages sent and not yet acked
+}
+
+// DefaultConf returns a Conf with default values
+func DefaultConf() Conf {
+	return Conf{
+		Topic:  "gt06/{imei}/{type}",
+		Buffer: 4096,
+		File: FileConf{
+			Enabled: false,
+			Path:    "data/events.ndjson",
+		},
+		MQTT: MQTTConf{
+			Enabled:     false,
+			Broker:      "tcp://localhost:1883",
+			ClientID:    "gt06",
+			QoS:         1,
+			KeepAlive:   30,
+			Timeout:     10,
+			MaxInflight: 64,
+		},
+	}
+}
@@ -0,0 +1,23 @@
+package sinks
+
+import "github.com/zeromicro/go-zero/core/metric"
+
+const metricNamespace = "gt06"
+
+var (
+	metricPublished = metric.NewCounterVec(&metric.CounterVecOpts{
+		Namespace: metricNamespace,
+		Subsystem: "sink",
+		Name:      "published_total",
+		Help:      "Events written by sink.",
+		Labels:    []string{"sink"},
+	})
+
+	metricDropped = metric.NewCounterVec(&metric.CounterVecOpts{
+		Namespace: metricNamespace,
+		Subsystem: "sink",
+		Name:      "dropped_total",
+		Help:      "Events a sink could not write, by reason.",
+		Labels:    []string{"sink", "reason"},
+	})
+)
@@ -0,0 +1,504 @@
+package sinks
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/logx"
+	"github.com/zeromicro/go-zero/core/threading"
+)
+
+// MQTT 3.1.1 control packet types, the high nibble of the fixed header
+const (
+	mqttConnect    = 0x10
+	mqttConnAck    = 0x20
+	mqttPublish    = 0x30
+	mqttPubAck     = 0x40
+	mqttPingReq    = 0xC0
+	mqttPingResp   = 0xD0
+	mqttDisconnect = 0xE0
+)
+
+// maxReconnectDelay bounds the doubling delay between connection attempts
+const maxReconnectDelay = time.Minute
+
+var errConnectionLost = errors.New("connection to the broker was lost")
+
+// connAckErrors are the return codes of a refused connection
+var connAckErrors = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "client identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+// mqttWriter publishes the messages to an MQTT broker, reconnecting in the background of the
+// writes. With QoS 1, up to MaxInflight messages wait for their ack and are sent again after a
+// reconnection, so they are delivered at least once.
+type mqttWriter struct {
+	conf    MQTTConf
+	addr    string
+	tls     *tls.Config // nil for plain TCP
+	stopped chan struct{}
+
+	// owned by the sink goroutine
+	conn     net.Conn
+	lost     chan struct{} // closed by the reader of conn when it fails
+	lastPing time.Time
+	retryAt  time.Time
+	delay    time.Duration
+	nextID   uint16
+
+	mu       sync.Mutex
+	inflight []inflight    // QoS 1 messages waiting for their ack, oldest first
+	acked    chan struct{} // signalled when an ack frees a slot
+}
+
+type inflight struct {
+	id     uint16
+	packet []byte
+}
+
+func newMQTTWriter(c MQTTConf) (*mqttWriter, error) {
+	defaults := DefaultConf().MQTT
+	if c.ClientID == "" {
+		c.ClientID = defaults.ClientID
+	}
+	if c.KeepAlive <= 0 {
+		c.KeepAlive = defaults.KeepAlive
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = defaults.Timeout
+	}
+	if c.MaxInflight <= 0 {
+		c.MaxInflight = defaults.MaxInflight
+	}
+	if c.QoS != 0 && c.QoS != 1 {
+		return nil, fmt.Errorf("MQTT QoS must be 0 or 1, not %d", c.QoS)
+	}
+
+	u, err := url.Parse(c.Broker)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid MQTT broker %q", c.Broker)
+	}
+	w := &mqttWriter{
+		conf:    c,
+		addr:    u.Host,
+		stopped: make(chan struct{}),
+		acked:   make(chan struct{}, 1),
+	}
+	port := "1883"
+	switch u.Scheme {
+	case "tcp", "mqtt":
+	case "ssl", "tls", "mqtts":
+		port = "8883"
+		w.tls = &tls.Config{ServerName: u.Hostname()}
+	default:
+		return nil, fmt.Errorf("MQTT broker %q must be tcp:// or ssl://", c.Broker)
+	}
+	if u.Port() == "" {
+		w.addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	return w, nil
+}
+
+func (w *mqttWriter) timeout() time.Duration {
+	return time.Duration(w.conf.Timeout) * time.Second
+}
+
+// write publishes m, waiting for the broker to be reachable until the sink closes
+func (w *mqttWriter) write(m *Message) error {
+	payload, err := json.Marshal(&m.Event)
+	if err != nil {
+		return err
+	}
+
+	for {
+		if err := w.connected(); err != nil {
+			return err
+		}
+		err := w.publish(m.Topic, payload)
+		if err == nil {
+			return nil
+		}
+		logx.Errorf("[SINK] mqtt: %v", err)
+		w.disconnect()
+		if errors.Is(err, errQueued) {
+			return nil
+		}
+	}
+}
+
+// connected connects to the broker unless it is, waiting for the delay after a failed attempt
+func (w *mqttWriter) connected() error {
+	for w.conn == nil {
+		if wait := time.Until(w.retryAt); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-w.stopped:
+				timer.Stop()
+				return errStopped
+			case <-timer.C:
+			}
+		}
+		select {
+		case <-w.stopped:
+			return errStopped
+		default:
+		}
+
+		if err := w.connect(); err != nil {
+			w.delay = min(max(2*w.delay, time.Second), maxReconnectDelay)
+			w.retryAt = time.Now().Add(w.delay)
+			logx.Errorf("[SINK] mqtt: failed to connect to %s, retrying in %s: %v", w.addr, w.delay, err)
+		}
+	}
+	return nil
+}
+
+// connect opens a clean session and sends again the messages that were not acked
+func (w *mqttWriter) connect() error {
+	dialer := &net.Dialer{Timeout: w.timeout()}
+	var conn net.Conn
+	var err error
+	if w.tls != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", w.addr, w.tls)
+	} else {
+		conn, err = dialer.Dial("tcp", w.addr)
+	}
+	if err != nil {
+		return err
+	}
+
+	_ = conn.SetDeadline(time.Now().Add(w.timeout()))
+	if _, err := conn.Write(w.connectPacket()); err != nil {
+		conn.Close()
+		return err
+	}
+	r := bufio.NewReader(conn)
+	kind, body, err := readPacket(r)
+	switch {
+	case err != nil:
+		conn.Close()
+		return fmt.Errorf("no CONNACK: %w", err)
+	case kind != mqttConnAck || len(body) != 2:
+		conn.Close()
+		return fmt.Errorf("expected a CONNACK, got packet type 0x%02X", kind)
+	case body[1] != 0:
+		conn.Close()
+		if reason, ok := connAckErrors[body[1]]; ok {
+			return fmt.Errorf("connection refused: %s", reason)
+		}
+		return fmt.Errorf("connection refused with code %d", body[1])
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	w.conn = conn
+	w.lost = make(chan struct{})
+	w.lastPing = time.Now()
+	w.delay = 0
+	lost := w.lost
+	threading.GoSafe(func() {
+		w.read(conn, r, lost)
+	})
+	logx.Infof("[SINK] mqtt: connected to %s as %s", w.addr, w.conf.ClientID)
+
+	w.mu.Lock()
+	resend := make([][]byte, 0, len(w.inflight))
+	for _, m := range w.inflight {
+		m.packet[0] |= 0x08 // DUP
+		resend = append(resend, m.packet)
+	}
+	w.mu.Unlock()
+	for _, packet := range resend {
+		if err := w.send(packet); err != nil {
+			w.disconnect()
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *mqttWriter) connectPacket() []byte {
+	var flags byte = 0x02 // clean session
+	payload := appendString(nil, w.conf.ClientID)
+	if w.conf.Username != "" {
+		flags |= 0x80
+		payload = appendString(payload, w.conf.Username)
+		if w.conf.Password != "" {
+			flags |= 0x40
+			payload = appendString(payload, w.conf.Password)
+		}
+	}
+
+	body := appendString(nil, "MQTT")
+	body = append(body, 4, flags) // protocol level 4 is MQTT 3.1.1
+	body = binary.BigEndian.AppendUint16(body, uint16(min(w.conf.KeepAlive, 0xFFFF)))
+	body = append(body, payload...)
+	return appendPacket(mqttConnect, body)
+}
+
+// publish sends a PUBLISH packet, with QoS 1 once a slot is free for its ack
+func (w *mqttWriter) publish(topic string, payload []byte) error {
+	header := byte(mqttPublish) | byte(w.conf.QoS)<<1
+	if w.conf.Retain {
+		header |= 0x01
+	}
+	body := appendString(nil, topic)
+
+	if w.conf.QoS == 0 {
+		return w.send(appendPacket(header, append(body, payload...)))
+	}
+
+	if err := w.waitSlot(); err != nil {
+		return err
+	}
+	w.nextID++
+	if w.nextID == 0 {
+		w.nextID = 1
+	}
+	body = binary.BigEndian.AppendUint16(body, w.nextID)
+	packet := appendPacket(header, append(body, payload...))
+
+	// queued before it is sent so that it is sent again when the connection fails
+	w.mu.Lock()
+	w.inflight = append(w.inflight, inflight{id: w.nextID, packet: packet})
+	w.mu.Unlock()
+	if err := w.send(packet); err != nil {
+		return fmt.Errorf("%w, %w", err, errQueued)
+	}
+	return nil
+}
+
+// errQueued marks a failed write whose message will be sent again on the next connection
+var errQueued = errors.New("queued for the next connection")
+
+// waitSlot waits until fewer than MaxInflight messages wait for their ack
+func (w *mqttWriter) waitSlot() error {
+	timer := time.NewTimer(w.timeout())
+	defer timer.Stop()
+
+	for {
+		w.mu.Lock()
+		free := len(w.inflight) < w.conf.MaxInflight
+		w.mu.Unlock()
+		if free {
+			return nil
+		}
+
+		select {
+		case <-w.acked:
+		case <-w.lost:
+			return errConnectionLost
+		case <-timer.C:
+			return fmt.Errorf("no ack within %s", w.timeout())
+		}
+	}
+}
+
+func (w *mqttWriter) send(packet []byte) error {
+	select {
+	case <-w.lost:
+		return errConnectionLost
+	default:
+	}
+
+	_ = w.conn.SetWriteDeadline(time.Now().Add(w.timeout()))
+	_, err := w.conn.Write(packet)
+	return err
+}
+
+// read handles the acks and ping responses of conn until it fails
+func (w *mqttWriter) read(conn net.Conn, r *bufio.Reader, lost chan struct{}) {
+	defer close(lost)
+
+	for {
+		// a ping is sent every KeepAlive seconds, the broker answering within the next
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Duration(w.conf.KeepAlive) * time.Second))
+		kind, body, err := readPacket(r)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				logx.Errorf("[SINK] mqtt: %v: %v", errConnectionLost, err)
+			}
+			return
+		}
+
+		switch kind {
+		case mqttPubAck:
+			if len(body) == 2 {
+				w.ack(binary.BigEndian.Uint16(body))
+			}
+		case mqttPingResp:
+		default:
+			logx.Errorf("[SINK] mqtt: unexpected packet type 0x%02X", kind)
+		}
+	}
+}
+
+func (w *mqttWriter) ack(id uint16) {
+	w.mu.Lock()
+	for i, m := range w.inflight {
+		if m.id == id {
+			w.inflight = append(w.inflight[:i], w.inflight[i+1:]...)
+			break
+		}
+	}
+	w.mu.Unlock()
+
+	select {
+	case w.acked <- struct{}{}:
+	default:
+	}
+}
+
+func (w *mqttWriter) disconnect() {
+	if w.conn == nil {
+		return
+	}
+	w.conn.Close()
+	<-w.lost
+	w.conn = nil
+	w.retryAt = time.Now().Add(w.delay)
+}
+
+// tick pings the broker every KeepAlive seconds and reconnects to send again the messages that
+// were not acked
+func (w *mqttWriter) tick() error {
+	if w.conn != nil {
+		select {
+		case <-w.lost:
+			w.disconnect()
+		default:
+			if time.Since(w.lastPing) < time.Duration(w.conf.KeepAlive)*time.Second {
+				return nil
+			}
+			w.lastPing = time.Now()
+			if err := w.send([]byte{mqttPingReq, 0}); err != nil {
+				w.disconnect()
+				return fmt.Errorf("failed to ping: %w", err)
+			}
+			return nil
+		}
+	}
+
+	w.mu.Lock()
+	pending := len(w.inflight)
+	w.mu.Unlock()
+	if pending > 0 && !time.Now().Before(w.retryAt) {
+		if err := w.connect(); err != nil {
+			w.delay = min(max(2*w.delay, time.Second), maxReconnectDelay)
+			w.retryAt = time.Now().Add(w.delay)
+			return fmt.Errorf("failed to connect to %s: %w", w.addr, err)
+		}
+	}
+	return nil
+}
+
+func (w *mqttWriter) stop() {
+	close(w.stopped)
+}
+
+// close waits up to Timeout for the pending acks and disconnects
+func (w *mqttWriter) close() error {
+	if w.conn == nil {
+		return w.unacked()
+	}
+
+	deadline := time.NewTimer(w.timeout())
+	defer deadline.Stop()
+	for waiting := true; waiting; {
+		w.mu.Lock()
+		waiting = len(w.inflight) > 0
+		w.mu.Unlock()
+		if !waiting {
+			break
+		}
+		select {
+		case <-w.acked:
+		case <-w.lost:
+			waiting = false
+		case <-deadline.C:
+			waiting = false
+		}
+	}
+
+	_ = w.send([]byte{mqttDisconnect, 0})
+	w.disconnect()
+	return w.unacked()
+}
+
+// unacked reports the messages lost on close
+func (w *mqttWriter) unacked() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.inflight) == 0 {
+		return nil
+	}
+	metricDropped.Add(float64(len(w.inflight)), "mqtt", "closed")
+	return fmt.Errorf("%d messages were not acked by the broker", len(w.inflight))
+}
+
+// appendPacket appends the fixed header of a packet to its type and flags, then its body
+func appendPacket(header byte, body []byte) []byte {
+	packet := make([]byte, 0, 5+len(body))
+	packet = append(packet, header)
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if n == 0 {
+			break
+		}
+	}
+	return append(packet, body...)
+}
+
+// appendString appends a length-prefixed UTF-8 string
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// readPacket reads a packet, returning its type without the flags and its body
+func readPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		if i == 4 {
+			return 0, nil, errors.New("malformed remaining length")
+		}
+		length += int(b&0x7F) * multiplier
+		multiplier *= 128
+		if b&0x80 == 0 {
+			break
+		}
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header & 0xF0, body, nil
+}
@@ -0,0 +1,174 @@
+package sinks
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"gt06/events"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPacketRemainingLength(t *testing.T) {
+	tests := []struct {
+		length      int
+		lengthBytes int
+	}{
+		{0, 1},
+		{127, 1},
+		{128, 2},
+		{16383, 2},
+		{16384, 3},
+		{2097151, 3},
+		{2097152, 4},
+	}
+
+	for _, tt := range tests {
+		body := bytes.Repeat([]byte{0xA5}, tt.length)
+		packet := appendPacket(mqttPublish|0x02, body)
+		if got := len(packet) - 1 - tt.length; got != tt.lengthBytes {
+			t.Errorf("length %d is encoded on %d bytes, want %d", tt.length, got, tt.lengthBytes)
+		}
+
+		kind, got, err := readPacket(bufio.NewReader(bytes.NewReader(packet)))
+		if err != nil {
+			t.Fatalf("length %d: readPacket: %v", tt.length, err)
+		}
+		if kind != mqttPublish {
+			t.Errorf("length %d: packet type 0x%02X, want 0x%02X", tt.length, kind, mqttPublish)
+		}
+		if !bytes.Equal(got, body) {
+			t.Errorf("length %d: body of %d bytes read back", tt.length, len(got))
+		}
+	}
+}
+
+func TestReadPacketMalformedLength(t *testing.T) {
+	packet := []byte{mqttPubAck, 0xFF, 0xFF, 0xFF, 0xFF, 0x01}
+	if _, _, err := readPacket(bufio.NewReader(bytes.NewReader(packet))); err == nil {
+		t.Fatal("a remaining length of 5 bytes was accepted")
+	}
+}
+
+// stubBroker accepts connections, answers their CONNECT and hands the packets they send to conns
+type stubBroker struct {
+	t     *testing.T
+	ln    net.Listener
+	conns chan *stubConn
+}
+
+type stubConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func newStubBroker(t *testing.T) *stubBroker {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &stubBroker{t: t, ln: ln, conns: make(chan *stubConn, 4)}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c := &stubConn{Conn: conn, r: bufio.NewReader(conn)}
+			if kind, _, err := readPacket(c.r); err != nil || kind != mqttConnect {
+				conn.Close()
+				continue
+			}
+			if _, err := conn.Write([]byte{mqttConnAck, 2, 0, 0}); err != nil {
+				conn.Close()
+				continue
+			}
+			b.conns <- c
+		}
+	}()
+	return b
+}
+
+func (b *stubBroker) accept() *stubConn {
+	b.t.Helper()
+
+	select {
+	case c := <-b.conns:
+		b.t.Cleanup(func() { c.Close() })
+		return c
+	case <-time.After(5 * time.Second):
+		b.t.Fatal("no connection to the broker")
+		return nil
+	}
+}
+
+// readPublish reads a PUBLISH packet and returns its flags and packet id
+func (c *stubConn) readPublish(t *testing.T) (byte, uint16) {
+	t.Helper()
+
+	_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	header, err := c.r.Peek(1)
+	if err != nil {
+		t.Fatalf("no PUBLISH: %v", err)
+	}
+	flags := header[0] & 0x0F
+	kind, body, err := readPacket(c.r)
+	if err != nil || kind != mqttPublish {
+		t.Fatalf("expected a PUBLISH, got packet type 0x%02X: %v", kind, err)
+	}
+
+	topicLength := int(binary.BigEndian.Uint16(body))
+	return flags, binary.BigEndian.Uint16(body[2+topicLength:])
+}
+
+func TestMQTTResendAfterReconnect(t *testing.T) {
+	broker := newStubBroker(t)
+
+	c := DefaultConf().MQTT
+	c.Broker = "tcp://" + broker.ln.Addr().String()
+	c.Timeout = 2
+	w, err := newMQTTWriter(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Message{Topic: "gt06/123456789123456/location", Event: events.Event{IMEI: "123456789123456"}}
+	if err := w.write(m); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	// the first connection fails before the message is acked
+	first := broker.accept()
+	flags, id := first.readPublish(t)
+	if flags&0x08 != 0 {
+		t.Fatal("the first PUBLISH is flagged DUP")
+	}
+	first.Close()
+	<-w.lost
+
+	if err := w.tick(); err != nil {
+		t.Fatalf("tick: %v", err)
+	}
+
+	second := broker.accept()
+	resentFlags, resentID := second.readPublish(t)
+	if resentID != id {
+		t.Fatalf("resent packet id %d, want %d", resentID, id)
+	}
+	if resentFlags != flags|0x08 {
+		t.Fatalf("resent flags 0x%X, want 0x%X", resentFlags, flags|0x08)
+	}
+
+	ack := binary.BigEndian.AppendUint16([]byte{mqttPubAck, 2}, id)
+	if _, err := second.Write(ack); err != nil {
+		t.Fatal(err)
+	}
+
+	w.stop()
+	if err := w.close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package sinks
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ndjsonWriter writes one JSON message per line, flushed every second
+type ndjsonWriter struct {
+	name string
+	buf  *bufio.Writer
+	enc  *json.Encoder
+	file *os.File // closed with the writer, nil for the standard output
+}
+
+func newNDJSONWriter(name string, w io.Writer, file *os.File) *ndjsonWriter {
+	buf := bufio.NewWriterSize(w, 64<<10)
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	return &ndjsonWriter{name: name, buf: buf, enc: enc, file: file}
+}
+
+// newFileWriter appends to path, creating it and its directory when missing
+func newFileWriter(path string) (*ndjsonWriter, error) {
+	if path == "" {
+		return nil, errors.New("event file path is not configured")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create event file directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return newNDJSONWriter(path, file, file), nil
+}
+
+func newStdoutWriter() *ndjsonWriter {
+	return newNDJSONWriter("standard output", os.Stdout, nil)
+}
+
+func (w *ndjsonWriter) write(m *Message) error {
+	return w.enc.Encode(m)
+}
+
+func (w *ndjsonWriter) tick() error {
+	if err := w.buf.Flush(); err != nil {
+		return fmt.Errorf("failed to flush %s: %w", w.name, err)
+	}
+	return nil
+}
+
+func (w *ndjsonWriter) stop() {}
+
+func (w *ndjsonWriter) close() error {
+	err := w.tick()
+	if w.file != nil {
+		if closeErr := w.file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
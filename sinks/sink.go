@@ -0,0 +1,230 @@
+// Package sinks publishes the events of the services outside of the process, to an NDJSON
+// file, the standard output, an in-memory channel or an MQTT broker, each event under a topic
+// named after a pattern such as gt06/{imei}/position.
+package sinks
+
+import (
+	"errors"
+	"gt06/events"
+	"sync"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/logx"
+	"github.com/zeromicro/go-zero/core/threading"
+)
+
+// Message is an event with its topic, the line written by the NDJSON sinks
+type Message struct {
+	Topic string `json:"topic"`
+	events.Event
+}
+
+// Sink receives the published events. Publish never blocks: an event that does not fit the
+// buffer of the sink is dropped.
+type Sink interface {
+	Name() string
+	Publish(e events.Event)
+	Close() error
+}
+
+// Open returns the sinks enabled by c, closing the ones already opened when one fails
+func Open(c Conf) ([]Sink, error) {
+	topics, err := NewTopics(c)
+	if err != nil {
+		return nil, err
+	}
+	if c.Buffer <= 0 {
+		c.Buffer = DefaultConf().Buffer
+	}
+
+	var opened []Sink
+	add := func(name string, w writer) {
+		opened = append(opened, newQueued(name, topics, c.Buffer, w))
+	}
+	fail := func(err error) ([]Sink, error) {
+		for _, s := range opened {
+			_ = s.Close()
+		}
+		return nil, err
+	}
+
+	if c.File.Enabled {
+		w, err := newFileWriter(c.File.Path)
+		if err != nil {
+			return fail(err)
+		}
+		add("file", w)
+	}
+	if c.Stdout.Enabled {
+		add("stdout", newStdoutWriter())
+	}
+	if c.MQTT.Enabled {
+		w, err := newMQTTWriter(c.MQTT)
+		if err != nil {
+			return fail(err)
+		}
+		add("mqtt", w)
+	}
+	return opened, nil
+}
+
+// writer writes messages for a queued sink, it is only used by the goroutine of the sink
+type writer interface {
+	write(m *Message) error
+	// tick is called every second, to flush or to keep a connection alive
+	tick() error
+	// stop is called from any goroutine when the sink closes, to abort a pending write
+	stop()
+	close() error
+}
+
+// queued is a sink writing the events in the background
+type queued struct {
+	name     string
+	topics   *Topics
+	messages chan Message
+	done     chan struct{}
+	once     sync.Once
+	wg       sync.WaitGroup
+	writer   writer
+}
+
+func newQueued(name string, topics *Topics, buffer int, w writer) *queued {
+	s := &queued{
+		name:     name,
+		topics:   topics,
+		messages: make(chan Message, buffer),
+		done:     make(chan struct{}),
+		writer:   w,
+	}
+	s.wg.Add(1)
+	threading.GoSafe(s.run)
+	return s
+}
+
+func (s *queued) Name() string {
+	return s.name
+}
+
+func (s *queued) Publish(e events.Event) {
+	topic, ok := s.topics.Topic(&e)
+	if !ok {
+		return
+	}
+
+	select {
+	case <-s.done:
+		metricDropped.Inc(s.name, "closed")
+	case s.messages <- Message{Topic: topic, Event: e}:
+	default:
+		metricDropped.Inc(s.name, "full")
+	}
+}
+
+// Close writes the queued events and closes the writer
+func (s *queued) Close() error {
+	s.once.Do(func() {
+		close(s.done)
+		s.writer.stop()
+	})
+	s.wg.Wait()
+	return s.writer.close()
+}
+
+// run writes the queued events, ticking the writer every second
+func (s *queued) run() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case m := <-s.messages:
+			s.write(&m)
+		case <-ticker.C:
+			if err := s.writer.tick(); err != nil {
+				logx.Errorf("[SINK] %s: %v", s.name, err)
+			}
+		case <-s.done:
+			for {
+				select {
+				case m := <-s.messages:
+					s.write(&m)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *queued) write(m *Message) {
+	if err := s.writer.write(m); err != nil {
+		reason := "error"
+		if errors.Is(err, errStopped) {
+			reason = "closed"
+		}
+		metricDropped.Inc(s.name, reason)
+		logx.Errorf("[SINK] %s: failed to write %s event of %s: %v", s.name, m.Type, m.IMEI, err)
+		return
+	}
+	metricPublished.Inc(s.name)
+}
+
+// errStopped is returned by a writer asked to write after its sink closed
+var errStopped = errors.New("sink is closed")
+
+// Channel is a sink delivering the events on a Go channel, for code embedding the server. An
+// event that does not fit the buffer of the channel is dropped.
+type Channel struct {
+	topics *Topics
+	c      chan Message
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewChannel returns a Channel of buffer messages
+func NewChannel(topics *Topics, buffer int) *Channel {
+	return &Channel{topics: topics, c: make(chan Message, buffer)}
+}
+
+// C returns the channel of the messages, closed once the sink is
+func (s *Channel) C() <-chan Message {
+	return s.c
+}
+
+func (s *Channel) Name() string {
+	return "channel"
+}
+
+func (s *Channel) Publish(e events.Event) {
+	topic, ok := s.topics.Topic(&e)
+	if !ok {
+		return
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		metricDropped.Inc("channel", "closed")
+		return
+	}
+	select {
+	case s.c <- Message{Topic: topic, Event: e}:
+		metricPublished.Inc("channel")
+	default:
+		metricDropped.Inc("channel", "full")
+	}
+}
+
+func (s *Channel) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.c)
+	}
+	return nil
+}
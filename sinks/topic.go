@@ -0,0 +1,77 @@
+package sinks
+
+import (
+	"fmt"
+	"gt06/events"
+	"regexp"
+	"strings"
+)
+
+var placeholder = regexp.MustCompile(`\{[^}]*\}`)
+
+// Topics names the topic of an event after the pattern of its type, and drops the event
+// types that are not published
+type Topics struct {
+	pattern  string
+	patterns map[events.Type]string
+	types    map[events.Type]struct{} // published, every type when empty
+}
+
+// NewTopics checks the patterns and the event types of c
+func NewTopics(c Conf) (*Topics, error) {
+	if c.Topic == "" {
+		c.Topic = DefaultConf().Topic
+	}
+	if err := checkPattern(c.Topic); err != nil {
+		return nil, err
+	}
+
+	t := &Topics{
+		pattern:  c.Topic,
+		patterns: make(map[events.Type]string, len(c.Topics)),
+		types:    make(map[events.Type]struct{}, len(c.Events)),
+	}
+	for name, pattern := range c.Topics {
+		if !events.Type(name).Known() {
+			return nil, fmt.Errorf("unknown event type %s in topics", name)
+		}
+		if err := checkPattern(pattern); err != nil {
+			return nil, err
+		}
+		t.patterns[events.Type(name)] = pattern
+	}
+	for _, name := range c.Events {
+		if !events.Type(name).Known() {
+			return nil, fmt.Errorf("unknown event type %s", name)
+		}
+		t.types[events.Type(name)] = struct{}{}
+	}
+	return t, nil
+}
+
+// checkPattern rejects unknown placeholders and the MQTT wildcards, which are not allowed in
+// the topic of a published message
+func checkPattern(pattern string) error {
+	if strings.ContainsAny(pattern, "+#") {
+		return fmt.Errorf("topic %q must not contain + or #", pattern)
+	}
+	for _, p := range placeholder.FindAllString(pattern, -1) {
+		if p != "{imei}" && p != "{type}" {
+			return fmt.Errorf("topic %q has an unknown placeholder %s, use {imei} or {type}", pattern, p)
+		}
+	}
+	return nil
+}
+
+// Topic returns the topic of e, false when its type is not published
+func (t *Topics) Topic(e *events.Event) (string, bool) {
+	if _, ok := t.types[e.Type]; len(t.types) > 0 && !ok {
+		return "", false
+	}
+
+	pattern, ok := t.patterns[e.Type]
+	if !ok {
+		pattern = t.pattern
+	}
+	return strings.NewReplacer("{imei}", e.IMEI, "{type}", string(e.Type)).Replace(pattern), true
+}
@@ -0,0 +1,35 @@
+package spool
+
+const (
+	FsyncAlways   = "always"
+	FsyncInterval = "interval"
+	FsyncNever    = "never"
+)
+
+// Conf configures the local write-ahead spool
+type Conf struct {
+	Enabled           bool   `json:"Enabled,optional" yaml:"Enabled"`
+	Dir               string `json:"Dir,optional" yaml:"Dir"`
+	SegmentBytes      int64  `json:"SegmentBytes,optional" yaml:"SegmentBytes"`
+	MaxBytes          int64  `json:"MaxBytes,optional" yaml:"MaxBytes"`
+	Fsync             string `json:"Fsync,optional,options=always|interval|never" yaml:"Fsync"`
+	FsyncInterval     int    `json:"FsyncInterval,optional" yaml:"FsyncInterval"`   // milliseconds
+	ReplayInterval    int    `json:"ReplayInterval,optional" yaml:"ReplayInterval"` // seconds
+	ReplayTimeout     int    `json:"ReplayTimeout,optional" yaml:"ReplayTimeout"`   // seconds a replay pass may take
+	MaxReplayAttempts int    `json:"MaxReplayAttempts,optional" yaml:"MaxReplayAttempts"`
+}
+
+// DefaultConf returns a Conf with default values
+func DefaultConf() Conf {
+	return Conf{
+		Enabled:           false,
+		Dir:               "data/spool",
+		SegmentBytes:      64 << 20,
+		MaxBytes:          1 << 30,
+		Fsync:             FsyncInterval,
+		FsyncInterval:     1000,
+		ReplayInterval:    5,
+		ReplayTimeout:     60,
+		MaxReplayAttempts: 5,
+	}
+}
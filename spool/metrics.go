@@ -0,0 +1,36 @@
+package spool
+
+import "github.com/zeromicro/go-zero/core/metric"
+
+const metricNamespace = "gt06"
+
+var (
+	metricSpoolDepth = metric.NewGaugeVec(&metric.GaugeVecOpts{
+		Namespace: metricNamespace,
+		Subsystem: "spool",
+		Name:      "depth",
+		Help:      "Number of records waiting in the spool to be replayed.",
+	})
+
+	metricSpoolBytes = metric.NewGaugeVec(&metric.GaugeVecOpts{
+		Namespace: metricNamespace,
+		Subsystem: "spool",
+		Name:      "bytes",
+		Help:      "Bytes waiting in the spool to be replayed.",
+	})
+
+	metricSpoolReplayed = metric.NewCounterVec(&metric.CounterVecOpts{
+		Namespace: metricNamespace,
+		Subsystem: "spool",
+		Name:      "replayed_total",
+		Help:      "Number of spooled records replayed to the destination.",
+	})
+
+	metricSpoolRejected = metric.NewCounterVec(&metric.CounterVecOpts{
+		Namespace: metricNamespace,
+		Subsystem: "spool",
+		Name:      "rejected_total",
+		Help:      "Number of records the spool could not accept or replay.",
+		Labels:    []string{"reason"},
+	})
+)
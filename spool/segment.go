@@ -0,0 +1,164 @@
+package spool
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	segmentExt = ".seg"
+
+	// Record layout: length(4) + crc(4) + body(length)
+	// Body layout:   kindLength(2) + kind(N) + unixNano(8) + data(M)
+	recordHeaderSize = 8
+	maxRecordSize    = 16 << 20
+)
+
+var (
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+	errCorruptRecord = errors.New("corrupt spool record")
+)
+
+// Record is a single buffered entry
+type Record struct {
+	Kind string
+	Data []byte
+	Time time.Time
+}
+
+type segment struct {
+	id   uint64
+	path string
+	size int64
+}
+
+func segmentPath(dir string, id uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", id, segmentExt))
+}
+
+// listSegments returns the segments found in dir ordered by id
+func listSegments(dir string) ([]*segment, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	segments := make([]*segment, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		segments = append(segments, &segment{
+			id:   id,
+			path: filepath.Join(dir, name),
+			size: info.Size(),
+		})
+	}
+
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].id < segments[j].id
+	})
+
+	return segments, nil
+}
+
+func encodeRecord(rec Record) []byte {
+	bodyLen := 2 + len(rec.Kind) + 8 + len(rec.Data)
+	buf := make([]byte, recordHeaderSize+bodyLen)
+
+	body := buf[recordHeaderSize:]
+	binary.BigEndian.PutUint16(body[0:2], uint16(len(rec.Kind)))
+	copy(body[2:], rec.Kind)
+	binary.BigEndian.PutUint64(body[2+len(rec.Kind):], uint64(rec.Time.UnixNano()))
+	copy(body[10+len(rec.Kind):], rec.Data)
+
+	binary.BigEndian.PutUint32(buf[0:4], uint32(bodyLen))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.Checksum(body, crcTable))
+
+	return buf
+}
+
+// readRecord reads the record starting at off, returning it with its encoded size
+func readRecord(r io.ReaderAt, off int64) (Record, int64, error) {
+	var header [recordHeaderSize]byte
+	if _, err := r.ReadAt(header[:], off); err != nil {
+		return Record{}, 0, err
+	}
+
+	bodyLen := binary.BigEndian.Uint32(header[0:4])
+	if bodyLen < 10 || bodyLen > maxRecordSize {
+		return Record{}, 0, errCorruptRecord
+	}
+
+	body := make([]byte, bodyLen)
+	if _, err := r.ReadAt(body, off+recordHeaderSize); err != nil {
+		if errors.Is(err, io.EOF) {
+			return Record{}, 0, io.ErrUnexpectedEOF
+		}
+		return Record{}, 0, err
+	}
+
+	if crc32.Checksum(body, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+		return Record{}, 0, errCorruptRecord
+	}
+
+	kindLen := int(binary.BigEndian.Uint16(body[0:2]))
+	if 10+kindLen > len(body) {
+		return Record{}, 0, errCorruptRecord
+	}
+
+	rec := Record{
+		Kind: string(body[2 : 2+kindLen]),
+		Time: time.Unix(0, int64(binary.BigEndian.Uint64(body[2+kindLen:10+kindLen]))),
+		Data: body[10+kindLen:],
+	}
+
+	return rec, recordHeaderSize + int64(bodyLen), nil
+}
+
+// scanSegment walks the records of a segment starting at off and returns the number of
+// valid records and the offset right after the last valid one. A torn or corrupt tail
+// stops the scan, so the returned offset is where the segment must be truncated.
+func scanSegment(path string, off int64) (count int64, end int64, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	end = off
+	for {
+		_, n, err := readRecord(f, end)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, errCorruptRecord) {
+				return count, end, nil
+			}
+			return count, end, err
+		}
+
+		count++
+		end += n
+	}
+}
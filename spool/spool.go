@@ -0,0 +1,614 @@
+package spool
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/logx"
+	"github.com/zeromicro/go-zero/core/threading"
+)
+
+const (
+	checkpointFile = "checkpoint"
+	rejectedFile   = "rejected.seg"
+
+	// checkpoint is persisted at most every checkpointEvery committed records while draining
+	checkpointEvery = 100
+)
+
+var (
+	ErrClosed = errors.New("spool is closed")
+	ErrFull   = errors.New("spool is full")
+)
+
+// Handler writes a replayed record to its final destination
+type Handler func(ctx context.Context, rec Record) error
+
+// Probe reports whether the destination is reachable
+type Probe func(ctx context.Context) error
+
+// Stats is a snapshot of the spool backlog
+type Stats struct {
+	Depth    int64 `json:"depth"`
+	Bytes    int64 `json:"bytes"`
+	Segments int   `json:"segments"`
+}
+
+// Spool is an append-only, segment-based write-ahead buffer on local disk.
+// Records are appended while the destination is unavailable and replayed in order once it is back.
+type Spool struct {
+	conf Conf
+
+	mu       sync.Mutex
+	segments []*segment
+	active   *os.File
+	dirty    bool
+	closed   bool
+
+	// read position: segments[0] at readOff
+	reader      *os.File
+	readOff     int64
+	uncommitted int
+
+	depth int64
+	bytes int64
+
+	// replay bookkeeping for the head record
+	headAttempts int
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Open opens the spool in c.Dir, recovering any state left from a previous run.
+func Open(c Conf) (*Spool, error) {
+	if c.Dir == "" {
+		return nil, errors.New("spool directory is not configured")
+	}
+
+	defaults := DefaultConf()
+	if c.SegmentBytes <= 0 {
+		c.SegmentBytes = defaults.SegmentBytes
+	}
+	if c.MaxBytes <= 0 {
+		c.MaxBytes = defaults.MaxBytes
+	}
+	if c.Fsync == "" {
+		c.Fsync = defaults.Fsync
+	}
+	if c.FsyncInterval <= 0 {
+		c.FsyncInterval = defaults.FsyncInterval
+	}
+	if c.ReplayInterval <= 0 {
+		c.ReplayInterval = defaults.ReplayInterval
+	}
+	if c.ReplayTimeout <= 0 {
+		c.ReplayTimeout = defaults.ReplayTimeout
+	}
+	if c.MaxReplayAttempts <= 0 {
+		c.MaxReplayAttempts = defaults.MaxReplayAttempts
+	}
+
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+
+	s := &Spool{
+		conf: c,
+		done: make(chan struct{}),
+	}
+
+	if err := s.recover(); err != nil {
+		return nil, err
+	}
+
+	if c.Fsync == FsyncInterval {
+		s.wg.Add(1)
+		threading.GoSafe(s.syncLoop)
+	}
+
+	s.updateMetrics()
+	logx.Infof("[SPOOL] Opened %s: depth=%d bytes=%d segments=%d", c.Dir, s.depth, s.bytes, len(s.segments))
+	return s, nil
+}
+
+// recover loads segments and the checkpoint, truncating torn writes left by a crash.
+func (s *Spool) recover() error {
+	segments, err := listSegments(s.conf.Dir)
+	if err != nil {
+		return fmt.Errorf("failed to list spool segments: %w", err)
+	}
+
+	ckptSeg, ckptOff, err := s.readCheckpoint()
+	if err != nil {
+		logx.Errorf("[SPOOL] Ignoring unreadable checkpoint: %v", err)
+		ckptSeg, ckptOff = 0, 0
+	}
+
+	live := segments[:0]
+	for _, seg := range segments {
+		// segments before the checkpoint have been fully replayed
+		if seg.id < ckptSeg {
+			if err := os.Remove(seg.path); err != nil {
+				return fmt.Errorf("failed to remove replayed segment: %w", err)
+			}
+			continue
+		}
+		live = append(live, seg)
+	}
+
+	for i, seg := range live {
+		start := int64(0)
+		if i == 0 && seg.id == ckptSeg {
+			start = ckptOff
+		}
+
+		count, end, err := scanSegment(seg.path, start)
+		if err != nil {
+			return fmt.Errorf("failed to scan spool segment %s: %w", seg.path, err)
+		}
+
+		if end < seg.size {
+			logx.Errorf("[SPOOL] Truncating %d corrupt bytes from %s", seg.size-end, seg.path)
+			if err := os.Truncate(seg.path, end); err != nil {
+				return fmt.Errorf("failed to truncate spool segment: %w", err)
+			}
+			seg.size = end
+		}
+
+		s.depth += count
+		s.bytes += seg.size - start
+	}
+
+	s.segments = live
+	if len(s.segments) > 0 && s.segments[0].id == ckptSeg {
+		s.readOff = ckptOff
+	}
+
+	if len(s.segments) == 0 {
+		return s.rotate(ckptSeg + 1)
+	}
+
+	last := s.segments[len(s.segments)-1]
+	s.active, err = os.OpenFile(last.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open spool segment: %w", err)
+	}
+
+	return nil
+}
+
+// Append durably buffers a record according to the fsync policy.
+func (s *Spool) Append(kind string, data []byte) error {
+	buf := encodeRecord(Record{Kind: kind, Data: data, Time: time.Now()})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	if s.diskBytes()+int64(len(buf)) > s.conf.MaxBytes {
+		metricSpoolRejected.Inc("full")
+		return ErrFull
+	}
+
+	last := s.segments[len(s.segments)-1]
+	if last.size > 0 && last.size+int64(len(buf)) > s.conf.SegmentBytes {
+		if err := s.rotate(last.id + 1); err != nil {
+			return err
+		}
+		last = s.segments[len(s.segments)-1]
+	}
+
+	if _, err := s.active.Write(buf); err != nil {
+		return fmt.Errorf("failed to write spool record: %w", err)
+	}
+
+	if s.conf.Fsync == FsyncAlways {
+		if err := s.active.Sync(); err != nil {
+			return fmt.Errorf("failed to sync spool segment: %w", err)
+		}
+	} else {
+		s.dirty = true
+	}
+
+	last.size += int64(len(buf))
+	s.depth++
+	s.bytes += int64(len(buf))
+	s.updateMetrics()
+
+	return nil
+}
+
+// Depth returns the number of records waiting to be replayed
+func (s *Spool) Depth() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.depth
+}
+
+// Stats returns a snapshot of the spool backlog
+func (s *Spool) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return Stats{
+		Depth:    s.depth,
+		Bytes:    s.bytes,
+		Segments: len(s.segments),
+	}
+}
+
+// Replay hands pending records to handle in append order until the spool is
+// drained or handle fails. A failed record stays at the head of the spool.
+func (s *Spool) Replay(ctx context.Context, handle Handler) (int, error) {
+	replayed := 0
+	defer s.checkpoint()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return replayed, err
+		}
+
+		rec, size, ok, err := s.peek()
+		if err != nil || !ok {
+			return replayed, err
+		}
+
+		if err := handle(ctx, rec); err != nil {
+			return replayed, err
+		}
+
+		if err := s.commit(size); err != nil {
+			return replayed, err
+		}
+		replayed++
+	}
+}
+
+// StartReplay drains the spool through handle every ReplayInterval while probe
+// reports the destination healthy. A record that keeps failing against a healthy
+// destination is moved to the rejected file after MaxReplayAttempts.
+func (s *Spool) StartReplay(probe Probe, handle Handler) {
+	s.wg.Add(1)
+	threading.GoSafe(func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(time.Duration(s.conf.ReplayInterval) * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+				s.drain(probe, handle)
+			}
+		}
+	})
+}
+
+func (s *Spool) drain(probe Probe, handle Handler) {
+	if s.Depth() == 0 {
+		return
+	}
+
+	// a pass is bounded so a hung destination does not hold the replay loop, and ends on Close
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(s.conf.ReplayTimeout)*time.Second)
+	defer cancel()
+	threading.GoSafe(func() {
+		select {
+		case <-s.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	})
+
+	if err := probe(ctx); err != nil {
+		logx.Infof("[SPOOL] Destination unavailable, %d records pending: %v", s.Depth(), err)
+		return
+	}
+
+	replayed, err := s.Replay(ctx, handle)
+	if replayed > 0 {
+		s.headAttempts = 0
+		metricSpoolReplayed.Add(float64(replayed))
+		logx.Infof("[SPOOL] Replayed %d records, %d pending", replayed, s.Depth())
+	}
+	if err == nil {
+		return
+	}
+	if ctx.Err() != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			logx.Errorf("[SPOOL] Replay pass timed out after %ds, %d records pending", s.conf.ReplayTimeout, s.Depth())
+		}
+		return
+	}
+
+	// the destination may have gone away again; only count failures while it is healthy
+	if probe(ctx) != nil {
+		logx.Errorf("[SPOOL] Replay interrupted: %v", err)
+		return
+	}
+
+	s.headAttempts++
+	logx.Errorf("[SPOOL] Replay failed (attempt %d/%d): %v", s.headAttempts, s.conf.MaxReplayAttempts, err)
+	if s.headAttempts >= s.conf.MaxReplayAttempts {
+		if err := s.reject(); err != nil {
+			logx.Errorf("[SPOOL] Failed to reject record: %v", err)
+			return
+		}
+		s.headAttempts = 0
+	}
+}
+
+// reject moves the head record to the rejected file so it no longer blocks the spool
+func (s *Spool) reject() error {
+	rec, size, ok, err := s.peek()
+	if err != nil || !ok {
+		return err
+	}
+
+	f, err := os.OpenFile(filepath.Join(s.conf.Dir, rejectedFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(encodeRecord(rec)); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	logx.Errorf("[SPOOL] Rejected %s record spooled at %s", rec.Kind, rec.Time.Format(time.RFC3339))
+	metricSpoolRejected.Inc("poison")
+
+	if err := s.commit(size); err != nil {
+		return err
+	}
+	return s.checkpoint()
+}
+
+// peek returns the record at the read position without consuming it
+func (s *Spool) peek() (Record, int64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return Record{}, 0, false, ErrClosed
+	}
+
+	if s.depth == 0 {
+		return Record{}, 0, false, nil
+	}
+
+	for s.readOff >= s.segments[0].size {
+		if len(s.segments) == 1 {
+			return Record{}, 0, false, nil
+		}
+		if err := s.dropHead(); err != nil {
+			return Record{}, 0, false, err
+		}
+	}
+
+	if s.reader == nil {
+		f, err := os.Open(s.segments[0].path)
+		if err != nil {
+			return Record{}, 0, false, err
+		}
+		s.reader = f
+	}
+
+	rec, size, err := readRecord(s.reader, s.readOff)
+	if err != nil {
+		return Record{}, 0, false, fmt.Errorf("failed to read spool record: %w", err)
+	}
+
+	return rec, size, true, nil
+}
+
+// commit advances the read position past a record returned by peek
+func (s *Spool) commit(size int64) error {
+	s.mu.Lock()
+	s.readOff += size
+	s.depth--
+	s.bytes -= size
+	s.uncommitted++
+
+	var err error
+	if s.depth == 0 {
+		// fully drained: start a fresh segment so the replayed ones can be removed
+		err = s.rotate(s.segments[len(s.segments)-1].id + 1)
+		for err == nil && len(s.segments) > 1 {
+			err = s.dropHead()
+		}
+	}
+	flush := s.uncommitted >= checkpointEvery
+	s.updateMetrics()
+	s.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	if flush {
+		return s.checkpoint()
+	}
+	return nil
+}
+
+// dropHead removes the fully replayed first segment, must be called with mu held
+func (s *Spool) dropHead() error {
+	head := s.segments[0]
+	if s.reader != nil {
+		s.reader.Close()
+		s.reader = nil
+	}
+
+	s.segments = s.segments[1:]
+	s.readOff = 0
+	if err := s.writeCheckpoint(s.segments[0].id, 0); err != nil {
+		return err
+	}
+
+	return os.Remove(head.path)
+}
+
+// rotate closes the active segment and starts segment id, must be called with mu held
+func (s *Spool) rotate(id uint64) error {
+	if s.active != nil {
+		if err := s.active.Sync(); err != nil {
+			return err
+		}
+		if err := s.active.Close(); err != nil {
+			return err
+		}
+	}
+
+	path := segmentPath(s.conf.Dir, id)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create spool segment: %w", err)
+	}
+
+	s.active = f
+	s.dirty = false
+	s.segments = append(s.segments, &segment{id: id, path: path})
+	return nil
+}
+
+func (s *Spool) checkpoint() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.uncommitted == 0 || len(s.segments) == 0 {
+		return nil
+	}
+
+	s.uncommitted = 0
+	return s.writeCheckpoint(s.segments[0].id, s.readOff)
+}
+
+func (s *Spool) readCheckpoint() (uint64, int64, error) {
+	data, err := os.ReadFile(filepath.Join(s.conf.Dir, checkpointFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if len(data) != 20 || crc32.Checksum(data[:16], crcTable) != binary.BigEndian.Uint32(data[16:]) {
+		return 0, 0, errCorruptRecord
+	}
+
+	return binary.BigEndian.Uint64(data[0:8]), int64(binary.BigEndian.Uint64(data[8:16])), nil
+}
+
+// writeCheckpoint atomically persists the read position
+func (s *Spool) writeCheckpoint(seg uint64, off int64) error {
+	var data [20]byte
+	binary.BigEndian.PutUint64(data[0:8], seg)
+	binary.BigEndian.PutUint64(data[8:16], uint64(off))
+	binary.BigEndian.PutUint32(data[16:20], crc32.Checksum(data[:16], crcTable))
+
+	path := filepath.Join(s.conf.Dir, checkpointFile)
+	tmp := path + ".tmp"
+
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data[:]); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func (s *Spool) syncLoop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(time.Duration(s.conf.FsyncInterval) * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			if s.dirty && !s.closed {
+				if err := s.active.Sync(); err != nil {
+					logx.Errorf("[SPOOL] Failed to sync segment: %v", err)
+				} else {
+					s.dirty = false
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// diskBytes returns the size of all segments on disk, must be called with mu held
+func (s *Spool) diskBytes() int64 {
+	var total int64
+	for _, seg := range s.segments {
+		total += seg.size
+	}
+	return total
+}
+
+func (s *Spool) updateMetrics() {
+	metricSpoolDepth.Set(float64(s.depth))
+	metricSpoolBytes.Set(float64(s.bytes))
+}
+
+// Close stops background loops, persists the read position and syncs the active segment.
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	ckptErr := s.checkpoint()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.reader != nil {
+		s.reader.Close()
+	}
+	if err := s.active.Sync(); err != nil {
+		return err
+	}
+	if err := s.active.Close(); err != nil {
+		return err
+	}
+
+	return ckptErr
+}
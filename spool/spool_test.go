@@ -0,0 +1,175 @@
+package spool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func openTestSpool(t *testing.T, dir string) *Spool {
+	t.Helper()
+
+	c := DefaultConf()
+	c.Dir = dir
+	c.Fsync = FsyncAlways
+	s, err := Open(c)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	return s
+}
+
+func appendRecords(t *testing.T, s *Spool, from, to int) {
+	t.Helper()
+
+	for i := from; i < to; i++ {
+		if err := s.Append("position", []byte(fmt.Sprintf("record-%d", i))); err != nil {
+			t.Fatalf("Append %d: %v", i, err)
+		}
+	}
+}
+
+// replayAll drains s and returns the data of the replayed records
+func replayAll(t *testing.T, s *Spool) []string {
+	t.Helper()
+
+	var got []string
+	_, err := s.Replay(context.Background(), func(ctx context.Context, rec Record) error {
+		got = append(got, string(rec.Data))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	return got
+}
+
+func expectRecords(t *testing.T, got []string, from, to int) {
+	t.Helper()
+
+	if len(got) != to-from {
+		t.Fatalf("replayed %d records, want %d: %q", len(got), to-from, got)
+	}
+	for i, data := range got {
+		if want := fmt.Sprintf("record-%d", from+i); data != want {
+			t.Fatalf("record %d is %q, want %q", i, data, want)
+		}
+	}
+}
+
+func TestRecoverTruncatesCorruptTail(t *testing.T) {
+	dir := t.TempDir()
+
+	s := openTestSpool(t, dir)
+	appendRecords(t, s, 0, 3)
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	segments, err := listSegments(dir)
+	if err != nil || len(segments) != 1 {
+		t.Fatalf("listSegments: %d segments, %v", len(segments), err)
+	}
+	valid := segments[0].size
+
+	// a complete record whose body no longer matches its CRC, then a torn header
+	corrupt := encodeRecord(Record{Kind: "position", Data: []byte("corrupt"), Time: time.Now()})
+	corrupt[len(corrupt)-1] ^= 0xff
+	f, err := os.OpenFile(segments[0].path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(append(corrupt, 0, 0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	s = openTestSpool(t, dir)
+	defer s.Close()
+
+	if depth := s.Depth(); depth != 3 {
+		t.Fatalf("depth after recovery is %d, want 3", depth)
+	}
+	info, err := os.Stat(segments[0].path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != valid {
+		t.Fatalf("segment is %d bytes after recovery, want %d", info.Size(), valid)
+	}
+
+	// records appended after the recovery follow the valid ones
+	appendRecords(t, s, 3, 5)
+	expectRecords(t, replayAll(t, s), 0, 5)
+	if depth := s.Depth(); depth != 0 {
+		t.Fatalf("depth after replay is %d, want 0", depth)
+	}
+}
+
+func TestReplayResumesFromCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+
+	s := openTestSpool(t, dir)
+	appendRecords(t, s, 0, 5)
+
+	errUnavailable := errors.New("unavailable")
+	var got []string
+	replayed, err := s.Replay(context.Background(), func(ctx context.Context, rec Record) error {
+		if len(got) == 2 {
+			return errUnavailable
+		}
+		got = append(got, string(rec.Data))
+		return nil
+	})
+	if !errors.Is(err, errUnavailable) || replayed != 2 {
+		t.Fatalf("Replay returned %d, %v, want 2, %v", replayed, err, errUnavailable)
+	}
+	expectRecords(t, got, 0, 2)
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	s = openTestSpool(t, dir)
+	defer s.Close()
+
+	if depth := s.Depth(); depth != 3 {
+		t.Fatalf("depth after reopening is %d, want 3", depth)
+	}
+	expectRecords(t, replayAll(t, s), 2, 5)
+}
+
+func TestDrainPassTimesOut(t *testing.T) {
+	s := openTestSpool(t, t.TempDir())
+	defer s.Close()
+	s.conf.ReplayTimeout = 1
+
+	appendRecords(t, s, 0, 1)
+
+	healthy := func(ctx context.Context) error { return nil }
+	hung := func(ctx context.Context, rec Record) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.drain(healthy, hung)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("drain did not return after its timeout")
+	}
+
+	if depth := s.Depth(); depth != 1 {
+		t.Fatalf("depth after a timed out pass is %d, want 1", depth)
+	}
+	if s.headAttempts != 0 {
+		t.Fatalf("a timed out pass counted %d failed attempts", s.headAttempts)
+	}
+}
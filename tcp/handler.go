@@ -2,23 +2,43 @@ package tcp
 
 import (
 	"context"
+	"fmt"
 	"gt06/common"
+	"gt06/database"
+	"gt06/events"
+	"gt06/journal"
+	"gt06/metrics"
 	"gt06/protocol"
 	"gt06/services"
 	"gt06/services/svc"
+	"gt06/tracing"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/panjf2000/gnet/v2"
 	"github.com/zeromicro/go-zero/core/logx"
+	"github.com/zeromicro/go-zero/core/threading"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// lastSeenInterval bounds how often a session refreshes the device registry
+const lastSeenInterval = time.Minute
+
+// tickInterval is the period of OnTick, it also proves that the engine is running
+const tickInterval = 10 * time.Second
+
 type ProtocolHandler struct {
 	sessions sync.Map // use sync.Map to store sessions instead of a map [con]session
 	eng      gnet.Engine
 	mu       sync.Mutex
 	c        context.Context
 	svc      *svc.ServiceContext
+	booted   atomic.Bool  // the listener accepts connections
+	lastTick atomic.Int64 // unix nanoseconds of the last OnTick
 }
 
 func (ph *ProtocolHandler) OnBoot(eng gnet.Engine) (action gnet.Action) {
@@ -27,30 +47,46 @@ func (ph *ProtocolHandler) OnBoot(eng gnet.Engine) (action gnet.Action) {
 
 	ph.eng = eng
 	ph.c = context.Background()
+	ph.lastTick.Store(time.Now().UnixNano())
+	ph.booted.Store(true)
 	logx.Info("Server booted")
 	return
 }
 
 func (ph *ProtocolHandler) OnOpen(c gnet.Conn) (out []byte, action gnet.Action) {
 
-	// root context
-	ctx := context.Background()
+	// root context, the session span is the root of the packet spans so the connection id is its trace id
+	remoteAddr := c.RemoteAddr().String()
+	ctx, span := tracing.Tracer().Start(context.Background(), "gt06.session",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithNewRoot(),
+		trace.WithAttributes(semconv.NetworkPeerAddress(remoteAddr)))
+	connectionID := common.TraceIDOf(ctx)
+	span.SetAttributes(attribute.String("gt06.connection_id", connectionID))
 
 	ctx = logx.ContextWithFields(ctx, logx.LogField{
 		Key:   string(common.SpanID),
-		Value: common.GenerateSpanID(),
+		Value: common.SpanIDOf(ctx),
 	}, logx.LogField{
 		Key:   string(common.TraceID),
-		Value: common.GenerateTraceID(),
+		Value: connectionID,
 	})
 
+	now := time.Now()
 	session := &Session{
-		Context:    ctx,
-		Conn:       c,
-		LastActive: time.Now(),
+		id:          connectionID,
+		Context:     ctx,
+		Conn:        c,
+		ConnectedAt: now,
+		LastActive:  now,
+		remoteAddr:  remoteAddr,
+		span:        span,
+		journal:     ph.svc.Journal,
 	}
 
 	ph.sessions.Store(c, session)
+	metrics.Connections.Inc()
+	metrics.ActiveConnections.Inc()
 
 	logx.WithContext(ctx).Infof("New connection from %s, fd %d", c.RemoteAddr(), c.Fd())
 	return
@@ -62,6 +98,12 @@ func (ph *ProtocolHandler) OnClose(c gnet.Conn, err error) (action gnet.Action)
 		session := value.(*Session)
 		logx.WithContext(session.Context).Infof("Client disconnected: %s", c.RemoteAddr())
 		ph.sessions.Delete(c)
+		if n := c.InboundBuffered(); n > 0 {
+			session.discard(n, errIncompleteFrame)
+		}
+		session.flushDiscarded()
+		session.closed(err)
+		ph.markOffline(session)
 	}
 	return
 }
@@ -74,81 +116,124 @@ func (ph *ProtocolHandler) OnTraffic(c gnet.Conn) (action gnet.Action) {
 			action = gnet.Close
 		}
 	}()
-	// Peek header to determine packet size (4 bytes: 0x78 0x78 length protocol)
-	headerData, err := c.Peek(4)
-	if err != nil || len(headerData) < 4 {
-		if err != nil {
-			logx.Errorf("Failed to peek header: %v", err)
-		}
-		return gnet.None
-	}
 
-	// Extract packet length from byte 2
-	packetLength := int(headerData[2])
-	if packetLength < 5 || packetLength > 255 {
-		logx.Errorf("Invalid packet length: %d", packetLength)
-		// Try to skip this invalid packet
-		return gnet.None
+	value, exists := ph.sessions.Load(c)
+	if !exists {
+		return gnet.Close
 	}
+	session := value.(*Session)
 
-	// Total packet size: header(2) + length(1) + protocol(1) + info(length-5) + serial(2) + crc(2) + stop(2)
-	// Which simplifies to: 10 + (length - 5) = length + 5
-	totalPacketSize := packetLength + 5
+	// a read may hold several frames, a device sending without waiting for the acks
+	for {
+		// Peek header to determine packet size (4 bytes: 0x78 0x78 length protocol)
+		headerData, err := c.Peek(4)
+		if err != nil || len(headerData) < 4 {
+			// Not enough data yet, wait for more
+			return gnet.None
+		}
 
-	// Peek exactly the amount needed for this packet
-	data, err := c.Peek(totalPacketSize)
-	if err != nil || len(data) < totalPacketSize {
-		// Not enough data yet, wait for more
-		return gnet.None
+		// Extract packet length from byte 2
+		packetLength := int(headerData[2])
+		if packetLength < 5 || packetLength > 255 {
+			logx.Errorf("Invalid packet length: %d", packetLength)
+			metrics.ParseFailures.Inc(parseFailureLength)
+			// skip a byte to resynchronize on the next frame
+			session.discard(1, fmt.Errorf("invalid packet length %d", packetLength))
+			continue
+		}
+
+		// Total packet size: header(2) + length(1) + protocol(1) + info(length-5) + serial(2) + crc(2) + stop(2)
+		// Which simplifies to: 10 + (length - 5) = length + 5
+		totalPacketSize := packetLength + 5
+
+		// Peek exactly the amount needed for this packet
+		data, err := c.Peek(totalPacketSize)
+		if err != nil || len(data) < totalPacketSize {
+			// Not enough data yet, wait for more
+			return gnet.None
+		}
+
+		if action = ph.handleFrame(c, session, data); action != gnet.None {
+			return action
+		}
 	}
+}
 
+// handleFrame decodes and processes one complete frame in a packet span of the session trace
+func (ph *ProtocolHandler) handleFrame(c gnet.Conn, session *Session, data []byte) (action gnet.Action) {
+	ctx, span := tracing.Tracer().Start(session.Context, "gt06.packet",
+		trace.WithAttributes(attribute.Int("gt06.packet.size", len(data))))
+	var spanErr error
+	session.processingSince.Store(time.Now().UnixNano())
+	defer func() {
+		session.processingSince.Store(0)
+		tracing.End(span, spanErr)
+	}()
+
+	_, decodeSpan := tracing.Tracer().Start(ctx, "gt06.decode")
 	packet, err := protocol.ParseAndValidatePacket(data)
+	tracing.End(decodeSpan, err)
 	if err != nil {
+		spanErr = err
 		logx.Errorf("Failed to parse packet: %v", err)
+		countParseFailure(err)
 		// Discard invalid data to prevent infinite loop
-		c.Discard(1)
+		session.discard(1, err)
 		return gnet.None
 	}
 
-	value, exists := ph.sessions.Load(c)
-	if !exists {
-		return gnet.Close
-	}
-	session := value.(*Session)
 	session.LastActive = time.Now()
+	session.receivedPacket(packet.ProtocolNumber, len(data), session.LastActive)
+	session.flushDiscarded()
+	session.journal.Record(journal.DirectionIn, frameIMEI(session, packet), session.ID(), session.LastActive, data)
+
+	name := protocol.ProtocolName(packet.ProtocolNumber)
+	span.SetName("gt06.packet " + name)
+	span.SetAttributes(
+		attribute.String("gt06.protocol", metrics.ProtocolLabel(packet.ProtocolNumber)),
+		attribute.Int("gt06.serial", int(packet.InfoSerialNumber)),
+	)
 
-	var service services.PacketService
-
-	switch packet.ProtocolNumber {
-	case protocol.ProtocolLogin:
-		service = services.NewLoginDeviceService(session.Context, ph.svc)
-	case protocol.ProtocolHeartbeat, protocol.ProtocolHeartbeatAlt:
-		service = services.NewHeartbeatService(session.Context)
-	case protocol.ProtocolLocation, protocol.ProtocolLocationUTC:
-		service = services.NewLocationService(session.Context, ph.svc)
-	case protocol.ProtocolAlarm:
-		service = services.NewAlarmService(session.Context, ph.svc)
-	default:
+	ctx, processSpan := tracing.Tracer().Start(ctx, "gt06.process "+name)
+	defer func() {
+		tracing.End(processSpan, spanErr)
+	}()
+
+	service := services.NewPacketService(ctx, ph.svc, session, packet.ProtocolNumber)
+	if service == nil {
+		spanErr = fmt.Errorf("unknown protocol number 0x%02X", packet.ProtocolNumber)
 		logx.WithContext(session.Context).Errorf("Unknown Protocol Number: 0x%02X", packet.ProtocolNumber)
+		metrics.ParseFailures.Inc(parseFailureUnknownProtocol)
 		return gnet.Close
 	}
 
 	out, err := service.ProcessPacket(packet)
 	if err != nil {
+		spanErr = err
 		logx.WithContext(session.Context).Errorf("Packet processing failed: %v", err)
+		metrics.PacketFailures.Inc(metrics.ProtocolLabel(packet.ProtocolNumber))
 		return gnet.Close
 	}
 
 	if out != nil {
 		// use callback function when handle error like retrying, push error notification or dead letter queue, etc..
-		if err := c.AsyncWrite(out, nil); err != nil {
+		if err := session.Send(out); err != nil {
+			spanErr = err
 			logx.WithContext(session.Context).Errorf("Failed to send response: %v", err)
+			metrics.AckWriteFailures.Inc()
 			return gnet.Close
 		}
 	}
 
+	if packet.ProtocolNumber == protocol.ProtocolLogin {
+		// the login itself refreshed last_seen
+		session.LastSeen = session.LastActive
+	} else {
+		ph.touchDevice(session)
+	}
+
 	// discard only this packet (not all buffered data) to allow processing of next packets
-	c.Discard(totalPacketSize)
+	c.Discard(len(data))
 	// logx.Info(common.ConvertToHexString(out))
 	return gnet.None
 }
@@ -156,6 +241,7 @@ func (ph *ProtocolHandler) OnTraffic(c gnet.Conn) (action gnet.Action) {
 func (ph *ProtocolHandler) OnShutdown(gnet.Engine) {
 	// TODO: implement graceful shutdown in many cases
 	ph.mu.Lock()
+	ph.booted.Store(false)
 	logx.Info("Server is shutting down...")
 
 	// close all connections
@@ -176,22 +262,138 @@ func (ph *ProtocolHandler) OnShutdown(gnet.Engine) {
 }
 
 func (ph *ProtocolHandler) OnTick() (delay time.Duration, action gnet.Action) {
-	delay = 10 * time.Second
+	delay = tickInterval
+	ph.lastTick.Store(time.Now().UnixNano())
 	ph.sessions.Range(func(key, value interface{}) bool {
 		conn := key.(gnet.Conn)
 		session := value.(*Session)
 
 		// Close inactive connections after 1 minute
 		if time.Since(session.LastActive) > time.Minute {
+			// OnClose removes the session
 			logx.WithContext(session.Context).Infof("Closing inactive connection: %s", conn.RemoteAddr())
 			conn.Close()
-			ph.sessions.Delete(conn)
 		}
 		return true
 	})
 	return
 }
 
+// frameIMEI is the IMEI of the session, or the one a login frame carries since the session is bound after processing it
+func frameIMEI(session *Session, packet *protocol.CONCOXPacket) string {
+	if imei := session.IMEI(); imei != "" || packet.ProtocolNumber != protocol.ProtocolLogin {
+		return imei
+	}
+	if login, err := protocol.ParseCONCOXLoginInfoContent(packet.InfoContent[:]); err == nil {
+		return login.IMEI()
+	}
+	return ""
+}
+
+// touchDevice refreshes the device last_seen, throttled to once per lastSeenInterval per session.
+// The write runs off the event loop within the storage timeout, Touch never moves last_seen back.
+func (ph *ProtocolHandler) touchDevice(session *Session) {
+	imei := session.IMEI()
+	if imei == "" || session.LastActive.Sub(session.LastSeen) < lastSeenInterval {
+		return
+	}
+
+	session.LastSeen = session.LastActive
+	at := session.LastActive
+	threading.GoSafe(func() {
+		ctx, cancel := context.WithTimeout(session.Context, time.Duration(ph.svc.Config.Timeout)*time.Second)
+		defer cancel()
+		if err := ph.svc.DeviceRepository.Touch(ctx, imei, at); err != nil {
+			logx.WithContext(session.Context).Errorf("Failed to update device last seen: %v", err)
+		}
+	})
+}
+
+// markOffline records that the device of session disconnected
+func (ph *ProtocolHandler) markOffline(session *Session) {
+	imei := session.IMEI()
+	if imei == "" {
+		return
+	}
+
+	ph.svc.Commands.Unregister(imei, session.ID())
+
+	offline := false
+	services.UpdateDeviceState(session.Context, ph.svc, &database.StateUpdate{
+		IMEI:         imei,
+		At:           session.LastActive,
+		ConnectionID: session.ID(),
+		Online:       &offline,
+	})
+	ph.svc.Publisher.Publish(events.SessionEvent(events.TypeSessionClosed, imei, session.ID(), session.RemoteAddr(), time.Now()))
+}
+
+// Sessions returns a snapshot of the live sessions, oldest connection first
+func (ph *ProtocolHandler) Sessions() []SessionInfo {
+	sessions := make([]SessionInfo, 0)
+	ph.sessions.Range(func(key, value interface{}) bool {
+		sessions = append(sessions, value.(*Session).Info())
+		return true
+	})
+
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].ConnectedAt.Equal(sessions[j].ConnectedAt) {
+			return sessions[i].ConnectedAt.Before(sessions[j].ConnectedAt)
+		}
+		return sessions[i].ID < sessions[j].ID
+	})
+	return sessions
+}
+
+// Session returns a snapshot of the live session with the connection id
+func (ph *ProtocolHandler) Session(id string) (SessionInfo, bool) {
+	session := ph.find(func(session *Session) bool {
+		return session.ID() == id
+	})
+	if len(session) == 0 {
+		return SessionInfo{}, false
+	}
+	return session[0].Info(), true
+}
+
+// Disconnect closes the live session with the connection id, it reports whether there was one
+func (ph *ProtocolHandler) Disconnect(id string) bool {
+	return ph.close(func(session *Session) bool {
+		return session.ID() == id
+	}) > 0
+}
+
+// DisconnectIMEI closes the live sessions of a device, it returns how many it closed
+func (ph *ProtocolHandler) DisconnectIMEI(imei string) int {
+	return ph.close(func(session *Session) bool {
+		return session.IMEI() == imei
+	})
+}
+
+// find returns the live sessions accepted by match
+func (ph *ProtocolHandler) find(match func(session *Session) bool) []*Session {
+	var matched []*Session
+	ph.sessions.Range(func(key, value interface{}) bool {
+		if session := value.(*Session); match(session) {
+			matched = append(matched, session)
+		}
+		return true
+	})
+	return matched
+}
+
+// close closes the sessions accepted by match, OnClose removes them
+func (ph *ProtocolHandler) close(match func(session *Session) bool) int {
+	sessions := ph.find(match)
+	for _, session := range sessions {
+		logx.WithContext(session.Context).Infof("Disconnecting session of %s on request", session.RemoteAddr())
+		if err := session.Conn.Close(); err != nil {
+			logx.WithContext(session.Context).Errorf("Failed to close connection: %v", err)
+		}
+	}
+	return len(sessions)
+}
+
 func (ph *ProtocolHandler) SetServiceContext(svc *svc.ServiceContext) {
 	ph.svc = svc
 }
@@ -0,0 +1,62 @@
+package tcp
+
+import (
+	"fmt"
+	"time"
+)
+
+// ListenerDetails describes the GT06 listener in the health checks
+type ListenerDetails struct {
+	Connections int `json:"connections"`
+}
+
+// EventLoopDetails describes the event loops in the health checks
+type EventLoopDetails struct {
+	LastTick time.Time `json:"last_tick"`
+	Sessions int       `json:"sessions"`
+}
+
+// ListenerStatus reports whether the engine is booted and accepting connections
+func (ph *ProtocolHandler) ListenerStatus() (any, error) {
+	if !ph.booted.Load() {
+		return nil, fmt.Errorf("listener is not running")
+	}
+
+	ph.mu.Lock()
+	eng := ph.eng
+	ph.mu.Unlock()
+
+	return ListenerDetails{Connections: eng.CountConnections()}, nil
+}
+
+// EventLoopStatus reports whether the engine ticks and no packet has been processed for longer than stallTimeout.
+// Before the boot there is nothing to be stuck on, the listener check reports it.
+func (ph *ProtocolHandler) EventLoopStatus(stallTimeout time.Duration) (any, error) {
+	details := EventLoopDetails{}
+	if !ph.booted.Load() {
+		return details, nil
+	}
+
+	lastTick := time.Unix(0, ph.lastTick.Load())
+	details.LastTick = lastTick
+	if since := time.Since(lastTick); since > 3*tickInterval {
+		return details, fmt.Errorf("engine has not ticked for %s", since.Truncate(time.Second))
+	}
+
+	var stuck *Session
+	var stuckFor time.Duration
+	ph.sessions.Range(func(_, value any) bool {
+		session := value.(*Session)
+		details.Sessions++
+		if since := session.processingSince.Load(); since != 0 {
+			if d := time.Since(time.Unix(0, since)); d > stallTimeout && d > stuckFor {
+				stuck, stuckFor = session, d
+			}
+		}
+		return true
+	})
+	if stuck != nil {
+		return details, fmt.Errorf("event loop stuck for %s on a packet of session %s", stuckFor.Truncate(time.Second), stuck.ID())
+	}
+	return details, nil
+}
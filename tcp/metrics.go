@@ -0,0 +1,37 @@
+package tcp
+
+import (
+	"errors"
+	"gt06/metrics"
+	"gt06/protocol"
+)
+
+// reasons of the parse failures metric
+const (
+	parseFailureShort           = "short"
+	parseFailureStartBits       = "start_bits"
+	parseFailureLength          = "length"
+	parseFailureStopBits        = "stop_bits"
+	parseFailureCRC             = "crc"
+	parseFailureUnknownProtocol = "unknown_protocol"
+	parseFailureOther           = "other"
+)
+
+// countParseFailure records a packet rejected by protocol.ParseAndValidatePacket
+func countParseFailure(err error) {
+	reason := parseFailureOther
+	switch {
+	case errors.Is(err, protocol.ErrShortPacket):
+		reason = parseFailureShort
+	case errors.Is(err, protocol.ErrInvalidStartBits):
+		reason = parseFailureStartBits
+	case errors.Is(err, protocol.ErrInvalidLength):
+		reason = parseFailureLength
+	case errors.Is(err, protocol.ErrInvalidStopBits):
+		reason = parseFailureStopBits
+	case errors.Is(err, protocol.ErrCRCMismatch):
+		reason = parseFailureCRC
+		metrics.CRCErrors.Inc()
+	}
+	metrics.ParseFailures.Inc(reason)
+}
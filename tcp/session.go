@@ -2,13 +2,221 @@ package tcp
 
 import (
 	"context"
+	"errors"
+	"gt06/journal"
+	"gt06/metrics"
+	"gt06/protocol"
+	"gt06/tracing"
+	"io"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/panjf2000/gnet/v2"
+	"github.com/zeromicro/go-zero/core/logx"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// maxDiscarded bounds the skipped bytes held for a single invalid journal frame
+const maxDiscarded = 1024
+
 type Session struct {
-	Context    context.Context
-	Conn       gnet.Conn
-	LastActive time.Time
+	id          string
+	Context     context.Context
+	Conn        gnet.Conn
+	ConnectedAt time.Time
+	LastActive  time.Time
+	LastSeen    time.Time // last time the device registry was touched
+	remoteAddr  string
+	span        trace.Span       // root of the session trace, ended on close
+	journal     *journal.Journal // nil when the frame journal is disabled
+
+	// discarded holds the bytes skipped to resynchronize, journaled as one invalid frame with the
+	// first reason once the stream is back in sync. Only the event loop touches them.
+	discarded     []byte
+	discardReason string
+
+	// processingSince is the unix nanoseconds at which the event loop started on the current packet, 0 when idle
+	processingSince atomic.Int64
+
+	// mu guards the fields read by the admin API while the event loop updates them
+	mu         sync.Mutex
+	imei       string
+	model      string
+	lastPacket time.Time
+	packets    map[string]uint64
+	bytesIn    atomic.Uint64
+	bytesOut   atomic.Uint64
+}
+
+// SessionInfo is a snapshot of a live session
+type SessionInfo struct {
+	ID           string            `json:"id"`
+	IMEI         string            `json:"imei,omitempty"`
+	Model        string            `json:"model,omitempty"`
+	RemoteAddr   string            `json:"remote_addr"`
+	ConnectedAt  time.Time         `json:"connected_at"`
+	LastPacketAt *time.Time        `json:"last_packet_at,omitempty"`
+	Packets      map[string]uint64 `json:"packets"` // by protocol name
+	BytesIn      uint64            `json:"bytes_in"`
+	BytesOut     uint64            `json:"bytes_out"`
+}
+
+// ID returns the connection id, the trace id of the session logs
+func (s *Session) ID() string {
+	return s.id
+}
+
+// IMEI returns the IMEI of the logged in device, empty before login
+func (s *Session) IMEI() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.imei
+}
+
+// SetIMEI binds the session to a logged in device
+func (s *Session) SetIMEI(imei string) {
+	s.mu.Lock()
+	s.imei = imei
+	s.mu.Unlock()
+
+	s.span.SetAttributes(attribute.String("gt06.imei", imei))
+}
+
+// SetModel records the model code reported at login, counted in the sessions by model
+func (s *Session) SetModel(model string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.model == model {
+		return
+	}
+	if s.model != "" {
+		metrics.SessionsByModel.Dec(s.model)
+	}
+	s.model = model
+	metrics.SessionsByModel.Inc(model)
+}
+
+// RemoteAddr returns the address of the connected device
+func (s *Session) RemoteAddr() string {
+	return s.remoteAddr
+}
+
+// Send writes out to the device, it is safe to call from any goroutine
+func (s *Session) Send(out []byte) error {
+	s.bytesOut.Add(uint64(len(out)))
+	s.journal.Record(journal.DirectionOut, s.IMEI(), s.id, time.Now(), out)
+	return s.Conn.AsyncWrite(out, nil)
+}
+
+// Reply sends the ack of an asynchronously processed packet, or closes the connection
+// on failure like the handler does for synchronous packets. It is safe to call from any goroutine.
+func (s *Session) Reply(out []byte, err error) {
+	if err != nil {
+		logx.WithContext(s.Context).Errorf("Packet processing failed: %v", err)
+		s.Conn.Close()
+		return
+	}
+
+	if len(out) > 0 {
+		if err := s.Send(out); err != nil {
+			metrics.AckWriteFailures.Inc()
+			logx.WithContext(s.Context).Errorf("Failed to send response: %v", err)
+			s.Conn.Close()
+		}
+	}
+}
+
+// Info returns a snapshot of the session statistics
+func (s *Session) Info() SessionInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info := SessionInfo{
+		ID:          s.id,
+		IMEI:        s.imei,
+		Model:       s.model,
+		RemoteAddr:  s.remoteAddr,
+		ConnectedAt: s.ConnectedAt,
+		Packets:     make(map[string]uint64, len(s.packets)),
+		BytesIn:     s.bytesIn.Load(),
+		BytesOut:    s.bytesOut.Load(),
+	}
+	if !s.lastPacket.IsZero() {
+		lastPacket := s.lastPacket
+		info.LastPacketAt = &lastPacket
+	}
+	for name, count := range s.packets {
+		info.Packets[name] = count
+	}
+	return info
+}
+
+// received accounts for size bytes read from the connection that were not a valid packet
+func (s *Session) received(size int) {
+	s.bytesIn.Add(uint64(size))
+}
+
+// discard skips n received bytes that failed validation, kept for the journal when the device is journaled
+func (s *Session) discard(n int, reason error) {
+	if s.journal.Enabled(s.IMEI()) {
+		if data, err := s.Conn.Peek(n); err == nil {
+			if len(s.discarded) == 0 {
+				s.discardReason = reason.Error()
+			}
+			s.discarded = append(s.discarded, data...)
+		}
+		if len(s.discarded) >= maxDiscarded {
+			s.flushDiscarded()
+		}
+	}
+	s.Conn.Discard(n)
+	s.received(n)
+}
+
+// errIncompleteFrame is the reason the bytes left unprocessed on close are journaled with
+var errIncompleteFrame = errors.New("incomplete frame on close")
+
+// flushDiscarded journals the bytes skipped since the last valid frame
+func (s *Session) flushDiscarded() {
+	if len(s.discarded) == 0 {
+		return
+	}
+	s.journal.RecordInvalid(s.IMEI(), s.id, time.Now(), s.discarded, s.discardReason)
+	s.discarded = s.discarded[:0]
+	s.discardReason = ""
+}
+
+// receivedPacket accounts for a valid packet of size bytes
+func (s *Session) receivedPacket(protocolNumber uint8, size int, at time.Time) {
+	s.bytesIn.Add(uint64(size))
+
+	s.mu.Lock()
+	if s.packets == nil {
+		s.packets = make(map[string]uint64)
+	}
+	s.packets[protocol.ProtocolName(protocolNumber)]++
+	s.lastPacket = at
+	s.mu.Unlock()
+
+	metrics.Packets.Inc(metrics.ProtocolLabel(protocolNumber))
+}
+
+// closed releases the session from the gauges and ends its trace with the close error
+func (s *Session) closed(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.model != "" {
+		metrics.SessionsByModel.Dec(s.model)
+	}
+	metrics.ActiveConnections.Dec()
+	s.span.SetAttributes(attribute.Int64("gt06.bytes_in", int64(s.bytesIn.Load())), attribute.Int64("gt06.bytes_out", int64(s.bytesOut.Load())))
+	if errors.Is(err, io.EOF) {
+		// the device hung up
+		err = nil
+	}
+	tracing.End(s.span, err)
 }
@@ -2,9 +2,7 @@ package tcp
 
 import (
 	"fmt"
-	"gt06/config"
 	"gt06/services/svc"
-	"log"
 	"runtime"
 
 	"github.com/panjf2000/gnet/v2"
@@ -15,30 +13,26 @@ type TCPServer struct {
 	ProtocolHandler *ProtocolHandler
 }
 
-func NewTCPServer(address string) *TCPServer {
-	return &TCPServer{
-		Address: address,
-	}
-}
-
-func (s *TCPServer) Start(c config.Config) error {
+// NewTCPServer builds the GT06 listener served with the services of serviceContext
+func NewTCPServer(address string, serviceContext *svc.ServiceContext) *TCPServer {
 	protocolHandler := &ProtocolHandler{}
-	serviceContext := svc.NewServiceContext(c)
+	protocolHandler.SetServiceContext(serviceContext)
 
-	if serviceContext == nil {
-		log.Fatalf("Failed to create service context")
+	return &TCPServer{
+		Address:         address,
+		ProtocolHandler: protocolHandler,
 	}
+}
 
-	protocolHandler.SetServiceContext(serviceContext)
-
+// Start serves GT06 connections, it blocks until the server stops
+func (s *TCPServer) Start() error {
 	options := gnet.WithOptions(
 		gnet.Options{
 			Multicore:    true,
 			Ticker:       true,
 			NumEventLoop: runtime.NumCPU(),
 		})
-	s.ProtocolHandler = protocolHandler
 
 	fmt.Printf("Starting server on %s\n", s.Address)
-	return gnet.Run(protocolHandler, fmt.Sprintf("tcp://%s", s.Address), options)
+	return gnet.Run(s.ProtocolHandler, fmt.Sprintf("tcp://%s", s.Address), options)
 }
@@ -0,0 +1,32 @@
+package tracing
+
+const (
+	ExporterNone     = "none"
+	ExporterStdout   = "stdout"   // human readable spans on standard output
+	ExporterFile     = "file"     // OTLP JSON lines, readable by the OpenTelemetry collector otlpjsonfile receiver
+	ExporterOTLPGRPC = "otlpgrpc" // OTLP over gRPC to Endpoint
+	ExporterOTLPHTTP = "otlphttp" // OTLP over HTTP to Endpoint
+)
+
+// Conf configures the export of the OpenTelemetry spans
+type Conf struct {
+	Enabled  bool              `json:"Enabled,optional" yaml:"Enabled"`
+	Name     string            `json:"Name,optional" yaml:"Name"` // service name of the spans
+	Exporter string            `json:"Exporter,optional" yaml:"Exporter"`
+	Endpoint string            `json:"Endpoint,optional" yaml:"Endpoint"` // host:port of the OTLP exporters
+	Insecure bool              `json:"Insecure,optional" yaml:"Insecure"` // plain text OTLP
+	Headers  map[string]string `json:"Headers,optional" yaml:"Headers"`
+	Path     string            `json:"Path,optional" yaml:"Path"`       // file of the file exporter
+	Sampler  float64           `json:"Sampler,optional" yaml:"Sampler"` // ratio of sessions traced
+}
+
+// DefaultConf returns a Conf with default values
+func DefaultConf() Conf {
+	return Conf{
+		Enabled:  false,
+		Name:     "gt06",
+		Exporter: ExporterFile,
+		Path:     "data/traces.jsonl",
+		Sampler:  1,
+	}
+}
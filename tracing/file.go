@@ -0,0 +1,121 @@
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// fileClient writes every upload as one line of OTLP JSON, the format of the
+// OpenTelemetry file exporter
+type fileClient struct {
+	path string
+
+	mu     sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+}
+
+var _ otlptrace.Client = (*fileClient)(nil)
+
+func newFileClient(path string) *fileClient {
+	return &fileClient{path: path}
+}
+
+func (c *fileClient) Start(ctx context.Context) error {
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(c.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.file = file
+	c.writer = bufio.NewWriter(file)
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *fileClient) Stop(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.file == nil {
+		return nil
+	}
+	if err := c.writer.Flush(); err != nil {
+		c.file.Close()
+		return err
+	}
+	err := c.file.Close()
+	c.file = nil
+	return err
+}
+
+func (c *fileClient) UploadTraces(ctx context.Context, protoSpans []*tracepb.ResourceSpans) error {
+	line, err := marshalOTLPJSON(&collectortrace.ExportTraceServiceRequest{ResourceSpans: protoSpans})
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.file == nil {
+		return os.ErrClosed
+	}
+	if _, err := c.writer.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return c.writer.Flush()
+}
+
+// idFields are the bytes fields that OTLP JSON encodes in hex instead of the base64 of protojson
+var idFields = map[string]bool{"traceId": true, "spanId": true, "parentSpanId": true}
+
+// marshalOTLPJSON encodes request in the OTLP JSON encoding
+func marshalOTLPJSON(request *collectortrace.ExportTraceServiceRequest) ([]byte, error) {
+	raw, err := protojson.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc any
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	hexIDs(doc)
+	return json.Marshal(doc)
+}
+
+func hexIDs(node any) {
+	switch node := node.(type) {
+	case map[string]any:
+		for key, value := range node {
+			if encoded, ok := value.(string); ok && idFields[key] {
+				if id, err := base64.StdEncoding.DecodeString(encoded); err == nil {
+					node[key] = hex.EncodeToString(id)
+				}
+				continue
+			}
+			hexIDs(value)
+		}
+	case []any:
+		for _, value := range node {
+			hexIDs(value)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package webhook
+
+// Endpoint is a URL receiving the events that pass its filters
+type Endpoint struct {
+	Name    string            `json:"Name" yaml:"Name"` // names the endpoint in logs, metrics and the admin API
+	URL     string            `json:"URL" yaml:"URL"`
+	Secret  string            `json:"Secret,optional" yaml:"Secret"`   // key of the HMAC-SHA256 signature, unsigned when empty
+	Events  []string          `json:"Events,optional" yaml:"Events"`   // event types delivered, every type when empty
+	Alarms  []string          `json:"Alarms,optional" yaml:"Alarms"`   // alarm types of the alarm events delivered, such as sos or power_cut, every type when empty
+	Devices []string          `json:"Devices,optional" yaml:"Devices"` // IMEIs delivered, every device when empty
+	Headers map[string]string `json:"Headers,optional" yaml:"Headers"` // added to every request, such as an Authorization
+}
+
+// Conf configures the webhook deliveries
+type Conf struct {
+	Enabled        bool       `json:"Enabled,optional" yaml:"Enabled"`
+	Endpoints      []Endpoint `json:"Endpoints,optional" yaml:"Endpoints"`
+	Queue          int        `json:"Queue,optional" yaml:"Queue"`                   // deliveries waiting per endpoint, further ones are dead-lettered
+	Workers        int        `json:"Workers,optional" yaml:"Workers"`               // concurrent deliveries per endpoint
+	Timeout        int        `json:"Timeout,optional" yaml:"Timeout"`               // seconds per request
+	MaxAttempts    int        `json:"MaxAttempts,optional" yaml:"MaxAttempts"`       // attempts before a delivery is dead-lettered
+	InitialBackoff int        `json:"InitialBackoff,optional" yaml:"InitialBackoff"` // milliseconds before the first retry, doubled after each
+	MaxBackoff     int        `json:"MaxBackoff,optional" yaml:"MaxBackoff"`         // seconds between retries at most
+	DeadLetterDir  string     `json:"DeadLetterDir,optional" yaml:"DeadLetterDir"`
+	MaxDeadLetters int        `json:"MaxDeadLetters,optional" yaml:"MaxDeadLetters"` // dead letters kept per endpoint, further ones are dropped
+}
+
+// DefaultConf returns a Conf with default values
+func DefaultConf() Conf {
+	return Conf{
+		Enabled:        false,
+		Queue:          1000,
+		Workers:        2,
+		Timeout:        10,
+		MaxAttempts:    8,
+		InitialBackoff: 1000,
+		MaxBackoff:     300,
+		DeadLetterDir:  "data/webhooks",
+		MaxDeadLetters: 10000,
+	}
+}
@@ -0,0 +1,163 @@
+package webhook
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Reasons a delivery is dead-lettered
+const (
+	ReasonAttempts  = "attempts"   // every attempt failed
+	ReasonRejected  = "rejected"   // the endpoint answered a client error, retrying would not help
+	ReasonQueueFull = "queue_full" // the endpoint had too many deliveries waiting
+	ReasonShutdown  = "shutdown"   // the server stopped before the delivery succeeded
+)
+
+// ErrDeadLettersFull is returned when an endpoint already has the most dead letters allowed
+var ErrDeadLettersFull = errors.New("too many dead letters")
+
+// DeadLetters keeps the failed deliveries of every endpoint in an NDJSON file of its own, until
+// they are redelivered or purged
+type DeadLetters struct {
+	dir string
+	max int // dead letters per endpoint, unbounded when not positive
+
+	mu     sync.Mutex
+	counts map[string]int // of the endpoints whose file was counted
+}
+
+// OpenDeadLetters opens the dead letters kept in dir, creating it when missing, keeping at most
+// max dead letters per endpoint when positive
+func OpenDeadLetters(dir string, max int) (*DeadLetters, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &DeadLetters{dir: dir, max: max, counts: make(map[string]int)}, nil
+}
+
+func (s *DeadLetters) path(endpoint string) string {
+	return filepath.Join(s.dir, endpoint+".ndjson")
+}
+
+// Add appends a failed delivery to the dead letters of its endpoint, it returns
+// ErrDeadLettersFull when the endpoint has the most dead letters allowed
+func (s *DeadLetters) Add(d *Delivery) error {
+	line, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count, err := s.count(d.Endpoint)
+	if err != nil {
+		return err
+	}
+	if s.max > 0 && count >= s.max {
+		return ErrDeadLettersFull
+	}
+
+	file, err := os.OpenFile(s.path(d.Endpoint), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		delete(s.counts, d.Endpoint) // a partial line may have been written, count the file again
+		return err
+	}
+	s.counts[d.Endpoint]++
+	return file.Close()
+}
+
+// List returns the dead letters of an endpoint, oldest first, at most limit when positive
+func (s *DeadLetters) List(endpoint string, limit int) ([]*Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read(endpoint, limit)
+}
+
+// Count returns the number of dead letters of an endpoint
+func (s *DeadLetters) Count(endpoint string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count(endpoint)
+}
+
+// count returns the number of dead letters of an endpoint, the file is only read the first time
+func (s *DeadLetters) count(endpoint string) (int, error) {
+	if count, ok := s.counts[endpoint]; ok {
+		return count, nil
+	}
+
+	file, err := os.Open(s.path(endpoint))
+	if errors.Is(err, os.ErrNotExist) {
+		s.counts[endpoint] = 0
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	count := 0
+	scanner := newScanner(file)
+	for scanner.Scan() {
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	s.counts[endpoint] = count
+	return count, nil
+}
+
+// Take removes and returns the dead letters of an endpoint
+func (s *DeadLetters) Take(endpoint string) ([]*Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deliveries, err := s.read(endpoint, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Remove(s.path(endpoint)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	s.counts[endpoint] = 0
+	return deliveries, nil
+}
+
+func (s *DeadLetters) read(endpoint string, limit int) ([]*Delivery, error) {
+	file, err := os.Open(s.path(endpoint))
+	if errors.Is(err, os.ErrNotExist) {
+		return []*Delivery{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	deliveries := make([]*Delivery, 0)
+	scanner := newScanner(file)
+	for line := 1; scanner.Scan() && (limit <= 0 || len(deliveries) < limit); line++ {
+		d := &Delivery{}
+		if err := json.Unmarshal(scanner.Bytes(), d); err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", s.path(endpoint), line, err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, scanner.Err()
+}
+
+func newScanner(file *os.File) *bufio.Scanner {
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+	return scanner
+}
@@ -0,0 +1,40 @@
+package webhook
+
+import "github.com/zeromicro/go-zero/core/metric"
+
+const metricNamespace = "gt06"
+
+var (
+	metricDeliveries = metric.NewCounterVec(&metric.CounterVecOpts{
+		Namespace: metricNamespace,
+		Subsystem: "webhook",
+		Name:      "attempts_total",
+		Help:      "Webhook requests by endpoint and result: delivered, retried or failed.",
+		Labels:    []string{"endpoint", "result"},
+	})
+
+	metricDeadLetters = metric.NewCounterVec(&metric.CounterVecOpts{
+		Namespace: metricNamespace,
+		Subsystem: "webhook",
+		Name:      "dead_letters_total",
+		Help:      "Deliveries moved to the dead letters by endpoint and reason.",
+		Labels:    []string{"endpoint", "reason"},
+	})
+
+	metricDropped = metric.NewCounterVec(&metric.CounterVecOpts{
+		Namespace: metricNamespace,
+		Subsystem: "webhook",
+		Name:      "dropped_total",
+		Help:      "Deliveries lost without a dead letter by endpoint and reason: queue_full, dead_letters_full or closed.",
+		Labels:    []string{"endpoint", "reason"},
+	})
+
+	metricDuration = metric.NewHistogramVec(&metric.HistogramVecOpts{
+		Namespace: metricNamespace,
+		Subsystem: "webhook",
+		Name:      "duration_ms",
+		Help:      "Webhook request latency in milliseconds by endpoint.",
+		Labels:    []string{"endpoint"},
+		Buckets:   []float64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000},
+	})
+)
@@ -0,0 +1,564 @@
+// Package webhook delivers events to HTTP endpoints as signed JSON payloads, retrying with an
+// exponential backoff and keeping the deliveries that failed in a dead-letter store.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gt06/database"
+	"gt06/events"
+	"gt06/metrics"
+	"gt06/protocol"
+	"io"
+	mathrand "math/rand"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/logx"
+	"github.com/zeromicro/go-zero/core/threading"
+)
+
+// Headers of the requests
+const (
+	HeaderEvent     = "X-GT06-Event"
+	HeaderDelivery  = "X-GT06-Delivery"
+	HeaderAttempt   = "X-GT06-Attempt"
+	HeaderTimestamp = "X-GT06-Timestamp"
+	HeaderSignature = "X-GT06-Signature"
+)
+
+var (
+	ErrUnknownEndpoint = errors.New("unknown webhook endpoint")
+
+	endpointName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// Payload is the JSON body of a delivery
+type Payload struct {
+	ID    string      `json:"id"` // of the delivery, the same across its attempts
+	Seq   uint64      `json:"seq"`
+	Type  events.Type `json:"type"`
+	IMEI  string      `json:"imei"`
+	Time  time.Time   `json:"time"`
+	Alarm string      `json:"alarm,omitempty"` // alarm type of alarm events, such as sos or power_cut
+	Data  any         `json:"data,omitempty"`
+}
+
+// Delivery is a payload on its way to an endpoint, or in the dead letters
+type Delivery struct {
+	ID        string          `json:"id"`
+	Endpoint  string          `json:"endpoint"`
+	Event     events.Type     `json:"event"`
+	IMEI      string          `json:"imei"`
+	Body      json.RawMessage `json:"body"`
+	Attempts  int             `json:"attempts"`
+	Status    int             `json:"status,omitempty"` // HTTP status of the last attempt
+	Error     string          `json:"error,omitempty"`  // of the last attempt
+	Reason    string          `json:"reason,omitempty"` // why it was dead-lettered
+	CreatedAt time.Time       `json:"created_at"`
+	FailedAt  *time.Time      `json:"failed_at,omitempty"`
+}
+
+// EndpointStatus describes an endpoint and its deliveries since the start
+type EndpointStatus struct {
+	Name        string   `json:"name"`
+	URL         string   `json:"url"`
+	Events      []string `json:"events"`
+	Alarms      []string `json:"alarms"`
+	Queued      int      `json:"queued"`
+	Delivered   uint64   `json:"delivered"`
+	Retried     uint64   `json:"retried"`
+	Dropped     uint64   `json:"dropped"` // lost to a full dead-letter store or a shutdown
+	DeadLetters int      `json:"dead_letters"`
+}
+
+// Dispatcher queues the events matching the filters of every endpoint and delivers them
+type Dispatcher struct {
+	conf      Conf
+	client    *http.Client
+	dead      *DeadLetters
+	endpoints []*endpoint
+	// failed holds the deliveries dead-lettered by Notify, written by a goroutine so that
+	// publishing an event never waits for the disk
+	failed chan *Delivery
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu     sync.RWMutex // held for writing by Close, so that no delivery is queued once the workers stop
+	closed bool
+}
+
+type endpoint struct {
+	conf   Endpoint
+	filter events.Filter
+	alarms map[string]struct{}
+	queue  chan *Delivery
+	label  string // of the queue depth metric
+
+	delivered atomic.Uint64
+	retried   atomic.Uint64
+	dropped   atomic.Uint64
+}
+
+// NewDispatcher checks the endpoints of c and starts their workers
+func NewDispatcher(c Conf) (*Dispatcher, error) {
+	defaults := DefaultConf()
+	if c.Queue <= 0 {
+		c.Queue = defaults.Queue
+	}
+	if c.Workers <= 0 {
+		c.Workers = defaults.Workers
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = defaults.Timeout
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = defaults.MaxAttempts
+	}
+	if c.InitialBackoff <= 0 {
+		c.InitialBackoff = defaults.InitialBackoff
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = defaults.MaxBackoff
+	}
+	if c.DeadLetterDir == "" {
+		c.DeadLetterDir = defaults.DeadLetterDir
+	}
+	if c.MaxDeadLetters <= 0 {
+		c.MaxDeadLetters = defaults.MaxDeadLetters
+	}
+
+	endpoints := make([]*endpoint, 0, len(c.Endpoints))
+	names := make(map[string]struct{}, len(c.Endpoints))
+	for _, e := range c.Endpoints {
+		if !endpointName.MatchString(e.Name) {
+			return nil, fmt.Errorf("webhook endpoint name %q must be letters, digits, - or _", e.Name)
+		}
+		if _, ok := names[e.Name]; ok {
+			return nil, fmt.Errorf("duplicate webhook endpoint %s", e.Name)
+		}
+		names[e.Name] = struct{}{}
+		for _, t := range e.Events {
+			if !events.Type(t).Known() {
+				return nil, fmt.Errorf("webhook endpoint %s has an unknown event type %s", e.Name, t)
+			}
+		}
+		if u, err := url.Parse(e.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("webhook endpoint %s has an invalid URL %q", e.Name, e.URL)
+		}
+
+		endpoints = append(endpoints, newEndpoint(e, c.Queue))
+	}
+
+	dead, err := OpenDeadLetters(c.DeadLetterDir, c.MaxDeadLetters)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Dispatcher{
+		conf:      c,
+		client:    &http.Client{Timeout: time.Duration(c.Timeout) * time.Second},
+		dead:      dead,
+		endpoints: endpoints,
+		failed:    make(chan *Delivery, c.Queue),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+	d.wg.Add(1)
+	threading.GoSafe(func() {
+		defer d.wg.Done()
+		for delivery := range d.failed {
+			d.deadLetter(delivery, delivery.Reason)
+		}
+	})
+	for _, e := range endpoints {
+		for i := 0; i < c.Workers; i++ {
+			d.wg.Add(1)
+			threading.GoSafe(func() {
+				defer d.wg.Done()
+				d.work(e)
+			})
+		}
+	}
+	return d, nil
+}
+
+func newEndpoint(c Endpoint, queue int) *endpoint {
+	e := &endpoint{
+		conf:   c,
+		alarms: make(map[string]struct{}, len(c.Alarms)),
+		queue:  make(chan *Delivery, queue),
+		label:  "webhook_" + c.Name,
+	}
+	if len(c.Events) > 0 {
+		e.filter.Types = make(map[events.Type]struct{}, len(c.Events))
+		for _, t := range c.Events {
+			e.filter.Types[events.Type(t)] = struct{}{}
+		}
+	}
+	if len(c.Devices) > 0 {
+		e.filter.IMEIs = make(map[string]struct{}, len(c.Devices))
+		for _, imei := range c.Devices {
+			e.filter.IMEIs[imei] = struct{}{}
+		}
+	}
+	for _, alarm := range c.Alarms {
+		e.alarms[alarm] = struct{}{}
+	}
+	return e
+}
+
+// match reports whether the endpoint wants an event, alarm being the alarm type of alarm events
+func (e *endpoint) match(ev *events.Event, alarm string) bool {
+	if !e.filter.Match(ev) {
+		return false
+	}
+	if _, ok := e.alarms[alarm]; ev.Type == events.TypeAlarm && len(e.alarms) > 0 && !ok {
+		return false
+	}
+	return true
+}
+
+// Notify queues the deliveries of an event to the endpoints that want it. It never blocks: a
+// delivery that does not fit the queue of its endpoint is dead-lettered in the background, or
+// dropped when too many are waiting to be.
+func (d *Dispatcher) Notify(ev events.Event) {
+	alarm := ""
+	if a, ok := ev.Data.(*database.Alarm); ok {
+		alarm = protocol.AlarmTypeName(uint8(a.AlarmLanguage >> 8))
+	}
+
+	var body []byte
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	for _, e := range d.endpoints {
+		if !e.match(&ev, alarm) {
+			continue
+		}
+
+		id := newID()
+		if body == nil {
+			var err error
+			body, err = json.Marshal(Payload{Seq: ev.Seq, Type: ev.Type, IMEI: ev.IMEI, Time: ev.Time, Alarm: alarm, Data: ev.Data})
+			if err != nil {
+				logx.Errorf("Failed to encode %s webhook payload: %v", ev.Type, err)
+				return
+			}
+		}
+		delivery := &Delivery{ID: id, Endpoint: e.conf.Name, Event: ev.Type, IMEI: ev.IMEI, Body: withID(body, id), CreatedAt: time.Now()}
+
+		if d.closed {
+			d.drop(e, delivery, "closed")
+			continue
+		}
+		select {
+		case e.queue <- delivery:
+			metrics.QueueDepth.Inc(e.label)
+		default:
+			delivery.Reason = ReasonQueueFull
+			select {
+			case d.failed <- delivery:
+			default:
+				d.drop(e, delivery, ReasonQueueFull)
+			}
+		}
+	}
+}
+
+// drop counts a delivery lost without a dead letter
+func (d *Dispatcher) drop(e *endpoint, delivery *Delivery, reason string) {
+	e.dropped.Add(1)
+	metricDropped.Inc(e.conf.Name, reason)
+	logx.Errorf("Webhook %s delivery %s of %s dropped (%s)", delivery.Endpoint, delivery.ID, delivery.Event, reason)
+}
+
+// withID sets the delivery id of a payload encoded without one
+func withID(body []byte, id string) []byte {
+	return bytes.Replace(body, []byte(`"id":""`), []byte(`"id":"`+id+`"`), 1)
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// work delivers the queued deliveries of an endpoint until the dispatcher closes
+func (d *Dispatcher) work(e *endpoint) {
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case delivery := <-e.queue:
+			metrics.QueueDepth.Dec(e.label)
+			d.deliver(e, delivery)
+		}
+	}
+}
+
+// deliver attempts a delivery until it succeeds, fails for good or the dispatcher closes
+func (d *Dispatcher) deliver(e *endpoint, delivery *Delivery) {
+	for {
+		delivery.Attempts++
+		retryAfter, err := d.attempt(e, delivery)
+		if err == nil {
+			e.delivered.Add(1)
+			metricDeliveries.Inc(e.conf.Name, "delivered")
+			return
+		}
+
+		delivery.Error = err.Error()
+		var rejected *rejectedError
+		switch {
+		case errors.As(err, &rejected):
+			metricDeliveries.Inc(e.conf.Name, "failed")
+			d.deadLetter(delivery, ReasonRejected)
+			return
+		case d.ctx.Err() != nil:
+			d.deadLetter(delivery, ReasonShutdown)
+			return
+		case delivery.Attempts >= d.conf.MaxAttempts:
+			metricDeliveries.Inc(e.conf.Name, "failed")
+			d.deadLetter(delivery, ReasonAttempts)
+			return
+		}
+
+		e.retried.Add(1)
+		metricDeliveries.Inc(e.conf.Name, "retried")
+		wait := max(d.backoff(delivery.Attempts), retryAfter)
+		logx.Infof("Webhook %s delivery %s attempt %d failed, retrying in %s: %v", e.conf.Name, delivery.ID, delivery.Attempts, wait, err)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-d.ctx.Done():
+			timer.Stop()
+			d.deadLetter(delivery, ReasonShutdown)
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff is the wait after the attempt-th attempt: InitialBackoff doubled after every attempt up
+// to MaxBackoff, spread by ±20% so that the retries of many deliveries do not line up
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+	wait := time.Duration(d.conf.InitialBackoff) * time.Millisecond << min(attempt-1, 30)
+	wait = min(wait, time.Duration(d.conf.MaxBackoff)*time.Second)
+	return time.Duration(float64(wait) * (0.8 + 0.4*mathrand.Float64()))
+}
+
+// rejectedError is a client error answer, the same request would be rejected again
+type rejectedError struct {
+	status int
+}
+
+func (e *rejectedError) Error() string {
+	return fmt.Sprintf("rejected with status %d", e.status)
+}
+
+// attempt posts a delivery once, returning the wait asked by a Retry-After header
+func (d *Dispatcher) attempt(e *endpoint, delivery *Delivery) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, e.conf.URL, bytes.NewReader(delivery.Body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "gt06-webhook")
+	for name, value := range e.conf.Headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set(HeaderEvent, string(delivery.Event))
+	req.Header.Set(HeaderDelivery, delivery.ID)
+	req.Header.Set(HeaderAttempt, strconv.Itoa(delivery.Attempts))
+	req.Header.Set(HeaderTimestamp, timestamp)
+	if e.conf.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(e.conf.Secret, timestamp, delivery.Body))
+	}
+
+	start := time.Now()
+	resp, err := d.client.Do(req)
+	metricDuration.ObserveFloat(float64(time.Since(start).Microseconds())/1000, e.conf.Name)
+	if err != nil {
+		delivery.Status = 0
+		return 0, err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	delivery.Status = resp.StatusCode
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return 0, nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests:
+		return 0, &rejectedError{status: resp.StatusCode}
+	default:
+		retryAfter := time.Duration(0)
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			retryAfter = min(time.Duration(seconds)*time.Second, time.Duration(d.conf.MaxBackoff)*time.Second)
+		}
+		return retryAfter, fmt.Errorf("status %d", resp.StatusCode)
+	}
+}
+
+func (d *Dispatcher) deadLetter(delivery *Delivery, reason string) {
+	now := time.Now()
+	delivery.Reason = reason
+	delivery.FailedAt = &now
+
+	if err := d.dead.Add(delivery); err != nil {
+		if e, _ := d.endpoint(delivery.Endpoint); e != nil && errors.Is(err, ErrDeadLettersFull) {
+			d.drop(e, delivery, "dead_letters_full")
+			return
+		}
+		logx.Errorf("Failed to dead-letter webhook %s delivery %s: %v", delivery.Endpoint, delivery.ID, err)
+		return
+	}
+	metricDeadLetters.Inc(delivery.Endpoint, reason)
+	logx.Errorf("Webhook %s delivery %s of %s dead-lettered (%s) after %d attempts: %s",
+		delivery.Endpoint, delivery.ID, delivery.Event, reason, delivery.Attempts, delivery.Error)
+}
+
+func (d *Dispatcher) endpoint(name string) (*endpoint, error) {
+	for _, e := range d.endpoints {
+		if e.conf.Name == name {
+			return e, nil
+		}
+	}
+	return nil, fmt.Errorf("%w %s", ErrUnknownEndpoint, name)
+}
+
+// Endpoints returns the status of every endpoint
+func (d *Dispatcher) Endpoints() ([]EndpointStatus, error) {
+	statuses := make([]EndpointStatus, 0, len(d.endpoints))
+	for _, e := range d.endpoints {
+		dead, err := d.dead.Count(e.conf.Name)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, EndpointStatus{
+			Name:        e.conf.Name,
+			URL:         e.conf.URL,
+			Events:      append([]string{}, e.conf.Events...),
+			Alarms:      append([]string{}, e.conf.Alarms...),
+			Queued:      len(e.queue),
+			Delivered:   e.delivered.Load(),
+			Retried:     e.retried.Load(),
+			Dropped:     e.dropped.Load(),
+			DeadLetters: dead,
+		})
+	}
+	return statuses, nil
+}
+
+// DeadLetters returns the dead letters of an endpoint, oldest first, at most limit when positive
+func (d *Dispatcher) DeadLetters(name string, limit int) ([]*Delivery, error) {
+	if _, err := d.endpoint(name); err != nil {
+		return nil, err
+	}
+	return d.dead.List(name, limit)
+}
+
+// Redeliver queues the dead letters of an endpoint again with fresh attempts, those that do not
+// fit the queue stay dead-lettered. It returns the number of deliveries queued.
+func (d *Dispatcher) Redeliver(name string) (int, error) {
+	e, err := d.endpoint(name)
+	if err != nil {
+		return 0, err
+	}
+	deliveries, err := d.dead.Take(name)
+	if err != nil {
+		return 0, err
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	queued := 0
+	for _, delivery := range deliveries {
+		if d.closed {
+			d.deadLetter(delivery, ReasonShutdown)
+			continue
+		}
+		delivery.Attempts, delivery.Status, delivery.Error, delivery.Reason, delivery.FailedAt = 0, 0, "", "", nil
+		select {
+		case e.queue <- delivery:
+			metrics.QueueDepth.Inc(e.label)
+			queued++
+		default:
+			d.deadLetter(delivery, ReasonQueueFull)
+		}
+	}
+	return queued, nil
+}
+
+// Purge deletes the dead letters of an endpoint and returns how many there were
+func (d *Dispatcher) Purge(name string) (int, error) {
+	if _, err := d.endpoint(name); err != nil {
+		return 0, err
+	}
+	deliveries, err := d.dead.Take(name)
+	return len(deliveries), err
+}
+
+// Close stops the deliveries, the ones waiting or being retried are dead-lettered to be redelivered later
+func (d *Dispatcher) Close() {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return
+	}
+	d.closed = true
+	d.mu.Unlock()
+
+	// Notify no longer queues once closed is set
+	d.cancel()
+	close(d.failed)
+	d.wg.Wait()
+
+	for _, e := range d.endpoints {
+		for {
+			select {
+			case delivery := <-e.queue:
+				metrics.QueueDepth.Dec(e.label)
+				d.deadLetter(delivery, ReasonShutdown)
+				continue
+			default:
+			}
+			break
+		}
+	}
+}
+
+// Sign returns the signature header of a body sent at timestamp: sha256= followed by the hex
+// HMAC-SHA256 of the timestamp, a dot and the body
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is the signature of a body sent at timestamp
+func Verify(secret, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
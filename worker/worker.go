@@ -1,12 +1,16 @@
 package worker
 
 import (
+	"gt06/metrics"
 	"sync"
 
 	"github.com/zeromicro/go-zero/core/logx"
 	"github.com/zeromicro/go-zero/core/threading"
 )
 
+// queueName labels the pool in the queue depth metric
+const queueName = "worker"
+
 type Task struct {
 	TraceID string
 	Action  func() error
@@ -47,6 +51,7 @@ func (wp *WorkerPool) Start() {
 				select {
 				case task, ok := <-wp.tasks:
 					if ok {
+						metrics.QueueDepth.Set(float64(len(wp.tasks)), queueName)
 						wp.rg.RunSafe(func() {
 							// Execute the task's action
 							err := task.Action()
@@ -86,6 +91,7 @@ func (wp *WorkerPool) Submit(task Task) {
 
 	// Submit the task since there is space available
 	wp.tasks <- task
+	metrics.QueueDepth.Set(float64(len(wp.tasks)), queueName)
 	logx.Infof("[WORKER] Task submitted successfully with TraceID: %v", task.TraceID)
 }
 